@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils/file"
+)
+
+// apis are the source types that are pulled from a statistics API
+var apis = []string{"fred", "bls", "census"}
+
+// the API endpoints
+var (
+	fredURL   = "https://api.stlouisfed.org/fred/series/observations"
+	blsURL    = "https://api.bls.gov/publicAPI/v2/timeseries/data/"
+	censusURL = "https://api.census.gov/data/"
+)
+
+// apiSpec holds the options of an API source
+type apiSpec struct {
+	series []string // FRED or BLS series ids, or the Census variables
+	key    string   // API key
+	years  []int    // first and last year of the observations, nil for the API's default
+	geoFor string   // Census geography (for=)
+	geoIn  string   // Census enclosing geography (in=), optional
+}
+
+// apiTypes returns the field types of the table produced for the API source sType, nil if they vary.
+func apiTypes(sType string) []string {
+	switch sType {
+	case "fred":
+		return []string{"s", "d", "f"}
+	case "bls":
+		return []string{"s", "d", "s", "f"}
+	}
+	return nil
+}
+
+// parseYears parses -years, which is of the form start:end
+func parseYears(years string) ([]int, error) {
+	if years == "" {
+		return nil, nil
+	}
+	se := strings.Split(years, ":")
+	if len(se) != 2 {
+		return nil, fmt.Errorf("-years is start:end, e.g. 2010:2024")
+	}
+	yrs := make([]int, 2)
+	for ind, y := range se {
+		var err error
+		if yrs[ind], err = strconv.Atoi(strings.TrimSpace(y)); err != nil {
+			return nil, fmt.Errorf("-years: %v", err)
+		}
+	}
+	if yrs[0] > yrs[1] {
+		return nil, fmt.Errorf("-years: %d is after %d", yrs[0], yrs[1])
+	}
+	return yrs, nil
+}
+
+// newAPI creates a reader for the data pulled from the API j.sType.  The data is a tidy table:
+//   - fred: series, date, value -- one row per observation
+//   - bls: series, date, period, value -- one row per observation.  The date is the start of the period.
+//   - census: the variables followed by the geography fields -- one row per geography
+func newAPI(j *job, skip int) (*file.Reader, error) {
+	var rows [][]string
+	var err error
+	switch j.sType {
+	case "fred":
+		rows, err = fred(j)
+	case "bls":
+		rows, err = bls(j)
+	case "census":
+		rows, err = census(j)
+	default:
+		return nil, fmt.Errorf("illegal -type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the data always has a header row
+	if len(j.headers) > 0 {
+		skip++
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if e := w.WriteAll(rows); e != nil {
+		return nil, e
+	}
+	return newBytes(buf.Bytes(), "csv", "", '"', skip, nil, "")
+}
+
+// apiGet sends the request to the API and decodes the JSON response into out
+func apiGet(j *job, w *webRequest, source string, out interface{}) error {
+	resp, err := w.get(source)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if e := json.Unmarshal(body, out); e != nil {
+		return fmt.Errorf("-type %s: unexpected response: %v", j.sType, e)
+	}
+	return nil
+}
+
+// fred pulls the observations of the FRED series
+func fred(j *job) ([][]string, error) {
+	rows := [][]string{{"series", "date", "value"}}
+	for _, series := range j.api.series {
+		q := url.Values{"series_id": {series}, "api_key": {j.api.key}, "file_type": {"json"}}
+		if j.api.years != nil {
+			q.Set("observation_start", fmt.Sprintf("%d-01-01", j.api.years[0]))
+			q.Set("observation_end", fmt.Sprintf("%d-12-31", j.api.years[1]))
+		}
+		var resp struct {
+			Observations []struct {
+				Date  string `json:"date"`
+				Value string `json:"value"`
+			} `json:"observations"`
+			ErrorMessage string `json:"error_message"`
+		}
+		if err := apiGet(j, j.web, fredURL+"?"+q.Encode(), &resp); err != nil {
+			return nil, fmt.Errorf("series %s: %v", series, err)
+		}
+		if resp.ErrorMessage != "" {
+			return nil, fmt.Errorf("series %s: %s", series, resp.ErrorMessage)
+		}
+		for _, o := range resp.Observations {
+			dt, err := time.Parse("2006-01-02", o.Date)
+			if err != nil {
+				return nil, fmt.Errorf("series %s: %v", series, err)
+			}
+			// FRED marks missing values with "."
+			value := o.Value
+			if value == "." {
+				value = ""
+			}
+			rows = append(rows, []string{series, dt.Format(j.dateFmt), value})
+		}
+	}
+	return rows, nil
+}
+
+// BLS limits on a request: series per request and years per request (without, with a key)
+const (
+	blsSeries   = 50
+	blsYears    = 10
+	blsKeyYears = 20
+)
+
+// bls pulls the observations of the BLS series.  The BLS limits the series and years of a request, so
+// several requests may be needed.  Without -years, the last 10 years are pulled.
+func bls(j *job) ([][]string, error) {
+	years, span := j.api.years, blsYears
+	if j.api.key != "" {
+		span = blsKeyYears
+	}
+	if years == nil {
+		years = []int{j.now.Year() - span + 1, j.now.Year()}
+	}
+
+	rows := [][]string{{"series", "date", "period", "value"}}
+	for st := 0; st < len(j.api.series); st += blsSeries {
+		series := j.api.series[st:min(st+blsSeries, len(j.api.series))]
+		for start := years[0]; start <= years[1]; start += span {
+			req := map[string]interface{}{"seriesid": series, "startyear": strconv.Itoa(start),
+				"endyear": strconv.Itoa(min(start+span-1, years[1]))}
+			if j.api.key != "" {
+				req["registrationkey"] = j.api.key
+			}
+			body, err := json.Marshal(req)
+			if err != nil {
+				return nil, err
+			}
+			w := *j.web
+			w.method, w.body, w.contentType = "POST", body, "application/json"
+
+			var resp struct {
+				Status  string   `json:"status"`
+				Message []string `json:"message"`
+				Results struct {
+					Series []struct {
+						SeriesID string `json:"seriesID"`
+						Data     []struct {
+							Year   string `json:"year"`
+							Period string `json:"period"`
+							Value  string `json:"value"`
+						} `json:"data"`
+					} `json:"series"`
+				} `json:"Results"`
+			}
+			if e := apiGet(j, &w, blsURL, &resp); e != nil {
+				return nil, e
+			}
+			if resp.Status != "REQUEST_SUCCEEDED" {
+				return nil, fmt.Errorf("-type bls: %s: %s", resp.Status, strings.Join(resp.Message, "; "))
+			}
+			for _, s := range resp.Results.Series {
+				for _, d := range s.Data {
+					dt, err := blsDate(d.Year, d.Period)
+					if err != nil {
+						return nil, fmt.Errorf("series %s: %v", s.SeriesID, err)
+					}
+					// missing values are "-"
+					value := d.Value
+					if _, e := strconv.ParseFloat(value, 64); e != nil {
+						value = ""
+					}
+					rows = append(rows, []string{s.SeriesID, dt.Format(j.dateFmt), d.Period, value})
+				}
+			}
+		}
+	}
+	return rows, nil
+}
+
+// blsDate returns the first day of the BLS period of year. Periods are M01-M12 (months), M13 (annual average),
+// Q01-Q05 (quarters, Q05 is the annual average), S01-S03 (half years, S03 is the annual average) and A01 (annual).
+func blsDate(year, period string) (time.Time, error) {
+	y, err := strconv.Atoi(year)
+	if err != nil || len(period) != 3 {
+		return time.Time{}, fmt.Errorf("bad BLS year %s or period %s", year, period)
+	}
+	n, err := strconv.Atoi(period[1:])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad BLS period %s", period)
+	}
+	month := 1
+	switch {
+	case period[0] == 'M' && n <= 12:
+		month = n
+	case period[0] == 'Q' && n <= 4:
+		month = 3*(n-1) + 1
+	case period[0] == 'S' && n <= 2:
+		month = 6*(n-1) + 1
+	}
+	return time.Date(y, time.Month(month), 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// census pulls the -series variables of the Census dataset j.source (e.g. 2021/acs/acs5) for the geography
+// -census-for within -census-in.
+func census(j *job) ([][]string, error) {
+	q := url.Values{"get": {strings.Join(j.api.series, ",")}, "for": {j.api.geoFor}}
+	if j.api.geoIn != "" {
+		q.Set("in", j.api.geoIn)
+	}
+	if j.api.key != "" {
+		q.Set("key", j.api.key)
+	}
+	source := censusURL + strings.Trim(j.source, "/") + "?" + q.Encode()
+
+	// the response is an array of rows, the first of which is the field names.  Values may be null.
+	var resp [][]*string
+	if err := apiGet(j, j.web, source, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp) == 0 {
+		return nil, fmt.Errorf("-type census: no data")
+	}
+	rows := make([][]string, 0, len(resp))
+	for _, r := range resp {
+		row := make([]string, len(r))
+		for ind, v := range r {
+			if v != nil {
+				row[ind] = *v
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
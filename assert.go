@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/invertedv/chutils"
+)
+
+// assertActions are the values of -assert-action
+var assertActions = []string{"fail", "warn"}
+
+// sortCheck checks that the values of a field of the rows of a pipeline never decrease (-assert-sorted)
+type sortCheck struct {
+	field  string
+	col    int    // index of field in the rows
+	action string // fail or warn
+	source string
+	rows   int         // rows checked in this pass over the data
+	prev   interface{} // value of the last row, nil if none
+	bad    int         // rows out of order in this pass
+	first  string      // the first row out of order, as reported
+}
+
+// newSortCheck returns the check of the rows of source that field, at index col, is non-decreasing
+func newSortCheck(field string, col int, action, source string) *sortCheck {
+	return &sortCheck{field: field, col: col, action: action, source: source}
+}
+
+// check checks the next converted row.  Rows with a NULL value are skipped.  With -assert-action fail, a row
+// with a smaller value than the last row is an error.
+func (sc *sortCheck) check(e *env, row []interface{}) error {
+	sc.rows++
+	v := row[sc.col]
+	if v == nil {
+		return nil
+	}
+	if sc.prev != nil {
+		c, err := compare(e, v, sc.prev)
+		if err != nil {
+			return fmt.Errorf("-assert-sorted %s, row %d: %v", sc.field, sc.rows, err)
+		}
+		if c < 0 {
+			msg := fmt.Sprintf("row %d: %s %s follows %s", sc.rows, sc.field, toStr(e, v), toStr(e, sc.prev))
+			if sc.action == "fail" {
+				return fmt.Errorf("%s: not sorted by %s, %s", sc.source, sc.field, msg)
+			}
+			if sc.bad == 0 {
+				sc.first = msg
+			}
+			sc.bad++
+		}
+	}
+	sc.prev = v
+	return nil
+}
+
+// reset starts a new pass over the data
+func (sc *sortCheck) reset() {
+	sc.rows, sc.prev, sc.bad, sc.first = 0, nil, 0, ""
+}
+
+// finish warns of the rows out of order in the last pass
+func (sc *sortCheck) finish() {
+	if sc.bad > 0 {
+		fmt.Printf("warning: %s: %d rows are out of order by %s, the first at %s\n", sc.source, sc.bad, sc.field, sc.first)
+	}
+}
+
+// duplicateActions are the values of -on-duplicate
+var duplicateActions = []string{"fail", "warn", "dedup"}
+
+// uniqueKeys is the -assert-unique check: no two rows loaded have the same values of fields.  The keys are kept
+// exactly or, with -unique-bloom, in a Bloom filter of bounded size.  It is shared by the files of a load.
+type uniqueKeys struct {
+	fields []string
+	action string // fail, warn or dedup
+
+	mu    sync.Mutex
+	seen  map[string]struct{} // the keys loaded, nil if bloom is used
+	bloom *bloom
+	keys  int    // keys checked
+	dups  int    // rows with a key seen before
+	first string // the first duplicate, as reported
+}
+
+// newUniqueKeys returns the check that the fields are a unique key, kept in a Bloom filter of bloomMB megabytes
+// if bloomMB > 0
+func newUniqueKeys(fields []string, action string, bloomMB int) *uniqueKeys {
+	if len(fields) == 0 {
+		return nil
+	}
+	u := &uniqueKeys{fields: fields, action: action}
+	if bloomMB > 0 {
+		u.bloom = newBloom(uint64(bloomMB) << 23)
+	} else {
+		u.seen = make(map[string]struct{})
+	}
+	return u
+}
+
+// watch returns rdr wrapped so that the keys of the rows are checked as they are read
+func (u *uniqueKeys) watch(rdr chutils.Input, source string) (chutils.Input, error) {
+	cols := make([]int, len(u.fields))
+	for ind, name := range u.fields {
+		col, _, err := rdr.TableSpec().Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("-assert-unique: %s is not a field", name)
+		}
+		cols[ind] = col
+	}
+	return &keyWatch{Input: rdr, u: u, cols: cols, source: source}, nil
+}
+
+// add records key and returns true if it was seen before.  A Bloom filter may wrongly say it was.
+func (u *uniqueKeys) add(key string) bool {
+	u.keys++
+	if u.bloom != nil {
+		return u.bloom.add(key)
+	}
+	if _, ok := u.seen[key]; ok {
+		return true
+	}
+	u.seen[key] = struct{}{}
+	return false
+}
+
+// finish reports the duplicate keys found by -on-duplicate warn or dedup
+func (u *uniqueKeys) finish() {
+	if u.dups == 0 {
+		return
+	}
+	verb := "loaded"
+	if u.action == "dedup" {
+		verb = "dropped"
+	}
+	fmt.Printf("warning: %d rows with a duplicate %s were %s, the first %s\n", u.dups, strings.Join(u.fields, ","), verb, u.first)
+	if u.bloom != nil {
+		fmt.Printf("the keys were checked with a Bloom filter: about %.4f%% of the rows may be reported wrongly\n",
+			100*u.bloom.falsePositive(u.keys))
+	}
+}
+
+// keyWatch is a chutils.Input that checks the keys of the validated rows of a source
+type keyWatch struct {
+	chutils.Input
+	u      *uniqueKeys
+	cols   []int
+	source string
+	rows   int
+}
+
+// Read reads from the underlying Input and checks the keys of the validated rows.  With -on-duplicate dedup,
+// the rows with keys seen before are left out.
+func (k *keyWatch) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	for {
+		if data, valid, err = k.Input.Read(nTarget, validate); !validate {
+			return data, valid, err
+		}
+		keep := 0
+		k.u.mu.Lock()
+		for ind, row := range data {
+			k.rows++
+			vals := make([]string, len(k.cols))
+			for c, col := range k.cols {
+				vals[c] = fmt.Sprint(row[col])
+			}
+			key := strings.Join(vals, "\x1f")
+			if k.u.add(key) {
+				msg := fmt.Sprintf("(%s) at row %d of %s", strings.Join(vals, ","), k.rows, k.source)
+				if k.u.action == "fail" {
+					k.u.mu.Unlock()
+					return nil, nil, fmt.Errorf("duplicate %s %s", strings.Join(k.u.fields, ","), msg)
+				}
+				if k.u.dups == 0 {
+					k.u.first = msg
+				}
+				k.u.dups++
+				if k.u.action == "dedup" {
+					continue
+				}
+			}
+			data[keep] = row
+			if ind < len(valid) {
+				valid[keep] = valid[ind]
+			}
+			keep++
+		}
+		k.u.mu.Unlock()
+		data = data[:keep]
+		if keep < len(valid) {
+			valid = valid[:keep]
+		}
+		// a batch of only duplicates isn't the end of the data
+		if keep > 0 || err != nil {
+			return data, valid, err
+		}
+	}
+}
+
+// bloom is a Bloom filter of strings
+type bloom struct {
+	bits []uint64
+	m    uint64 // number of bits
+}
+
+// bloomHashes is the number of bits set for each string, about right at 10 bits a key
+const bloomHashes = 7
+
+// newBloom returns a Bloom filter of m bits
+func newBloom(m uint64) *bloom {
+	return &bloom{bits: make([]uint64, (m+63)/64), m: m}
+}
+
+// add adds s to the filter and returns true if it may have been added before
+func (b *bloom) add(s string) bool {
+	h1, h2 := fnv.New64a(), fnv.New64()
+	_, _ = h1.Write([]byte(s))
+	_, _ = h2.Write([]byte(s))
+	a, c := h1.Sum64(), h2.Sum64()|1
+	seen := true
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (a + i*c) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			seen = false
+			b.bits[bit/64] |= 1 << (bit % 64)
+		}
+	}
+	return seen
+}
+
+// falsePositive returns the chance that a string not added is taken to have been, after n strings are added
+func (b *bloom) falsePositive(n int) float64 {
+	return math.Pow(1-math.Exp(-bloomHashes*float64(n)/float64(b.m)), bloomHashes)
+}
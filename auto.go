@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sniffed is what -type auto found out about a source
+type sniffed struct {
+	sType  string // -type
+	delim  string // -sep, "" if the type's own
+	fields int    // number of fields of a text or csv source
+	header bool   // the first line of a text or csv source is a header
+}
+
+// autoType sniffs the start of the file or web source, as "toch sniff" does, for -type auto.  Spreadsheets
+// and Parquet files are known by their first bytes.  Otherwise the source, decompressed if it is gzip or bzip2,
+// is taken to be delimited text: the delimiter is the one of tab, comma, semicolon and pipe that splits the
+// first lines into the same number of fields, and the first line is a header if none of its fields is a number
+// or empty.
+func autoType(source string, web *webRequest) (*sniffed, error) {
+	if isS3(source) {
+		return nil, fmt.Errorf("-type auto can't sniff an S3 source: give -type")
+	}
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		return nil, fmt.Errorf("-type auto needs a file or URL, not a directory: give -type")
+	}
+	sample, size, err := sniffSample(source, web)
+	if err != nil {
+		return nil, err
+	}
+	whole := int64(len(sample)) == size
+	for _, m := range magics {
+		if !bytes.HasPrefix(sample, m.prefix) {
+			continue
+		}
+		switch m.name {
+		case "gzip", "bzip2":
+			if sample, err = decompress(m.name, sample); err != nil {
+				return nil, err
+			}
+			whole = whole && len(sample) < sniffBytes
+		case "zip":
+			if bytes.Contains(sample, []byte("xl/")) {
+				return &sniffed{sType: "xlsx"}, nil
+			}
+			return nil, fmt.Errorf("-type auto can't tell the type of the zip archive %s: give -type", source)
+		case "xls", "parquet":
+			return &sniffed{sType: m.name}, nil
+		default:
+			return nil, fmt.Errorf("-type auto: %s is %s, which toch doesn't read", source, m.name)
+		}
+		break
+	}
+
+	_, body := sniffEncoding(sample)
+	lines := strings.Split(strings.ReplaceAll(body, "\r", ""), "\n")
+	// the last line of a partial sample may be cut off
+	if !whole && len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("-type auto: %s is empty", source)
+	}
+	d := sniffDelimiter(lines)
+	if d == 0 {
+		return nil, fmt.Errorf("-type auto can't find a delimiter that splits the lines of %s into the same number of fields: "+
+			"give -type and -sep", source)
+	}
+	s := &sniffed{sType: "csv", fields: len(splitFields(lines[0], d)), header: looksLikeHeader(lines, d)}
+	switch d {
+	case '\t':
+		s.sType = "text"
+	case ',':
+	default:
+		s.delim = string(d)
+	}
+	return s, nil
+}
+
+// String describes s
+func (s *sniffed) String() string {
+	desc := s.sType
+	if s.delim != "" {
+		desc = fmt.Sprintf("%s with -sep '%s'", desc, s.delim)
+	}
+	if s.sType != "text" && s.sType != "csv" {
+		return desc
+	}
+	if !s.header {
+		return fmt.Sprintf("%s, %d fields, no header row", desc, s.fields)
+	}
+	return fmt.Sprintf("%s, %d fields and a header row", desc, s.fields)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/invertedv/chutils"
+)
+
+// maxLowCard is the largest number of distinct values of a LowCardinality(String) field chosen by -auto-string
+const maxLowCard = 10000
+
+// autoString chooses the type of the imputed String fields of in from the lengths and number of distinct
+// values seen: FixedString(n) if every value has n bytes, LowCardinality(String) if there are at most
+// maxLowCard distinct values and each appears twice on average, and String otherwise.  It reads in to find the
+// values and then resets it.  The choice made for each field is reported.
+func autoString(in chutils.Input) error {
+	spec := in.TableSpec()
+	type profile struct {
+		minLen, maxLen int
+		distinct       map[string]bool // nil once there are more than maxLowCard
+	}
+	profiles := make(map[int]*profile)
+	for ind, fd := range spec.FieldDefs {
+		if fd.ChSpec.Base == chutils.ChString && len(fd.ChSpec.Funcs) == 0 {
+			profiles[ind] = &profile{minLen: -1, distinct: make(map[string]bool)}
+		}
+	}
+	if len(profiles) == 0 {
+		return nil
+	}
+
+	if err := in.Reset(); err != nil {
+		return err
+	}
+	rows := 0
+	for {
+		data, valid, err := in.Read(1000, true)
+		rows += len(data)
+		for r, row := range data {
+			for ind, p := range profiles {
+				if r >= len(valid) || ind >= len(row) || ind >= len(valid[r]) || valid[r][ind] != chutils.VPass {
+					continue
+				}
+				s, _ := row[ind].(string)
+				if p.minLen < 0 || len(s) < p.minLen {
+					p.minLen = len(s)
+				}
+				p.maxLen = max(p.maxLen, len(s))
+				if p.distinct != nil {
+					if p.distinct[s] = true; len(p.distinct) > maxLowCard {
+						p.distinct = nil
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := in.Reset(); err != nil {
+		return err
+	}
+
+	for ind, fd := range spec.FieldDefs {
+		p, ok := profiles[ind]
+		if !ok || p.minLen < 0 {
+			continue
+		}
+		switch {
+		case p.minLen == p.maxLen && p.maxLen > 0:
+			fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChFixedString, p.maxLen, ""
+			fmt.Printf("%s: %s (every value is %d bytes)\n", fd.Name, chType(fd), p.maxLen)
+		case p.distinct != nil && 2*len(p.distinct) <= rows:
+			fd.ChSpec.Funcs = chutils.OuterFuncs{chutils.OuterLowCardinality}
+			fmt.Printf("%s: %s (%d distinct values in %d rows)\n", fd.Name, chType(fd), len(p.distinct), rows)
+		default:
+			fmt.Printf("%s: String (%d to %d bytes, %s distinct values)\n", fd.Name, p.minLen, p.maxLen, distinctCount(p.distinct))
+		}
+	}
+	return nil
+}
+
+// distinctCount returns the number of distinct values, or more than maxLowCard if they weren't all kept
+func distinctCount(distinct map[string]bool) string {
+	if distinct == nil {
+		return fmt.Sprintf("more than %d", maxLowCard)
+	}
+	return fmt.Sprint(len(distinct))
+}
+
+// lowCardinality makes the imputed String fields of in with at most n distinct values LowCardinality(String)
+// (-lc-threshold).  It reads in to count the values and then resets it.  The fields changed are reported.
+func lowCardinality(in chutils.Input, n int) error {
+	spec := in.TableSpec()
+	distinct := make(map[int]map[string]bool)
+	for ind, fd := range spec.FieldDefs {
+		if fd.ChSpec.Base == chutils.ChString && len(fd.ChSpec.Funcs) == 0 {
+			distinct[ind] = make(map[string]bool)
+		}
+	}
+	if len(distinct) == 0 {
+		return nil
+	}
+
+	if err := in.Reset(); err != nil {
+		return err
+	}
+	rows := 0
+	for {
+		data, valid, err := in.Read(1000, true)
+		rows += len(data)
+		for r, row := range data {
+			for ind, d := range distinct {
+				if r >= len(valid) || ind >= len(row) || ind >= len(valid[r]) || valid[r][ind] != chutils.VPass {
+					continue
+				}
+				s, _ := row[ind].(string)
+				if d[s] = true; len(d) > n {
+					delete(distinct, ind)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := in.Reset(); err != nil {
+		return err
+	}
+
+	for ind, fd := range spec.FieldDefs {
+		if d, ok := distinct[ind]; ok && len(d) > 0 {
+			fd.ChSpec.Funcs = chutils.OuterFuncs{chutils.OuterLowCardinality}
+			fmt.Printf("%s: %s (%d distinct values in %d rows)\n", fd.Name, chType(fd), len(d), rows)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// createBackupLog creates the table that records the backups made by toch.
+func createBackupLog(log string, con *chutils.Connect) error {
+	qry := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  runId String,
+  target String,
+  backup String,
+  created DateTime,
+  expires DateTime
+) ENGINE=MergeTree() ORDER BY (runId, target)`, log)
+	_, err := con.Exec(qry)
+	return err
+}
+
+// backupName returns the name for the backup of table made by run runID
+func backupName(table, runID string) string {
+	return fmt.Sprintf("%s_toch_%s", table, strings.ReplaceAll(runID, "-", "")[:12])
+}
+
+// backup keeps table as it is before the run runID, so the run can be undone, and records it in log.  The
+// backup is kept for keep.  If the run replaces the table, the table is renamed aside.  If the run appends to
+// it, the table is copied (see copyTable).  If the table doesn't exist, the run is still recorded so that undo
+// drops the table it creates.
+func backup(table, runID string, keep time.Duration, appending bool, log string, con *chutils.Connect) error {
+	if err := createBackupLog(log, con); err != nil {
+		return err
+	}
+	exists, _, _, err := tableInfo(table, con)
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	if exists {
+		name = backupName(table, runID)
+		until := time.Now().Add(keep).Format("2006-01-02 15:04:05")
+		if appending {
+			if e := copyTable(table, name, con); e != nil {
+				return e
+			}
+			fmt.Printf("table %s copied to %s, kept until %s\n", table, name, until)
+		} else {
+			if _, e := con.Exec(fmt.Sprintf("RENAME TABLE %s TO %s", table, name)); e != nil {
+				return e
+			}
+			fmt.Printf("table %s renamed to %s, kept until %s\n", table, name, until)
+		}
+	}
+
+	now := time.Now()
+	_, err = con.Exec(fmt.Sprintf("INSERT INTO %s VALUES (?, ?, ?, ?, ?)", log), runID, table, name, now, now.Add(keep))
+	return err
+}
+
+// copyTable creates the table dest with the structure and the rows of table.  The partitions of table are
+// attached to dest (ATTACH PARTITION ... FROM), which hard-links their parts, so the copy takes little time or
+// disk space whatever the size of table.  table must be of the MergeTree family.
+func copyTable(table, dest string, con *chutils.Connect) error {
+	if _, err := con.Exec(fmt.Sprintf("CREATE TABLE %s AS %s", dest, table)); err != nil {
+		return err
+	}
+	db, name := splitTable(table)
+	rows, err := con.Query("SELECT DISTINCT partition_id FROM system.parts "+
+		"WHERE database = if(? = '', currentDatabase(), ?) AND table = ? AND active", db, db, name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	partitions := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if e := rows.Scan(&id); e != nil {
+			return e
+		}
+		partitions = append(partitions, id)
+	}
+	if e := rows.Err(); e != nil {
+		return e
+	}
+	for _, id := range partitions {
+		if _, e := con.Exec(fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION ID '%s' FROM %s", dest, id, table)); e != nil {
+			return fmt.Errorf("copying %s to %s: %v", table, dest, e)
+		}
+	}
+	return nil
+}
+
+// cleanBackups drops the backups in log that have expired
+func cleanBackups(log string, con *chutils.Connect) error {
+	if exists, _, _, err := tableInfo(log, con); err != nil || !exists {
+		return err
+	}
+
+	rows, err := con.Query(fmt.Sprintf("SELECT backup FROM %s WHERE expires < now() AND backup != ''", log))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	expired := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if e := rows.Scan(&name); e != nil {
+			return e
+		}
+		expired = append(expired, name)
+	}
+	for _, name := range expired {
+		if _, e := con.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", name)); e != nil {
+			return e
+		}
+	}
+
+	_, err = con.Exec(fmt.Sprintf("ALTER TABLE %s DELETE WHERE expires < now()", log))
+	return err
+}
+
+// undo restores the tables loaded by run runID to their state before the run.  A table that didn't exist
+// before the run is dropped.
+func undo(runID, log string, con *chutils.Connect) error {
+	if exists, _, _, err := tableInfo(log, con); err != nil || !exists {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("no backups have been made: there is no table %s", log)
+	}
+
+	rows, err := con.Query(fmt.Sprintf("SELECT target, backup FROM %s WHERE runId = ?", log), runID)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	type restore struct{ target, backup string }
+	restores := make([]restore, 0)
+	for rows.Next() {
+		var r restore
+		if e := rows.Scan(&r.target, &r.backup); e != nil {
+			return e
+		}
+		restores = append(restores, r)
+	}
+	if len(restores) == 0 {
+		return fmt.Errorf("no backup of run %s: it was not run with -keep-backup or the backup has expired", runID)
+	}
+
+	for _, r := range restores {
+		if _, e := con.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", r.target)); e != nil {
+			return e
+		}
+		if r.backup == "" {
+			fmt.Printf("dropped %s, which run %s created\n", r.target, runID)
+			continue
+		}
+		if _, e := con.Exec(fmt.Sprintf("RENAME TABLE %s TO %s", r.backup, r.target)); e != nil {
+			return e
+		}
+		fmt.Printf("restored %s from %s\n", r.target, r.backup)
+	}
+
+	_, err = con.Exec(fmt.Sprintf("ALTER TABLE %s DELETE WHERE runId = ?", log), runID)
+	return err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/invertedv/chutils"
+)
+
+// badMu serializes the writes to the -bad file by the readers of a multi-file load
+var badMu sync.Mutex
+
+// badCheck records the rows of a pipeline that are rejected or have values that don't convert, for -bad.  The
+// rows of a pass over the data are held in a temporary file, since the inference passes read the data too, and
+// those of the last pass are appended to the -bad file when the pipeline is closed.
+type badCheck struct {
+	path     string // the -bad file
+	source   string
+	tmp      *os.File // rows of this pass, nil until there is one
+	w        *csv.Writer
+	problems int // problems recorded in this pass
+}
+
+// newBadCheck returns the record of the bad rows of source for the -bad file path
+func newBadCheck(path, source string) *badCheck {
+	return &badCheck{path: path, source: source}
+}
+
+// add records field of row rowNo of the source (field "" for the whole row), its value, the problem and the
+// fields of the row as read
+func (b *badCheck) add(rowNo int, field, value, problem string, row []interface{}) error {
+	if b.tmp == nil {
+		tmp, err := os.CreateTemp("", "toch-bad-*.csv")
+		if err != nil {
+			return err
+		}
+		b.tmp, b.w = tmp, csv.NewWriter(tmp)
+	}
+	rec := make([]string, len(row))
+	for ind, v := range row {
+		if v != nil {
+			rec[ind] = fmt.Sprint(v)
+		}
+	}
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	_ = w.Write(rec)
+	w.Flush()
+	b.problems++
+	return b.w.Write([]string{b.source, strconv.Itoa(rowNo), field, value, problem, strings.TrimRight(sb.String(), "\n")})
+}
+
+// converted records the fields of row rowNo whose values, raw before conversion, didn't convert to their types
+// and were filled in.  Fields that held a -null token are meant to be missing and are left out.
+func (b *badCheck) converted(rowNo int, spec *chutils.TableDef, raw []interface{}, status chutils.Valid, nulls []int, row []interface{}) error {
+	for ind, st := range status {
+		if st == chutils.VPass || isNull(nulls, ind) {
+			continue
+		}
+		fd := spec.FieldDefs[ind]
+		v := ""
+		if raw[ind] != nil {
+			v = fmt.Sprint(raw[ind])
+		}
+		problem := fmt.Sprintf("not a %s", chType(fd))
+		if fd.ChSpec.Base == chutils.ChDate {
+			problem += " (format " + fd.ChSpec.Format + ")"
+		}
+		if strings.TrimSpace(v) == "" {
+			problem = "empty"
+		}
+		if err := b.add(rowNo, fd.Name, v, fmt.Sprintf("%s, loaded as %v", problem, fd.Missing), row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isNull returns true if field ind is one of nulls
+func isNull(nulls []int, ind int) bool {
+	for _, n := range nulls {
+		if n == ind {
+			return true
+		}
+	}
+	return false
+}
+
+// reset starts a new pass over the data
+func (b *badCheck) reset() error {
+	b.problems = 0
+	if b.tmp == nil {
+		return nil
+	}
+	b.w.Flush()
+	if err := b.tmp.Truncate(0); err != nil {
+		return err
+	}
+	_, err := b.tmp.Seek(0, io.SeekStart)
+	return err
+}
+
+// finish appends the rows of the last pass to the -bad file, which gets a header row if it's new, and reports
+// them
+func (b *badCheck) finish() error {
+	if b.tmp == nil {
+		return nil
+	}
+	defer func() {
+		_ = b.tmp.Close()
+		_ = os.Remove(b.tmp.Name())
+	}()
+	b.w.Flush()
+	if err := b.w.Error(); err != nil {
+		return err
+	}
+	if b.problems == 0 {
+		return nil
+	}
+	if _, err := b.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	badMu.Lock()
+	defer badMu.Unlock()
+	f, err := os.OpenFile(b.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	if info, e := f.Stat(); e == nil && info.Size() == 0 {
+		w := csv.NewWriter(f)
+		if e := w.Write([]string{"_source", "_row", "_field", "_value", "_problem", "_record"}); e != nil {
+			return e
+		}
+		w.Flush()
+		if e := w.Error(); e != nil {
+			return e
+		}
+	}
+	if _, err := io.Copy(f, b.tmp); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d problems written to %s\n", b.source, b.problems, b.path)
+	return nil
+}
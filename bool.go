@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// parseBoolTokens parses -bool-tokens, the values read as true and as false: 'y,true,1/n,false,0'.  The
+// tokens are not case-sensitive.  It returns the value of each token.
+func parseBoolTokens(list string) (map[string]bool, error) {
+	parts := strings.Split(list, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("-bool-tokens is the true values, /, and the false values, e.g. 'y,1/n,0', got %s", list)
+	}
+	tokens := make(map[string]bool)
+	for ind, part := range parts {
+		for _, t := range splitList(strings.ToLower(part)) {
+			if v, ok := tokens[t]; ok && v != (ind == 0) {
+				return nil, fmt.Errorf("-bool-tokens: %s is both true and false", t)
+			}
+			tokens[t] = ind == 0
+		}
+	}
+	return tokens, nil
+}
+
+// isBool returns true if field ind is a Bool: from -t, or found by -infer-bool
+func (j *job) isBool(ind int) bool {
+	return j.typeOf(ind) == "b" || j.bools[ind]
+}
+
+// hasBool returns true if the job has Bool fields or may find them
+func (j *job) hasBool() bool {
+	if j.inferBool || len(j.bools) > 0 || j.allTypes == "b" {
+		return true
+	}
+	for _, t := range j.fieldTypes {
+		if t == "b" {
+			return true
+		}
+	}
+	return false
+}
+
+// boolStep returns a step that replaces the tokens of the Bool fields with 1 and 0, which are read as Int8
+// and inserted into the Bool column as they are.  A value that isn't a token is loaded as false.
+func (j *job) boolStep(nSrc int) step {
+	return func(row []interface{}) (bool, error) {
+		for ind := 0; ind < nSrc; ind++ {
+			if !j.isBool(ind) {
+				continue
+			}
+			v, ok := j.boolTokens[strings.ToLower(strings.TrimSpace(fmt.Sprint(row[ind])))]
+			switch {
+			case !ok:
+				row[ind] = ""
+			case v:
+				row[ind] = "1"
+			default:
+				row[ind] = "0"
+			}
+		}
+		return true, nil
+	}
+}
+
+// inferBools finds the imputed String and Int64 fields of in whose values are all -bool-tokens, e.g. Y and N
+// or 0 and 1, and makes them Bools.  Empty values are skipped, but a field must have a value.  Only the first
+// nSrc fields, those of the source, are looked at, and the first field, the table key, is left alone.  It reads
+// in to find the values and then resets it.  The fields changed are reported.
+func (j *job) inferBools(in chutils.Input, nSrc int) error {
+	spec := in.TableSpec()
+	seen := make(map[int]bool) // whether a token was seen, by field
+	for ind := 1; ind < nSrc; ind++ {
+		fd := spec.FieldDefs[ind]
+		if (fd.ChSpec.Base == chutils.ChString || fd.ChSpec.Base == chutils.ChInt) && len(fd.ChSpec.Funcs) == 0 && !fd.Drop {
+			seen[ind] = false
+		}
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+
+	if err := in.Reset(); err != nil {
+		return err
+	}
+	for {
+		data, _, err := in.Read(1000, false)
+		for _, row := range data {
+			for ind := range seen {
+				s := strings.ToLower(strings.TrimSpace(fmt.Sprint(row[ind])))
+				if row[ind] == nil || s == "" {
+					continue
+				}
+				if _, ok := j.boolTokens[s]; !ok {
+					delete(seen, ind)
+					continue
+				}
+				seen[ind] = true
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := in.Reset(); err != nil {
+		return err
+	}
+
+	if j.bools == nil {
+		j.bools = make(map[int]bool)
+	}
+	for ind := 1; ind < nSrc; ind++ {
+		if !seen[ind] {
+			continue
+		}
+		j.bools[ind] = true
+		fd := spec.FieldDefs[ind]
+		fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChInt, 8, 0
+		fmt.Printf("field %s is Bool\n", fd.Name)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBoolTokens(t *testing.T) {
+	tokens, err := parseBoolTokens("Y, True,1/n,FALSE,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(tokens); got != "map[0:false 1:true false:false n:false true:true y:true]" {
+		t.Errorf("parseBoolTokens = %s", got)
+	}
+
+	// field 1 is a Bool; fields 0 and 2 aren't
+	j := &job{fieldTypes: []string{"s", "b", "s"}, boolTokens: tokens}
+	for _, tt := range [][2][]interface{}{
+		{{"y", " TRUE ", "y"}, {"y", "1", "y"}},
+		{{"n", "n", "n"}, {"n", "0", "n"}},
+		{{"x", "maybe", "1"}, {"x", "", "1"}},
+		{{"x", 0, "1"}, {"x", "0", "1"}},
+	} {
+		row := append([]interface{}{}, tt[0]...)
+		if _, e := j.boolStep(3)(row); e != nil || fmt.Sprint(row) != fmt.Sprint(tt[1]) {
+			t.Errorf("boolStep(%v) = %v, %v; want %v", tt[0], row, e, tt[1])
+		}
+	}
+
+	for _, list := range []string{"y,n", "y/n/x", "y,n/n"} {
+		if _, e := parseBoolTokens(list); e == nil {
+			t.Errorf("parseBoolTokens(%s): no error", list)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// parseCases returns the changes of case of -upper, -lower and -titlecase, whose values are comma-separated
+// lists of fields; * is every field
+func parseCases(upper, lower, title string) []fieldMap {
+	ms := make([]fieldMap, 0)
+	for _, c := range []struct {
+		list string
+		f    func(string) string
+	}{{upper, strings.ToUpper}, {lower, strings.ToLower}, {title, titleCase}} {
+		for _, name := range splitList(c.list) {
+			if name == "*" {
+				name = ""
+			}
+			ms = append(ms, fieldMap{field: name, f: c.f})
+		}
+	}
+	return ms
+}
+
+// titleCase upper-cases the first letter of each word of s and lower-cases the rest, e.g. MARY-ANNE o'neil
+// becomes Mary-Anne O'neil.  A word starts after any character that isn't a letter, digit or apostrophe.
+func titleCase(s string) string {
+	var b strings.Builder
+	start := true
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r):
+			if start {
+				r = unicode.ToTitle(r)
+			} else {
+				r = unicode.ToLower(r)
+			}
+			start = false
+		case unicode.IsDigit(r), r == '\'', r == '\u2019':
+			start = false
+		default:
+			start = true
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// catOpts are the options of "toch cat"
+type catOpts struct {
+	out    string // file the rows are written to, stdout if ""
+	policy string // how the fields of the files are matched: first, union or strict (see -schema-policy)
+}
+
+// cat writes the rows of the files that match the pattern j.source to one CSV file with a header row.  The
+// fields of the files are matched by name, as for a multi-file load, with opts.policy
+//   - union: the fields are those of all the files, in the order they are first seen.
+//   - first: the fields are those of the first file; the fields of later files that it doesn't have are dropped.
+//   - strict: it is an error if the fields of a file differ from the first file's.
+//
+// A file without one of the fields has an empty value.  The files are read in the order of their names with the
+// options of a load that apply to reading (-type, -h, -skip, -q...).  The values are written as they are read.
+func cat(j *job, args []string, opts *catOpts) error {
+	if len(args) > 0 || j.source == "" {
+		return fmt.Errorf("usage: toch cat -s '<pattern>' [-out file] [-schema-policy union|first|strict] (and the options of a load)")
+	}
+	files, err := globFiles(j.source)
+	if err != nil {
+		return err
+	}
+
+	// the fields of each file and of the output
+	fileCols := make([][]string, len(files))
+	cols := make([]string, 0)
+	have := make(map[string]bool)
+	for ind, name := range files {
+		fj := *j
+		fj.source = name
+		if fileCols[ind] = j.headers; len(j.headers) == 0 {
+			if fileCols[ind], err = fj.fileFields(); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		if ind > 0 && opts.policy == "strict" && strings.Join(fileCols[ind], ",") != strings.Join(cols, ",") {
+			return fmt.Errorf("%s: the fields %s differ from the first file's %s", name,
+				strings.Join(fileCols[ind], ", "), strings.Join(cols, ", "))
+		}
+		if ind > 0 && opts.policy != "union" {
+			continue
+		}
+		for _, c := range fileCols[ind] {
+			if !have[c] {
+				cols, have[c] = append(cols, c), true
+			}
+		}
+	}
+
+	fds := make(map[int]*chutils.FieldDef)
+	for ind, c := range cols {
+		fds[ind] = &chutils.FieldDef{Name: c, ChSpec: chutils.ChField{Base: chutils.ChString}, Legal: &chutils.LegalValues{}}
+	}
+	spec := chutils.NewTableDef(cols[0], chutils.MergeTree, fds)
+
+	var w io.Writer = os.Stdout
+	if opts.out != "" {
+		f, e := os.Create(opts.out)
+		if e != nil {
+			return e
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+	cw := csv.NewWriter(w)
+	if e := cw.Write(cols); e != nil {
+		return e
+	}
+	total := 0
+	for ind, name := range files {
+		rows, e := catFile(j, name, fileCols[ind], spec, cw)
+		if e != nil {
+			return fmt.Errorf("%s: %v", name, e)
+		}
+		total += rows
+	}
+	cw.Flush()
+	if e := cw.Error(); e != nil {
+		return e
+	}
+	if opts.out != "" {
+		fmt.Printf("%d rows of %d files written to %s\n", total, len(files), opts.out)
+	}
+	return nil
+}
+
+// catFile writes the rows of the file name, whose fields are fileCols, to cw with the fields of spec.  The
+// fields that are dropped and missing are reported.
+func catFile(j *job, name string, fileCols []string, spec *chutils.TableDef, cw *csv.Writer) (int, error) {
+	inFile, inSpec := make(map[string]bool), make(map[string]bool)
+	for _, c := range fileCols {
+		inFile[c] = true
+	}
+	var missing, extra []string
+	for _, fd := range spec.FieldDefs {
+		inSpec[fd.Name] = true
+		if !inFile[fd.Name] {
+			missing = append(missing, fd.Name)
+		}
+	}
+	for _, c := range fileCols {
+		if !inSpec[c] {
+			extra = append(extra, c)
+		}
+	}
+	if len(extra) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: fields %s dropped\n", name, strings.Join(extra, ", "))
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: fields %s missing, left empty\n", name, strings.Join(missing, ", "))
+	}
+
+	fj := *j
+	fj.source = name
+	rdr, err := fj.newReader()
+	if err != nil {
+		return 0, err
+	}
+	in := newRemap(rdr, fileCols, spec)
+	defer func() { _ = in.Close() }()
+	rows := 0
+	for {
+		data, _, err := in.Read(1000, false)
+		for _, row := range data {
+			values := make([]string, len(row))
+			for ind, v := range row {
+				values[ind] = fmt.Sprint(v)
+			}
+			if e := cw.Write(values); e != nil {
+				return rows, e
+			}
+			rows++
+		}
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, err
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/chutils/sql"
+)
+
+// chained is a chutils.Input that returns the rows sent to it by loadTargets
+type chained struct {
+	spec *chutils.TableDef
+	rows chan chutils.Row
+	done chan struct{} // closed when the export stops reading
+}
+
+// TableSpec returns the spec of the target table
+func (c *chained) TableSpec() *chutils.TableDef {
+	return c.spec
+}
+
+// Read returns the next nTarget rows (all of them if nTarget is 0).  The rows are already validated.
+func (c *chained) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	for nTarget <= 0 || len(data) < nTarget {
+		row, ok := <-c.rows
+		if !ok {
+			if len(data) == 0 {
+				return nil, nil, io.EOF
+			}
+			break
+		}
+		status := make(chutils.Valid, len(row))
+		for ind := range status {
+			status[ind] = chutils.VPass
+		}
+		data, valid = append(data, row), append(valid, status)
+		// don't wait for more rows if there are none yet
+		if len(c.rows) == 0 {
+			break
+		}
+	}
+	return data, valid, nil
+}
+
+// Reset does nothing: the rows can be read only once
+func (c *chained) Reset() error { return nil }
+
+// CountLines is not known in advance
+func (c *chained) CountLines() (int, error) { return 0, nil }
+
+// Seek is not supported
+func (c *chained) Seek(lineNo int) error { return fmt.Errorf("cannot seek in a chained load") }
+
+// Close does nothing
+func (c *chained) Close() error { return nil }
+
+// loadTargets reads j.source once and loads each target table from it.  Each target's table is created
+// (or, with -exists append, appended to) with the selected fields.  The where expression of a target can
+// use any field of the source.
+func loadTargets(j *job, targets []target, mode string, yes bool, con *chutils.Connect) ([]fileResult, error) {
+	in, err := openReader(j, nil)
+	if err == nil && j.fresh != nil {
+		in, err = j.fresh.watch(in)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e := in.Close(); e != nil {
+			fmt.Println(e)
+		}
+	}()
+	src := in.TableSpec()
+	cols := make(map[string]int)
+	for ind, fd := range src.FieldDefs {
+		cols[fd.Name] = ind
+	}
+
+	// set up the targets
+	type dest struct {
+		in     *chained
+		pick   []int
+		tj     *job // j for the table
+		where  expr
+		env    *env
+		result fileResult
+	}
+	dests := make([]*dest, 0, len(targets))
+	for _, t := range targets {
+		d := &dest{result: fileResult{source: j.source + " -> " + t.Table}}
+		names := t.Select
+		if len(names) == 0 {
+			for ind := 0; ind < len(src.FieldDefs); ind++ {
+				names = append(names, src.FieldDefs[ind].Name)
+			}
+		}
+		fds := make(map[int]*chutils.FieldDef)
+		for ind, name := range names {
+			col, ok := cols[name]
+			if !ok {
+				return nil, fmt.Errorf("table %s: field %s is not in the data", t.Table, name)
+			}
+			fds[ind], d.pick = src.FieldDefs[col], append(d.pick, col)
+		}
+		if t.Where != "" {
+			if d.where, err = parseExpr(t.Where); err != nil {
+				return nil, fmt.Errorf("table %s: where: %v", t.Table, err)
+			}
+		}
+		d.env = &env{cols: cols, dateFmt: j.dateFmt,
+			vars: map[string]interface{}{"run_id": j.runID, "source": j.source, "now": j.now}}
+
+		if e := checkExists(t.Table, mode, yes, con); e != nil {
+			return nil, e
+		}
+		exists, _, _, e := tableInfo(t.Table, con)
+		if e != nil {
+			return nil, e
+		}
+		tj := j.target(t.Table, d.pick)
+		tj.appending = exists && mode == "append"
+		d.tj = tj
+		spec := chutils.NewTableDef(names[0], src.Engine, fds)
+		if e := tj.create(spec, con); e != nil {
+			return nil, e
+		}
+		d.in = &chained{spec: spec, rows: make(chan chutils.Row, 10*j.batch), done: make(chan struct{})}
+		dests = append(dests, d)
+	}
+
+	// the exports
+	var wg sync.WaitGroup
+	for ind, d := range dests {
+		wg.Add(1)
+		go func(d *dest, table string) {
+			defer wg.Done()
+			defer close(d.in.done)
+			s := time.Now()
+			wtr := sql.NewWriter(table, con)
+			defer func() {
+				if e := wtr.Close(); e != nil {
+					fmt.Println(e)
+				}
+			}()
+			cnt := &counter{Input: d.tj.insertable(d.in), progress: j.progress}
+			d.result.err = chutils.Export(cnt, wtr, j.batch, j.ignore)
+			d.result.rows, d.result.secs, d.result.spec = cnt.rows, time.Since(s).Seconds(), d.in.spec
+		}(d, targets[ind].Table)
+	}
+
+	// one pass over the source
+	var readErr error
+	for {
+		data, _, e := in.Read(1000, true)
+		for _, row := range data {
+			for _, d := range dests {
+				if d.where != nil {
+					d.env.row = row
+					v, e := d.where.eval(d.env)
+					if e != nil {
+						readErr = e
+						break
+					}
+					keep, e := toBool(v)
+					if e != nil {
+						readErr = e
+						break
+					}
+					if !keep {
+						continue
+					}
+				}
+				out := make(chutils.Row, len(d.pick))
+				for ind, col := range d.pick {
+					out[ind] = row[col]
+				}
+				select {
+				case d.in.rows <- out:
+				case <-d.in.done:
+				}
+			}
+			if readErr != nil {
+				break
+			}
+		}
+		if e == io.EOF || readErr != nil {
+			break
+		}
+		if e != nil {
+			readErr = e
+			break
+		}
+	}
+	for _, d := range dests {
+		close(d.in.rows)
+	}
+	wg.Wait()
+
+	results := make([]fileResult, 0, len(dests))
+	for _, d := range dests {
+		if readErr != nil && d.result.err == nil {
+			d.result.err = readErr
+		}
+		results = append(results, d.result)
+	}
+	return results, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// rowRange is the expected number of rows of a load.  max is 0 if there is no maximum.
+type rowRange struct {
+	min, max int
+}
+
+// parseRowRange parses -expect-rows, which is min:max, where either may be omitted (e.g. 1000000: or :5000)
+func parseRowRange(expect string) (*rowRange, error) {
+	if expect == "" {
+		return nil, nil
+	}
+	mm := strings.Split(expect, ":")
+	if len(mm) != 2 {
+		return nil, fmt.Errorf("-expect-rows is min:max, min: or :max")
+	}
+	r := &rowRange{}
+	for ind, p := range []*int{&r.min, &r.max} {
+		s := strings.TrimSpace(mm[ind])
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("-expect-rows: %s is not a row count", s)
+		}
+		*p = n
+	}
+	if r.max > 0 && r.min > r.max {
+		return nil, fmt.Errorf("-expect-rows: %d is more than %d", r.min, r.max)
+	}
+	return r, nil
+}
+
+// check returns an error if rows is outside the range
+func (r *rowRange) check(table string, rows int) error {
+	if rows < r.min || (r.max > 0 && rows > r.max) {
+		return fmt.Errorf("%d rows loaded into %s, expected %s", rows, table, r)
+	}
+	return nil
+}
+
+// String returns the range as it's written to -expect-rows
+func (r *rowRange) String() string {
+	switch {
+	case r.max == 0:
+		return fmt.Sprintf("at least %d", r.min)
+	case r.min == 0:
+		return fmt.Sprintf("at most %d", r.max)
+	}
+	return fmt.Sprintf("%d to %d", r.min, r.max)
+}
+
+// expectRows checks the rows loaded against -expect-rows.  The rows of a chained load are checked for each
+// target table, otherwise the total of the files is.
+func expectRows(r *rowRange, results []fileResult, tables []string, chained bool) error {
+	if !chained {
+		rows := 0
+		for _, res := range results {
+			rows += res.rows
+		}
+		return r.check(tables[0], rows)
+	}
+	for ind, res := range results {
+		if err := r.check(tables[ind], res.rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRequired returns an error if any of the -require-cols fields is not among the fields of j.source.  The
+// fields are those given by -h or read from the header and named as they are in the table.
+func (j *job) checkRequired() error {
+	if len(j.required) == 0 {
+		return nil
+	}
+	names := j.headers
+	if len(names) == 0 {
+		var err error
+		if names, err = j.fileFields(); err != nil {
+			return err
+		}
+	}
+	have := make(map[string]bool)
+	for _, name := range names {
+		have[name] = true
+	}
+	missing := make([]string, 0)
+	for _, name := range j.required {
+		if !have[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is missing the required fields %s", j.source, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// freshness is the -expect-max-date check: an expression on a date field that must be true of the newest date
+// loaded.
+type freshness struct {
+	src  string // the expression
+	x    expr
+	name string // the date field
+
+	mu     sync.Mutex
+	newest time.Time
+}
+
+// parseFreshness parses -expect-max-date.  The expression must use exactly one field, the date field.
+func parseFreshness(src string) (*freshness, error) {
+	if src == "" {
+		return nil, nil
+	}
+	x, err := parseExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("-expect-max-date: %v", err)
+	}
+	names := fieldsOf(x, nil)
+	if len(names) != 1 {
+		return nil, fmt.Errorf("-expect-max-date must refer to one date field, e.g. 'asof >= today()-3'")
+	}
+	return &freshness{src: src, x: x, name: names[0]}, nil
+}
+
+// fieldsOf appends the fields the expression x refers to to names
+func fieldsOf(x expr, names []string) []string {
+	switch n := x.(type) {
+	case *field:
+		if !isIn(&n.name, names, false) {
+			names = append(names, n.name)
+		}
+	case *unary:
+		names = fieldsOf(n.x, names)
+	case *binary:
+		names = fieldsOf(n.r, fieldsOf(n.l, names))
+	case *call:
+		for _, a := range n.args {
+			names = fieldsOf(a, names)
+		}
+	}
+	return names
+}
+
+// watch returns rdr wrapped so that the newest date of the field is recorded as the rows are read
+func (f *freshness) watch(rdr chutils.Input) (chutils.Input, error) {
+	col, _, err := rdr.TableSpec().Get(f.name)
+	if err != nil {
+		return nil, fmt.Errorf("-expect-max-date: %s is not a field", f.name)
+	}
+	return &dateWatch{Input: rdr, f: f, col: col}, nil
+}
+
+// check returns an error if the expression is false for the newest date loaded
+func (f *freshness) check(dateFmt string) error {
+	if f.newest.IsZero() {
+		return fmt.Errorf("-expect-max-date: no dates in %s were loaded", f.name)
+	}
+	e := &env{cols: map[string]int{f.name: 0}, row: []interface{}{f.newest}, dateFmt: dateFmt}
+	v, err := f.x.eval(e)
+	if err != nil {
+		return fmt.Errorf("-expect-max-date: %v", err)
+	}
+	ok, err := toBool(v)
+	if err != nil {
+		return fmt.Errorf("-expect-max-date: %v", err)
+	}
+	if !ok {
+		return fmt.Errorf("the data is stale: the newest %s is %s and %s is false", f.name,
+			f.newest.Format("2006-01-02"), f.src)
+	}
+	return nil
+}
+
+// dateWatch is a chutils.Input that records the newest value of a date field
+type dateWatch struct {
+	chutils.Input
+	f   *freshness
+	col int
+}
+
+// Read reads from the underlying Input and records the newest date of the validated rows
+func (d *dateWatch) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	data, valid, err = d.Input.Read(nTarget, validate)
+	if !validate {
+		return data, valid, err
+	}
+	d.f.mu.Lock()
+	defer d.f.mu.Unlock()
+	for ind, row := range data {
+		if ind >= len(valid) || d.col >= len(row) || d.col >= len(valid[ind]) || valid[ind][d.col] != chutils.VPass {
+			continue
+		}
+		if t, ok := row[d.col].(time.Time); ok && t.After(d.f.newest) {
+			d.f.newest = t
+		}
+	}
+	return data, valid, err
+}
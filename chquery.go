@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/chutils/sql"
+)
+
+// newQuery creates a reader for the results of j.query run on the ClickHouse server j.src and creates j.table
+// on con to hold them.  The field types come from the query unless they are supplied by the user.
+func newQuery(j *job, con *chutils.Connect) (*sql.Reader, error) {
+	rdr := sql.NewReader(j.query, j.src)
+	if err := rdr.Init("", chutils.MergeTree); err != nil {
+		return nil, err
+	}
+
+	spec := rdr.TableSpec()
+	if len(j.headers) > 0 {
+		if len(j.headers) != len(spec.FieldDefs) {
+			return nil, fmt.Errorf("supplied headers have length %d, query has %d columns", len(j.headers), len(spec.FieldDefs))
+		}
+		for ind, fd := range spec.FieldDefs {
+			fd.Name = j.headers[ind]
+		}
+	}
+	j.nameFields(spec)
+
+	if j.typed() {
+		if err := j.setTypes(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := j.create(spec, con); err != nil {
+		return nil, err
+	}
+	return rdr, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardSource is the -s of the system clipboard
+const clipboardSource = "clipboard"
+
+// clipboardCommands are the commands that print the clipboard, by OS, tried in order
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbpaste"}},
+	"windows": {{"powershell", "-NoProfile", "-Command", "Get-Clipboard"}},
+	"linux":   {{"wl-paste", "--no-newline"}, {"xclip", "-selection", "clipboard", "-o"}, {"xsel", "--clipboard", "--output"}},
+}
+
+// spoolClipboard copies the system clipboard to a temporary text file and returns its name.  A range copied from
+// a spreadsheet or a web page is tab-separated text, which -type auto recognizes.  The caller removes the file.
+func spoolClipboard() (string, error) {
+	cmds := clipboardCommands[runtime.GOOS]
+	if len(cmds) == 0 {
+		cmds = clipboardCommands["linux"]
+	}
+	var tried []string
+	for _, c := range cmds {
+		if _, err := exec.LookPath(c[0]); err != nil {
+			tried = append(tried, c[0])
+			continue
+		}
+		out, err := exec.Command(c[0], c[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("reading the clipboard with %s: %v", c[0], err)
+		}
+		if strings.TrimSpace(string(out)) == "" {
+			return "", fmt.Errorf("the clipboard is empty")
+		}
+		tmp, err := os.CreateTemp("", "toch-clipboard-*.txt")
+		if err != nil {
+			return "", err
+		}
+		_, err = tmp.Write(out)
+		if e := tmp.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return "", err
+		}
+		fmt.Printf("clipboard: %d bytes read\n", len(out))
+		return tmp.Name(), nil
+	}
+	return "", fmt.Errorf("-s clipboard needs one of %s on the PATH", strings.Join(tried, ", "))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTypes are the -t codes of times of day and durations.  Both are loaded as Int64 seconds.
+var clockTypes = map[string]func(string) (int64, error){
+	"tm":  timeOfDay,
+	"dur": duration,
+}
+
+// hmsRe matches h:mm, h:mm:ss and h:mm:ss.fff, with any number of hours
+var hmsRe = regexp.MustCompile(`^(-?)(\d+):([0-5]\d)(?::([0-5]\d)(\.\d+)?)?$`)
+
+// isoDurRe matches an ISO 8601 duration of days, hours, minutes and seconds, e.g. PT1H23M45S
+var isoDurRe = regexp.MustCompile(`^(-?)P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// hms returns the seconds of h:mm[:ss[.fff]], rounded to the nearest second.  ok is false if s isn't of that form.
+func hms(s string) (secs int64, ok bool) {
+	m := hmsRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	h, _ := strconv.ParseInt(m[2], 10, 64)
+	mi, _ := strconv.ParseInt(m[3], 10, 64)
+	var sec float64
+	if m[4] != "" {
+		sec, _ = strconv.ParseFloat(m[4]+m[5], 64)
+	}
+	secs = h*3600 + mi*60 + int64(sec+0.5)
+	if m[1] == "-" {
+		secs = -secs
+	}
+	return secs, true
+}
+
+// timeOfDay returns the seconds since midnight of the time of day s: 15:04, 15:04:05 (with any fraction of a
+// second) or 3:04 PM.  Hours past 23, as transit timetables use for trips after midnight, are allowed.
+func timeOfDay(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if secs, ok := hms(s); ok && secs >= 0 {
+		return secs, nil
+	}
+	for _, format := range []string{"3:04 PM", "3:04:05 PM", "3:04PM", "3:04:05PM"} {
+		if t, err := time.Parse(format, strings.ToUpper(s)); err == nil {
+			return int64(t.Hour()*3600 + t.Minute()*60 + t.Second()), nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a time of day", s)
+}
+
+// duration returns the seconds of the duration s: h:mm:ss or h:mm (with any number of hours), an ISO 8601
+// duration such as PT1H23M45S, a Go duration such as 1h23m45s or a number of seconds.
+func duration(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if secs, ok := hms(s); ok {
+		return secs, nil
+	}
+	if u := strings.ToUpper(s); u != "P" && u != "PT" {
+		if m := isoDurRe.FindStringSubmatch(u); m != nil {
+			var secs float64
+			for ind, mult := range []float64{86400, 3600, 60, 1} {
+				if m[ind+2] != "" {
+					v, _ := strconv.ParseFloat(m[ind+2], 64)
+					secs += v * mult
+				}
+			}
+			if m[1] == "-" {
+				secs = -secs
+			}
+			return int64(math.Round(secs)), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return int64(d.Round(time.Second) / time.Second), nil
+	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return int64(math.Round(v)), nil
+	}
+	return 0, fmt.Errorf("%q is not a duration", s)
+}
+
+// hasClock returns true if the job has time of day or duration fields
+func (j *job) hasClock() bool {
+	if _, ok := clockTypes[j.allTypes]; ok {
+		return true
+	}
+	for _, t := range j.fieldTypes {
+		if _, ok := clockTypes[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// clockStep returns a step that converts the time of day and duration fields to seconds.  A value that doesn't
+// convert is left as it is, so it fails validation as an Int64.
+func (j *job) clockStep(nSrc int) step {
+	return func(row []interface{}) (bool, error) {
+		for ind := 0; ind < nSrc; ind++ {
+			conv, ok := clockTypes[j.typeOf(ind)]
+			if !ok {
+				continue
+			}
+			s, ok := row[ind].(string)
+			if !ok || strings.TrimSpace(s) == "" {
+				continue
+			}
+			if secs, e := conv(s); e == nil {
+				row[ind] = strconv.FormatInt(secs, 10)
+			}
+		}
+		return true, nil
+	}
+}
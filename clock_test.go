@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestClockTypes(t *testing.T) {
+	// bad marks an input the type must reject
+	const bad = int64(-999)
+	tests := []struct {
+		code string
+		in   string
+		want int64
+	}{
+		{"tm", "00:00", 0},
+		{"tm", "15:04", 54240},
+		{"tm", "15:04:05", 54245},
+		{"tm", "15:04:05.6", 54246},
+		{"tm", "25:30:00", 91800},
+		{"tm", "3:04 pm", 54240},
+		{"tm", "12:00:01AM", 1},
+		{"tm", "-1:00", bad},
+		{"tm", "15:60", bad},
+		{"tm", "noon", bad},
+
+		{"dur", "1:23:45", 5025},
+		{"dur", "-0:30", -1800},
+		{"dur", "100:00", 360000},
+		{"dur", "PT1H23M45S", 5025},
+		{"dur", "p1dt0.5s", 86401},
+		{"dur", "-PT90M", -5400},
+		{"dur", "P2D", 172800},
+		{"dur", "1h23m45s", 5025},
+		{"dur", "1500ms", 2},
+		{"dur", "42.4", 42},
+		{"dur", "PT", bad},
+		{"dur", "P1Y", bad},
+		{"dur", "an hour", bad},
+	}
+	for _, tt := range tests {
+		got, err := clockTypes[tt.code](tt.in)
+		switch {
+		case tt.want == bad && err == nil:
+			t.Errorf("%s %q = %d, want an error", tt.code, tt.in, got)
+		case tt.want != bad && (err != nil || got != tt.want):
+			t.Errorf("%s %q = %d, %v; want %d", tt.code, tt.in, got, err, tt.want)
+		}
+	}
+}
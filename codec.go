@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// codecRe matches a ClickHouse compression codec, e.g. ZSTD(3) or Delta
+var codecRe = regexp.MustCompile(`(?i)^(NONE|LZ4|LZ4HC|ZSTD|Delta|DoubleDelta|Gorilla|T64|FPC)(\(\d+\))?$`)
+
+// parseCodecs parses -codec: items field=codecs separated by ;, where codecs is a comma-separated chain such
+// as Delta,ZSTD(3).  The field * is every field without its own codec.
+func parseCodecs(list string) (map[string]string, error) {
+	codecs := make(map[string]string)
+	for _, item := range strings.Split(list, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, chain, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("-codec: expected field=codec, got %s", item)
+		}
+		parts := splitList(chain)
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("-codec: no codec for %s", name)
+		}
+		for _, c := range parts {
+			if !codecRe.MatchString(c) {
+				return nil, fmt.Errorf("-codec: %s is not a codec", c)
+			}
+		}
+		codecs[strings.Trim(strings.TrimSpace(name), "`")] = strings.Join(parts, ", ")
+	}
+	return codecs, nil
+}
+
+// codecOf returns the -codec of the field name, "" if it has none
+func (j *job) codecOf(name string) string {
+	if c, ok := j.codecs[name]; ok {
+		return c
+	}
+	return j.codecs["*"]
+}
+
+// checkCodecs checks that the fields given -codec codecs are fields of spec
+func (j *job) checkCodecs(spec *chutils.TableDef) error {
+	for name := range j.codecs {
+		if _, _, err := spec.Get(name); err != nil && name != "*" {
+			return fmt.Errorf("-codec: %s is not a field", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestParseCodecs(t *testing.T) {
+	codecs, err := parseCodecs("ts=Delta, ZSTD(3); `amt`=gorilla;*=LZ4HC(9);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := &job{codecs: codecs}
+	for name, want := range map[string]string{"ts": "Delta, ZSTD(3)", "amt": "gorilla", "other": "LZ4HC(9)"} {
+		if got := j.codecOf(name); got != want {
+			t.Errorf("codecOf(%s) = %q, want %q", name, got, want)
+		}
+	}
+	if got := (&job{}).codecOf("ts"); got != "" {
+		t.Errorf("no -codec: codecOf(ts) = %q", got)
+	}
+
+	for _, list := range []string{"ts", "ts=", "ts=Brotli", "ts=ZSTD(a)", "ts=ZSTD 3", "ts=Delta;amt=zstd()"} {
+		if c, e := parseCodecs(list); e == nil {
+			t.Errorf("parseCodecs(%s) = %v, want an error", list, c)
+		}
+	}
+}
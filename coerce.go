@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// kinds are the kinds of value a coercion rule can list
+var kinds = []string{"int", "float", "date", "string", "empty"}
+
+// coercion is a rule of the coercion section of a config file: a field whose values are of exactly the kinds
+// Observed gets the ClickHouse type Type, e.g. [int, float] -> Float64.
+type coercion struct {
+	Observed []string `yaml:"observed"`
+	Type     string   `yaml:"type"`
+}
+
+// check validates the rule
+func (c *coercion) check() error {
+	if len(c.Observed) == 0 {
+		return fmt.Errorf("observed is empty")
+	}
+	for ind := range c.Observed {
+		if !isIn(&c.Observed[ind], kinds, true) {
+			return fmt.Errorf("%s is not one of %s", c.Observed[ind], strings.Join(kinds, ", "))
+		}
+	}
+	if !baseField(&chutils.FieldDef{Legal: &chutils.LegalValues{}}, c.Type, "") {
+		return fmt.Errorf("type %s is not supported", c.Type)
+	}
+	return nil
+}
+
+// key returns the kinds of the rule, sorted and joined
+func (c *coercion) key() string {
+	return kindKey(c.Observed)
+}
+
+// kindKey returns the distinct kinds, sorted and joined with +
+func kindKey(ks []string) string {
+	seen := make(map[string]bool)
+	out := make([]string, 0)
+	for _, k := range ks {
+		if !seen[k] {
+			seen[k] = true
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return strings.Join(out, "+")
+}
+
+// kindOf returns the kind of the raw value v
+func kindOf(v interface{}, dateFmt string) string {
+	s := strings.TrimSpace(fmt.Sprint(v))
+	if v == nil || s == "" {
+		return "empty"
+	}
+	if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return "int"
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return "float"
+	}
+	if _, err := time.Parse(dateFmt, s); err == nil {
+		return "date"
+	}
+	return "string"
+}
+
+// coerce applies the coercion rules to the fields of in.  It reads the raw values of in to find the kinds of
+// value of each field and then resets it.  A field whose kinds match a rule gets the rule's type (the first
+// rule, if several match), replacing the imputed type.  The fields retyped are reported.
+func coerce(in chutils.Input, rules []coercion, dateFmt string) error {
+	spec := in.TableSpec()
+	observed := make([]map[string]bool, len(spec.FieldDefs))
+	for ind := range observed {
+		observed[ind] = make(map[string]bool)
+	}
+	if err := in.Reset(); err != nil {
+		return err
+	}
+	for {
+		data, _, err := in.Read(1000, false)
+		for _, row := range data {
+			for ind, v := range row {
+				if ind < len(observed) {
+					observed[ind][kindOf(v, dateFmt)] = true
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := in.Reset(); err != nil {
+		return err
+	}
+
+	for ind, fd := range spec.FieldDefs {
+		ks := make([]string, 0)
+		for k := range observed[ind] {
+			ks = append(ks, k)
+		}
+		key := kindKey(ks)
+		for _, r := range rules {
+			if r.key() != key {
+				continue
+			}
+			was := chType(fd)
+			if err := setType(fd, r.Type, dateFmt); err != nil {
+				return fmt.Errorf("field %s: %v", fd.Name, err)
+			}
+			fmt.Printf("%s: %s -> %s (values are %s)\n", fd.Name, was, chType(fd), key)
+			break
+		}
+	}
+	return nil
+}
+
+// outerFunc returns the chutils wrapper of the ClickHouse type name
+func outerFunc(name string) (chutils.OuterFunc, error) {
+	switch name {
+	case "Nullable":
+		return chutils.OuterNullable, nil
+	case "LowCardinality":
+		return chutils.OuterLowCardinality, nil
+	case "Array":
+		return chutils.OuterArray, nil
+	}
+	return 0, fmt.Errorf("%s is not a type toch can wrap a field in", name)
+}
+
+// setType sets the type of fd to the ClickHouse type t, which baseField supports, and the value illegal values
+// are filled in with.  The Nullable and LowCardinality wrappers of t are kept.
+func setType(fd *chutils.FieldDef, t, dateFmt string) error {
+	fd.ChSpec.Funcs = nil
+	for m := wrapRe.FindStringSubmatch(t); m != nil; m = wrapRe.FindStringSubmatch(m[2]) {
+		f, err := outerFunc(m[1])
+		if err != nil {
+			return err
+		}
+		fd.ChSpec.Funcs = append(fd.ChSpec.Funcs, f)
+	}
+	fd.ChSpec.Length, fd.ChSpec.Format = 0, ""
+	if fd.Legal != nil {
+		fd.Legal.LowLimit, fd.Legal.HighLimit = nil, nil
+	}
+	baseField(fd, t, dateFmt)
+	switch fd.ChSpec.Base {
+	case chutils.ChInt:
+		fd.Missing = int(math.MaxInt64 >> (64 - fd.ChSpec.Length))
+		// that of an unsigned type is its largest value
+		if fd.Legal != nil {
+			if hi, ok := fd.Legal.HighLimit.(int64); ok {
+				fd.Missing = int(hi)
+			}
+		}
+	case chutils.ChFloat:
+		fd.Missing = math.MaxFloat64
+		if fd.ChSpec.Length == 32 {
+			fd.Missing = math.MaxFloat32
+		}
+	case chutils.ChDate:
+		fd.Missing = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	case chutils.ChFixedString:
+		fd.Missing = ""
+	default:
+		fd.Missing = "!"
+	}
+	for _, f := range fd.ChSpec.Funcs {
+		if f == chutils.OuterNullable {
+			fd.Missing = nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/invertedv/chutils"
+)
+
+func TestSetTypeWrappers(t *testing.T) {
+	tests := []struct {
+		chType  string
+		funcs   chutils.OuterFuncs
+		missing interface{}
+	}{
+		{"Int32", nil, 2147483647},
+		{"Nullable(Int32)", chutils.OuterFuncs{chutils.OuterNullable}, nil},
+		{"LowCardinality(String)", chutils.OuterFuncs{chutils.OuterLowCardinality}, "!"},
+		{"LowCardinality(Nullable(String))", chutils.OuterFuncs{chutils.OuterLowCardinality, chutils.OuterNullable}, nil},
+	}
+	for _, tt := range tests {
+		fd := &chutils.FieldDef{Legal: &chutils.LegalValues{}}
+		if err := setType(fd, tt.chType, ""); err != nil {
+			t.Errorf("setType(%s): %v", tt.chType, err)
+			continue
+		}
+		if chType(fd) != tt.chType || len(fd.ChSpec.Funcs) != len(tt.funcs) || fd.Missing != tt.missing {
+			t.Errorf("setType(%s) = %s %v, missing %v", tt.chType, chType(fd), fd.ChSpec.Funcs, fd.Missing)
+			continue
+		}
+		for ind, f := range tt.funcs {
+			if fd.ChSpec.Funcs[ind] != f {
+				t.Errorf("setType(%s): wrapper %d is %s, want %s", tt.chType, ind, fd.ChSpec.Funcs[ind], f)
+			}
+		}
+	}
+	for _, name := range []string{"Nullable", "LowCardinality", "Array"} {
+		if _, err := outerFunc(name); err != nil {
+			t.Errorf("outerFunc(%s): %v", name, err)
+		}
+	}
+	if _, err := outerFunc("Map"); err == nil {
+		t.Errorf("outerFunc(Map): no error")
+	}
+}
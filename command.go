@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// commands are the toch commands that take the usual options.
+var commands = []string{"undo", "serve", "sample", "diff", "selftest", "split", "cat"}
+
+// loadCommands are the commands that read a source, so they take the options of a load.  The other commands
+// take only the connection options.
+var loadCommands = []string{"sample", "diff", "selftest", "split", "cat"}
+
+// command removes the command name and its arguments from os.Args so the options can be parsed by flag.
+// Options may come before or after the arguments.  If the first argument isn't a command, "" is returned.
+func command() (cmd string, args []string) {
+	if len(os.Args) < 2 || !isIn(&os.Args[1], commands, false) {
+		return "", nil
+	}
+	cmd = os.Args[1]
+	rest := make([]string, 0)
+	for ind := 2; ind < len(os.Args); ind++ {
+		a := os.Args[ind]
+		if !strings.HasPrefix(a, "-") {
+			args = append(args, a)
+			continue
+		}
+		rest = append(rest, a)
+		// the option's value
+		if !strings.Contains(a, "=") && ind+1 < len(os.Args) && !strings.HasPrefix(os.Args[ind+1], "-") && !isBoolFlag(a) {
+			rest = append(rest, os.Args[ind+1])
+			ind++
+		}
+	}
+	os.Args = append(os.Args[:1], rest...)
+	return cmd, args
+}
+
+// isBoolFlag returns true if the option a doesn't take a value
+func isBoolFlag(a string) bool {
+	return strings.TrimLeft(a, "-") == "yes"
+}
+
+// cmdOptions are the options the commands use
+type cmdOptions struct {
+	backupLog string        // -backup-log
+	listen    string        // -listen
+	drain     time.Duration // -drain-timeout
+	journal   bool          // -journal
+	jobsDB    string        // -jobs-db
+	maxJobs   int           // -max-concurrent-jobs
+	token     string        // -serve-token
+	root      string        // -serve-root
+}
+
+// runCommand runs the toch command cmd
+func runCommand(cmd string, args []string, cs *connSpec, opts *cmdOptions) error {
+	con, err := cs.connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := con.Close(); e != nil {
+			fmt.Println(e)
+		}
+	}()
+
+	switch cmd {
+	case "undo":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: toch undo <run id>")
+		}
+		return undo(args[0], opts.backupLog, con)
+	case "serve":
+		return serve(opts, con)
+	}
+	return fmt.Errorf("unknown command %s", cmd)
+}
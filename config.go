@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is a load described in a YAML file given by -config
+type config struct {
+	Options  map[string]string `yaml:"options"`  // command line options, without the -
+	Targets  []target          `yaml:"targets"`  // tables loaded in one pass over the source
+	Coercion []coercion        `yaml:"coercion"` // types of the fields whose values are of mixed kinds
+}
+
+// target is a table loaded from the source.  It gets the fields in Select (all of them if Select is empty)
+// of the rows for which Where is true (all of them if Where is empty).
+type target struct {
+	Table  string   `yaml:"table"`
+	Select []string `yaml:"select"`
+	Where  string   `yaml:"where"`
+}
+
+// loadConfig reads the config file fileName
+func loadConfig(fileName string) (*config, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	if e := yaml.Unmarshal(b, c); e != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, e)
+	}
+	for ind, t := range c.Targets {
+		if t.Table == "" {
+			return nil, fmt.Errorf("%s: target %d has no table", fileName, ind+1)
+		}
+	}
+	for ind := range c.Coercion {
+		if e := c.Coercion[ind].check(); e != nil {
+			return nil, fmt.Errorf("%s: coercion rule %d: %v", fileName, ind+1, e)
+		}
+	}
+	return c, nil
+}
+
+// apply sets the command line options that are in the config file but were not given on the command line
+func (c *config) apply() error {
+	return setDefaults(c.Options, "config file")
+}
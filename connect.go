@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/invertedv/chutils"
+)
+
+// connSpec describes a connection to a ClickHouse server
+type connSpec struct {
+	host     string
+	port     int
+	tls      bool
+	user     string
+	password string
+	settings clickhouse.Settings
+	compress string // compression of the blocks sent: none, lz4 or zstd
+	level    int    // compression level of zstd, 0 for the default
+}
+
+// compressions are the methods of -compress
+var compressions = map[string]clickhouse.CompressionMethod{
+	"none": clickhouse.CompressionNone,
+	"lz4":  clickhouse.CompressionLZ4,
+	"zstd": clickhouse.CompressionZSTD,
+}
+
+// connect opens a connection to the ClickHouse server.  The options are built here rather than by
+// chutils.NewConnect, which always uses port 9000 and LZ4, so that -port, -tls and -compress are honoured.
+func (cs *connSpec) connect() (*chutils.Connect, error) {
+	opts := &clickhouse.Options{
+		Addr:        []string{fmt.Sprintf("%s:%d", cs.host, cs.port)},
+		Auth:        clickhouse.Auth{Database: "default", Username: cs.user, Password: cs.password},
+		Settings:    cs.settings,
+		DialTimeout: 300 * time.Second,
+	}
+	if cs.compress != "none" {
+		opts.Compression = &clickhouse.Compression{Method: compressions[cs.compress], Level: cs.level}
+	}
+	if cs.tls {
+		opts.TLS = &tls.Config{ServerName: cs.host}
+	}
+	db := clickhouse.OpenDB(opts)
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	return &chutils.Connect{Host: cs.host, User: cs.user, Password: cs.password, DB: db}, nil
+}
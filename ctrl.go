@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// scrubber returns a function that replaces the control characters selected by policy with replace.
+// policy is "none", "all" (every Unicode control character, including tab and newline) or a comma-separated
+// list of character codes, e.g. '0x1e,0x1f' or '30,31'.  It returns nil for "none".
+func scrubber(policy, replace string) (func(string) string, error) {
+	var strip func(r rune) bool
+	switch strings.ToLower(policy) {
+	case "none", "":
+		return nil, nil
+	case "all":
+		strip = unicode.IsControl
+	default:
+		codes := make(map[rune]bool)
+		for _, c := range splitList(policy) {
+			n, err := strconv.ParseInt(c, 0, 32)
+			if err != nil || !unicode.IsControl(rune(n)) {
+				return nil, fmt.Errorf("-strip-ctrl: %s is not the code of a control character", c)
+			}
+			codes[rune(n)] = true
+		}
+		strip = func(r rune) bool { return codes[r] }
+	}
+
+	return func(s string) string {
+		if strings.IndexFunc(s, strip) < 0 {
+			return s
+		}
+		var b strings.Builder
+		for _, r := range s {
+			if strip(r) {
+				b.WriteString(replace)
+				continue
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	}, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateTimeType is a ClickHouse DateTime type that can be given with -t
+type dateTimeType struct {
+	ch        string // ClickHouse type
+	precision int    // digits of the fraction of a second
+}
+
+// dateTimeTypes are the -t codes of the DateTime types.  The times are stored in UTC.
+var dateTimeTypes = map[string]dateTimeType{
+	"dt":  {"DateTime('UTC')", 0},
+	"dt3": {"DateTime64(3, 'UTC')", 3},
+	"dt6": {"DateTime64(6, 'UTC')", 6},
+}
+
+// zeroTime is the value of a missing or invalid DateTime
+const zeroTime = "1970-01-01 00:00:00"
+
+// dateTimeLayouts are the formats -datetime-format auto tries, in order
+var dateTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700", // Snowflake's TIMESTAMP_TZ and TIMESTAMP_LTZ
+	"2006-01-02 15:04:05.999999999 MST",   // BigQuery's TIMESTAMP, in UTC
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"1/2/2006 15:04:05.999999999",
+	"1/2/2006 3:04:05 PM",
+	"1/2/2006 15:04",
+	"1/2/2006 3:04 PM",
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02",
+}
+
+// parseDateTime parses s with layout, which is a Go time layout or
+//   - auto: one of dateTimeLayouts or a Unix time in seconds, milliseconds, microseconds or nanoseconds,
+//     told apart by the number of digits (10, 13, 16 or 19).
+//   - epoch, epoch_ms: a Unix time in seconds or milliseconds.
+//
+// Times without a time zone are taken to be UTC.
+func parseDateTime(s, layout string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	switch layout {
+	case "epoch", "epoch_ms":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			if layout == "epoch_ms" {
+				return time.UnixMilli(n).UTC(), nil
+			}
+			return time.Unix(n, 0).UTC(), nil
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not a Unix time", s)
+		}
+		if layout == "epoch_ms" {
+			v /= 1000
+		}
+		sec, frac := math.Modf(v)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))).UTC(), nil
+	case "auto":
+		digits := strings.TrimPrefix(s, "-")
+		if _, err := strconv.ParseInt(digits, 10, 64); err == nil {
+			n, _ := strconv.ParseInt(s, 10, 64)
+			switch len(digits) {
+			case 10:
+				return time.Unix(n, 0).UTC(), nil
+			case 13:
+				return time.UnixMilli(n).UTC(), nil
+			case 16:
+				return time.UnixMicro(n).UTC(), nil
+			case 19:
+				return time.Unix(0, n).UTC(), nil
+			}
+		}
+		for _, l := range dateTimeLayouts {
+			if t, err := time.Parse(l, s); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("%q is not a date and time", s)
+	default:
+		t, err := time.Parse(layout, s)
+		return t.UTC(), err
+	}
+}
+
+// formatDateTime formats t as ClickHouse reads a DateTime with precision digits of the fraction of a second
+func formatDateTime(t time.Time, precision int) string {
+	if precision == 0 {
+		return t.Format("2006-01-02 15:04:05")
+	}
+	return t.Format("2006-01-02 15:04:05." + strings.Repeat("0", precision))
+}
+
+// hasDateTime returns true if the job has DateTime fields
+func (j *job) hasDateTime() bool {
+	if _, ok := dateTimeTypes[j.allTypes]; ok {
+		return true
+	}
+	for _, t := range j.fieldTypes {
+		if _, ok := dateTimeTypes[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dateTimeStep returns a step that converts the DateTime fields to ClickHouse's text format.  An empty or
+// invalid value is loaded as 1970-01-01 00:00:00.
+func (j *job) dateTimeStep(nSrc int) step {
+	return func(row []interface{}) (bool, error) {
+		for ind := 0; ind < nSrc; ind++ {
+			dt, ok := dateTimeTypes[j.typeOf(ind)]
+			if !ok {
+				continue
+			}
+			v := zeroTime
+			if t, e := parseDateTime(fmt.Sprint(row[ind]), j.dateTimeFmt); e == nil {
+				v = formatDateTime(t, dt.precision)
+			}
+			row[ind] = v
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// decimal is a ClickHouse Decimal(precision, scale) type
+type decimal struct {
+	precision int // digits in all
+	scale     int // digits after the decimal point
+}
+
+// decRe matches the -t code dec(P,S)
+var decRe = regexp.MustCompile(`^dec\((\d+),(\d+)\)$`)
+
+// decValueRe matches a plain decimal number, with its digits before and after the point
+var decValueRe = regexp.MustCompile(`^[+-]?(\d*)(?:\.(\d+))?$`)
+
+// parseDecimal returns the decimal type of the -t code t, ok is false if t isn't dec(P,S)
+func parseDecimal(t string) (d decimal, ok bool, err error) {
+	m := decRe.FindStringSubmatch(t)
+	if m == nil {
+		return decimal{}, false, nil
+	}
+	d.precision, _ = strconv.Atoi(m[1])
+	d.scale, _ = strconv.Atoi(m[2])
+	if d.precision < 1 || d.precision > 76 || d.scale > d.precision {
+		return decimal{}, true, fmt.Errorf("%s: the precision is 1 to 76 and the scale is 0 to the precision", t)
+	}
+	return d, true, nil
+}
+
+// String returns the ClickHouse type of d
+func (d decimal) String() string {
+	return fmt.Sprintf("Decimal(%d, %d)", d.precision, d.scale)
+}
+
+// decimalOf returns the decimal type of field ind: from -t, or found by -infer-decimal
+func (j *job) decimalOf(ind int) (decimal, bool) {
+	if d, ok, _ := parseDecimal(j.typeOf(ind)); ok {
+		return d, true
+	}
+	d, ok := j.decimals[ind]
+	return d, ok
+}
+
+// hasDecimal returns true if the job has Decimal fields or may find them
+func (j *job) hasDecimal() bool {
+	if j.inferDecimal || len(j.decimals) > 0 {
+		return true
+	}
+	if _, ok, _ := parseDecimal(j.allTypes); ok {
+		return true
+	}
+	for _, t := range j.fieldTypes {
+		if _, ok, _ := parseDecimal(t); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// decimalStep returns a step that rounds the values of the Decimal fields to their scales, exactly, as text,
+// which is inserted as it is (see insertValue).  A value that isn't a number, or has too many digits, is loaded
+// as 0.
+func (j *job) decimalStep(nSrc int) step {
+	return func(row []interface{}) (bool, error) {
+		for ind := 0; ind < nSrc; ind++ {
+			d, ok := j.decimalOf(ind)
+			if !ok {
+				continue
+			}
+			row[ind] = d.format(fmt.Sprint(row[ind]))
+		}
+		return true, nil
+	}
+}
+
+// format returns s rounded to the scale of d, or 0 if it isn't a number that fits d
+func (d decimal) format(s string) string {
+	s = strings.TrimSpace(s)
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || s == "" {
+		return "0"
+	}
+	v := r.FloatString(d.scale)
+	digits := strings.TrimLeft(strings.Replace(strings.TrimLeft(v, "+-"), ".", "", 1), "0")
+	if len(digits) > d.precision {
+		return "0"
+	}
+	return v
+}
+
+// inferDecimals finds the Float64 fields of in whose values are all written with the same number of digits
+// after the decimal point, e.g. prices such as 12.50 and 3.25, and makes them Decimals with that scale.  The
+// precision is 18 or, if the values need more digits, 38.  Only the first nSrc fields, those of the source, are
+// looked at, and the first field, the table key, is left alone.  It reads in to find the values and then resets
+// it.  The fields changed are reported.
+func (j *job) inferDecimals(in chutils.Input, nSrc int) error {
+	spec := in.TableSpec()
+	scale, digits := make(map[int]int), make(map[int]int)
+	for ind := 1; ind < nSrc; ind++ {
+		if fd := spec.FieldDefs[ind]; fd.ChSpec.Base == chutils.ChFloat && !fd.Drop {
+			scale[ind] = -1
+		}
+	}
+	if len(scale) == 0 {
+		return nil
+	}
+
+	if err := in.Reset(); err != nil {
+		return err
+	}
+	for {
+		data, _, err := in.Read(1000, false)
+		for _, row := range data {
+			for ind, sc := range scale {
+				s := strings.TrimSpace(fmt.Sprint(row[ind]))
+				if row[ind] == nil || s == "" {
+					continue
+				}
+				m := decValueRe.FindStringSubmatch(s)
+				switch {
+				case m == nil || m[2] == "" || (sc >= 0 && len(m[2]) != sc):
+					delete(scale, ind)
+				default:
+					scale[ind], digits[ind] = len(m[2]), max(digits[ind], len(strings.TrimLeft(m[1], "0"))+len(m[2]))
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := in.Reset(); err != nil {
+		return err
+	}
+
+	if j.decimals == nil {
+		j.decimals = make(map[int]decimal)
+	}
+	for ind := 1; ind < nSrc; ind++ {
+		sc, ok := scale[ind]
+		if !ok || sc < 0 || digits[ind] > 38 {
+			continue
+		}
+		d := decimal{precision: 18, scale: sc}
+		if digits[ind] > 18 {
+			d.precision = 38
+		}
+		j.decimals[ind] = d
+		fd := spec.FieldDefs[ind]
+		fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChString, 0, "0"
+		fmt.Printf("field %s is %s\n", fd.Name, d)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestParseDecimal(t *testing.T) {
+	d, ok, err := parseDecimal("dec(10,2)")
+	if !ok || err != nil || d.String() != "Decimal(10, 2)" {
+		t.Errorf("dec(10,2) = %v, %v, %v", d, ok, err)
+	}
+	if _, ok, err := parseDecimal("dec(76,76)"); !ok || err != nil {
+		t.Errorf("dec(76,76): %v, %v", ok, err)
+	}
+	// dec codes out of range are errors, other codes aren't decimals at all
+	for _, code := range []string{"dec(0,0)", "dec(77,2)", "dec(3,4)"} {
+		if _, ok, err := parseDecimal(code); !ok || err == nil {
+			t.Errorf("%s: ok %v, err %v; want an error", code, ok, err)
+		}
+	}
+	for _, code := range []string{"f", "dec", "dec(10)", "dec( 10,2)", "Decimal(10,2)"} {
+		if _, ok, err := parseDecimal(code); ok || err != nil {
+			t.Errorf("%s: ok %v, err %v; want not a decimal", code, ok, err)
+		}
+	}
+}
+
+func TestDecimalFormat(t *testing.T) {
+	d := decimal{precision: 5, scale: 2}
+	for in, want := range map[string]string{
+		"3.14159":  "3.14",
+		"2.005":    "2.01",
+		"-1.5":     "-1.50",
+		" 42 ":     "42.00",
+		"1e2":      "100.00",
+		"999.99":   "999.99",
+		"999.999":  "0", // rounds to 1000.00, which has 6 digits
+		"0.001":    "0.00",
+		"":         "0",
+		"12,50":    "0",
+		"not a no": "0",
+	} {
+		if got := d.format(in); got != want {
+			t.Errorf("format(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
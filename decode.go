@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// decoders are the kinds of -decode
+var decoders = map[string]func(string) string{
+	"url":  urlDecode,
+	"html": html.UnescapeString,
+}
+
+// fieldMap changes the values of field, or of every field if field is "", with f
+type fieldMap struct {
+	field string
+	f     func(string) string
+}
+
+// urlDecode decodes the percent-encoded s, e.g. New%20York.  + is left as it is, since it is a space only in
+// form data.  s is returned unchanged if it isn't valid percent-encoding.
+func urlDecode(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	d, err := url.PathUnescape(s)
+	if err != nil {
+		return s
+	}
+	return d
+}
+
+// parseDecode parses -decode: a comma-separated list whose items are a kind of decoders, which applies to every
+// field, or name=kind, which applies to the field name.  The items are applied in order.
+func parseDecode(list string) ([]fieldMap, error) {
+	ds := make([]fieldMap, 0)
+	for _, item := range splitList(list) {
+		field, kind, found := strings.Cut(item, "=")
+		if !found {
+			field, kind = "", item
+		}
+		f, ok := decoders[strings.ToLower(kind)]
+		if !ok || (found && field == "") {
+			kinds := make([]string, 0, len(decoders))
+			for k := range decoders {
+				kinds = append(kinds, k)
+			}
+			sort.Strings(kinds)
+			return nil, fmt.Errorf("-decode items are <kind> or <field>=<kind>, where kind is %s; got %s",
+				strings.Join(kinds, " or "), item)
+		}
+		ds = append(ds, fieldMap{field: field, f: f})
+	}
+	return ds, nil
+}
+
+// fieldMapStep returns the step that changes the values of the first nSrc fields for ms, in order.  cols
+// gives the position of each field.  opt is the option ms come from, for errors.
+func fieldMapStep(opt string, nSrc int, ms []fieldMap, cols map[string]int) (step, error) {
+	type target struct {
+		col int // -1 for every field
+		f   func(string) string
+	}
+	targets := make([]target, len(ms))
+	for ind, m := range ms {
+		targets[ind] = target{col: -1, f: m.f}
+		if m.field == "" {
+			continue
+		}
+		col, ok := cols[m.field]
+		if !ok || col >= nSrc {
+			return nil, fmt.Errorf("%s field %s is not in the source", opt, m.field)
+		}
+		targets[ind].col = col
+	}
+	return func(row []interface{}) (bool, error) {
+		for _, t := range targets {
+			for ind := 0; ind < nSrc; ind++ {
+				if t.col >= 0 && ind != t.col {
+					continue
+				}
+				if s, ok := row[ind].(string); ok {
+					row[ind] = t.f(s)
+				}
+			}
+		}
+		return true, nil
+	}, nil
+}
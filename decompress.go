@@ -0,0 +1,149 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/invertedv/chutils/file"
+)
+
+// decompressions are the values of -decompress: auto detects the compression from the extension and the
+// first bytes of the source
+var decompressions = []string{"auto", "none", "gzip", "zip", "bz2"}
+
+// compressedExts are the extensions of compressed sources
+var compressedExts = map[string]string{".gz": "gzip", ".zip": "zip", ".bz2": "bz2"}
+
+// compressionOf returns the compression of the source, which starts with head, for -decompress mode
+func compressionOf(mode, source string, head []byte) string {
+	if mode != "auto" {
+		return mode
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return "gzip"
+	case bytes.HasPrefix(head, []byte("BZh")):
+		return "bz2"
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return "zip"
+	}
+	if c, ok := compressedExts[strings.ToLower(filepath.Ext(source))]; ok {
+		return c
+	}
+	return "none"
+}
+
+// decompressed reads the compressed data of r: gzip, bz2 or a zip archive with one file
+func decompressed(compression string, r io.Reader) (io.Reader, string, error) {
+	switch compression {
+	case "gzip":
+		gz, err := gzip.NewReader(r)
+		return gz, "", err
+	case "bz2":
+		return bzip2.NewReader(r), "", nil
+	case "zip":
+		// the directory of a zip archive is at its end
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, "", err
+		}
+		zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			return nil, "", err
+		}
+		members := make([]*zip.File, 0)
+		for _, f := range zr.File {
+			if !f.FileInfo().IsDir() {
+				members = append(members, f)
+			}
+		}
+		if len(members) != 1 {
+			return nil, "", fmt.Errorf("the zip archive has %d files, toch reads archives of one file", len(members))
+		}
+		mr, err := members[0].Open()
+		return mr, members[0].Name, err
+	}
+	return nil, "", fmt.Errorf("unknown compression %s", compression)
+}
+
+// openSource creates a reader of the file, S3 or web source of j, decompressing it if it is compressed (see
+// -decompress).  A compressed source is decompressed to a temporary file, which is read from disk.  An S3
+// object is copied to a temporary file first.
+func (j *job) openSource(skip int) (*file.Reader, error) {
+	source := j.source
+	if isS3(source) {
+		tmp, err := j.s3.fetch(source)
+		if err != nil {
+			return nil, err
+		}
+		// the reader keeps the file open
+		defer func() { _ = os.Remove(tmp) }()
+		source = tmp
+	}
+	// spreadsheets are zip archives themselves
+	if j.decompress == "none" || j.sType == "xlsx" {
+		return NewReader(source, j.web, j.sType, j.delim, j.quote, skip, j.xlArea, j.xlSheet)
+	}
+	var src io.Reader
+	if strings.Contains(strings.ToLower(source), "http") {
+		resp, err := j.web.get(j.source)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = resp.Body.Close() }()
+		src = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = f.Close() }()
+		src = f
+	}
+	br := bufio.NewReader(src)
+	head, _ := br.Peek(4)
+	compression := compressionOf(j.decompress, j.source, head)
+	if compression == "none" {
+		// not compressed after all
+		if strings.Contains(strings.ToLower(source), "http") {
+			body, err := io.ReadAll(br)
+			if err != nil {
+				return nil, err
+			}
+			return newBytes(body, j.sType, j.delim, j.quote, skip, j.xlArea, j.xlSheet)
+		}
+		return newFile(source, j.sType, j.delim, j.quote, skip, j.xlArea, j.xlSheet)
+	}
+
+	r, member, err := decompressed(compression, br)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", j.source, err)
+	}
+	// the temporary file keeps the extension of the data, which newFile needs for xls
+	name := strings.TrimSuffix(j.source, filepath.Ext(j.source))
+	if member != "" {
+		name = member
+	}
+	tmp, err := os.CreateTemp("", "toch-*"+filepath.Ext(name))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, e := io.Copy(tmp, r); e != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("%s: %v", j.source, e)
+	}
+	if e := tmp.Close(); e != nil {
+		return nil, e
+	}
+	fmt.Printf("%s: %s decompressed\n", j.source, compression)
+	return newFile(tmp.Name(), j.sType, j.delim, j.quote, skip, j.xlArea, j.xlSheet)
+}
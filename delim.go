@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// delimNames are the names -sep accepts for delimiters that are awkward to type
+var delimNames = map[string]string{
+	"tab":       "\t",
+	"comma":     ",",
+	"pipe":      "|",
+	"semicolon": ";",
+	"space":     " ",
+}
+
+// unitSep is the separator multi-character delimiters are replaced with before the data is read
+const unitSep = '\x1f'
+
+// newDelim returns the field delimiter given by -sep: a name of delimNames, one or more characters, or Go
+// escapes such as \x01 or \t.  "" leaves the delimiter to -type.
+func newDelim(s string, quote rune) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if d, ok := delimNames[strings.ToLower(s)]; ok {
+		return d, nil
+	}
+	d, err := strconv.Unquote(`"` + strings.ReplaceAll(s, `"`, `\"`) + `"`)
+	if err != nil {
+		return "", fmt.Errorf("-sep %s: %v", s, err)
+	}
+	if d == "" || strings.ContainsAny(d, "\r\n") || (quote != 0 && strings.ContainsRune(d, quote)) {
+		return "", fmt.Errorf("-sep can't be empty or include a line end or the -q character")
+	}
+	return d, nil
+}
+
+// sep returns the field separator of a source of type sType whose delimiter is delim.  A delimiter of more than
+// one character is read as unitSep, which unsep puts in its place.
+func sep(sType, delim string) rune {
+	switch {
+	case delim == "" && sType == "text", delim == "" && sType == "xlsx":
+		return '\t'
+	case delim == "":
+		return ','
+	case len([]rune(delim)) == 1:
+		return []rune(delim)[0]
+	default:
+		return unitSep
+	}
+}
+
+// multiDelim returns true if delim is more than one character, so the data must go through unsep
+func multiDelim(delim string) bool {
+	return len([]rune(delim)) > 1
+}
+
+// unsep copies r to w, replacing the delimiter delim with unitSep where it isn't inside quotes
+func unsep(r io.Reader, w io.Writer, delim string, quote rune) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	inQuote := false
+	for {
+		line, err := br.ReadString('\n')
+		for ind := 0; ind < len(line); {
+			switch {
+			case quote != 0 && rune(line[ind]) == quote:
+				inQuote = !inQuote
+			case !inQuote && strings.HasPrefix(line[ind:], delim):
+				if _, e := bw.WriteRune(unitSep); e != nil {
+					return e
+				}
+				ind += len(delim)
+				continue
+			}
+			if e := bw.WriteByte(line[ind]); e != nil {
+				return e
+			}
+			ind++
+		}
+		if err == io.EOF {
+			return bw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// unsepBytes returns body with its delimiter replaced by unitSep
+func unsepBytes(body []byte, delim string, quote rune) ([]byte, error) {
+	var buf bytes.Buffer
+	if e := unsep(bytes.NewReader(body), &buf, delim, quote); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// unsepFile returns a temporary copy of the file source with its delimiter replaced by unitSep.  The caller
+// removes it.
+func unsepFile(source, delim string, quote rune) (string, error) {
+	f, err := os.Open(source)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	tmp, err := os.CreateTemp("", "toch-sep-*")
+	if err != nil {
+		return "", err
+	}
+	if e := unsep(f, tmp, delim, quote); e != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", e
+	}
+	return tmp.Name(), tmp.Close()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/invertedv/chutils"
+)
+
+func TestNewDelim(t *testing.T) {
+	tests := []struct {
+		sep, want string
+		bad       bool
+	}{
+		{sep: "", want: ""},
+		{sep: "TAB", want: "\t"},
+		{sep: "pipe", want: "|"},
+		{sep: `\x01`, want: "\x01"},
+		{sep: "||", want: "||"},
+		{sep: `~^~`, want: `~^~`},
+		{sep: `a"b`, bad: true}, // has the -q character
+		{sep: `\n`, bad: true},
+		{sep: `\q`, bad: true},
+	}
+	for _, tt := range tests {
+		got, err := newDelim(tt.sep, '"')
+		if (err != nil) != tt.bad || got != tt.want {
+			t.Errorf("newDelim(%q) = %q, %v", tt.sep, got, err)
+		}
+	}
+	// without a quote character, any character may be in the delimiter
+	if got, err := newDelim(`a"b`, 0); err != nil || got != `a"b` {
+		t.Errorf(`newDelim(a"b) with no quote = %q, %v`, got, err)
+	}
+}
+
+// TestMultiDelim reads csv with a delimiter of two characters, which may also be inside quoted values
+func TestMultiDelim(t *testing.T) {
+	body := "id||name||note\n1||\"a||b\"||x|y\n2||c||\n"
+	rdr, err := newBytes([]byte(body), "csv", "||", '"', 1, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := rdr.Init("", chutils.MergeTree); e != nil {
+		t.Fatal(e)
+	}
+	if n := len(rdr.TableSpec().FieldDefs); n != 3 {
+		t.Fatalf("%d fields, want 3", n)
+	}
+	rows, _, err := rdr.Read(0, false)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	got := make([]string, len(rows))
+	for ind, row := range rows {
+		vals := make([]string, len(row))
+		for c, v := range row {
+			vals[c] = v.(string)
+		}
+		got[ind] = strings.Join(vals, ",")
+	}
+	if want := "1,a||b,x|y;2,c,"; strings.Join(got, ";") != want {
+		t.Errorf("read %s, want %s", strings.Join(got, ";"), want)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// maxDiagnoses is the number of failures -debug describes in full
+const maxDiagnoses = 20
+
+// diagnoser is a chutils.Input that describes the rows that fail: a read error or a value that doesn't convert
+// to its field's type.  It prints the row, the raw bytes of the line in hex around the failing field, the
+// fields as they were split and the conversion attempted.  It validates the rows itself, as pipeline does.
+type diagnoser struct {
+	chutils.Input
+	j      *job
+	rows   int         // rows read
+	shown  int         // failures described
+	failed int         // failures
+	lines  *lineReader // lines of the source file, nil if they can't be read
+}
+
+// newDiagnoser returns rdr wrapped to describe its failures
+func newDiagnoser(rdr chutils.Input, j *job) *diagnoser {
+	return &diagnoser{Input: rdr, j: j, lines: newLineReader(j)}
+}
+
+// Read reads from the underlying Input, describing the failures
+func (d *diagnoser) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	data, valid, err = d.Input.Read(nTarget, false)
+	if err != nil && err != io.EOF {
+		d.failed++
+		if d.show() {
+			fmt.Printf("debug: read error after row %d: %v\n", d.rows+len(data), err)
+			d.hexLine(d.rows+len(data)+1, "")
+		}
+	}
+	if !validate {
+		d.rows += len(data)
+		return data, valid, err
+	}
+	spec := d.TableSpec()
+	valid = make([]chutils.Valid, len(data))
+	for r, row := range data {
+		raw := append([]interface{}{}, row...)
+		valid[r] = make(chutils.Valid, len(row))
+		for ind := range row {
+			if ind >= len(spec.FieldDefs) {
+				continue
+			}
+			row[ind], valid[r][ind] = convert(spec.FieldDefs[ind], row[ind])
+			if valid[r][ind] != chutils.VPass {
+				d.failed++
+				if d.show() {
+					d.describe(d.rows+r+1, raw, ind, valid[r][ind])
+				}
+			}
+		}
+	}
+	d.rows += len(data)
+	return data, valid, err
+}
+
+// show returns true if the current failure is to be described
+func (d *diagnoser) show() bool {
+	if d.shown++; d.shown == maxDiagnoses+1 {
+		fmt.Printf("debug: only the first %d failures are described\n", maxDiagnoses)
+	}
+	return d.shown <= maxDiagnoses
+}
+
+// describe prints the failure of field ind of row rowNo, whose raw values are raw
+func (d *diagnoser) describe(rowNo int, raw []interface{}, ind int, status chutils.Status) {
+	fds := d.TableSpec().FieldDefs
+	fd := fds[ind]
+	conv := chType(fd)
+	if fd.ChSpec.Base == chutils.ChDate {
+		conv += " (format " + fd.ChSpec.Format + ")"
+	}
+	v := fmt.Sprint(raw[ind])
+	fmt.Printf("debug: row %d, field %d (%s): %q does not convert to %s: %s\n", rowNo, ind+1, fd.Name, v, conv, status)
+	fields := make([]string, len(raw))
+	for c, rv := range raw {
+		name := fmt.Sprintf("col_%d", c+1)
+		if c < len(fds) {
+			name = fds[c].Name
+		}
+		mark := ""
+		if c == ind {
+			mark = " <--"
+		}
+		fields[c] = fmt.Sprintf("  %d %s=%q%s", c+1, name, fmt.Sprint(rv), mark)
+	}
+	fmt.Printf("debug: fields as split:\n%s\n", strings.Join(fields, "\n"))
+	d.hexLine(d.line(rowNo), v)
+}
+
+// line returns the line of the source file of row rowNo, or 0 if it isn't known
+func (d *diagnoser) line(rowNo int) int {
+	// rows dropped or added on the way don't correspond to lines
+	if d.j.pipelined() || d.j.pivot != nil {
+		return 0
+	}
+	if rg := d.j.region; rg != nil {
+		// the line numbers before -start-byte aren't counted
+		if rg.startLine == 0 {
+			return 0
+		}
+		return rg.startLine + rowNo - 1
+	}
+	line := d.j.skip + rowNo
+	if len(d.j.headers) == 0 {
+		line++
+	}
+	return line
+}
+
+// hexLine prints the raw bytes of line lineNo of the source in hex: up to 32 bytes on either side of value, or
+// the start of the line if value is "" or not found.
+func (d *diagnoser) hexLine(lineNo int, value string) {
+	if d.lines == nil || lineNo <= 0 {
+		return
+	}
+	b, err := d.lines.line(lineNo)
+	if err != nil {
+		_ = d.lines.f.Close()
+		d.lines = nil
+		return
+	}
+	st := 0
+	if ind := bytes.Index(b, []byte(value)); value != "" && ind >= 0 {
+		st = max(0, ind-32)
+	}
+	end := min(len(b), st+64+len(value))
+	fmt.Printf("debug: line %d, %d bytes, bytes %d to %d:\n%s", lineNo, len(b), st, end, hex.Dump(b[st:end]))
+}
+
+// lineReader reads the lines of a file in order
+type lineReader struct {
+	f    *os.File
+	r    *bufio.Reader
+	next int    // number of the next line
+	last []byte // the line before next
+}
+
+// newLineReader returns a reader of the lines of the source of j, or nil if it isn't an uncompressed file
+func newLineReader(j *job) *lineReader {
+	if j.body != nil || !isIn(&j.sType, []string{"text", "csv"}, false) || isS3(j.source) || strings.Contains(strings.ToLower(j.source), "http") {
+		return nil
+	}
+	f, err := os.Open(j.source)
+	if err != nil {
+		return nil
+	}
+	r := bufio.NewReader(f)
+	if head, _ := r.Peek(4); compressionOf(j.decompress, j.source, head) != "none" {
+		_ = f.Close()
+		return nil
+	}
+	return &lineReader{f: f, r: r, next: 1}
+}
+
+// line returns line lineNo, without its end of line.  It can't go back past the last line returned.
+func (l *lineReader) line(lineNo int) ([]byte, error) {
+	if lineNo == l.next-1 {
+		return l.last, nil
+	}
+	if lineNo < l.next {
+		return nil, fmt.Errorf("line %d was passed", lineNo)
+	}
+	for {
+		b, err := l.r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(b) == 0) {
+			return nil, err
+		}
+		l.next++
+		if l.next > lineNo {
+			l.last = bytes.TrimRight(b, "\r\n")
+			return l.last, nil
+		}
+	}
+}
+
+// Close closes the underlying Input and the source file
+func (d *diagnoser) Close() error {
+	if d.lines != nil {
+		_ = d.lines.f.Close()
+	}
+	if d.failed > 0 {
+		fmt.Printf("debug: %d failures\n", d.failed)
+	}
+	return d.Input.Close()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// diffOpts are the options of "toch diff"
+type diffOpts struct {
+	key   []string // fields that identify a row
+	out   string   // file the delta is written to, none if ""
+	apply bool     // insert the added and changed rows into the table
+}
+
+// diff compares j.source to the current contents of j.table, matching rows on the key fields.  It reports the
+// rows that are added (in the source only), removed (in the table only) and changed (in both, with different
+// values).  The source is loaded into a scratch table with the columns of j.table, which is dropped afterwards.
+func diff(j *job, args []string, opts *diffOpts, con *chutils.Connect) error {
+	if len(args) > 0 || j.table == "" || len(opts.key) == 0 {
+		return fmt.Errorf("usage: toch diff -s <source> -table <table> -key 'f1,...' [-out file] [-apply Y] (and the options of a load)")
+	}
+	cols, err := describe(j.table, con)
+	if err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		return fmt.Errorf("table %s does not exist", j.table)
+	}
+	inTable := make(map[string]bool)
+	for _, c := range cols {
+		inTable[c.name] = true
+	}
+	missing := make([]string, 0)
+	for _, k := range opts.key {
+		if !inTable[k] {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("-key fields not in %s: %s", j.table, strings.Join(missing, ", "))
+	}
+	values := make([]string, 0)
+	for _, c := range cols {
+		if !isIn(&c.name, opts.key, false) {
+			values = append(values, c.name)
+		}
+	}
+
+	scratch, drop, err := loadScratch(j, "diff", con)
+	if err != nil {
+		return err
+	}
+	defer drop()
+
+	key := "(" + quoteNames(opts.key, "") + ")"
+	changed := "0"
+	if len(values) > 0 {
+		conds := make([]string, len(values))
+		for ind, v := range values {
+			conds[ind] = fmt.Sprintf("n.`%s` != o.`%s`", v, v)
+		}
+		changed = strings.Join(conds, " OR ")
+	}
+	queries := map[string]string{
+		"added":   fmt.Sprintf("SELECT %%s FROM %s WHERE %s NOT IN (SELECT %s FROM %s)", scratch, key, key, j.table),
+		"removed": fmt.Sprintf("SELECT %%s FROM %s WHERE %s NOT IN (SELECT %s FROM %s)", j.table, key, key, scratch),
+		"changed": fmt.Sprintf("SELECT %%s FROM %s AS n INNER JOIN %s AS o USING (%s) WHERE %s", scratch, j.table,
+			quoteNames(opts.key, ""), changed),
+	}
+	changes := []string{"added", "removed", "changed"}
+	for _, change := range changes {
+		var n uint64
+		if e := con.QueryRow(fmt.Sprintf(queries[change], "count()")).Scan(&n); e != nil {
+			return e
+		}
+		fmt.Printf("%-8s %d rows\n", change, n)
+	}
+
+	if opts.out != "" {
+		if e := writeDelta(opts.out, cols, queries, changes, con); e != nil {
+			return e
+		}
+		fmt.Printf("delta written to %s\n", opts.out)
+	}
+	if opts.apply {
+		// the added rows and the new values of the changed rows
+		all := quoteNames(columnNames(cols), "n.")
+		qry := fmt.Sprintf("INSERT INTO %s SELECT * FROM (SELECT * FROM %s WHERE %s NOT IN (SELECT %s FROM %s) "+
+			"UNION ALL %s)", j.table, scratch, key, key, j.table, fmt.Sprintf(queries["changed"], all))
+		if _, e := con.Exec(qry); e != nil {
+			return e
+		}
+		fmt.Printf("added and changed rows inserted into %s\n", j.table)
+	}
+	return nil
+}
+
+// loadScratch loads j.source into a new table like j.table, named <table>_<use>_<run id>.  The table is dropped
+// by calling drop.
+func loadScratch(j *job, use string, con *chutils.Connect) (scratch string, drop func(), err error) {
+	scratch = fmt.Sprintf("%s_%s_%s", j.table, use, strings.ReplaceAll(j.runID, "-", ""))
+	if _, e := con.Exec(fmt.Sprintf("CREATE TABLE %s AS %s", scratch, j.table)); e != nil {
+		return "", nil, e
+	}
+	drop = func() {
+		if _, e := con.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", scratch)); e != nil {
+			fmt.Println(e)
+		}
+	}
+	sj := *j
+	sj.table, sj.appending = scratch, true
+	if r := sj.loadFile(nil, con); r.err != nil {
+		drop()
+		return "", nil, r.err
+	}
+	return scratch, drop, nil
+}
+
+// writeDelta writes the rows that are added, removed and changed to the CSV file fileName.  The first field,
+// _change, is the kind of change.  Removed rows have the values of the table, the others those of the source.
+func writeDelta(fileName string, cols []column, queries map[string]string, changes []string, con *chutils.Connect) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	w := csv.NewWriter(f)
+	if e := w.Write(append([]string{"_change"}, columnNames(cols)...)); e != nil {
+		return e
+	}
+	for _, change := range changes {
+		prefix := ""
+		if change == "changed" {
+			prefix = "n."
+		}
+		strs := make([]string, len(cols))
+		for ind, c := range cols {
+			strs[ind] = fmt.Sprintf("toString(%s`%s`)", prefix, c.name)
+		}
+		if e := writeRows(w, change, fmt.Sprintf(queries[change], strings.Join(strs, ", ")), len(cols), con); e != nil {
+			return e
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// writeRows writes the rows of qry, which returns n strings, to w after the field change
+func writeRows(w *csv.Writer, change, qry string, n int, con *chutils.Connect) error {
+	rows, err := con.Query(qry)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+	vals := make([]string, n)
+	ptrs := make([]interface{}, n)
+	for ind := range vals {
+		ptrs[ind] = &vals[ind]
+	}
+	for rows.Next() {
+		if e := rows.Scan(ptrs...); e != nil {
+			return e
+		}
+		if e := w.Write(append([]string{change}, vals...)); e != nil {
+			return e
+		}
+	}
+	return rows.Err()
+}
+
+// quoteNames returns the names, each quoted and prefixed by prefix, separated by commas
+func quoteNames(names []string, prefix string) string {
+	q := make([]string, len(names))
+	for ind, name := range names {
+		q[ind] = fmt.Sprintf("%s`%s`", prefix, name)
+	}
+	return strings.Join(q, ", ")
+}
+
+// columnNames returns the names of the columns
+func columnNames(cols []column) []string {
+	names := make([]string, len(cols))
+	for ind, c := range cols {
+		names[ind] = c.name
+	}
+	return names
+}
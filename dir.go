@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/invertedv/chutils"
+)
+
+// fileResult is the outcome of loading one file
+type fileResult struct {
+	source string
+	rows   int
+	secs   float64
+	err    error
+	spec   *chutils.TableDef // table spec used to load the file
+}
+
+// loadDir loads every file under the directory j.source into j.table.
+// A file is loaded if it matches one of the include patterns (or there are none) and none of the exclude patterns.
+// The first file to load successfully creates the table, the remaining files are appended to it after their
+// fields are matched to the table's (see conform).  Up to j.fileWorkers files are loaded at a time.
+// An error loading one file does not stop the others from loading. The result of each file is returned.
+func loadDir(j *job, include, exclude []string, con *chutils.Connect) ([]fileResult, error) {
+	root := j.source
+	sources := make([]string, 0)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, e := filepath.Rel(root, p)
+		if e != nil {
+			return e
+		}
+		if selected(filepath.ToSlash(rel), include, exclude) {
+			sources = append(sources, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no files in %s match -include/-exclude", root)
+	}
+
+	return loadSources(j, sources, con), nil
+}
+
+// loadSources loads the files sources into j.table, up to j.fileWorkers at a time.  The result of each file is
+// returned.
+func loadSources(j *job, sources []string, con *chutils.Connect) []fileResult {
+	l := newLoader(j, j.fileWorkers, con)
+	for _, source := range sources {
+		fj := *j
+		fj.source = source
+		l.slot()
+		l.load(&fj)
+	}
+	return l.wait()
+}
+
+// summarize prints a table of the results of a multi-file load. It returns an error if any file failed.
+func summarize(results []fileResult) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "file\tstatus\trows\tseconds\terror")
+	failed := 0
+	for _, r := range results {
+		status, msg := "ok", ""
+		if r.err != nil {
+			status, msg = "failed", r.err.Error()
+			failed++
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%0.1f\t%s\n", r.source, status, r.rows, r.secs, msg)
+	}
+	_ = tw.Flush()
+	fmt.Printf("%d files loaded, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to load", failed, len(results))
+	}
+	return nil
+}
+
+// selected determines whether the file at the slash-separated relative path rel is to be loaded.
+func selected(rel string, include, exclude []string) bool {
+	for _, pattern := range exclude {
+		if matchGlob(pattern, rel) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether the slash-separated path rel matches pattern.
+// A pattern without a "/" is matched against the file name only. Otherwise, the pattern is matched
+// against the entire path, with "**" matching any number of directories.
+func matchGlob(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchParts(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchParts matches the path elements in parts to the pattern elements in pattern.
+func matchParts(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// ** can consume zero or more elements
+			for ind := 0; ind <= len(parts); ind++ {
+				if matchParts(pattern[1:], parts[ind:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// splitList splits a comma-separated list, removing spaces and single quotes.
+func splitList(list string) []string {
+	list = strings.ReplaceAll(strings.ReplaceAll(list, " ", ""), "'", "")
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		// no /: the file name only
+		{"*.csv", "a.csv", true},
+		{"*.csv", "2024/01/a.csv", true},
+		{"*.csv", "a.csv.gz", false},
+		{"a?.csv", "x/a1.csv", true},
+		// with /: the whole path
+		{"2024/*.csv", "2024/a.csv", true},
+		{"2024/*.csv", "2024/01/a.csv", false},
+		{"*/a.csv", "a.csv", false},
+		// ** is any number of directories, none included
+		{"**/a.csv", "a.csv", true},
+		{"**/a.csv", "x/y/z/a.csv", true},
+		{"2024/**/*.csv", "2024/a.csv", true},
+		{"2024/**/*.csv", "2024/01/02/a.csv", true},
+		{"2024/**/*.csv", "2023/01/a.csv", false},
+		{"2024/**", "2024/01/a.csv", true},
+		{"**/tmp/**", "a/tmp/b/c.csv", true},
+		{"**/tmp/**", "a/b/c.csv", false},
+		// a bad pattern matches nothing
+		{"[", "[", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestSelected(t *testing.T) {
+	include, exclude := []string{"*.csv", "data/**/*.txt"}, []string{"**/tmp/**", "skip*"}
+	for rel, want := range map[string]bool{
+		"a.csv":          true,
+		"x/a.csv":        true,
+		"data/a.txt":     true,
+		"data/x/y/a.txt": true,
+		"other/a.txt":    false,
+		"x/tmp/a.csv":    false,
+		"skip.csv":       false,
+		"a.json":         false,
+	} {
+		if got := selected(rel, include, exclude); got != want {
+			t.Errorf("selected(%q) = %v, want %v", rel, got, want)
+		}
+	}
+	if !selected("a.json", nil, nil) {
+		t.Errorf("with no patterns, every file is included")
+	}
+}
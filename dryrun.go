@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// maxDistinct is the most distinct values of a field the size estimate counts
+const maxDistinct = 100000
+
+// tableType returns the ClickHouse type of the column of field ind.  chutils has no geo, DateTime, Decimal,
+// unsigned or Bool types, so the spec has another type for those fields.
+func (j *job) tableType(ind int, fd *chutils.FieldDef) string {
+	t := j.typeOf(ind)
+	if g, ok := geoTypes[t]; ok {
+		return g.ch
+	}
+	if dt, ok := dateTimeTypes[t]; ok {
+		return dt.ch
+	}
+	if d, ok := j.decimalOf(ind); ok {
+		return d.String()
+	}
+	if bits, ok := uintTypes[t]; ok {
+		return fmt.Sprintf("UInt%d", bits)
+	}
+	if j.isBool(ind) {
+		return "Bool"
+	}
+	return chType(fd)
+}
+
+// colSize accumulates the values of a field as ClickHouse stores them, to estimate the space the field takes
+type colSize struct {
+	name     string
+	chType   string // type of the column, without wrappers
+	codec    string
+	width    int  // bytes of a value of a fixed-width type, 0 for strings
+	lowCard  bool // LowCardinality: a dictionary of the values and an index into it
+	nullable bool
+	data     []byte         // the values, or the indexes of a LowCardinality column
+	dict     map[string]int // distinct values, up to maxDistinct
+	extra    int            // bytes of the dictionary of a LowCardinality column and the null map of a Nullable one
+}
+
+// newColSize returns the accumulator of the field fd, of the ClickHouse type t, compressed with codec
+func newColSize(fd *chutils.FieldDef, t, codec string) *colSize {
+	c := &colSize{name: fd.Name, codec: codec, dict: make(map[string]int)}
+	for m := wrapRe.FindStringSubmatch(t); m != nil; m = wrapRe.FindStringSubmatch(t) {
+		c.lowCard = c.lowCard || m[1] == "LowCardinality"
+		c.nullable = c.nullable || m[1] == "Nullable"
+		t = m[2]
+	}
+	c.chType = t
+	switch {
+	case t == "Int8" || t == "UInt8" || t == "Bool":
+		c.width = 1
+	case t == "Int16" || t == "UInt16" || t == "Date":
+		c.width = 2
+	case t == "Int32" || t == "UInt32" || t == "Float32" || strings.HasPrefix(t, "DateTime("):
+		c.width = 4
+	case t == "Int64" || t == "UInt64" || t == "Float64" || strings.HasPrefix(t, "DateTime64("):
+		c.width = 8
+	case strings.HasPrefix(t, "FixedString("):
+		c.width, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(t, "FixedString("), ")"))
+	case strings.HasPrefix(t, "Decimal("):
+		p, _ := strconv.Atoi(strings.Split(strings.TrimPrefix(t, "Decimal("), ",")[0])
+		c.width = 4
+		for _, w := range []struct{ digits, width int }{{9, 4}, {18, 8}, {38, 16}, {76, 32}} {
+			if c.width = w.width; p <= w.digits {
+				break
+			}
+		}
+	}
+	return c
+}
+
+// add adds the converted value v
+func (c *colSize) add(v interface{}) {
+	if c.nullable {
+		c.extra++ // the null map, a byte a row
+	}
+	key := fmt.Sprint(v)
+	if len(c.dict) < maxDistinct {
+		if _, ok := c.dict[key]; !ok {
+			c.dict[key] = len(c.dict)
+			if c.lowCard {
+				c.extra += len(c.encode(v))
+			}
+		}
+	}
+	if !c.lowCard {
+		c.data = append(c.data, c.encode(v)...)
+		return
+	}
+	// the index is 1, 2 or 4 bytes as the dictionary grows; the final width is applied in size
+	ind := c.dict[key]
+	c.data = append(c.data, byte(ind), byte(ind>>8), byte(ind>>16), byte(ind>>24))
+}
+
+// encode returns v in ClickHouse's binary format for the type of c, near enough for an estimate
+func (c *colSize) encode(v interface{}) []byte {
+	le := func(n uint64, width int) []byte {
+		b := make([]byte, width)
+		for ind := 0; ind < width && ind < 8; ind++ {
+			b[ind] = byte(n >> (8 * ind))
+		}
+		return b
+	}
+	s := toStr(&env{}, v)
+	switch {
+	case c.width == 0:
+		// a String: its length as a varint, then its bytes
+		b := make([]byte, 0, len(s)+2)
+		for n := uint64(len(s)); ; n >>= 7 {
+			if n < 0x80 {
+				b = append(b, byte(n))
+				break
+			}
+			b = append(b, byte(n)|0x80)
+		}
+		return append(b, s...)
+	case strings.HasPrefix(c.chType, "FixedString("):
+		b := make([]byte, c.width)
+		copy(b, s)
+		return b
+	case c.chType == "Float32":
+		f, _ := toNum(v)
+		return le(uint64(math.Float32bits(float32(f))), 4)
+	case c.chType == "Float64":
+		f, _ := toNum(v)
+		return le(math.Float64bits(f), 8)
+	case c.chType == "Date":
+		t, _ := v.(time.Time)
+		return le(uint64(t.Unix()/86400), 2)
+	case strings.HasPrefix(c.chType, "DateTime"):
+		t, _ := time.Parse("2006-01-02 15:04:05.999999", s)
+		if c.width == 4 {
+			return le(uint64(t.Unix()), 4)
+		}
+		return le(uint64(t.UnixMicro()), 8)
+	case strings.HasPrefix(c.chType, "Decimal("):
+		// the value as an integer count of the units of its scale
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return le(0, c.width)
+		}
+		scale := strings.TrimSuffix(strings.TrimSpace(strings.Split(c.chType, ",")[1]), ")")
+		n, _ := strconv.Atoi(scale)
+		r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)))
+		f, _ := r.Float64()
+		return le(uint64(int64(f)), c.width)
+	default:
+		// the integer types
+		if n, e := strconv.ParseInt(s, 10, 64); e == nil {
+			return le(uint64(n), c.width)
+		}
+		n, _ := strconv.ParseUint(s, 10, 64)
+		return le(n, c.width)
+	}
+}
+
+// size returns the bytes the values added take before and, estimated, after compression with the codec
+func (c *colSize) size() (raw, packed int) {
+	data := c.data
+	if c.lowCard {
+		// the index has the width the dictionary needs
+		w := 4
+		switch {
+		case len(c.dict) <= 1<<8:
+			w = 1
+		case len(c.dict) <= 1<<16:
+			w = 2
+		}
+		idx := make([]byte, 0, len(data)/4*w)
+		for ind := 0; ind+4 <= len(data); ind += 4 {
+			idx = append(idx, data[ind:ind+w]...)
+		}
+		data = idx
+	}
+	raw = len(data) + c.extra
+	return raw, compressed(data, c.codec, c.width) + c.extra
+}
+
+// compressed returns the estimated bytes of data compressed with the codec chain, whose values are width bytes
+// (0 if they vary).  LZ4 is estimated by deflate at its fastest and ZSTD and LZ4HC by deflate at its best, so the
+// figures are a guide to the choices rather than exact.  Delta and DoubleDelta store the differences of the
+// values.
+func compressed(data []byte, codec string, width int) int {
+	if codec == "" {
+		codec = "LZ4"
+	}
+	level := flate.BestSpeed
+	for _, c := range strings.Split(strings.ToUpper(codec), ",") {
+		c = strings.TrimSpace(c)
+		switch {
+		case strings.HasPrefix(c, "DELTA"), strings.HasPrefix(c, "DOUBLEDELTA"):
+			if width > 0 && width <= 8 {
+				data = delta(data, width)
+			}
+		case c == "NONE":
+			level = flate.NoCompression
+		case strings.HasPrefix(c, "ZSTD"), strings.HasPrefix(c, "LZ4HC"):
+			level = flate.BestCompression
+		}
+	}
+	if level == flate.NoCompression {
+		return len(data)
+	}
+	var b bytes.Buffer
+	w, _ := flate.NewWriter(&b, level)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return b.Len()
+}
+
+// delta returns the differences of the successive little-endian values of width bytes of data
+func delta(data []byte, width int) []byte {
+	out := make([]byte, len(data))
+	var prev uint64
+	for ind := 0; ind+width <= len(data); ind += width {
+		var n uint64
+		for k := 0; k < width; k++ {
+			n |= uint64(data[ind+k]) << (8 * k)
+		}
+		d := n - prev
+		for k := 0; k < width; k++ {
+			out[ind+k] = byte(d >> (8 * k))
+		}
+		prev = n
+	}
+	return out
+}
+
+// ddl returns the CREATE TABLE statement of the new table j.table with the fields of spec: with their table
+// types (see tableType), the -codec codecs and, for -sparse, the defaults of the sparse fields.  The sparse
+// setting is left out if the server doesn't support it.
+func (j *job) ddl(spec *chutils.TableDef) string {
+	cols := make([]string, 0, len(spec.FieldDefs))
+	sparse := false
+	for ind := 0; ind < len(spec.FieldDefs); ind++ {
+		fd := spec.FieldDefs[ind]
+		if fd.Drop {
+			continue
+		}
+		col := fmt.Sprintf("    `%s` %s", fd.Name, j.tableType(ind, fd))
+		if fd.Default != nil {
+			sparse = true
+			v := j.insertValue(ind, fd.Default)
+			def := chString(v)
+			if _, ok := v.(string); ok || fd.ChSpec.Base == chutils.ChDate {
+				def = "'" + strings.ReplaceAll(def, "'", "\\'") + "'"
+			}
+			col += " DEFAULT " + def
+		}
+		if c := j.codecOf(fd.Name); c != "" {
+			col += " CODEC(" + c + ")"
+		}
+		cols = append(cols, col)
+	}
+	qry := fmt.Sprintf("CREATE TABLE %s (\n%s\n) ENGINE = MergeTree()\nORDER BY (`%s`)", j.table, strings.Join(cols, ",\n"), spec.Key)
+	if sparse && j.server.supports("sparse columns") {
+		qry += fmt.Sprintf("\nSETTINGS ratio_of_defaults_for_sparse_serialization = %v", sparseShare)
+	}
+	return qry
+}
+
+// printSample prints the rows of sample, which have the fields of spec, as a table
+func printSample(spec *chutils.TableDef, sample []chutils.Row) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	names := make([]string, 0, len(spec.FieldDefs))
+	for ind := 0; ind < len(spec.FieldDefs); ind++ {
+		if !spec.FieldDefs[ind].Drop {
+			names = append(names, spec.FieldDefs[ind].Name)
+		}
+	}
+	_, _ = fmt.Fprintln(tw, strings.Join(names, "\t"))
+	for _, row := range sample {
+		vals := make([]string, 0, len(names))
+		for ind := 0; ind < len(spec.FieldDefs) && ind < len(row); ind++ {
+			if !spec.FieldDefs[ind].Drop {
+				vals = append(vals, chString(row[ind]))
+			}
+		}
+		_, _ = fmt.Fprintln(tw, strings.Join(vals, "\t"))
+	}
+	_ = tw.Flush()
+}
+
+// dryRun reads up to rows rows of j.source as a load would, without connecting to ClickHouse.  It prints the
+// CREATE TABLE statement of the table, the first nSample rows as they would be loaded and, for each field, its
+// type, its codec, the number of distinct values and the bytes a row takes on disk before and, estimated,
+// after compression.  The totals are given for a million rows, to compare type and codec choices.
+func dryRun(j *job, rows, nSample int) error {
+	in, err := openReader(j, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	if err := j.emptyFields(in); err != nil {
+		return err
+	}
+	if err := in.Reset(); err != nil {
+		return err
+	}
+	spec := in.TableSpec()
+	fmt.Printf("%s\n\n", j.ddl(spec))
+	cols := make([]*colSize, 0, len(spec.FieldDefs))
+	inds := make([]int, 0, len(spec.FieldDefs))
+	for ind, fd := range spec.FieldDefs {
+		if fd.Drop {
+			continue
+		}
+		cols, inds = append(cols, newColSize(fd, j.tableType(ind, fd), j.codecOf(fd.Name))), append(inds, ind)
+	}
+
+	n := 0
+	var sample []chutils.Row
+	for n < rows {
+		data, _, e := in.Read(min(1000, rows-n), true)
+		for _, row := range data {
+			if len(sample) < nSample {
+				sample = append(sample, row)
+			}
+			for c, ind := range inds {
+				cols[c].add(row[ind])
+			}
+		}
+		n += len(data)
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return e
+		}
+	}
+	if n == 0 {
+		return fmt.Errorf("%s has no rows", j.source)
+	}
+
+	if len(sample) > 0 {
+		fmt.Printf("the first %d rows, as loaded:\n", len(sample))
+		printSample(spec, sample)
+		fmt.Println()
+	}
+
+	fmt.Printf("estimated size on disk, from %d rows (LZ4 is the codec if none is given):\n", n)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "field\ttype\tcodec\tdistinct\tbytes/row\tcompressed bytes/row\tratio")
+	var rawAll, packedAll int
+	for c, col := range cols {
+		raw, packed := col.size()
+		rawAll, packedAll = rawAll+raw, packedAll+packed
+		codec := col.codec
+		if codec == "" {
+			codec = "LZ4"
+		}
+		distinct := fmt.Sprint(len(col.dict))
+		if len(col.dict) >= maxDistinct {
+			distinct = fmt.Sprintf(">=%d", maxDistinct)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.2f\t%.2f\t%.1f\n", col.name, j.tableType(inds[c], spec.FieldDefs[inds[c]]),
+			codec, distinct, float64(raw)/float64(n), float64(packed)/float64(n), float64(raw)/math.Max(float64(packed), 1))
+	}
+	_, _ = fmt.Fprintf(tw, "total\t\t\t\t%.2f\t%.2f\t%.1f\n", float64(rawAll)/float64(n), float64(packedAll)/float64(n),
+		float64(rawAll)/math.Max(float64(packedAll), 1))
+	_ = tw.Flush()
+	fmt.Printf("a million rows: %s, %s compressed\n", bytesString(uint64(float64(rawAll)/float64(n)*1e6)),
+		bytesString(uint64(float64(packedAll)/float64(n)*1e6)))
+	return nil
+}
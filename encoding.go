@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// cp1252 maps the bytes 0x80-0x9f of Windows-1252 to runes.  The other bytes are the same as Latin-1.
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+// toUTF8 detects the encoding of body and returns it converted to UTF-8 without a byte order mark.
+// UTF-16 is recognized by its byte order mark.  Data that isn't valid UTF-8 is taken to be Windows-1252,
+// which is a superset of the printable characters of Latin-1.
+func toUTF8(body []byte) (out []byte, encoding string) {
+	switch {
+	case bytes.HasPrefix(body, []byte{0xef, 0xbb, 0xbf}):
+		return body[3:], "utf-8 with BOM"
+	case bytes.HasPrefix(body, []byte{0xff, 0xfe}):
+		return fromUTF16(body[2:], false), "utf-16le"
+	case bytes.HasPrefix(body, []byte{0xfe, 0xff}):
+		return fromUTF16(body[2:], true), "utf-16be"
+	case utf8.Valid(body):
+		return body, "utf-8"
+	}
+
+	out = make([]byte, 0, len(body)+len(body)/8)
+	for _, b := range body {
+		r := rune(b)
+		if b >= 0x80 && b < 0xa0 {
+			r = cp1252[b-0x80]
+		}
+		out = utf8.AppendRune(out, r)
+	}
+	return out, "windows-1252"
+}
+
+// fromUTF16 converts UTF-16 data, which is big endian if bigEndian is true, to UTF-8
+func fromUTF16(body []byte, bigEndian bool) []byte {
+	units := make([]uint16, len(body)/2)
+	for ind := range units {
+		hi, lo := body[2*ind+1], body[2*ind]
+		if bigEndian {
+			hi, lo = lo, hi
+		}
+		units[ind] = uint16(hi)<<8 | uint16(lo)
+	}
+	out := make([]byte, 0, len(units))
+	for _, r := range utf16.Decode(units) {
+		out = utf8.AppendRune(out, r)
+	}
+	return out
+}
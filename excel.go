@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// dateSystems are the values of -xl-date-system: auto reads it from the workbook
+var dateSystems = []string{"auto", "1900", "1904"}
+
+// the days of Excel serial dates count from these.  The 1900 system's epoch is the last day of 1899, not the
+// first day of 1900, because Excel counts 1900 as a leap year.
+var (
+	epoch1900 = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	epoch1904 = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// maxSerial is the serial date of 9999-12-31 in the 1900 system
+const maxSerial = 2958465
+
+// date1904Attr is the 1904 date system flag of workbook.xml
+var date1904Attr = regexp.MustCompile(`<(?:\w+:)?workbookPr\b[^>]*\bdate1904="(?:1|true)"`)
+
+// excelEpoch returns the epoch of the serial dates of the Excel source of j for -xl-date-system mode.  With
+// auto, the date system is read from the workbook if it is a local file or an archive member; other workbooks
+// are taken to use the 1900 system.
+func (j *job) excelEpoch(mode string) time.Time {
+	switch mode {
+	case "1900":
+		return epoch1900
+	case "1904":
+		return epoch1904
+	}
+	var r io.ReaderAt
+	var size int64
+	switch {
+	case j.body != nil:
+		r, size = bytes.NewReader(j.body), int64(len(j.body))
+	case isS3(j.source) || strings.Contains(strings.ToLower(j.source), "http"):
+		return epoch1900
+	default:
+		// xls files are converted to xlsx alongside them
+		name := j.source
+		if j.sType == "xls" {
+			name = strings.Replace(name, ".xls", ".xlsx", 1)
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return epoch1900
+		}
+		defer func() { _ = f.Close() }()
+		info, err := f.Stat()
+		if err != nil {
+			return epoch1900
+		}
+		r, size = f, info.Size()
+	}
+	if is1904(r, size) {
+		return epoch1904
+	}
+	return epoch1900
+}
+
+// is1904 returns true if the workbook r, of size bytes, uses the 1904 date system
+func is1904(r io.ReaderAt, size int64) bool {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return false
+	}
+	for _, f := range zr.File {
+		if f.Name != "xl/workbook.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return false
+		}
+		defer func() { _ = rc.Close() }()
+		b, err := io.ReadAll(rc)
+		return err == nil && date1904Attr.Match(b)
+	}
+	return false
+}
+
+// serialDates returns the pipeline step that converts the values of the Date fields of spec that are Excel
+// serial dates, such as 45292, to dates in the fields' formats.  Values that are dates in the format are
+// left alone.
+func serialDates(nSrc int, spec *chutils.TableDef, epoch time.Time) step {
+	return func(row []interface{}) (bool, error) {
+		for ind := 0; ind < nSrc; ind++ {
+			fd := spec.FieldDefs[ind]
+			s, ok := row[ind].(string)
+			if !ok || fd.ChSpec.Base != chutils.ChDate {
+				continue
+			}
+			if _, err := time.Parse(fd.ChSpec.Format, s); err == nil {
+				continue
+			}
+			if days, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil && days >= 1 && days <= maxSerial {
+				row[ind] = epoch.AddDate(0, 0, int(days)).Format(fd.ChSpec.Format)
+			}
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,955 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// The expression language used by -derive, -where and -recode.
+//
+// Values are strings, numbers, dates, booleans or null.  Field values from the source are strings: the
+// operators convert them as needed.  Arithmetic (+ - * / %) works on numbers; + also adds days to a date and
+// - subtracts days from a date or gives the days between two dates.  Comparisons (= == != <> < <= > >=) are
+// by date if either side is a date, numeric if either side is a number (the other must be one too) or both
+// are strings that are numbers, and by string otherwise.  Booleans are combined with and, or, not (also &&,
+// ||, !).  Strings are quoted with ' or ".  Field names that aren't identifiers are quoted with back-ticks.
+// Run metadata is referenced in braces: {run_id}, {source}, {now} and {env:NAME} for the environment variable
+// NAME.
+
+// expr is a compiled expression
+type expr interface {
+	eval(e *env) (interface{}, error)
+}
+
+// env is the environment an expression is evaluated in: the current row and its field names
+type env struct {
+	cols    map[string]int         // field name -> index into row
+	row     []interface{}          // current row
+	dateFmt string                 // format of dates in the data
+	vars    map[string]interface{} // values that are not fields
+}
+
+// lookup returns the value of name in the current row
+func (e *env) lookup(name string) (interface{}, error) {
+	if ind, ok := e.cols[name]; ok {
+		if ind < len(e.row) {
+			return e.row[ind], nil
+		}
+		return nil, nil
+	}
+	if v, ok := e.vars[name]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("unknown field %s", name)
+}
+
+// function is a function callable from an expression
+type function struct {
+	minArgs, maxArgs int // maxArgs < 0 means any number
+	doc              string
+	f                func(e *env, args []interface{}) (interface{}, error)
+}
+
+// functions callable in expressions
+var functions map[string]*function
+
+func init() {
+	functions = map[string]*function{
+		// strings
+		"upper": {1, 1, "upper(s) upper-cases s", func(e *env, a []interface{}) (interface{}, error) {
+			return strings.ToUpper(toStr(e, a[0])), nil
+		}},
+		"lower": {1, 1, "lower(s) lower-cases s", func(e *env, a []interface{}) (interface{}, error) {
+			return strings.ToLower(toStr(e, a[0])), nil
+		}},
+		"trim": {1, 1, "trim(s) removes leading and trailing white space", func(e *env, a []interface{}) (interface{}, error) {
+			return strings.TrimSpace(toStr(e, a[0])), nil
+		}},
+		"length": {1, 1, "length(s) number of characters in s", func(e *env, a []interface{}) (interface{}, error) {
+			return float64(len([]rune(toStr(e, a[0])))), nil
+		}},
+		"substr": {2, 3, "substr(s, start, n) n characters of s starting at start (1-based); all if n is omitted",
+			func(e *env, a []interface{}) (interface{}, error) {
+				s := []rune(toStr(e, a[0]))
+				start, err := toNum(a[1])
+				if err != nil {
+					return nil, err
+				}
+				st := int(start) - 1
+				if st < 0 {
+					st = 0
+				}
+				if st > len(s) {
+					st = len(s)
+				}
+				end := len(s)
+				if len(a) == 3 {
+					n, err := toNum(a[2])
+					if err != nil {
+						return nil, err
+					}
+					if st+int(n) < end {
+						end = st + int(n)
+					}
+				}
+				if end < st {
+					end = st
+				}
+				return string(s[st:end]), nil
+			}},
+		"concat": {1, -1, "concat(s1, s2, ...) joins its arguments", func(e *env, a []interface{}) (interface{}, error) {
+			s := ""
+			for _, x := range a {
+				s += toStr(e, x)
+			}
+			return s, nil
+		}},
+		"replace": {3, 3, "replace(s, old, new) replaces all occurrences of old with new", func(e *env, a []interface{}) (interface{}, error) {
+			return strings.ReplaceAll(toStr(e, a[0]), toStr(e, a[1]), toStr(e, a[2])), nil
+		}},
+		"contains": {2, 2, "contains(s, sub) true if sub is in s", func(e *env, a []interface{}) (interface{}, error) {
+			return strings.Contains(toStr(e, a[0]), toStr(e, a[1])), nil
+		}},
+		"startsWith": {2, 2, "startsWith(s, prefix) true if s starts with prefix", func(e *env, a []interface{}) (interface{}, error) {
+			return strings.HasPrefix(toStr(e, a[0]), toStr(e, a[1])), nil
+		}},
+		"endsWith": {2, 2, "endsWith(s, suffix) true if s ends with suffix", func(e *env, a []interface{}) (interface{}, error) {
+			return strings.HasSuffix(toStr(e, a[0]), toStr(e, a[1])), nil
+		}},
+		"isEmpty": {1, 1, "isEmpty(x) true if x is null or white space", func(e *env, a []interface{}) (interface{}, error) {
+			return a[0] == nil || strings.TrimSpace(toStr(e, a[0])) == "", nil
+		}},
+		"toString": {1, 1, "toString(x) x as a string", func(e *env, a []interface{}) (interface{}, error) {
+			return toStr(e, a[0]), nil
+		}},
+		"toNumber": {1, 1, "toNumber(x) x as a number", func(e *env, a []interface{}) (interface{}, error) {
+			return toNum(a[0])
+		}},
+
+		// regular expressions
+		"match": {2, 2, "match(s, re) true if the regular expression re matches s", func(e *env, a []interface{}) (interface{}, error) {
+			re, err := compileRe(toStr(e, a[1]))
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(toStr(e, a[0])), nil
+		}},
+		"extract": {2, 3, "extract(s, re, n) capture group n (default 1; the match, if 0 or re has no groups) of re in s",
+			func(e *env, a []interface{}) (interface{}, error) {
+				re, err := compileRe(toStr(e, a[1]))
+				if err != nil {
+					return nil, err
+				}
+				group := 1
+				if len(a) == 3 {
+					n, err := toNum(a[2])
+					if err != nil {
+						return nil, err
+					}
+					group = int(n)
+				}
+				m := re.FindStringSubmatch(toStr(e, a[0]))
+				switch {
+				case len(m) == 0:
+					return "", nil
+				case len(m) == 1:
+					return m[0], nil
+				case group < 0 || group >= len(m):
+					return nil, fmt.Errorf("%s has no group %d", a[1], group)
+				default:
+					return m[group], nil
+				}
+			}},
+		"regexReplace": {3, 3, "regexReplace(s, re, new) replaces matches of re in s with new ($1 refers to a group)",
+			func(e *env, a []interface{}) (interface{}, error) {
+				re, err := compileRe(toStr(e, a[1]))
+				if err != nil {
+					return nil, err
+				}
+				return re.ReplaceAllString(toStr(e, a[0]), toStr(e, a[2])), nil
+			}},
+
+		// dates
+		"today": {0, 0, "today() the current date", func(e *env, a []interface{}) (interface{}, error) {
+			y, m, d := now(e).Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), nil
+		}},
+		"now": {0, 0, "now() the current time", func(e *env, a []interface{}) (interface{}, error) {
+			return now(e), nil
+		}},
+		"date": {1, 2, "date(s, format) parses s as a date using format (default: -dateFormat)", func(e *env, a []interface{}) (interface{}, error) {
+			format := e.dateFmt
+			if len(a) == 2 {
+				format = toStr(e, a[1])
+			}
+			if t, ok := a[0].(time.Time); ok {
+				return t, nil
+			}
+			return time.Parse(format, strings.TrimSpace(toStr(e, a[0])))
+		}},
+		"formatDate": {2, 2, "formatDate(d, format) formats the date d using format, e.g. 2006-01-02", func(e *env, a []interface{}) (interface{}, error) {
+			t, err := toTime(e, a[0])
+			if err != nil {
+				return nil, err
+			}
+			return t.Format(toStr(e, a[1])), nil
+		}},
+		"addDays": {2, 2, "addDays(d, n) adds n days to the date d", func(e *env, a []interface{}) (interface{}, error) {
+			t, err := toTime(e, a[0])
+			if err != nil {
+				return nil, err
+			}
+			n, err := toNum(a[1])
+			if err != nil {
+				return nil, err
+			}
+			return t.AddDate(0, 0, int(n)), nil
+		}},
+		"addMonths": {2, 2, "addMonths(d, n) adds n months to the date d", func(e *env, a []interface{}) (interface{}, error) {
+			t, err := toTime(e, a[0])
+			if err != nil {
+				return nil, err
+			}
+			n, err := toNum(a[1])
+			if err != nil {
+				return nil, err
+			}
+			return t.AddDate(0, int(n), 0), nil
+		}},
+		"year": {1, 1, "year(d) the year of the date d", func(e *env, a []interface{}) (interface{}, error) {
+			t, err := toTime(e, a[0])
+			return float64(t.Year()), err
+		}},
+		"month": {1, 1, "month(d) the month (1-12) of the date d", func(e *env, a []interface{}) (interface{}, error) {
+			t, err := toTime(e, a[0])
+			return float64(t.Month()), err
+		}},
+		"day": {1, 1, "day(d) the day of the month of the date d", func(e *env, a []interface{}) (interface{}, error) {
+			t, err := toTime(e, a[0])
+			return float64(t.Day()), err
+		}},
+
+		// math
+		"abs":   {1, 1, "abs(x) absolute value", mathFunc(math.Abs)},
+		"floor": {1, 1, "floor(x) largest integer <= x", mathFunc(math.Floor)},
+		"ceil":  {1, 1, "ceil(x) smallest integer >= x", mathFunc(math.Ceil)},
+		"sqrt":  {1, 1, "sqrt(x) square root", mathFunc(math.Sqrt)},
+		"log":   {1, 1, "log(x) natural logarithm", mathFunc(math.Log)},
+		"exp":   {1, 1, "exp(x) e to the x", mathFunc(math.Exp)},
+		"round": {1, 2, "round(x, n) x rounded to n decimal places (default 0)", func(e *env, a []interface{}) (interface{}, error) {
+			x, err := toNum(a[0])
+			if err != nil {
+				return nil, err
+			}
+			n := 0.0
+			if len(a) == 2 {
+				if n, err = toNum(a[1]); err != nil {
+					return nil, err
+				}
+			}
+			p := math.Pow(10, n)
+			return math.Round(x*p) / p, nil
+		}},
+		"pow": {2, 2, "pow(x, y) x to the y", func(e *env, a []interface{}) (interface{}, error) {
+			x, err := toNum(a[0])
+			if err != nil {
+				return nil, err
+			}
+			y, err := toNum(a[1])
+			if err != nil {
+				return nil, err
+			}
+			return math.Pow(x, y), nil
+		}},
+		"min": {1, -1, "min(x1, x2, ...) smallest of the arguments", func(e *env, a []interface{}) (interface{}, error) {
+			return extreme(e, a, -1)
+		}},
+		"max": {1, -1, "max(x1, x2, ...) largest of the arguments", func(e *env, a []interface{}) (interface{}, error) {
+			return extreme(e, a, 1)
+		}},
+
+		// logic
+		"if": {3, 3, "if(cond, a, b) a if cond is true, otherwise b", func(e *env, a []interface{}) (interface{}, error) {
+			c, err := toBool(a[0])
+			if err != nil {
+				return nil, err
+			}
+			if c {
+				return a[1], nil
+			}
+			return a[2], nil
+		}},
+		"coalesce": {1, -1, "coalesce(x1, x2, ...) the first argument that isn't null or empty", func(e *env, a []interface{}) (interface{}, error) {
+			for _, x := range a {
+				if x != nil && toStr(e, x) != "" {
+					return x, nil
+				}
+			}
+			return nil, nil
+		}},
+	}
+}
+
+// listFunctions returns the documentation of the functions available to expressions
+func listFunctions() []string {
+	docs := make([]string, 0, len(functions))
+	for _, f := range functions {
+		docs = append(docs, f.doc)
+	}
+	sort.Strings(docs)
+	return docs
+}
+
+// mathFunc makes a function of one numeric argument from f
+func mathFunc(f func(float64) float64) func(e *env, a []interface{}) (interface{}, error) {
+	return func(e *env, a []interface{}) (interface{}, error) {
+		x, err := toNum(a[0])
+		if err != nil {
+			return nil, err
+		}
+		return f(x), nil
+	}
+}
+
+// extreme returns the min (sign=-1) or max (sign=1) of a
+func extreme(e *env, a []interface{}, sign int) (interface{}, error) {
+	best := a[0]
+	for _, x := range a[1:] {
+		c, err := compare(e, x, best)
+		if err != nil {
+			return nil, err
+		}
+		if c*sign > 0 {
+			best = x
+		}
+	}
+	return best, nil
+}
+
+// now returns the time the expression is evaluated.  It can be pinned by the variable "now".
+func now(e *env) time.Time {
+	if t, ok := e.vars["now"].(time.Time); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// regexes is a cache of compiled regular expressions.  The expressions of the files of a -file-workers load
+// are evaluated at the same time, so it is guarded by regexMu.
+var (
+	regexes = make(map[string]*regexp.Regexp)
+	regexMu sync.Mutex
+)
+
+// compileRe compiles re, using the cache
+func compileRe(re string) (*regexp.Regexp, error) {
+	regexMu.Lock()
+	defer regexMu.Unlock()
+	if r, ok := regexes[re]; ok {
+		return r, nil
+	}
+	r, err := regexp.Compile(re)
+	if err != nil {
+		return nil, err
+	}
+	regexes[re] = r
+	return r, nil
+}
+
+// toStr converts v to a string.  Dates are formatted using the data's date format.
+func toStr(e *env, v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32)
+	case time.Time:
+		if x.Hour() == 0 && x.Minute() == 0 && x.Second() == 0 && x.Nanosecond() == 0 {
+			return x.Format(e.dateFmt)
+		}
+		return x.Format("2006-01-02 15:04:05")
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// toNum converts v to a number.  v may be a number of any width, as convert gives the values of Int and Float
+// fields, a boolean or a string.
+func toNum(v interface{}) (float64, error) {
+	if f, ok := asFloat64(v); ok {
+		return f, nil
+	}
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", x)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("%v is not a number", v)
+	}
+}
+
+// toTime converts v to a date
+func toTime(e *env, v interface{}) (time.Time, error) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, nil
+	case string:
+		x = strings.TrimSpace(x)
+		for _, format := range []string{e.dateFmt, "2006-01-02", "2006-01-02 15:04:05", time.RFC3339} {
+			if t, err := time.Parse(format, x); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("%q is not a date", x)
+	default:
+		return time.Time{}, fmt.Errorf("%v is not a date", v)
+	}
+}
+
+// toBool converts v to a boolean.  A number, of any width, is true if it isn't 0.
+func toBool(v interface{}) (bool, error) {
+	if f, ok := asFloat64(v); ok {
+		return f != 0, nil
+	}
+	switch x := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return x, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(x))
+		if err != nil {
+			return false, fmt.Errorf("%q is not true/false", x)
+		}
+		return b, nil
+	default:
+		return false, fmt.Errorf("%v is not true/false", v)
+	}
+}
+
+// compare returns -1, 0, 1 as a is less than, equal to or greater than b.  Dates are compared as dates.  If
+// either side is a number, of any width, both are compared as numbers, and it is an error if the other side
+// isn't one.  Two strings that are numbers are compared as numbers, and other strings, and null, as strings.
+func compare(e *env, a, b interface{}) (int, error) {
+	_, at := a.(time.Time)
+	_, bt := b.(time.Time)
+	if at || bt {
+		ta, err := toTime(e, a)
+		if err != nil {
+			return 0, err
+		}
+		tb, err := toTime(e, b)
+		if err != nil {
+			return 0, err
+		}
+		return ta.Compare(tb), nil
+	}
+	if a != nil && b != nil {
+		_, an := asFloat64(a)
+		_, bn := asFloat64(b)
+		na, ea := toNum(a)
+		nb, eb := toNum(b)
+		switch {
+		case (an || bn) && ea != nil:
+			return 0, fmt.Errorf("can't compare %v with the number %v", a, b)
+		case (an || bn) && eb != nil:
+			return 0, fmt.Errorf("can't compare %v with the number %v", b, a)
+		case ea == nil && eb == nil:
+			switch {
+			case na < nb:
+				return -1, nil
+			case na > nb:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return strings.Compare(toStr(e, a), toStr(e, b)), nil
+}
+
+// AST nodes
+
+type literal struct{ val interface{} }
+
+type field struct{ name string }
+
+type template struct{ name string }
+
+type unary struct {
+	op string
+	x  expr
+}
+
+type binary struct {
+	op   string
+	l, r expr
+}
+
+type call struct {
+	name string
+	fn   *function
+	args []expr
+}
+
+func (l *literal) eval(e *env) (interface{}, error) { return l.val, nil }
+
+func (f *field) eval(e *env) (interface{}, error) { return e.lookup(f.name) }
+
+func (t *template) eval(e *env) (interface{}, error) {
+	if name, ok := strings.CutPrefix(t.name, "env:"); ok {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	}
+	v, ok := e.vars[t.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template {%s}", t.name)
+	}
+	return v, nil
+}
+
+func (u *unary) eval(e *env) (interface{}, error) {
+	x, err := u.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if u.op == "-" {
+		n, err := toNum(x)
+		return -n, err
+	}
+	b, err := toBool(x)
+	return !b, err
+}
+
+func (b *binary) eval(e *env) (interface{}, error) {
+	l, err := b.l.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	// short-circuit the logical operators
+	switch b.op {
+	case "and", "or":
+		lb, err := toBool(l)
+		if err != nil {
+			return nil, err
+		}
+		if (b.op == "and" && !lb) || (b.op == "or" && lb) {
+			return lb, nil
+		}
+		r, err := b.r.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		return toBool(r)
+	}
+
+	r, err := b.r.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		c, err := compare(e, l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch b.op {
+		case "=":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+
+	// date arithmetic
+	if lt, ok := l.(time.Time); ok {
+		if rt, ok := r.(time.Time); ok && b.op == "-" {
+			return math.Round(lt.Sub(rt).Hours() / 24), nil
+		}
+		n, err := toNum(r)
+		if err != nil {
+			return nil, err
+		}
+		switch b.op {
+		case "+":
+			return lt.AddDate(0, 0, int(n)), nil
+		case "-":
+			return lt.AddDate(0, 0, -int(n)), nil
+		}
+	}
+
+	ln, err := toNum(l)
+	if err != nil {
+		return nil, err
+	}
+	rn, err := toNum(r)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "+":
+		return ln + rn, nil
+	case "-":
+		return ln - rn, nil
+	case "*":
+		return ln * rn, nil
+	case "/", "%":
+		if rn == 0 {
+			return nil, fmt.Errorf("%v %s 0: division by zero", ln, b.op)
+		}
+		if b.op == "/" {
+			return ln / rn, nil
+		}
+		return math.Mod(ln, rn), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", b.op)
+}
+
+func (c *call) eval(e *env) (interface{}, error) {
+	args := make([]interface{}, len(c.args))
+	for ind, a := range c.args {
+		v, err := a.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		args[ind] = v
+	}
+	v, err := c.fn.f(e, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", c.name, err)
+	}
+	return v, nil
+}
+
+// parsing
+
+// token kinds
+const (
+	tEOF = iota
+	tNum
+	tStr
+	tIdent
+	tOp
+	tTemplate
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+// lex splits src into tokens
+func lex(src string) ([]token, error) {
+	toks := make([]token, 0)
+	rs := []rune(src)
+	for ind := 0; ind < len(rs); {
+		r := rs[ind]
+		switch {
+		case unicode.IsSpace(r):
+			ind++
+		case unicode.IsDigit(r) || (r == '.' && ind+1 < len(rs) && unicode.IsDigit(rs[ind+1])):
+			st := ind
+			for ind < len(rs) && (unicode.IsDigit(rs[ind]) || rs[ind] == '.' || rs[ind] == 'e' || rs[ind] == 'E' ||
+				((rs[ind] == '-' || rs[ind] == '+') && (rs[ind-1] == 'e' || rs[ind-1] == 'E'))) {
+				ind++
+			}
+			toks = append(toks, token{tNum, string(rs[st:ind])})
+		case r == '{':
+			st := ind + 1
+			for ind < len(rs) && rs[ind] != '}' {
+				ind++
+			}
+			if ind == len(rs) {
+				return nil, fmt.Errorf("unterminated { in %s", src)
+			}
+			toks = append(toks, token{tTemplate, strings.TrimSpace(string(rs[st:ind]))})
+			ind++
+		case r == '\'' || r == '"' || r == '`':
+			val, end, err := quoted(rs, ind)
+			if err != nil {
+				return nil, fmt.Errorf("%v in %s", err, src)
+			}
+			kind := tStr
+			if r == '`' {
+				kind = tIdent
+			}
+			toks = append(toks, token{kind, val})
+			ind = end
+		case unicode.IsLetter(r) || r == '_':
+			st := ind
+			for ind < len(rs) && (unicode.IsLetter(rs[ind]) || unicode.IsDigit(rs[ind]) || rs[ind] == '_' || rs[ind] == '.') {
+				ind++
+			}
+			toks = append(toks, token{tIdent, string(rs[st:ind])})
+		default:
+			op := string(r)
+			if ind+1 < len(rs) {
+				switch two := string(rs[ind : ind+2]); two {
+				case "==", "!=", "<>", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+			if len(op) == 1 && !strings.Contains("+-*/%=!<>(),", op) {
+				return nil, fmt.Errorf("unexpected character %q in %s", r, src)
+			}
+			ind += len(op)
+			toks = append(toks, token{tOp, op})
+		}
+	}
+	return append(toks, token{kind: tEOF}), nil
+}
+
+// quoted returns the value of the quoted string starting at rs[st] and the index after its closing quote.  The
+// quote is put in a string by doubling it or with \, and \\ is a backslash.  Other backslashes are kept, so
+// regular expressions such as '\d+' need no escaping.
+func quoted(rs []rune, st int) (string, int, error) {
+	q := rs[st]
+	val := make([]rune, 0)
+	for ind := st + 1; ind < len(rs); ind++ {
+		switch {
+		case rs[ind] == '\\' && ind+1 < len(rs) && (rs[ind+1] == q || rs[ind+1] == '\\'):
+			ind++
+		case rs[ind] == q && ind+1 < len(rs) && rs[ind+1] == q:
+			ind++
+		case rs[ind] == q:
+			return string(val), ind + 1, nil
+		}
+		val = append(val, rs[ind])
+	}
+	return "", 0, fmt.Errorf("unterminated quote")
+}
+
+// parser is a precedence-climbing parser of expressions
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// parseExpr compiles src
+func parseExpr(src string) (expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	x, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tEOF {
+		return nil, fmt.Errorf("unexpected %q in %s", p.peek().text, src)
+	}
+	return x, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tEOF {
+		p.pos++
+	}
+	return t
+}
+
+// binaryOp returns the normalized operator and its precedence if t is a binary operator
+func binaryOp(t token) (string, int) {
+	op := t.text
+	if t.kind == tIdent {
+		op = strings.ToLower(op)
+	} else if t.kind != tOp {
+		return "", -1
+	}
+	switch op {
+	case "or", "||":
+		return "or", 1
+	case "and", "&&":
+		return "and", 2
+	case "=", "==":
+		return "=", 4
+	case "!=", "<>":
+		return "!=", 4
+	case "<", "<=", ">", ">=":
+		return op, 4
+	case "+", "-":
+		return op, 5
+	case "*", "/", "%":
+		return op, 6
+	}
+	return "", -1
+}
+
+// expr parses a sequence of binary operations whose operators have precedence at least minPrec
+func (p *parser) expr(minPrec int) (expr, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, prec := binaryOp(p.peek())
+		if prec < 0 || prec < minPrec {
+			return l, nil
+		}
+		p.next()
+		r, err := p.expr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{op: op, l: l, r: r}
+	}
+}
+
+// unary parses a unary operation or a primary
+func (p *parser) unary() (expr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tOp && t.text == "-":
+		p.next()
+		x, err := p.expr(7)
+		return &unary{op: "-", x: x}, err
+	case (t.kind == tOp && t.text == "!") || (t.kind == tIdent && strings.ToLower(t.text) == "not"):
+		p.next()
+		x, err := p.expr(3)
+		return &unary{op: "not", x: x}, err
+	}
+	return p.primary()
+}
+
+// primary parses a literal, field, function call or parenthesized expression
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tNum:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %s", t.text)
+		}
+		return &literal{f}, nil
+	case tStr:
+		return &literal{t.text}, nil
+	case tTemplate:
+		return &template{t.text}, nil
+	case tIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return &literal{true}, nil
+		case "false":
+			return &literal{false}, nil
+		case "null":
+			return &literal{nil}, nil
+		}
+		if nt := p.peek(); nt.kind == tOp && nt.text == "(" {
+			return p.call(t.text)
+		}
+		return &field{t.text}, nil
+	case tOp:
+		if t.text == "(" {
+			x, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			if c := p.next(); c.text != ")" {
+				return nil, fmt.Errorf("missing )")
+			}
+			return x, nil
+		}
+	}
+	if t.kind == tEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+// call parses the arguments of the function name
+func (p *parser) call(name string) (expr, error) {
+	fn, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	p.next() // (
+	args := make([]expr, 0)
+	if t := p.peek(); !(t.kind == tOp && t.text == ")") {
+		for {
+			a, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if t := p.peek(); t.kind == tOp && t.text == "," {
+				p.next()
+				continue
+			}
+			break
+		}
+	}
+	if t := p.next(); t.text != ")" {
+		return nil, fmt.Errorf("missing ) in call to %s", name)
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments to %s: %s", name, fn.doc)
+	}
+	return &call{name: name, fn: fn, args: args}, nil
+}
+
+// exprCmd runs the command "toch expr".  With -list-functions, it lists the functions available to
+// expressions.  Otherwise, it evaluates each argument as an expression and prints the result.
+func exprCmd(args []string) error {
+	fs := flag.NewFlagSet("expr", flag.ExitOnError)
+	listPtr := fs.Bool("list-functions", false, "bool")
+	datePtr := fs.String("dateFormat", "1/2/2006", "string")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *listPtr {
+		for _, doc := range listFunctions() {
+			fmt.Println(doc)
+		}
+		return nil
+	}
+
+	e := &env{dateFmt: *datePtr}
+	for _, src := range fs.Args() {
+		x, err := parseExpr(src)
+		if err != nil {
+			return err
+		}
+		v, err := x.eval(e)
+		if err != nil {
+			return err
+		}
+		fmt.Println(toStr(e, v))
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWidths checks the expressions on the values convert gives Int and Float fields, of every width
+func TestWidths(t *testing.T) {
+	e := &env{cols: map[string]int{"x": 0}, dateFmt: "2006-01-02"}
+	values := []interface{}{int8(10), int16(10), int32(10), int64(10), int(10), uint8(10), uint16(10), uint32(10),
+		uint64(10), float32(10), float64(10), "10"}
+	for _, v := range values {
+		e.row = []interface{}{v}
+		for src, want := range map[string]bool{"x > 9": true, "x < 9": false, "x = 10": true, "x + 1 = 11": true,
+			"x > '9'": true, "not (x = 10)": false} {
+			x, err := parseExpr(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := x.eval(e)
+			if err != nil {
+				t.Errorf("%s with x %T: %v", src, v, err)
+				continue
+			}
+			if got != want {
+				t.Errorf("%s with x %T(%v) = %v, want %v", src, v, v, got, want)
+			}
+		}
+	}
+
+	// a number can't be compared with a value that isn't one
+	e.row = []interface{}{int32(10)}
+	x, _ := parseExpr("x > 'abc'")
+	if _, err := x.eval(e); err == nil {
+		t.Errorf("x > 'abc' with x int32: no error")
+	}
+	// two strings that aren't numbers compare as strings
+	e.row = []interface{}{"b"}
+	x, _ = parseExpr("x > 'a'")
+	if got, err := x.eval(e); err != nil || got != true {
+		t.Errorf("'b' > 'a' = %v, %v", got, err)
+	}
+}
+
+// TestRegexConcurrent evaluates the regular expression functions from many goroutines, as the files of a
+// -file-workers load do.  Run with -race.
+func TestRegexConcurrent(t *testing.T) {
+	x, err := parseExpr("match(s, concat('^a', n)) and extract(s, '(b+)') = regexReplace(s, concat('^a', n), '')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	for w := 0; w < 8; w++ {
+		go func(w int) {
+			var err error
+			for ind := 0; ind < 200 && err == nil; ind++ {
+				// a new pattern each time, so the cache is written to
+				n := fmt.Sprint(w*1000 + ind)
+				e := &env{cols: map[string]int{"s": 0, "n": 1}, row: []interface{}{"a" + n + "bb", n}}
+				var v interface{}
+				if v, err = x.eval(e); err == nil && v != true {
+					err = fmt.Errorf("%v: got %v", e.row, v)
+				}
+			}
+			done <- err
+		}(w)
+	}
+	for w := 0; w < 8; w++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestExpr(t *testing.T) {
+	t.Setenv("TOCH_TEST_DATE", "2024-06-30")
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	e := &env{cols: map[string]int{"x": 0, "s": 1, "d": 2, "empty": 3, "odd name": 4, "short": 9},
+		row: []interface{}{"10", "ab", "2024-05-06", "", "7"}, dateFmt: "2006-01-02",
+		vars: map[string]interface{}{"run_id": "r1", "now": day("2024-01-02")}}
+	tests := []struct {
+		src  string
+		want interface{}
+		err  string // part of the error, if one is expected
+	}{
+		// precedence and associativity
+		{src: "1 + 2 * 3", want: 7.0},
+		{src: "(1 + 2) * 3", want: 9.0},
+		{src: "10 - 4 - 3", want: 3.0},
+		{src: "2 * 3 % 4", want: 2.0},
+		{src: "-2 * 3", want: -6.0},
+		{src: "- 2 + 3", want: 1.0},
+		{src: "2 - -1", want: 3.0},
+		{src: "1 + 2 = 3", want: true},
+		{src: "1 < 2 and 3 > 4 or true", want: true},
+		{src: "true or false and false", want: true},
+		{src: "not 1 = 2", want: true},
+		{src: "not true and false", want: false},
+		{src: "!(1 = 1) || 2 >= 2 && 1 <> 2", want: true},
+
+		// types: fields are strings, converted as needed
+		{src: "x + 5", want: 15.0},
+		{src: "x > 9", want: true},
+		{src: "s = \"ab\"", want: true},
+		{src: "s < 'b'", want: true},
+		{src: "`odd name` * 2", want: 14.0},
+		{src: "concat(s, 1, true)", want: "ab1true"},
+		{src: "length('héllo')", want: 5.0},
+		{src: "substr('abcdef', 2, 3)", want: "bcd"},
+		{src: "substr('abc', 2)", want: "bc"},
+		{src: "upper(s)", want: "AB"},
+		{src: "replace('a-b-c', '-', '')", want: "abc"},
+		{src: "toNumber('1e3')", want: 1000.0},
+		{src: "toString(1.5)", want: "1.5"},
+		{src: "if(x > 1, 'big', 'small')", want: "big"},
+		{src: "min(3, 1, 2)", want: 1.0},
+		{src: "max('b', 'a')", want: "b"},
+		{src: "round(2.5)", want: 3.0},
+		{src: "pow(2, 10)", want: 1024.0},
+		{src: "match('abc123', '[0-9]+$')", want: true},
+		{src: "extract('key=val', '(\\w+)=(\\w+)', 2)", want: "val"},
+		{src: "extract('abc', 'x(y)')", want: ""},
+		{src: "regexReplace('a-b-c', '-', '_')", want: "a_b_c"},
+
+		// dates
+		{src: "year(d)", want: 2024.0},
+		{src: "month(d) * 100 + day(d)", want: 506.0},
+		{src: "date(d) + 1", want: day("2024-05-07")},
+		{src: "date('2024-03-01') - date('2024-02-01')", want: 29.0},
+		{src: "formatDate(addMonths(date('31/01/2024', '02/01/2006'), 1), '2006-01-02')", want: "2024-03-02"},
+		{src: "date(d) > date('2024-01-01')", want: true},
+		{src: "today()", want: day("2024-01-02")},
+
+		// run metadata
+		{src: "{run_id}", want: "r1"},
+		{src: "date({env:TOCH_TEST_DATE}) - date('2024-06-01')", want: 29.0},
+
+		// nulls
+		{src: "null", want: nil},
+		{src: "short", want: nil},
+		{src: "isEmpty(null)", want: true},
+		{src: "isEmpty(empty) and not isEmpty(s)", want: true},
+		{src: "empty = null", want: true},
+		{src: "coalesce(null, empty, 'z')", want: "z"},
+		{src: "'O''Brien'", want: "O'Brien"},
+		{src: `'O\'Brien'`, want: "O'Brien"},
+		{src: `"say ""hi"""`, want: `say "hi"`},
+		{src: `'a\\b'`, want: `a\b`},
+		{src: `length('\d+')`, want: 3.0},
+		{src: "null and true", want: false},
+		{src: "null or true", want: true},
+
+		// errors
+		{src: "null + 1", err: "not a number"},
+		{src: "'abc' * 2", err: "not a number"},
+		{src: "1 > 'abc'", err: "can't compare"},
+		{src: "x > 'abc'", want: false},
+		{src: "nope", err: "unknown field nope"},
+		{src: "{nope}", err: "unknown template"},
+		{src: "{env:TOCH_TEST_UNSET}", err: "not set"},
+		{src: "extract('ab', '(a)(b)', 5)", err: "no group 5"},
+		{src: "match('a', '(')", err: "missing closing )"},
+		{src: "s and true", err: "not true/false"},
+		{src: "year('soon')", err: "not a date"},
+		{src: "x / 0", err: "division by zero"},
+		{src: "x % (1 - 1)", err: "division by zero"},
+	}
+	for _, tt := range tests {
+		x, err := parseExpr(tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		got, err := x.eval(e)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: got %v, %v; want an error with %q", tt.src, got, err, tt.err)
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.src, err)
+		case tt.err == "" && got != tt.want:
+			t.Errorf("%s = %v (%T), want %v (%T)", tt.src, got, got, tt.want, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"1 +":       "unexpected end",
+		"(1 + 2":    "missing )",
+		"1 2":       "unexpected \"2\"",
+		"foo(1)":    "unknown function foo",
+		"upper()":   "wrong number of arguments",
+		"if(1, 2)":  "wrong number of arguments",
+		"'abc":      "unterminated quote",
+		"{run_id":   "unterminated {",
+		"1 $ 2":     "unexpected character",
+		"concat(1,": "unexpected end",
+	} {
+		if _, err := parseExpr(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want an error with %q", src, err, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// parseExtract parses -extract: items 'names=field:/re/' separated by ;.  Each of the comma-separated names
+// is a field added to the table holding a capture group of the regular expression re in the source field
+// field, the first name the first group and so on.  A single name of a re without groups holds the match.
+// A value that doesn't match gives empty fields.  A / inside re is written \/.  The fields are returned as
+// -derive assignments of extract(field, re, n).
+func parseExtract(list string) ([]assignment, error) {
+	as := make([]assignment, 0)
+	for rest := strings.TrimSpace(list); rest != ""; rest = strings.TrimSpace(rest) {
+		names, afterNames, ok := strings.Cut(rest, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected names=field:/re/, got %s", rest)
+		}
+		src, afterSrc, ok := strings.Cut(afterNames, ":")
+		afterSrc = strings.TrimSpace(afterSrc)
+		if !ok || !strings.HasPrefix(afterSrc, "/") {
+			return nil, fmt.Errorf("expected names=field:/re/, got %s", rest)
+		}
+		pattern, after, ok := closingSlash(afterSrc[1:])
+		if !ok {
+			return nil, fmt.Errorf("the regular expression of %s isn't closed by /", rest)
+		}
+		after = strings.TrimSpace(after)
+		if after != "" && !strings.HasPrefix(after, ";") {
+			return nil, fmt.Errorf("expected ; after the regular expression of %s", rest)
+		}
+		rest = strings.TrimPrefix(after, ";")
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		fields := splitList(names)
+		src = strings.Trim(strings.TrimSpace(src), "`")
+		if len(fields) == 0 || src == "" {
+			return nil, fmt.Errorf("expected names=field:/re/ with a field and at least one name")
+		}
+		if groups := re.NumSubexp(); len(fields) > max(groups, 1) {
+			return nil, fmt.Errorf("/%s/ has %d groups for the %d fields %s", pattern, groups, len(fields), names)
+		}
+		for ind, name := range fields {
+			as = append(as, assignment{name: strings.Trim(name, "`"),
+				x: &call{name: "extract", fn: functions["extract"],
+					args: []expr{&field{src}, &literal{pattern}, &literal{float64(ind + 1)}}}})
+		}
+	}
+	return as, nil
+}
+
+// closingSlash splits s at the first / that isn't escaped, returning what comes before it, with \/ unescaped,
+// and what comes after it.  ok is false if there is no such /.
+func closingSlash(s string) (before, after string, ok bool) {
+	var b strings.Builder
+	for ind := 0; ind < len(s); ind++ {
+		switch {
+		case s[ind] == '\\' && ind+1 < len(s) && s[ind+1] == '/':
+			b.WriteByte('/')
+			ind++
+		case s[ind] == '/':
+			return b.String(), s[ind+1:], true
+		default:
+			b.WriteByte(s[ind])
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/invertedv/chutils"
+)
+
+// isFIFO returns true if source is a named pipe
+func isFIFO(source string) bool {
+	info, err := os.Stat(source)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// spoolFIFO copies what is written to the named pipe source, until its writer closes it, to a temporary file and
+// returns its name.  Like stdin, a pipe can only be read once, and the source is read more than once.  The file
+// has the extension of sType.  The caller removes the file.
+func spoolFIFO(source, sType string) (string, error) {
+	f, err := os.Open(source)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	tmp, err := os.CreateTemp("", "toch-fifo-*."+sType)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(tmp, f)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("reading %s: %v", source, err)
+	}
+	fmt.Printf("%s: %d bytes read\n", source, n)
+	return tmp.Name(), nil
+}
+
+// fifoOpts are the options of -reopen-on-eof
+type fifoOpts struct {
+	sType  string
+	header bool // the data of each writer starts with a header row
+	batch  int  // lines loaded at a time, 0 to load when the writer closes the pipe
+}
+
+// fifoLoop runs until it is interrupted, loading what the writers of the named pipe j.source write into j.table.
+// Each time a writer closes the pipe, what it wrote is loaded and the pipe is opened again for the next writer.
+// With opts.batch, a writer's lines are also loaded every opts.batch lines; the header row, if any, is repeated
+// at the top of each batch.  A batch is appended to the table, which the first batch creates if it doesn't
+// exist.  A batch that fails to load is reported and skipped.
+func fifoLoop(j *job, opts *fifoOpts, con *chutils.Connect) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	fmt.Printf("reading %s until interrupted\n", j.source)
+	sdReady(ctx, "reading "+j.source)
+
+	loaded, failed, rows := 0, 0, 0
+	load := func(tmp string) {
+		defer func() { _ = os.Remove(tmp) }()
+		res := watchLoad(j, tmp, con)
+		res.source = j.source
+		if res.err != nil {
+			failed++
+			fmt.Printf("%s: batch failed: %v\n", j.source, res.err)
+		} else {
+			loaded, rows = loaded+1, rows+res.rows
+			fmt.Printf("%s: %d rows loaded into %s in %0.1f seconds\n", j.source, res.rows, j.table, res.secs)
+		}
+		if j.journal {
+			journalLoad(res, j.table, j.runID)
+		}
+		sdNotify(fmt.Sprintf("STATUS=reading %s: %d batches, %d rows loaded, %d batches failed", j.source, loaded, rows, failed))
+	}
+	for {
+		// opening a pipe waits for a writer, so it's done apart from the wait for an interrupt
+		opened := make(chan *os.File, 1)
+		errs := make(chan error, 1)
+		go func() {
+			f, err := os.Open(j.source)
+			if err != nil {
+				errs <- err
+				return
+			}
+			opened <- f
+		}()
+		var f *os.File
+		select {
+		case <-ctx.Done():
+			fmt.Printf("stopped reading %s: %d batches, %d rows loaded, %d batches failed\n", j.source, loaded, rows, failed)
+			return nil
+		case err := <-errs:
+			return err
+		case f = <-opened:
+		}
+
+		err := fifoBatches(f, opts, load)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fifoBatches reads r, a writer's data, to its end, calling load with a temporary file of each batch of lines.
+// load removes the file.
+func fifoBatches(r io.Reader, opts *fifoOpts, load func(tmp string)) error {
+	br := bufio.NewReader(r)
+	var header []byte
+	for first := true; ; first = false {
+		tmp, err := os.CreateTemp("", "toch-fifo-*."+opts.sType)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(tmp)
+		if !first {
+			_, _ = w.Write(header)
+		}
+		lines, eof := 0, false
+		for opts.batch == 0 || lines < opts.batch {
+			line, e := br.ReadBytes('\n')
+			if len(line) > 0 {
+				if first && opts.header && header == nil {
+					header = line
+				} else {
+					lines++
+				}
+				if _, we := w.Write(line); we != nil {
+					e = we
+				}
+			}
+			if e == io.EOF {
+				eof = true
+				break
+			}
+			if e != nil {
+				_ = tmp.Close()
+				_ = os.Remove(tmp.Name())
+				return e
+			}
+		}
+		err = w.Flush()
+		if e := tmp.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+		if lines == 0 {
+			_ = os.Remove(tmp.Name())
+		} else {
+			load(tmp.Name())
+		}
+		if eof {
+			return nil
+		}
+	}
+}
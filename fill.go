@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// fill is an item of -fill: the value of the source field name is replaced with x when the condition when is
+// true
+type fill struct {
+	name string
+	x    expr
+	when expr
+}
+
+// whenRe matches the condition of a -fill item
+var whenRe = regexp.MustCompile(`(?i)\s+when\s+`)
+
+// parseFills parses -fill: comma-separated items name=expr [when cond].  cond is "empty", the default, or an
+// expression.  The items are applied in order.
+func parseFills(list string) ([]fill, error) {
+	fs := make([]fill, 0)
+	for _, item := range splitOutside(list, ',') {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		m := assignRe.FindStringSubmatch(item)
+		if m == nil {
+			return nil, fmt.Errorf("expected name=value [when cond], got %s", item)
+		}
+		f := fill{name: strings.Trim(m[1], "`")}
+		value, cond := m[2], "empty"
+		if locs := whenRe.FindAllStringIndex(m[2], -1); len(locs) > 0 {
+			last := locs[len(locs)-1]
+			value, cond = m[2][:last[0]], m[2][last[1]:]
+		}
+		var err error
+		if f.x, err = parseExpr(value); err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(strings.TrimSpace(cond), "empty") {
+			f.when = &call{name: "isEmpty", fn: functions["isEmpty"], args: []expr{&field{f.name}}}
+		} else if f.when, err = parseExpr(cond); err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// fillStep returns the step that applies fs to the first nSrc fields of the rows of p
+func (p *pipeline) fillStep(fs []fill) (step, error) {
+	cols := make([]int, len(fs))
+	for ind, f := range fs {
+		col, ok := p.env.cols[f.name]
+		if !ok || col >= p.nSrc {
+			return nil, fmt.Errorf("-fill field %s is not in the source", f.name)
+		}
+		cols[ind] = col
+	}
+	return func(row []interface{}) (bool, error) {
+		for ind, f := range fs {
+			w, err := f.when.eval(p.env)
+			if err != nil {
+				return false, err
+			}
+			ok, err := toBool(w)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				continue
+			}
+			v, err := f.x.eval(p.env)
+			if err != nil {
+				return false, err
+			}
+			row[cols[ind]] = toStr(p.env, v)
+		}
+		return true, nil
+	}, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFill applies -fill items, in order, to rows of name, state, qty
+func TestFill(t *testing.T) {
+	fs, err := parseFills("state='NA', qty=0 when qty < 0, name=concat(state, '-', qty) when isEmpty(name)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &pipeline{nSrc: 3, env: &env{cols: map[string]int{"name": 0, "state": 1, "qty": 2, "total": 3}}}
+	st, err := p.fillStep(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in, want := range map[string]string{
+		"a|CA|5":  "a|CA|5",
+		"b||5":    "b|NA|5",
+		"c|  |-2": "c|NA|0", // blanks are empty
+		"||-1":    "NA-0|NA|0",
+	} {
+		row := make([]interface{}, 0)
+		for _, v := range strings.Split(in, "|") {
+			row = append(row, v)
+		}
+		p.env.row = row
+		if _, e := st(row); e != nil {
+			t.Errorf("%s: %v", in, e)
+			continue
+		}
+		got := make([]string, len(row))
+		for ind, v := range row {
+			got[ind] = v.(string)
+		}
+		if strings.Join(got, "|") != want {
+			t.Errorf("%s filled to %s, want %s", in, strings.Join(got, "|"), want)
+		}
+	}
+
+	// a derived field isn't in the source
+	if fs, _ := parseFills("total=0"); fs != nil {
+		if _, e := p.fillStep(fs); e == nil {
+			t.Errorf("-fill of a derived field: no error")
+		}
+	}
+	for _, list := range []string{"qty", "qty=(1", "qty=1 when (", "qty=1 WHEN"} {
+		if _, e := parseFills(list); e == nil {
+			t.Errorf("parseFills(%s): no error", list)
+		}
+	}
+}
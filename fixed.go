@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/invertedv/chutils"
+)
+
+// fsRe matches the -t type fs(n), a FixedString(n)
+var fsRe = regexp.MustCompile(`^fs\(([1-9][0-9]*)\)$`)
+
+// truncatePolicies are the allowed values of -truncate-policy
+var truncatePolicies = []string{"error", "truncate", "pad"}
+
+// validType returns true if t is a -t field type
+func validType(t string) bool {
+	_, geo := geoTypes[t]
+	_, clock := clockTypes[t]
+	_, dt := dateTimeTypes[t]
+	_, signed := intTypes[t]
+	_, unsigned := uintTypes[t]
+	_, dec, err := parseDecimal(t)
+	return isIn(&t, ftypes, false) || fsRe.MatchString(t) || geo || clock || dt || signed || unsigned || (dec && err == nil)
+}
+
+// fixedWidth returns n if t is fs(n) and 0 otherwise
+func fixedWidth(t string) int {
+	m := fsRe.FindStringSubmatch(t)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// hasFixed returns true if the job has FixedString fields
+func (j *job) hasFixed() bool {
+	if fixedWidth(j.allTypes) > 0 {
+		return true
+	}
+	for _, t := range j.fieldTypes {
+		if fixedWidth(t) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// fitFixed applies the -truncate-policy to the value s of the FixedString(n) field name.
+// ClickHouse rejects a value longer than n bytes, so with "error" it is an error and with "truncate" it is
+// cut to n bytes (without splitting a character).  With "pad", a shorter value is padded with spaces rather
+// than the zero bytes ClickHouse uses; a longer value is an error.
+func fitFixed(name, s string, n int, policy string) (string, error) {
+	switch {
+	case len(s) > n && policy == "truncate":
+		s = s[:n]
+		for !utf8.ValidString(s) {
+			s = s[:len(s)-1]
+		}
+		return s, nil
+	case len(s) > n:
+		return s, fmt.Errorf("field %s: value %q is longer than FixedString(%d); see -truncate-policy", name, s, n)
+	case len(s) < n && policy == "pad":
+		return s + strings.Repeat(" ", n-len(s)), nil
+	}
+	return s, nil
+}
+
+// fixedStep returns a step that applies the -truncate-policy to the FixedString fields of spec
+func fixedStep(spec *chutils.TableDef, policy string) step {
+	return func(row []interface{}) (bool, error) {
+		for ind, fd := range spec.FieldDefs {
+			if fd.ChSpec.Base != chutils.ChFixedString {
+				continue
+			}
+			s, ok := row[ind].(string)
+			if !ok {
+				continue
+			}
+			v, err := fitFixed(fd.Name, strings.TrimSpace(s), fd.ChSpec.Length, policy)
+			if err != nil {
+				return false, err
+			}
+			row[ind] = v
+		}
+		return true, nil
+	}
+}
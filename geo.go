@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// geoType is a ClickHouse geo type that can be given with -t
+type geoType struct {
+	ch    string // ClickHouse type
+	depth int    // nesting of the coordinate lists: 0 for a point, 1 for a ring...
+	empty string // value of a missing or invalid entry
+}
+
+// geoTypes are the -t codes of the geo types
+var geoTypes = map[string]geoType{
+	"pt":    {"Point", 0, "(0,0)"},
+	"ring":  {"Ring", 1, "[]"},
+	"poly":  {"Polygon", 2, "[]"},
+	"mpoly": {"MultiPolygon", 3, "[]"},
+}
+
+// typeOf returns the -t (or -all-types) type of field ind
+func (j *job) typeOf(ind int) string {
+	if ind < len(j.fieldTypes) {
+		return j.fieldTypes[ind]
+	}
+	return j.allTypes
+}
+
+// hasGeo returns true if the job has geo fields
+func (j *job) hasGeo() bool {
+	if _, ok := geoTypes[j.allTypes]; ok {
+		return true
+	}
+	for _, t := range j.fieldTypes {
+		if _, ok := geoTypes[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// geoStep returns a step that converts the geo fields to ClickHouse's text format, which is inserted as it
+// is (see insertValue).
+func (j *job) geoStep(nSrc int) step {
+	return func(row []interface{}) (bool, error) {
+		for ind := 0; ind < nSrc; ind++ {
+			g, ok := geoTypes[j.typeOf(ind)]
+			if !ok {
+				continue
+			}
+			v, e := toGeo(fmt.Sprint(row[ind]), g.depth)
+			if e != nil {
+				v = g.empty
+			}
+			row[ind] = v
+		}
+		return true, nil
+	}
+}
+
+// toGeo converts s to the ClickHouse text format of a geo value with lists nested depth deep.
+// s is WKT (e.g. POINT(1 2), POLYGON((0 0, 1 0, 1 1, 0 0))) or, for a point, "lon lat" or "lon,lat".
+func toGeo(s string, depth int) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", fmt.Errorf("empty")
+	}
+	// drop the WKT keyword
+	if ind := strings.Index(s, "("); ind > 0 {
+		s = strings.TrimSpace(s[ind:])
+	}
+	if depth == 0 {
+		// POINT(x y), (x y), "x y" or "x,y"
+		if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+			s = s[1 : len(s)-1]
+		}
+		return geoPair(strings.ReplaceAll(s, ",", " "))
+	}
+
+	out, rest, err := geoList(s, depth)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return "", fmt.Errorf("unexpected %s", rest)
+	}
+	return out, nil
+}
+
+// geoList parses a parenthesized list nested depth deep from the start of s, returning it in ClickHouse
+// text format and the rest of s.  At depth 1 the entries are coordinate pairs "x y".
+func geoList(s string, depth int) (out, rest string, err error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "(") {
+		return "", s, fmt.Errorf("expected ( at %s", s)
+	}
+	s = s[1:]
+	parts := make([]string, 0)
+	for {
+		s = strings.TrimSpace(s)
+		var part string
+		if depth == 1 {
+			end := strings.IndexAny(s, ",)")
+			if end < 0 {
+				return "", s, fmt.Errorf("missing )")
+			}
+			if part, err = geoPair(s[:end]); err != nil {
+				return "", s, err
+			}
+			s = s[end:]
+		} else if part, s, err = geoList(s, depth-1); err != nil {
+			return "", s, err
+		}
+		parts = append(parts, part)
+
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.HasPrefix(s, ","):
+			s = s[1:]
+		case strings.HasPrefix(s, ")"):
+			return "[" + strings.Join(parts, ",") + "]", s[1:], nil
+		default:
+			return "", s, fmt.Errorf("expected , or ) at %s", s)
+		}
+	}
+}
+
+// geoPair converts the coordinates "x y" to (x,y)
+func geoPair(s string) (string, error) {
+	xy := strings.Fields(s)
+	if len(xy) != 2 {
+		return "", fmt.Errorf("bad coordinates %s", s)
+	}
+	for _, c := range xy {
+		if _, e := strconv.ParseFloat(c, 64); e != nil {
+			return "", fmt.Errorf("bad coordinates %s", s)
+		}
+	}
+	return "(" + xy[0] + "," + xy[1] + ")", nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestToGeo(t *testing.T) {
+	// depth 0 is a Point, 1 a Ring, 2 a Polygon and 3 a MultiPolygon
+	tests := []struct {
+		in    string
+		depth int
+		want  string
+	}{
+		{"POINT(1 2)", 0, "(1,2)"},
+		{"-73.9 40.7", 0, "(-73.9,40.7)"},
+		{"-73.9,40.7", 0, "(-73.9,40.7)"},
+		{" (1.5e2 -3) ", 0, "(1.5e2,-3)"},
+		{"LINESTRING(0 0, 1 0, 1 1)", 1, "[(0,0),(1,0),(1,1)]"},
+		{"POLYGON((0 0, 1 0, 1 1, 0 0))", 2, "[[(0,0),(1,0),(1,1),(0,0)]]"},
+		{"polygon ((0 0,4 0,4 4,0 0), (1 1,2 1,2 2,1 1))", 2, "[[(0,0),(4,0),(4,4),(0,0)],[(1,1),(2,1),(2,2),(1,1)]]"},
+		{"MULTIPOLYGON(((0 0, 1 0, 0 1, 0 0)), ((5 5, 6 5, 5 6, 5 5)))", 3,
+			"[[[(0,0),(1,0),(0,1),(0,0)]],[[(5,5),(6,5),(5,6),(5,5)]]]"},
+	}
+	for _, tt := range tests {
+		got, err := toGeo(tt.in, tt.depth)
+		if err != nil || got != tt.want {
+			t.Errorf("toGeo(%q, %d) = %s, %v; want %s", tt.in, tt.depth, got, err, tt.want)
+		}
+	}
+
+	for in, depth := range map[string]int{
+		"":                          0,
+		"POINT(1)":                  0,
+		"POINT(a b)":                0,
+		"1 2 3":                     0,
+		"POLYGON((0 0, 1 0, 1 1)":   2,
+		"POLYGON(0 0, 1 0)":         2,
+		"POLYGON((0 0, 1 0)) extra": 2,
+		"LINESTRING(0 0; 1 1)":      1,
+	} {
+		if got, err := toGeo(in, depth); err == nil {
+			t.Errorf("toGeo(%q, %d) = %s, want an error", in, depth, got)
+		}
+	}
+}
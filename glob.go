@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// isGlob determines whether source is a pattern of files, e.g. data/part-*.csv, rather than a file
+func isGlob(source string) bool {
+	if !strings.ContainsAny(source, "*?[") || isS3(source) || strings.Contains(strings.ToLower(source), "http") {
+		return false
+	}
+	// a file whose name has the characters
+	_, err := os.Stat(source)
+	return err != nil
+}
+
+// globFiles returns the files that match pattern, in the order of their names
+func globFiles(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("-s: %v", err)
+	}
+	files := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if info, e := os.Stat(m); e == nil && !info.IsDir() {
+			files = append(files, m)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s", pattern)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// loadGlob loads the files that match the pattern j.source into j.table, as loadDir loads the files of a
+// directory: the first file creates the table, or the fields are given by -h and -t, and the rest are
+// matched to it.
+func loadGlob(j *job, con *chutils.Connect) ([]fileResult, error) {
+	sources, err := globFiles(j.source)
+	if err != nil {
+		return nil, err
+	}
+	return loadSources(j, sources, con), nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// strEscaper escapes a value for use between single quotes in an expression
+var strEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// incremental compiles the -incremental expression, e.g. asof > (SELECT max(asof) FROM prices).  Each
+// parenthesized SELECT is run on ClickHouse and replaced by its value, so the expression is evaluated on the
+// rows of the source like -where.  If the table doesn't exist yet or a SELECT returns NULL, there is no
+// watermark and nil is returned: every row is loaded.
+func incremental(src, table string, con *chutils.Connect) (expr, error) {
+	exists, _, _, err := tableInfo(table, con)
+	if err != nil || !exists {
+		return nil, err
+	}
+	out := ""
+	for {
+		st, end, e := findSubquery(src)
+		if e != nil {
+			return nil, fmt.Errorf("-incremental: %v", e)
+		}
+		if st < 0 {
+			break
+		}
+		var v *string
+		if e := con.QueryRow(fmt.Sprintf("SELECT toString(%s)", src[st:end])).Scan(&v); e != nil {
+			return nil, fmt.Errorf("-incremental: %v", e)
+		}
+		if v == nil {
+			fmt.Printf("-incremental: %s is NULL, loading every row\n", src[st:end])
+			return nil, nil
+		}
+		out += src[:st] + "'" + strEscaper.Replace(*v) + "'"
+		src = src[end:]
+	}
+	x, err := parseExpr(out + src)
+	if err != nil {
+		return nil, fmt.Errorf("-incremental: %v", err)
+	}
+	fmt.Printf("incremental load: %s\n", out+src)
+	return x, nil
+}
+
+// findSubquery returns the start and end of the first parenthesized SELECT of src, or -1 if there is none.
+// Parentheses within quotes, which may have backslash escapes, are skipped.
+func findSubquery(src string) (st, end int, err error) {
+	st, depth := -1, 0
+	var quote byte
+	for ind := 0; ind < len(src); ind++ {
+		c := src[ind]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				ind++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(' && st < 0:
+			if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(src[ind+1:])), "SELECT") {
+				st, depth = ind, 1
+			}
+		case c == '(':
+			depth++
+		case c == ')' && st >= 0:
+			if depth--; depth == 0 {
+				return st, ind + 1, nil
+			}
+		}
+	}
+	if st >= 0 {
+		return 0, 0, fmt.Errorf("unbalanced parentheses in %s", src[st:])
+	}
+	return -1, -1, nil
+}
+
+// tombstone marks the keys that are in j.table, and not deleted, but not in j.source as deleted by inserting a
+// row with the key, _deleted = 1 and _asof = the time of the run for each.  The other fields of the row have their defaults.
+// The source is loaded, in full (where applies, but not -incremental), into a scratch table to find its keys.
+func tombstone(j *job, key []string, where expr, con *chutils.Connect) error {
+	cols, err := describe(j.table, con)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for _, c := range cols {
+		have[c.name] = true
+	}
+	for _, name := range append([]string{"_deleted", "_asof"}, key...) {
+		if !have[name] {
+			return fmt.Errorf("-tombstones: %s has no field %s", j.table, name)
+		}
+	}
+
+	sj := *j
+	sj.where = where
+	scratch, drop, err := loadScratch(&sj, "keys", con)
+	if err != nil {
+		return err
+	}
+	defer drop()
+
+	keys := quoteNames(key, "")
+	gone := fmt.Sprintf("SELECT %s FROM %s GROUP BY %s HAVING argMax(_deleted, _asof) = 0 AND (%s) NOT IN (SELECT %s FROM %s)",
+		keys, j.table, keys, keys, keys, scratch)
+	var n uint64
+	if e := con.QueryRow(fmt.Sprintf("SELECT count() FROM (%s)", gone)).Scan(&n); e != nil {
+		return e
+	}
+	if n > 0 {
+		qry := fmt.Sprintf("INSERT INTO %s (%s, _deleted, _asof) SELECT %s, 1, toDateTime(%d) FROM (%s)", j.table, keys, keys,
+			j.now.Unix(), gone)
+		if _, e := con.Exec(qry); e != nil {
+			return e
+		}
+	}
+	fmt.Printf("%d keys marked deleted in %s\n", n, j.table)
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+// TestSubqueryValue checks that a value put in place of a subquery reads back as itself, whatever quotes it has
+func TestSubqueryValue(t *testing.T) {
+	for _, v := range []string{`plain`, `O'Brien`, `say "hi"`, `both ' and "`, `back\slash`, `\'`} {
+		x, err := parseExpr("'" + strEscaper.Replace(v) + "'")
+		if err != nil {
+			t.Errorf("%s: %v", v, err)
+			continue
+		}
+		if got, _ := x.eval(&env{}); got != v {
+			t.Errorf("%s reads back as %v", v, got)
+		}
+	}
+}
+
+func TestFindSubquery(t *testing.T) {
+	tests := []struct {
+		src string
+		sub string
+	}{
+		{"asof > (SELECT max(asof) FROM t)", "(SELECT max(asof) FROM t)"},
+		{"(a > 1) and b > ( select max(b) from t)", "( select max(b) from t)"},
+		{"s = ')(SELECT' or s > (SELECT 1)", "(SELECT 1)"},
+		{`s = 'it\'s (SELECT' or s > (SELECT 2)`, "(SELECT 2)"},
+		{"x > 1", ""},
+	}
+	for _, tt := range tests {
+		st, end, err := findSubquery(tt.src)
+		got := ""
+		if st >= 0 {
+			got = tt.src[st:end]
+		}
+		if err != nil || got != tt.sub {
+			t.Errorf("findSubquery(%s) = %q, %v; want %q", tt.src, got, err, tt.sub)
+		}
+	}
+	if _, _, err := findSubquery("x > (SELECT max(x) FROM t"); err == nil {
+		t.Errorf("unbalanced: no error")
+	}
+}
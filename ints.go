@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// intTypes are the -t codes of the signed integer types narrower than Int64, by bits
+var intTypes = map[string]int{"i8": 8, "i16": 16, "i32": 32}
+
+// uintTypes are the -t codes of the unsigned integer types, by bits.  chutils has no unsigned types, so these
+// are read as Strings and inserted as uint64 (see insertValue).
+var uintTypes = map[string]int{"u8": 8, "u16": 16, "u32": 32, "u64": 64}
+
+// hasUnsigned returns true if the job has unsigned fields
+func (j *job) hasUnsigned() bool {
+	if _, ok := uintTypes[j.allTypes]; ok {
+		return true
+	}
+	for _, t := range j.fieldTypes {
+		if _, ok := uintTypes[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// maxUint returns the largest value of an unsigned type of bits bits, as text
+func maxUint(bits int) string {
+	return strconv.FormatUint(math.MaxUint64>>(64-bits), 10)
+}
+
+// unsignedStep returns a step that checks the values of the unsigned fields.  A value that is empty, isn't a
+// whole number or doesn't fit is loaded as the maximum value of the type, as for the signed types.
+func (j *job) unsignedStep(nSrc int) step {
+	return func(row []interface{}) (bool, error) {
+		for ind := 0; ind < nSrc; ind++ {
+			bits, ok := uintTypes[j.typeOf(ind)]
+			if !ok {
+				continue
+			}
+			s := strings.TrimSpace(fmt.Sprint(row[ind]))
+			if n, e := strconv.ParseUint(s, 10, bits); e == nil {
+				row[ind] = strconv.FormatUint(n, 10)
+				continue
+			}
+			row[ind] = maxUint(bits)
+		}
+		return true, nil
+	}
+}
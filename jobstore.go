@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// jobsBucket is the bucket of the -jobs-db store that holds the jobs, keyed by id
+var jobsBucket = []byte("jobs")
+
+// jobStore keeps the jobs of toch serve in a bbolt file, so they survive a restart
+type jobStore struct {
+	db *bolt.DB
+}
+
+// openJobStore opens the store in the file path, creating it if need be
+func openJobStore(path string) (*jobStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("-jobs-db %s: %v", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists(jobsBucket)
+		return e
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &jobStore{db: db}, nil
+}
+
+// jobKey returns the key of the job with the id, which sorts in the order of the ids
+func jobKey(id int) []byte {
+	return []byte(fmt.Sprintf("%012d", id))
+}
+
+// load returns the jobs of the store in the order of their ids
+func (st *jobStore) load() ([]*serverJob, error) {
+	jobs := make([]*serverJob, 0)
+	err := st.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			j := &serverJob{}
+			if e := json.Unmarshal(v, j); e != nil {
+				return fmt.Errorf("job %s: %v", k, e)
+			}
+			jobs = append(jobs, j)
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+// save writes j to the store.  A nil store saves nothing.
+func (st *jobStore) save(j *serverJob) error {
+	if st == nil {
+		return nil
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return st.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put(jobKey(j.ID), b)
+	})
+}
+
+// Close closes the store.  A nil store is left alone.
+func (st *jobStore) Close() error {
+	if st == nil {
+		return nil
+	}
+	return st.db.Close()
+}
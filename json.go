@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/invertedv/chutils/file"
+)
+
+// jsonTypes are the -type values of JSON sources
+var jsonTypes = []string{"json", "ndjson"}
+
+// newJSON creates a reader of the JSON source of j: an array of objects (json) or one object per line
+// (ndjson).  Each object is a row.  Nested objects are flattened: their fields are named
+// <field><j.flattenSep><nested field>.  Arrays are loaded as their JSON text.  The fields are those of all
+// the rows, in the order they are first seen; a row without a field has an empty value.
+func newJSON(j *job, skip int) (*file.Reader, error) {
+	body := j.body
+	if body == nil {
+		var err error
+		if body, err = readSource(j); err != nil {
+			return nil, err
+		}
+	}
+	objs, err := decodeJSON(body, j.sType == "ndjson")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", j.source, err)
+	}
+
+	names := make([]string, 0)
+	cols := make(map[string]int)
+	flat := make([]map[string]string, len(objs))
+	for ind, obj := range objs {
+		flat[ind] = make(map[string]string)
+		flatten(obj, "", j.flattenSep, flat[ind], func(name string) {
+			if _, ok := cols[name]; !ok {
+				cols[name] = len(names)
+				names = append(names, name)
+			}
+		})
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s has no fields", j.source)
+	}
+
+	// the data always has a header row
+	if len(j.headers) > 0 {
+		skip++
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if e := w.Write(names); e != nil {
+		return nil, e
+	}
+	row := make([]string, len(names))
+	for _, f := range flat {
+		for ind, name := range names {
+			row[ind] = f[name]
+		}
+		if e := w.Write(row); e != nil {
+			return nil, e
+		}
+	}
+	w.Flush()
+	if e := w.Error(); e != nil {
+		return nil, e
+	}
+	return newBytes(buf.Bytes(), "csv", "", '"', skip, nil, "")
+}
+
+// readSource reads all of j.source, from S3, the web or a file, decompressing it if it is compressed
+func readSource(j *job) ([]byte, error) {
+	var body []byte
+	var err error
+	switch {
+	case isS3(j.source):
+		r, e := j.s3.open(j.source)
+		if e != nil {
+			return nil, e
+		}
+		defer func() { _ = r.Close() }()
+		body, err = io.ReadAll(r)
+	case !strings.Contains(strings.ToLower(j.source), "http"):
+		body, err = os.ReadFile(j.source)
+	default:
+		resp, e := j.web.get(j.source)
+		if e != nil {
+			return nil, e
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, err = io.ReadAll(resp.Body)
+	}
+	if err != nil || j.decompress == "none" {
+		return body, err
+	}
+	compression := compressionOf(j.decompress, j.source, body[:min(4, len(body))])
+	if compression == "none" {
+		return body, nil
+	}
+	r, _, err := decompressed(compression, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", j.source, err)
+	}
+	return io.ReadAll(r)
+}
+
+// jsonField is a field of a JSON object.  Objects are decoded as []jsonField so that the order of their fields
+// is kept.
+type jsonField struct {
+	name  string
+	value interface{}
+}
+
+// decodeJSON decodes the objects of body.  If lines is true, body has one object per line, otherwise it is an
+// array of objects (or a single object).
+func decodeJSON(body []byte, lines bool) ([][]jsonField, error) {
+	objs := make([][]jsonField, 0)
+	if !lines {
+		v, err := jsonValue(newJSONDecoder(body))
+		if err != nil {
+			return nil, err
+		}
+		switch x := v.(type) {
+		case []jsonField:
+			return append(objs, x), nil
+		case []interface{}:
+			for ind, elem := range x {
+				obj, ok := elem.([]jsonField)
+				if !ok {
+					return nil, fmt.Errorf("element %d of the array is not an object", ind+1)
+				}
+				objs = append(objs, obj)
+			}
+			return objs, nil
+		}
+		return nil, fmt.Errorf("expected an array of objects")
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		v, err := jsonValue(newJSONDecoder(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		obj, ok := v.([]jsonField)
+		if !ok {
+			return nil, fmt.Errorf("line %d is not an object", lineNo)
+		}
+		objs = append(objs, obj)
+	}
+	return objs, sc.Err()
+}
+
+// newJSONDecoder returns a decoder of body that keeps numbers as they are written
+func newJSONDecoder(body []byte) *json.Decoder {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	return dec
+}
+
+// jsonValue decodes the next value of dec.  Objects are []jsonField, arrays []interface{} and the rest string,
+// json.Number, bool or nil.
+func jsonValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	switch delim {
+	case '{':
+		obj := make([]jsonField, 0)
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := jsonValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonField{name: fmt.Sprint(name), value: v})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case '[':
+		arr := make([]interface{}, 0)
+		for dec.More() {
+			v, err := jsonValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return nil, fmt.Errorf("unexpected %v", delim)
+}
+
+// flatten puts the values of obj into flat, naming nested fields prefix<sep>name.  seen is called with each
+// field name.
+func flatten(obj []jsonField, prefix, sep string, flat map[string]string, seen func(string)) {
+	for _, f := range obj {
+		name := prefix + f.name
+		if nested, ok := f.value.([]jsonField); ok {
+			flatten(nested, name+sep, sep, flat, seen)
+			continue
+		}
+		flat[name] = jsonText(f.value, false)
+		seen(name)
+	}
+}
+
+// jsonText returns v as JSON text.  If quote is false, a string is returned without quotes and null as "".
+func jsonText(v interface{}, quote bool) string {
+	switch x := v.(type) {
+	case nil:
+		if !quote {
+			return ""
+		}
+		return "null"
+	case string:
+		if !quote {
+			return x
+		}
+		b, _ := json.Marshal(x)
+		return string(b)
+	case []jsonField:
+		parts := make([]string, len(x))
+		for ind, f := range x {
+			parts[ind] = jsonText(f.name, true) + ":" + jsonText(f.value, true)
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	case []interface{}:
+		parts := make([]string, len(x))
+		for ind, elem := range x {
+			parts[ind] = jsonText(elem, true)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+	return fmt.Sprint(v)
+}
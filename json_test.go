@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestFlatten decodes objects as -type json and ndjson do and flattens them, checking the field names are in
+// the order they're first seen and the values are as loaded
+func TestFlatten(t *testing.T) {
+	array := `[
+		{"id": 1, "addr": {"city": "Paris", "geo": {"lat": 48.85, "lon": 2.35}}, "tags": ["a", "b"]},
+		{"id": 2, "addr": {"city": null, "zip": "75001"}, "ok": true, "note": "say \"hi\""}
+	]`
+	lines := strings.Join([]string{
+		`{"id": 1, "addr": {"city": "Paris", "geo": {"lat": 48.85, "lon": 2.35}}, "tags": ["a", "b"]}`,
+		``,
+		`{"id": 2, "addr": {"city": null, "zip": "75001"}, "ok": true, "note": "say \"hi\""}`,
+	}, "\n")
+	want := `id addr.city addr.geo.lat addr.geo.lon tags addr.zip ok note
+1|Paris|48.85|2.35|["a","b"]|||
+2|||||75001|true|say "hi"`
+
+	for body, isLines := range map[string]bool{array: false, lines: true} {
+		objs, err := decodeJSON([]byte(body), isLines)
+		if err != nil {
+			t.Fatalf("decodeJSON (lines %v): %v", isLines, err)
+		}
+		names := make([]string, 0)
+		have := make(map[string]bool)
+		rows := make([]map[string]string, len(objs))
+		for ind, obj := range objs {
+			rows[ind] = make(map[string]string)
+			flatten(obj, "", ".", rows[ind], func(name string) {
+				if !have[name] {
+					have[name] = true
+					names = append(names, name)
+				}
+			})
+		}
+		got := strings.Join(names, " ")
+		for _, row := range rows {
+			vals := make([]string, len(names))
+			for ind, name := range names {
+				vals[ind] = row[name]
+			}
+			got += "\n" + strings.Join(vals, "|")
+		}
+		if got != want {
+			t.Errorf("lines %v: got\n%s\nwant\n%s", isLines, got, want)
+		}
+	}
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	for _, tt := range []struct {
+		body  string
+		lines bool
+		err   string
+	}{
+		{`[{"a": 1}, 2]`, false, "element 2"},
+		{`"just a string"`, false, "array of objects"},
+		{"{\"a\": 1}\n[1]", true, "line 2 is not an object"},
+		{"{\"a\": 1}\n{\"a\": ", true, "line 2"},
+	} {
+		_, err := decodeJSON([]byte(tt.body), tt.lines)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: %v, want %s", tt.body, err, tt.err)
+		}
+	}
+}
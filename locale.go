@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// locale is a -locale preset: how numbers and dates are written in the source
+type locale struct {
+	decimal   string   // decimal mark
+	thousands []string // thousands separators
+	dates     []string // date formats, using Jan 2, 2006 as the prototype
+}
+
+// spaces are the thousands separators of locales that group digits with a space
+var spaces = []string{" ", "\u00a0", "\u202f"}
+
+// locales are the values of -locale
+var locales = map[string]*locale{
+	"en_US": {decimal: ".", thousands: []string{","}, dates: []string{"1/2/2006", "01/02/2006"}},
+	"en_GB": {decimal: ".", thousands: []string{","}, dates: []string{"02/01/2006", "2/1/2006"}},
+	"de_DE": {decimal: ",", thousands: []string{"."}, dates: []string{"02.01.2006", "2.1.2006"}},
+	"de_AT": {decimal: ",", thousands: append([]string{"."}, spaces...), dates: []string{"02.01.2006", "2.1.2006"}},
+	"de_CH": {decimal: ".", thousands: []string{"'", "\u2019"}, dates: []string{"02.01.2006", "2.1.2006"}},
+	"fr_FR": {decimal: ",", thousands: spaces, dates: []string{"02/01/2006", "2/1/2006"}},
+	"fr_CA": {decimal: ",", thousands: spaces, dates: []string{"2006-01-02"}},
+	"es_ES": {decimal: ",", thousands: []string{"."}, dates: []string{"02/01/2006", "2/1/2006"}},
+	"it_IT": {decimal: ",", thousands: []string{"."}, dates: []string{"02/01/2006", "2/1/2006"}},
+	"nl_NL": {decimal: ",", thousands: []string{"."}, dates: []string{"02-01-2006", "2-1-2006"}},
+	"pt_BR": {decimal: ",", thousands: []string{"."}, dates: []string{"02/01/2006", "2/1/2006"}},
+	"pl_PL": {decimal: ",", thousands: spaces, dates: []string{"02.01.2006", "2.1.2006"}},
+	"sv_SE": {decimal: ",", thousands: spaces, dates: []string{"2006-01-02"}},
+}
+
+// newLocale returns the preset name, nil if name is ""
+func newLocale(name string) (*locale, error) {
+	if name == "" {
+		return nil, nil
+	}
+	for n, l := range locales {
+		if strings.EqualFold(n, name) || strings.EqualFold(strings.ReplaceAll(n, "_", "-"), name) {
+			return l, nil
+		}
+	}
+	names := make([]string, 0, len(locales))
+	for n := range locales {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("-locale is one of %s", strings.Join(names, ", "))
+}
+
+// numberRegexp returns the pattern of the numbers of the locale: digits, optionally grouped in threes, and an
+// optional decimal part
+func (l *locale) numberRegexp() *regexp.Regexp {
+	seps := make([]string, len(l.thousands))
+	for ind, s := range l.thousands {
+		seps[ind] = regexp.QuoteMeta(s)
+	}
+	return regexp.MustCompile(fmt.Sprintf(`^[+-]?(\d{1,3}((%s)\d{3})+|\d+)(%s\d+)?$`, strings.Join(seps, "|"),
+		regexp.QuoteMeta(l.decimal)))
+}
+
+// normalizer returns the function that rewrites a value of the locale as toch reads it: numbers without
+// thousands separators and with a decimal point, and dates as 2006-01-02.  Other values are unchanged.
+func (l *locale) normalizer() func(string) string {
+	number := l.numberRegexp()
+	return func(s string) string {
+		t := strings.TrimSpace(s)
+		if number.MatchString(t) {
+			for _, sep := range l.thousands {
+				t = strings.ReplaceAll(t, sep, "")
+			}
+			return strings.Replace(t, l.decimal, ".", 1)
+		}
+		for _, format := range l.dates {
+			if dt, err := time.Parse(format, t); err == nil {
+				return dt.Format("2006-01-02")
+			}
+		}
+		return s
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewLocale(t *testing.T) {
+	for _, name := range []string{"de_DE", "de-de", "DE_de"} {
+		if l, err := newLocale(name); err != nil || l != locales["de_DE"] {
+			t.Errorf("newLocale(%s) = %v, %v", name, l, err)
+		}
+	}
+	if l, err := newLocale(""); l != nil || err != nil {
+		t.Errorf(`newLocale("") = %v, %v`, l, err)
+	}
+	if _, err := newLocale("xx_XX"); err == nil || !strings.Contains(err.Error(), "en_GB, en_US") {
+		t.Errorf("newLocale(xx_XX): %v", err)
+	}
+}
+
+func TestLocaleNormalizer(t *testing.T) {
+	cases := map[string]map[string]string{
+		"en_US": {
+			"1,234,567.89": "1234567.89",
+			"-1,000":       "-1000",
+			"1234.5":       "1234.5",
+			"3/7/2024":     "2024-03-07",
+			"12,34":        "12,34", // not grouped in threes, so left alone
+		},
+		"de_DE": {
+			"1.234.567,89": "1234567.89",
+			"0,5":          "0.5",
+			"07.03.2024":   "2024-03-07",
+			"1.5":          "1.5",
+		},
+		"fr_FR": {
+			"1 234,5":      "1234.5",
+			"1\u00a0234,5": "1234.5",
+			"1\u202f234,5": "1234.5",
+			"07/03/2024":   "2024-03-07",
+		},
+		"de_CH": {
+			"1'234.50":   "1234.50",
+			"1\u2019234": "1234",
+		},
+		"sv_SE": {
+			" 12 345,6 ": "12345.6",
+			"2024-03-07": "2024-03-07",
+			"hej":        "hej",
+		},
+	}
+	for name, values := range cases {
+		norm := locales[name].normalizer()
+		for in, want := range values {
+			if got := norm(in); got != want {
+				t.Errorf("%s: %q -> %q, want %q", name, in, got, want)
+			}
+		}
+	}
+}
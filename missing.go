@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// missingTypes are the type codes -missing sets the value of illegal entries for
+var missingTypes = []string{"f", "i", "d", "s"}
+
+// parseMissing parses -missing: comma-separated items code=value, where code is f (Float), i (Int), d (Date,
+// as 2006-01-02) or s (String), e.g. 'f=-1,i=-1,d=1970-01-01,s='.  The values are checked against the type.
+func parseMissing(list string) (map[string]interface{}, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	missing := make(map[string]interface{})
+	for _, item := range strings.Split(list, ",") {
+		code, val, ok := strings.Cut(item, "=")
+		code = strings.ToLower(strings.TrimSpace(code))
+		if !ok || !isIn(&code, missingTypes, false) {
+			return nil, fmt.Errorf("-missing: expected code=value, code one of %s, got %s", strings.Join(missingTypes, ", "), item)
+		}
+		if _, ok := missing[code]; ok {
+			return nil, fmt.Errorf("-missing: %s is given twice", code)
+		}
+		val = strings.TrimSpace(val)
+		switch code {
+		case "f":
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return nil, fmt.Errorf("-missing f=%s is not a number", val)
+			}
+			missing[code] = f
+		case "i":
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("-missing i=%s is not an integer", val)
+			}
+			missing[code] = int(n)
+		case "d":
+			d, err := time.Parse("2006-01-02", val)
+			if err != nil {
+				return nil, fmt.Errorf("-missing d=%s is not a date (2006-01-02)", val)
+			}
+			if d.Before(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)) || d.After(time.Date(2149, 6, 6, 0, 0, 0, 0, time.UTC)) {
+				return nil, fmt.Errorf("-missing d=%s: a ClickHouse Date is from 1970-01-01 to 2149-06-06", val)
+			}
+			missing[code] = d
+		case "s":
+			missing[code] = val
+		}
+	}
+	return missing, nil
+}
+
+// setMissing sets the value the illegal entries of the fields of spec are filled in with to the -missing value
+// of their type.  Nullable fields, which get NULL, and the fields whose column has a type chutils doesn't have
+// (geo, DateTime, Decimal, unsigned and Bool) keep theirs.  An integer value must fit the field,
+// which may have been narrowed.
+func (j *job) setMissing(spec *chutils.TableDef) error {
+	if len(j.missing) == 0 {
+		return nil
+	}
+	for ind, fd := range spec.FieldDefs {
+		if fd.Drop || fd.Missing == nil || j.tableType(ind, fd) != chType(fd) {
+			continue
+		}
+		switch fd.ChSpec.Base {
+		case chutils.ChFloat:
+			if v, ok := j.missing["f"]; ok {
+				if fd.ChSpec.Length == 32 && math.Abs(v.(float64)) > math.MaxFloat32 {
+					return fmt.Errorf("-missing f=%v doesn't fit %s, a Float32", v, fd.Name)
+				}
+				fd.Missing = v
+			}
+		case chutils.ChInt:
+			if v, ok := j.missing["i"]; ok {
+				n, bits := v.(int), fd.ChSpec.Length
+				if bits < 64 && (n < -(1<<(bits-1)) || n > 1<<(bits-1)-1) {
+					return fmt.Errorf("-missing i=%d doesn't fit %s, an Int%d: give -int64 Y or a -t type", n, fd.Name, bits)
+				}
+				fd.Missing = n
+			}
+		case chutils.ChDate:
+			if v, ok := j.missing["d"]; ok {
+				fd.Missing = v
+			}
+		case chutils.ChFixedString:
+			if v, ok := j.missing["s"]; ok {
+				if len(v.(string)) > fd.ChSpec.Length {
+					return fmt.Errorf("-missing s=%s is longer than %s, a FixedString(%d)", v, fd.Name, fd.ChSpec.Length)
+				}
+				fd.Missing = v
+			}
+		default:
+			if v, ok := j.missing["s"]; ok {
+				fd.Missing = v
+			}
+		}
+	}
+	return nil
+}
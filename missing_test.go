@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+func TestParseMissing(t *testing.T) {
+	m, err := parseMissing("f=-1.5, I=-9,d=1970-01-01 ,s=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["f"] != -1.5 || m["i"] != -9 || m["s"] != "" || !m["d"].(time.Time).Equal(time.Unix(0, 0).UTC()) {
+		t.Errorf("parseMissing = %v", m)
+	}
+	for list, msg := range map[string]string{
+		"x=1":          "code one of",
+		"f":            "code one of",
+		"f=NA":         "not a number",
+		"i=1.5":        "not an integer",
+		"d=01/02/2024": "not a date",
+		"d=1969-12-31": "1970-01-01 to 2149-06-06",
+		"i=1,s=-,i=2":  "given twice",
+	} {
+		if _, e := parseMissing(list); e == nil || !strings.Contains(e.Error(), msg) {
+			t.Errorf("parseMissing(%s): %v, want %s", list, e, msg)
+		}
+	}
+}
+
+// TestSetMissing gives the fields of each type the -missing value of their type
+func TestSetMissing(t *testing.T) {
+	types := []string{"Int64", "Float32", "Date", "String", "FixedString(2)", "Nullable(Int64)", "Int8"}
+	spec := func() *chutils.TableDef {
+		fds := make(map[int]*chutils.FieldDef)
+		for ind, ct := range types {
+			fds[ind] = &chutils.FieldDef{Name: ct, Legal: &chutils.LegalValues{}}
+			if err := setType(fds[ind], ct, "2006-01-02"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return chutils.NewTableDef(types[0], chutils.MergeTree, fds)
+	}
+
+	j := &job{}
+	j.missing, _ = parseMissing("f=-1,i=-1,d=2000-01-01,s=NA")
+	s := spec()
+	if err := j.setMissing(s); err != nil {
+		t.Fatal(err)
+	}
+	for ind, want := range []interface{}{-1, -1.0, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), "NA", "NA", nil, -1} {
+		if got := s.FieldDefs[ind].Missing; got != want {
+			t.Errorf("%s: missing %v (%T), want %v", types[ind], got, got, want)
+		}
+	}
+
+	// values that don't fit the field
+	for _, list := range []string{"s=ABC", "f=1e300", "i=200"} {
+		j.missing, _ = parseMissing(list)
+		if err := j.setMissing(spec()); err == nil {
+			t.Errorf("-missing %s: no error", list)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normForms are the allowed values of -normalize
+var normForms = []string{"none", "nfc", "nfkc"}
+
+// invisible maps the zero-width characters to nothing and the non-breaking spaces to a space
+var invisible = strings.NewReplacer(
+	"\u200b", "", // zero width space
+	"\u200c", "", // zero width non-joiner
+	"\u200d", "", // zero width joiner
+	"\u2060", "", // word joiner
+	"\ufeff", "", // zero width no-break space (BOM)
+	"\u00a0", " ", // no-break space
+	"\u2007", " ", // figure space
+	"\u202f", " ", // narrow no-break space
+)
+
+// normalizer returns a function that puts a string in the Unicode normal form (nfc or nfkc) and strips the
+// zero-width and non-breaking spaces.  It returns nil for "none".
+func normalizer(form string) func(string) string {
+	var f norm.Form
+	switch form {
+	case "nfc":
+		f = norm.NFC
+	case "nfkc":
+		f = norm.NFKC
+	default:
+		return nil
+	}
+	return func(s string) string {
+		return f.String(invisible.Replace(s))
+	}
+}
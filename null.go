@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nullTokens are the values of -null: the tokens read as missing in every field and in particular fields
+type nullTokens struct {
+	all    map[string]bool
+	fields map[string]map[string]bool
+}
+
+// parseNull parses -null: items separated by ;, each a comma-separated list of tokens, for every field, or
+// field=tokens, for one field, e.g. 'NA,N/A;price=.,-'.  Spaces around the tokens are ignored.
+func parseNull(list string) (*nullTokens, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	nt := &nullTokens{all: make(map[string]bool), fields: make(map[string]map[string]bool)}
+	for _, item := range strings.Split(list, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		tokens := nt.all
+		if name, rest, ok := strings.Cut(item, "="); ok {
+			name = strings.Trim(strings.TrimSpace(name), "`")
+			if name == "" {
+				return nil, fmt.Errorf("-null: no field in %s", item)
+			}
+			if nt.fields[name] == nil {
+				nt.fields[name] = make(map[string]bool)
+			}
+			tokens, item = nt.fields[name], rest
+		}
+		for _, t := range strings.Split(item, ",") {
+			tokens[strings.TrimSpace(t)] = true
+		}
+	}
+	return nt, nil
+}
+
+// nullStep returns the step that replaces the null tokens of the first nSrc fields with empty values and notes
+// them in p.nulls, so that a field whose value is still empty when the row is converted gets the missing value
+// of its type (NULL with -nullable), even if it's a String
+func (p *pipeline) nullStep(nt *nullTokens) (step, error) {
+	tokens := make([]map[string]bool, p.nSrc)
+	for name := range nt.fields {
+		if col, ok := p.env.cols[name]; !ok || col >= p.nSrc {
+			return nil, fmt.Errorf("-null field %s is not in the source", name)
+		}
+	}
+	for ind := 0; ind < p.nSrc; ind++ {
+		tokens[ind] = nt.fields[p.spec.FieldDefs[ind].Name]
+	}
+	return func(row []interface{}) (bool, error) {
+		p.nulls = p.nulls[:0]
+		for ind := 0; ind < p.nSrc; ind++ {
+			s, ok := row[ind].(string)
+			if !ok {
+				continue
+			}
+			s = strings.TrimSpace(s)
+			if nt.all[s] || tokens[ind][s] {
+				row[ind] = ""
+				p.nulls = append(p.nulls, ind)
+			}
+		}
+		return true, nil
+	}, nil
+}
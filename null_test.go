@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/invertedv/chutils"
+)
+
+func TestParseNull(t *testing.T) {
+	nt, err := parseNull(" NA , N/A;price=.,-; `qty` = ;;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"NA": true, "N/A": true}; !reflect.DeepEqual(nt.all, want) {
+		t.Errorf("all = %v", nt.all)
+	}
+	want := map[string]map[string]bool{"price": {".": true, "-": true}, "qty": {"": true}}
+	if !reflect.DeepEqual(nt.fields, want) {
+		t.Errorf("fields = %v", nt.fields)
+	}
+	if nt, err := parseNull(" "); nt != nil || err != nil {
+		t.Errorf("empty -null = %v, %v", nt, err)
+	}
+	if _, err := parseNull("=NA"); err == nil {
+		t.Errorf("-null =NA: no error")
+	}
+}
+
+func TestNullStep(t *testing.T) {
+	fds := map[int]*chutils.FieldDef{0: {Name: "id"}, 1: {Name: "price"}, 2: {Name: "note"}}
+	p := &pipeline{nSrc: 3, spec: chutils.NewTableDef("id", chutils.MergeTree, fds),
+		env: &env{cols: map[string]int{"id": 0, "price": 1, "note": 2}}}
+	nt, _ := parseNull("NA;price=-")
+	st, err := p.nullStep(nt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := []interface{}{"NA", " - ", "-"}
+	if _, e := st(row); e != nil {
+		t.Fatal(e)
+	}
+	// "-" is a null token of price, but not of note
+	if !reflect.DeepEqual(row, []interface{}{"", "", "-"}) || !reflect.DeepEqual(p.nulls, []int{0, 1}) {
+		t.Errorf("row %q, nulls %v", row, p.nulls)
+	}
+
+	nt, _ = parseNull("total=0")
+	if _, e := p.nullStep(nt); e == nil {
+		t.Errorf("-null of a field not in the source: no error")
+	}
+}
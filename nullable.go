@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// nullable makes the fields of spec Nullable, so that their empty and illegal values are loaded as NULL rather
+// than the missing value of the type.  The first field, the table key, and the fields except are left alone.
+func nullable(spec *chutils.TableDef, except []string) {
+	skip := make(map[string]bool)
+	for _, name := range except {
+		skip[name] = true
+	}
+	kept := make([]string, 0)
+	for ind := 0; ind < len(spec.FieldDefs); ind++ {
+		fd := spec.FieldDefs[ind]
+		if fd.Drop {
+			continue
+		}
+		// MergeTree keys can't be Nullable
+		if ind == 0 || skip[fd.Name] {
+			kept = append(kept, fd.Name)
+			continue
+		}
+		fd.Missing = nil
+		isNullable := false
+		for _, f := range fd.ChSpec.Funcs {
+			isNullable = isNullable || f == chutils.OuterNullable
+		}
+		// LowCardinality(Nullable(T)): Nullable is the innermost wrapper
+		if !isNullable {
+			fd.ChSpec.Funcs = append(fd.ChSpec.Funcs, chutils.OuterNullable)
+		}
+	}
+	fmt.Printf("fields not Nullable: %s\n", strings.Join(kept, ", "))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauthClient fetches bearer tokens with the OAuth2 client credentials flow
+type oauthClient struct {
+	tokenURL string
+	id       string
+	secret   string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time // zero if the token doesn't expire
+}
+
+// newOAuthClient creates the client for the -oauth options.  The secret may be given as a -password-source
+// (keyring:, vault:// or env:) so it needn't be on the command line.
+func newOAuthClient(tokenURL, id, secret string) (*oauthClient, error) {
+	if tokenURL == "" {
+		if id != "" || secret != "" {
+			return nil, fmt.Errorf("-oauth-client-id and -oauth-client-secret need -oauth-token-url")
+		}
+		return nil, nil
+	}
+	if id == "" || secret == "" {
+		return nil, fmt.Errorf("-oauth-token-url needs -oauth-client-id and -oauth-client-secret")
+	}
+	secret, err := resolveSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &oauthClient{tokenURL: tokenURL, id: id, secret: secret}, nil
+}
+
+// bearer returns a token, fetching a new one if there is none, it is about to expire or refresh is true
+func (o *oauthClient) bearer(refresh bool) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !refresh && o.token != "" && (o.expires.IsZero() || time.Until(o.expires) > time.Minute) {
+		return o.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest("POST", o.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.id), url.QueryEscape(o.secret))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oauth token request to %s: %s", o.tokenURL, resp.Status)
+	}
+
+	var t struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if e := json.NewDecoder(resp.Body).Decode(&t); e != nil {
+		return "", fmt.Errorf("oauth token response: %v", e)
+	}
+	if t.AccessToken == "" {
+		return "", fmt.Errorf("oauth token response from %s has no access_token", o.tokenURL)
+	}
+	if t.TokenType != "" && !strings.EqualFold(t.TokenType, "bearer") {
+		return "", fmt.Errorf("oauth token type %s is not supported", t.TokenType)
+	}
+	o.token, o.expires = t.AccessToken, time.Time{}
+	if t.ExpiresIn > 0 {
+		o.expires = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+	return o.token, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// optimizeModes are the allowed values of -optimize
+var optimizeModes = []string{"n", "y", "final", "partition"}
+
+// optimize runs OPTIMIZE on table after a load that started at start.  With "y", ClickHouse merges some parts;
+// with "final", it merges each partition into a single part, which applies the deduplication of a
+// ReplacingMergeTree; with "partition", only the partitions that have parts written since start are merged
+// (with FINAL).
+func optimize(table, mode string, start time.Time, con *chutils.Connect) error {
+	switch mode {
+	case "y":
+		_, err := con.Exec(fmt.Sprintf("OPTIMIZE TABLE %s", table))
+		return err
+	case "final":
+		_, err := con.Exec(fmt.Sprintf("OPTIMIZE TABLE %s FINAL", table))
+		return err
+	case "partition":
+		db, name := splitTable(table)
+		qry := `SELECT DISTINCT partition_id FROM system.parts 
+WHERE database = if(? = '', currentDatabase(), ?) AND table = ? AND active AND modification_time >= ?`
+		rows, err := con.Query(qry, db, db, name, start.Add(-time.Second))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rows.Close() }()
+
+		ids := make([]string, 0)
+		for rows.Next() {
+			var id string
+			if e := rows.Scan(&id); e != nil {
+				return e
+			}
+			ids = append(ids, id)
+		}
+		for _, id := range ids {
+			if _, e := con.Exec(fmt.Sprintf("OPTIMIZE TABLE %s PARTITION ID '%s' FINAL", table, id)); e != nil {
+				return e
+			}
+		}
+		fmt.Printf("optimized %d partitions of %s\n", len(ids), table)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/chutils/sql"
+)
+
+// shared is a chutils.Input that several exports read from at once.  Each row goes to whichever export reads
+// next, so the rows may be inserted out of order.
+type shared struct {
+	chutils.Input
+	mu    sync.Mutex
+	reset sync.Once
+	err   error
+}
+
+// Read reads from the underlying Input, one export at a time
+func (s *shared) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Input.Read(nTarget, validate)
+}
+
+// Reset resets the underlying Input the first time it is called.  Later calls, by the other exports, do nothing
+// so the exports don't start over.
+func (s *shared) Reset() error {
+	s.reset.Do(func() { s.err = s.Input.Reset() })
+	return s.err
+}
+
+// exportUnordered exports rdr to j.table with a writer per CPU (at most 8), each inserting its own batches.
+// The order of the rows in the table is not that of the source.
+//
+// Only the inserts are parallel.  The readers below rdr (the pipeline, the checks, the counters) keep state
+// from row to row, so shared serializes the reads: one writer at a time reads, parses and validates a batch,
+// while the others format theirs and wait on ClickHouse.
+func exportUnordered(rdr chutils.Input, j *job, con *chutils.Connect) error {
+	workers := runtime.NumCPU()
+	if workers > 8 {
+		workers = 8
+	}
+
+	s := &shared{Input: rdr}
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wtr := sql.NewWriter(j.table, con)
+			defer func() {
+				if e := wtr.Close(); e != nil {
+					fmt.Println(e)
+				}
+			}()
+			if e := chutils.Export(s, wtr, j.batch, j.ignore); e != nil {
+				errs <- e
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
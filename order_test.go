@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/invertedv/chutils"
+)
+
+// rows is a chutils.Input of the numbers 0 to n-1, one per row.  It isn't safe for concurrent use.
+type rows struct {
+	n, next int
+}
+
+func (r *rows) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	for ; len(data) < nTarget && r.next < r.n; r.next++ {
+		data = append(data, chutils.Row{r.next})
+		valid = append(valid, chutils.Valid{chutils.VPass})
+	}
+	return data, valid, nil
+}
+
+func (r *rows) Reset() error                 { r.next = 0; return nil }
+func (r *rows) CountLines() (int, error)     { return r.n, nil }
+func (r *rows) Seek(lineNo int) error        { r.next = lineNo; return nil }
+func (r *rows) Close() error                 { return nil }
+func (r *rows) TableSpec() *chutils.TableDef { return nil }
+
+// TestShared reads a source with several exports at once, as exportUnordered does.  Every row must be read by
+// exactly one of them, and the Reset of each export must not start the others over.  Run with -race.
+func TestShared(t *testing.T) {
+	const n, workers = 10000, 8
+	s := &shared{Input: &rows{n: n}}
+	seen := make([]int, n)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if e := s.Reset(); e != nil {
+				t.Error(e)
+				return
+			}
+			for {
+				data, _, e := s.Read(7, true)
+				if e != nil {
+					t.Error(e)
+					return
+				}
+				if len(data) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, r := range data {
+					seen[r[0].(int)]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	for ind, c := range seen {
+		if c != 1 {
+			t.Fatalf("row %d read %d times", ind, c)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/chutils/file"
+)
+
+// parquetCmd runs ClickHouse's local mode, which reads Parquet
+var parquetCmd = []string{"clickhouse", "local", "--query"}
+
+// parquetTable returns the table function that reads the Parquet source: file() or, for web sources, url()
+func parquetTable(source string) string {
+	fn := "file"
+	if strings.Contains(strings.ToLower(source), "http") {
+		fn = "url"
+	}
+	return fmt.Sprintf("%s('%s', Parquet)", fn, strings.ReplaceAll(source, "'", `\'`))
+}
+
+// runParquet runs qry with clickhouse local, writing its output to w
+func runParquet(qry string, w io.Writer) error {
+	c := exec.Command(parquetCmd[0], append(parquetCmd[1:], qry)...)
+	var stderr bytes.Buffer
+	c.Stdout, c.Stderr = w, &stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("clickhouse local failed (is clickhouse installed?): %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// newParquet creates a reader of the Parquet source of j.  The columns and their ClickHouse types are read
+// from the Parquet schema into j.parquet, so the types needn't be imputed.  The rows are then streamed, as CSV
+// with a header row, by a SELECT of clickhouse local, which reads the file a row group at a time: neither the
+// data nor its CSV is held in memory or written to disk.  An S3 source is copied to a temporary file first.
+func newParquet(j *job, skip int) (*file.Reader, error) {
+	source, done := j.source, func() {}
+	if isS3(source) {
+		tmp, err := j.s3.fetch(source)
+		if err != nil {
+			return nil, err
+		}
+		source, done = tmp, func() { _ = os.Remove(tmp) }
+	}
+	var desc bytes.Buffer
+	if e := runParquet(fmt.Sprintf("DESCRIBE %s FORMAT TabSeparated", parquetTable(source)), &desc); e != nil {
+		done()
+		return nil, e
+	}
+	j.parquet = make([]column, 0)
+	sc := bufio.NewScanner(&desc)
+	for sc.Scan() {
+		// name, type, default type, default expression...
+		f := strings.Split(sc.Text(), "\t")
+		if len(f) >= 2 {
+			j.parquet = append(j.parquet, column{name: f[0], chType: f[1]})
+		}
+	}
+
+	rows := &parquetStream{qry: fmt.Sprintf("SELECT * FROM %s FORMAT CSVWithNames", parquetTable(source)), done: done}
+	// the data always has a header row
+	if len(j.headers) > 0 {
+		skip++
+	}
+	return file.NewReader(j.source, ',', '\n', '"', 0, skip, 0, rows, 0), nil
+}
+
+// parquetStream is the output of the query qry of clickhouse local, read as it is written.  toch reads a source
+// more than once, e.g. to count its rows, so seeking to the start runs the query again.
+type parquetStream struct {
+	qry    string
+	done   func() // run by Close
+	c      *exec.Cmd
+	out    io.ReadCloser
+	stderr *bytes.Buffer
+	eof    bool
+}
+
+// start runs the query
+func (p *parquetStream) start() error {
+	c := exec.Command(parquetCmd[0], append(parquetCmd[1:], p.qry)...)
+	out, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	p.stderr = new(bytes.Buffer)
+	c.Stderr = p.stderr
+	if e := c.Start(); e != nil {
+		return fmt.Errorf("clickhouse local failed (is clickhouse installed?): %v", e)
+	}
+	p.c, p.out = c, out
+	return nil
+}
+
+// stop waits for the query to end.  With kill, it is ended first and how it ended doesn't matter.
+func (p *parquetStream) stop(kill bool) error {
+	if p.c == nil {
+		return nil
+	}
+	c := p.c
+	p.c = nil
+	if kill {
+		_ = c.Process.Kill()
+		_ = p.out.Close()
+	}
+	if e := c.Wait(); e != nil && !kill {
+		return fmt.Errorf("clickhouse local failed: %v: %s", e, strings.TrimSpace(p.stderr.String()))
+	}
+	return nil
+}
+
+// Read reads the output of the query, which is started by the first Read.  At the end of the output, an
+// error of the query is returned in place of io.EOF.
+func (p *parquetStream) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	if p.c == nil {
+		if e := p.start(); e != nil {
+			return 0, e
+		}
+	}
+	n, err := p.out.Read(b)
+	if err != io.EOF {
+		return n, err
+	}
+	p.eof = true
+	if e := p.stop(false); e != nil {
+		return n, e
+	}
+	return n, io.EOF
+}
+
+// Seek goes back to the start of the output: the query is stopped and run again by the next Read.  The output
+// can't be read from anywhere else.
+func (p *parquetStream) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, fmt.Errorf("a Parquet source can only be read from the start")
+	}
+	p.eof = false
+	return 0, p.stop(true)
+}
+
+// Close stops the query
+func (p *parquetStream) Close() error {
+	err := p.stop(true)
+	if p.done != nil {
+		p.done()
+		p.done = nil
+	}
+	return err
+}
+
+// parquetTypes gives the fields of spec the types of the Parquet columns.  Types that chutils has no
+// equivalent of (e.g. DateTime, Decimal, Bool, UInt64) are loaded as String, as are derived fields.
+func (j *job) parquetTypes(spec *chutils.TableDef) error {
+	for ind, fd := range spec.FieldDefs {
+		t := "String"
+		if ind < len(j.parquet) {
+			if pt := j.parquet[ind].chType; baseField(&chutils.FieldDef{Legal: &chutils.LegalValues{}}, pt, "") {
+				t = pt
+			}
+		}
+		// clickhouse local writes dates as 2006-01-02
+		if err := setType(fd, t, "2006-01-02"); err != nil {
+			return fmt.Errorf("field %s: %v", fd.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestParquetStream runs shell commands in place of clickhouse local
+func TestParquetStream(t *testing.T) {
+	saved := parquetCmd
+	defer func() { parquetCmd = saved }()
+	parquetCmd = []string{"sh", "-c"}
+
+	p := &parquetStream{qry: "printf 'a,b\\n1,2\\n'"}
+	for pass := 1; pass <= 2; pass++ {
+		body, err := io.ReadAll(p)
+		if err != nil || string(body) != "a,b\n1,2\n" {
+			t.Errorf("pass %d: %q, %v", pass, body, err)
+		}
+		if _, e := p.Seek(0, io.SeekStart); e != nil {
+			t.Errorf("pass %d: Seek: %v", pass, e)
+		}
+	}
+
+	// going back part way through stops the query that is running
+	cleaned := false
+	p = &parquetStream{qry: "yes", done: func() { cleaned = true }}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(p, buf); err != nil || string(buf) != "y\ny\n" {
+		t.Errorf("yes: %q, %v", buf, err)
+	}
+	if _, err := p.Seek(0, io.SeekStart); err != nil {
+		t.Errorf("Seek part way: %v", err)
+	}
+	if _, err := p.Seek(10, io.SeekStart); err == nil {
+		t.Errorf("Seek(10): no error")
+	}
+	if err := p.Close(); err != nil || !cleaned {
+		t.Errorf("Close: %v, cleaned %v", err, cleaned)
+	}
+
+	p = &parquetStream{qry: "echo a,b; echo 'Cannot read the file' >&2; exit 36"}
+	if _, err := io.ReadAll(p); err == nil || !strings.Contains(err.Error(), "Cannot read the file") {
+		t.Errorf("failing query: %v", err)
+	}
+}
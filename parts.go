@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// partsWarn is the estimated number of parts above which toch warns.  ClickHouse slows inserts at
+// parts_to_delay_insert (150 by default) active parts in a partition and refuses them at parts_to_throw_insert.
+const partsWarn = 100
+
+// countLines counts the lines in the file fileName
+func countLines(fileName string) (int, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	n := 0
+	buf := make([]byte, 1024*1024)
+	for {
+		m, e := f.Read(buf)
+		n += bytes.Count(buf[:m], []byte{'\n'})
+		if e == io.EOF {
+			return n, nil
+		}
+		if e != nil {
+			return 0, e
+		}
+	}
+}
+
+// checkParts estimates the number of parts the load of a local file will create.  Each insert of a batch of
+// rows creates at least one part (one per partition it touches), so the estimate is the number of batches.
+// It warns if the estimate is large and fails if it is above maxParts (if maxParts > 0).
+func checkParts(j *job, maxParts int) error {
+	if j.body != nil || j.readerCmd != "" || strings.Contains(strings.ToLower(j.source), "http") {
+		return nil
+	}
+	if info, err := os.Stat(j.source); err != nil || info.IsDir() {
+		return nil
+	}
+	lines, err := countLines(j.source)
+	if err != nil {
+		return err
+	}
+	parts := (lines + j.batch - 1) / j.batch
+	return partsGuard(parts, "will create about", maxParts, j.batch, lines)
+}
+
+// partsGuard warns if parts is large and returns an error if it is over maxParts (if maxParts > 0).
+func partsGuard(parts int, verb string, maxParts, batch, rows int) error {
+	if parts <= partsWarn && (maxParts <= 0 || parts <= maxParts) {
+		return nil
+	}
+	msg := fmt.Sprintf("the load %s %d parts (%d rows in batches of %d); ClickHouse has to merge them. "+
+		"Use a larger -batch (e.g. -batch %d) and, for partitioned tables, coarser partitions", verb, parts, rows, batch,
+		suggestBatch(rows))
+	if maxParts > 0 && parts > maxParts {
+		return fmt.Errorf("%s: over -max-parts %d", msg, maxParts)
+	}
+	fmt.Println("warning:", msg)
+	return nil
+}
+
+// suggestBatch suggests a batch size that loads rows in about 10 inserts, between 100,000 and 1,000,000 rows.
+func suggestBatch(rows int) int {
+	b := rows / 10
+	if b < 100000 {
+		b = 100000
+	}
+	if b > 1000000 {
+		b = 1000000
+	}
+	return b
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// step operates on a raw row of a pipeline.  It returns false if the row is to be dropped.
+type step func(row []interface{}) (keep bool, err error)
+
+// pipeline is a chutils.Input that applies a sequence of steps to the raw rows read from another Input and then
+// validates them against its own table spec.  The steps may change values, drop rows and fill in fields that are
+// not in the source (derived fields).  The derived fields come after the source fields in the table spec.
+type pipeline struct {
+	chutils.Input                   // source of raw rows
+	spec          *chutils.TableDef // spec of the rows returned by the pipeline
+	nSrc          int               // number of fields in the source
+	steps         []step
+	env           *env
+	rules         *ruleCheck // checks of the converted rows, nil if none
+	sorted        *sortCheck // check of the order of the converted rows, nil if none
+	nulls         []int      // fields of the current row that held a -null token
+	bad           *badCheck  // record of the rows for -bad, nil if none
+	read          int        // rows read from the source in this pass
+}
+
+// pipelined returns true if the job needs a pipeline
+func (j *job) pipelined() bool {
+	return j.hasFixed() || j.hasGeo() || j.hasClock() || j.hasDateTime() || j.hasDecimal() || j.hasUnsigned() || j.hasBool() || j.scrub != nil || len(j.decode) > 0 || j.normValues || len(j.cases) > 0 || len(j.fills) > 0 || j.locale != nil || !j.epoch.IsZero() || len(j.derive) > 0 || len(j.recode) > 0 || j.where != nil || j.rules != nil || j.assertSorted != "" || j.nulls != nil || j.bad != ""
+}
+
+// newPipeline creates a pipeline reading from rdr for the options in j.
+// If spec is nil, the spec is the source spec plus the derived fields, whose types are unknown.
+func newPipeline(rdr chutils.Input, j *job, spec *chutils.TableDef) (*pipeline, error) {
+	src := rdr.TableSpec()
+	nSrc := len(src.FieldDefs)
+	if spec == nil {
+		fds := make(map[int]*chutils.FieldDef)
+		for ind, fd := range src.FieldDefs {
+			fds[ind] = fd
+		}
+		for ind, d := range j.derive {
+			fds[nSrc+ind] = &chutils.FieldDef{Name: d.name, ChSpec: chutils.ChField{Base: chutils.ChUnknown}, Legal: &chutils.LegalValues{}}
+		}
+		spec = chutils.NewTableDef(src.Key, src.Engine, fds)
+	}
+
+	// run metadata available to expressions as templates.  {now} is the start of the run, so it is the same
+	// for every row.
+	vars := map[string]interface{}{"run_id": j.runID, "source": j.source, "now": j.now}
+	p := &pipeline{Input: rdr, spec: spec, nSrc: nSrc,
+		env: &env{cols: make(map[string]int), dateFmt: j.dateFmt, vars: vars}}
+	for ind, fd := range spec.FieldDefs {
+		p.env.cols[fd.Name] = ind
+	}
+
+	if !j.epoch.IsZero() {
+		p.steps = append(p.steps, serialDates(nSrc, spec, j.epoch))
+	}
+	if j.nulls != nil {
+		st, err := p.nullStep(j.nulls)
+		if err != nil {
+			return nil, err
+		}
+		p.steps = append(p.steps, st)
+	}
+	if len(j.decode) > 0 {
+		s, err := fieldMapStep("-decode", nSrc, j.decode, p.env.cols)
+		if err != nil {
+			return nil, err
+		}
+		p.steps = append(p.steps, s)
+	}
+	if j.scrub != nil {
+		p.steps = append(p.steps, mapStrings(nSrc, j.scrub))
+	}
+	if j.normValues {
+		p.steps = append(p.steps, mapStrings(nSrc, j.normalize))
+	}
+	if len(j.cases) > 0 {
+		s, err := fieldMapStep("-upper, -lower or -titlecase", nSrc, j.cases, p.env.cols)
+		if err != nil {
+			return nil, err
+		}
+		p.steps = append(p.steps, s)
+	}
+	if len(j.fills) > 0 {
+		s, err := p.fillStep(j.fills)
+		if err != nil {
+			return nil, err
+		}
+		p.steps = append(p.steps, s)
+	}
+	if j.locale != nil {
+		// String fields keep the values as they are written
+		f := j.locale.normalizer()
+		p.steps = append(p.steps, func(row []interface{}) (bool, error) {
+			for ind := 0; ind < nSrc; ind++ {
+				if s, ok := row[ind].(string); ok && spec.FieldDefs[ind].ChSpec.Base != chutils.ChString {
+					row[ind] = f(s)
+				}
+			}
+			return true, nil
+		})
+	}
+	for ind, d := range j.derive {
+		x, col := d.x, nSrc+ind
+		p.steps = append(p.steps, func(row []interface{}) (bool, error) {
+			v, err := x.eval(p.env)
+			row[col] = toStr(p.env, v)
+			return true, err
+		})
+	}
+	for _, r := range j.recode {
+		x, name := r.x, r.name
+		col, ok := p.env.cols[name]
+		if !ok || col >= nSrc {
+			return nil, fmt.Errorf("-recode field %s is not in the source", name)
+		}
+		p.steps = append(p.steps, func(row []interface{}) (bool, error) {
+			v, err := x.eval(p.env)
+			row[col] = toStr(p.env, v)
+			return true, err
+		})
+	}
+	if j.hasGeo() {
+		p.steps = append(p.steps, j.geoStep(nSrc))
+	}
+	if j.hasClock() {
+		p.steps = append(p.steps, j.clockStep(nSrc))
+	}
+	if j.hasDateTime() {
+		p.steps = append(p.steps, j.dateTimeStep(nSrc))
+	}
+	if j.hasDecimal() {
+		p.steps = append(p.steps, j.decimalStep(nSrc))
+	}
+	if j.hasUnsigned() {
+		p.steps = append(p.steps, j.unsignedStep(nSrc))
+	}
+	if j.hasBool() {
+		p.steps = append(p.steps, j.boolStep(nSrc))
+	}
+	if j.hasFixed() {
+		p.steps = append(p.steps, fixedStep(spec, j.truncate))
+	}
+	if j.rules != nil {
+		p.rules = newRuleCheck(j.rules, j.source)
+	}
+	if j.bad != "" {
+		p.bad = newBadCheck(j.bad, j.source)
+	}
+	if j.assertSorted != "" {
+		col, ok := p.env.cols[j.assertSorted]
+		if !ok {
+			return nil, fmt.Errorf("-assert-sorted field %s is not in the table", j.assertSorted)
+		}
+		p.sorted = newSortCheck(j.assertSorted, col, j.assertAction, j.source)
+	}
+	if j.where != nil {
+		x := j.where
+		p.steps = append(p.steps, func(row []interface{}) (bool, error) {
+			v, err := x.eval(p.env)
+			if err != nil {
+				return false, err
+			}
+			return toBool(v)
+		})
+	}
+
+	return p, nil
+}
+
+// mapStrings returns a step that applies f to the string values of the first nSrc fields
+func mapStrings(nSrc int, f func(string) string) step {
+	return func(row []interface{}) (bool, error) {
+		for ind := 0; ind < nSrc; ind++ {
+			if s, ok := row[ind].(string); ok {
+				row[ind] = f(s)
+			}
+		}
+		return true, nil
+	}
+}
+
+// Reset resets the source and starts a new pass of the rule and order checks and of the -bad record
+func (p *pipeline) Reset() error {
+	p.read = 0
+	if p.bad != nil {
+		if e := p.bad.reset(); e != nil {
+			return e
+		}
+	}
+	if p.rules != nil {
+		p.rules.reset()
+	}
+	if p.sorted != nil {
+		p.sorted.reset()
+	}
+	return p.Input.Reset()
+}
+
+// Close closes the source, first reporting the rows that failed the rule and order checks in the last pass and
+// writing its -bad rows
+func (p *pipeline) Close() error {
+	var err error
+	if p.rules != nil {
+		err = p.rules.finish(p.spec)
+	}
+	if p.bad != nil {
+		if e := p.bad.finish(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if p.sorted != nil {
+		p.sorted.finish()
+	}
+	if e := p.Input.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// TableSpec returns the table spec of the rows produced by the pipeline
+func (p *pipeline) TableSpec() *chutils.TableDef {
+	return p.spec
+}
+
+// Read reads nTarget rows (all of them, if nTarget is 0) that make it through the steps.
+func (p *pipeline) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	for nTarget <= 0 || len(data) < nTarget {
+		rows, _, e := p.Input.Read(1, false)
+		if e == io.EOF && len(data) > 0 {
+			return data, valid, nil
+		}
+		if e != nil {
+			if p.bad != nil && e != io.EOF {
+				p.read++
+				if be := p.bad.add(p.read, "", "", e.Error(), nil); be != nil {
+					return data, valid, be
+				}
+			}
+			return data, valid, e
+		}
+		p.read++
+
+		row := make([]interface{}, len(p.spec.FieldDefs))
+		copy(row, rows[0])
+		p.env.row = row
+		keep := true
+		for _, s := range p.steps {
+			if keep, e = s(row); e != nil || !keep {
+				break
+			}
+		}
+		if e != nil {
+			return data, valid, e
+		}
+		if !keep {
+			continue
+		}
+
+		status := make(chutils.Valid, len(row))
+		if validate {
+			var raw []interface{}
+			if p.rules != nil || p.bad != nil {
+				raw = append(raw, row...)
+			}
+			for ind, fd := range p.spec.FieldDefs {
+				row[ind], status[ind] = convert(fd, row[ind])
+			}
+			// a -null token that is still empty is missing, whatever the type
+			for _, ind := range p.nulls {
+				if s, ok := row[ind].(string); ok && s == "" {
+					row[ind], status[ind] = p.spec.FieldDefs[ind].Missing, chutils.VTypeFail
+				}
+			}
+			if p.bad != nil {
+				if e = p.bad.converted(p.read, p.spec, raw, status, p.nulls, rows[0]); e != nil {
+					return data, valid, e
+				}
+			}
+			if p.rules != nil {
+				if keep, e = p.rules.check(p.env, row, raw, p.spec); e != nil {
+					return data, valid, e
+				}
+				if !keep {
+					if p.bad != nil {
+						if e = p.bad.add(p.read, "", "", "fails "+p.rules.last, rows[0]); e != nil {
+							return data, valid, e
+						}
+					}
+					continue
+				}
+			}
+			if p.sorted != nil {
+				if e = p.sorted.check(p.env, row); e != nil {
+					return data, valid, e
+				}
+			}
+		}
+		data, valid = append(data, row), append(valid, status)
+	}
+	return data, valid, nil
+}
+
+// assignment is a field name and the expression that gives its value
+type assignment struct {
+	name string
+	x    expr
+}
+
+// assignRe matches name=expr, but not name==expr
+var assignRe = regexp.MustCompile(`^\s*(\x60[^\x60]+\x60|[A-Za-z_][A-Za-z0-9_.]*)\s*=([^=].*)$`)
+
+// parseAssignments parses a list of name=expr separated by semicolons
+func parseAssignments(list string) ([]assignment, error) {
+	as := make([]assignment, 0)
+	for _, a := range splitOutside(list, ';') {
+		if strings.TrimSpace(a) == "" {
+			continue
+		}
+		m := assignRe.FindStringSubmatch(a)
+		if m == nil {
+			return nil, fmt.Errorf("expected name=expression, got %s", a)
+		}
+		x, err := parseExpr(m[2])
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, assignment{name: strings.Trim(m[1], "`"), x: x})
+	}
+	return as, nil
+}
+
+// splitOutside splits s on sep where sep is not inside quotes or parentheses
+func splitOutside(s string, sep rune) []string {
+	parts := make([]string, 0)
+	var quote rune
+	depth, st := 0, 0
+	for ind, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case r == sep && depth == 0:
+			parts = append(parts, s[st:ind])
+			st = ind + 1
+		}
+	}
+	return append(parts, s[st:])
+}
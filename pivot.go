@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// pivotSpec is the -pivot option: the rows with the same index fields become one row with a field for each
+// value of the columns field, holding the values field.
+type pivotSpec struct {
+	index   []string
+	columns string
+	values  string
+}
+
+// parsePivot parses -pivot, e.g. 'index=msa; columns=year; values=hpi'.  index may be a comma-separated list.
+func parsePivot(opt string) (*pivotSpec, error) {
+	if strings.TrimSpace(opt) == "" {
+		return nil, nil
+	}
+	p := &pivotSpec{}
+	for _, part := range strings.Split(opt, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("-pivot: expected key=value, got %s", part)
+		}
+		v := strings.TrimSpace(kv[1])
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "index":
+			p.index = splitList(v)
+		case "columns":
+			p.columns = v
+		case "values":
+			p.values = v
+		default:
+			return nil, fmt.Errorf("-pivot: unknown key %s", kv[0])
+		}
+	}
+	if len(p.index) == 0 || p.columns == "" || p.values == "" {
+		return nil, fmt.Errorf("-pivot needs index, columns and values")
+	}
+	return p, nil
+}
+
+// pivot is a chutils.Input that returns the rows of another Input pivoted from long to wide.  The source is
+// read into memory when the pivot is created.
+type pivot struct {
+	src  chutils.Input
+	spec *chutils.TableDef
+	rows []chutils.Row
+	pos  int
+}
+
+// nonIdent matches the characters that can't be in a field name
+var nonIdent = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// newPivot pivots the rows of in as described by ps.  The fields are the index fields followed by one
+// field for each value of the columns field, in the order they are first seen, named <values>_<value>.
+// There may be at most maxCols of these.
+func newPivot(in chutils.Input, ps *pivotSpec, maxCols int) (*pivot, error) {
+	src := in.TableSpec()
+	find := func(name string) (int, error) {
+		for ind, fd := range src.FieldDefs {
+			if fd.Name == name {
+				return ind, nil
+			}
+		}
+		return 0, fmt.Errorf("-pivot: field %s is not in the data", name)
+	}
+	idx := make([]int, len(ps.index))
+	for ind, name := range ps.index {
+		var err error
+		if idx[ind], err = find(name); err != nil {
+			return nil, err
+		}
+	}
+	colInd, err := find(ps.columns)
+	if err != nil {
+		return nil, err
+	}
+	valInd, err := find(ps.values)
+	if err != nil {
+		return nil, err
+	}
+
+	// read the source
+	if e := in.Reset(); e != nil {
+		return nil, e
+	}
+	cols := make(map[string]int)
+	colNames := make([]string, 0)
+	keys := make(map[string]int)
+	rows := make([]chutils.Row, 0)
+	cells := make([]map[int]interface{}, 0)
+	for {
+		data, _, e := in.Read(1000, true)
+		for _, row := range data {
+			c := fmt.Sprint(row[colInd])
+			ci, ok := cols[c]
+			if !ok {
+				if len(colNames) == maxCols {
+					return nil, fmt.Errorf("-pivot: %s has more than -pivot-max-cols %d values", ps.columns, maxCols)
+				}
+				ci = len(colNames)
+				cols[c], colNames = ci, append(colNames, c)
+			}
+			keyVals := make(chutils.Row, len(idx))
+			for ind, i := range idx {
+				keyVals[ind] = row[i]
+			}
+			key := fmt.Sprint(keyVals...)
+			ri, ok := keys[key]
+			if !ok {
+				ri = len(rows)
+				keys[key] = ri
+				rows, cells = append(rows, keyVals), append(cells, make(map[int]interface{}))
+			}
+			cells[ri][ci] = row[valInd]
+		}
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	// the spec of the pivoted rows
+	fds := make(map[int]*chutils.FieldDef)
+	for ind, i := range idx {
+		fd := *src.FieldDefs[i]
+		fds[ind] = &fd
+	}
+	vfd := src.FieldDefs[valInd]
+	for ind, c := range colNames {
+		fd := *vfd
+		fd.Name = ps.values + "_" + strings.Trim(nonIdent.ReplaceAllString(c, "_"), "_")
+		fds[len(idx)+ind] = &fd
+	}
+	spec := chutils.NewTableDef(fds[0].Name, src.Engine, fds)
+	uniqueNames(spec)
+
+	// fill in the rows
+	for ri := range rows {
+		for ci := range colNames {
+			v, ok := cells[ri][ci]
+			if !ok {
+				v = vfd.Missing
+			}
+			rows[ri] = append(rows[ri], v)
+		}
+	}
+	fmt.Printf("pivot: %d rows, %d %s columns\n", len(rows), len(colNames), ps.values)
+	return &pivot{src: in, spec: spec, rows: rows}, nil
+}
+
+// TableSpec returns the spec of the pivoted rows
+func (p *pivot) TableSpec() *chutils.TableDef {
+	return p.spec
+}
+
+// Read returns the next nTarget pivoted rows (all of them if nTarget is 0).  They are already validated.
+func (p *pivot) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	if p.pos >= len(p.rows) {
+		return nil, nil, io.EOF
+	}
+	end := len(p.rows)
+	if nTarget > 0 && p.pos+nTarget < end {
+		end = p.pos + nTarget
+	}
+	data = p.rows[p.pos:end]
+	for range data {
+		valid = append(valid, make(chutils.Valid, len(p.spec.FieldDefs)))
+	}
+	for _, v := range valid {
+		for ind := range v {
+			v[ind] = chutils.VPass
+		}
+	}
+	p.pos = end
+	return data, valid, nil
+}
+
+// Reset starts over at the first row
+func (p *pivot) Reset() error {
+	p.pos = 0
+	return nil
+}
+
+// CountLines returns the number of pivoted rows
+func (p *pivot) CountLines() (int, error) {
+	return len(p.rows), nil
+}
+
+// Seek moves to row lineNo
+func (p *pivot) Seek(lineNo int) error {
+	p.pos = lineNo
+	return nil
+}
+
+// Close closes the source
+func (p *pivot) Close() error {
+	return p.src.Close()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/chutils/str"
+)
+
+func TestParsePivot(t *testing.T) {
+	p, err := parsePivot(" index = msa, 'st' ; COLUMNS=year;values=hpi")
+	if err != nil || strings.Join(p.index, ",") != "msa,st" || p.columns != "year" || p.values != "hpi" {
+		t.Errorf("parsePivot = %+v, %v", p, err)
+	}
+	if p, err := parsePivot("  "); p != nil || err != nil {
+		t.Errorf("empty -pivot = %v, %v", p, err)
+	}
+	for _, opt := range []string{"index=msa; columns=year", "index=msa; columns=year; values=hpi; fill=0", "msa;year;hpi"} {
+		if _, err := parsePivot(opt); err == nil {
+			t.Errorf("parsePivot(%s): no error", opt)
+		}
+	}
+}
+
+func TestPivot(t *testing.T) {
+	src := "year,msa,hpi\n2020,A,1.5\n2021,A,2.5\n2021,New York,3.5\n2022,St. Louis,4.5\n"
+	in := func() chutils.Input {
+		rdr := str.NewReader(src, ',', '\n', '"', 0, 1, 0)
+		if err := rdr.Init("", chutils.MergeTree); err != nil {
+			t.Fatal(err)
+		}
+		if err := rdr.TableSpec().Impute(rdr, 0, 0.95); err != nil {
+			t.Fatal(err)
+		}
+		return rdr
+	}
+	ps := &pivotSpec{index: []string{"year"}, columns: "msa", values: "hpi"}
+	p, err := newPivot(in(), ps, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(p.TableSpec().FieldDefs))
+	for ind, fd := range p.TableSpec().FieldDefs {
+		names[ind] = fd.Name
+	}
+	if got := strings.Join(names, ","); got != "year,hpi_A,hpi_New_York,hpi_St_Louis" {
+		t.Errorf("fields %s", got)
+	}
+	miss := p.TableSpec().FieldDefs[1].Missing
+	want := fmt.Sprintf("[2020 1.5 %[1]v %[1]v] [2021 2.5 3.5 %[1]v] [2022 %[1]v %[1]v 4.5]", miss)
+	rows, _, _ := p.Read(0, true)
+	if got := strings.Trim(fmt.Sprint(rows), "[]"); got != strings.Trim(want, "[]") {
+		t.Errorf("rows %s, want %s", got, want)
+	}
+
+	// there are three msas, one more than allowed
+	if _, err := newPivot(in(), ps, 2); err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("-pivot-max-cols 2: %v", err)
+	}
+	if _, err := newPivot(in(), &pivotSpec{index: []string{"year"}, columns: "cbsa", values: "hpi"}, 10); err == nil {
+		t.Errorf("unknown field: no error")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/invertedv/chutils/file"
+)
+
+// newCmd creates a reader for the output of the user-supplied reader command cmd.
+//
+// This is how toch is extended to formats it does not read itself.  The command is run by the shell with the
+// environment variable TOCH_SOURCE set to -s.  It writes the data to stdout, one row per line, in the format
+// given by -type (text or csv), optionally with a header row.  toch handles the rest: field names, types, the
+// table DDL and the insert.  A non-zero exit status fails the load, with the command's stderr as the error.
+func newCmd(cmd, source, sType, delim string, quote rune, skip int) (*file.Reader, error) {
+	if sType != "text" && sType != "csv" {
+		return nil, fmt.Errorf("-reader-cmd requires -type text or csv")
+	}
+	c := exec.Command("sh", "-c", cmd)
+	c.Env = append(os.Environ(), "TOCH_SOURCE="+source)
+
+	tmp, err := spoolCmd(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the reader keeps the file open
+	defer func() { _ = os.Remove(tmp) }()
+	return newFile(tmp, sType, delim, quote, skip, nil, "")
+}
+
+// spoolCmd runs c and streams its stdout, through conv if it isn't nil, to a temporary file, whose name it
+// returns.  The output is read more than once, so, like a named pipe, it is spooled rather than read from the
+// pipe.  If c fails, its stderr is included in the error.  The caller removes the file.
+func spoolCmd(c *exec.Cmd, conv func(r io.Reader, w io.Writer) error) (string, error) {
+	if conv == nil {
+		conv = func(r io.Reader, w io.Writer) error {
+			_, err := io.Copy(w, r)
+			return err
+		}
+	}
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	stderr := new(bytes.Buffer)
+	c.Stderr = stderr
+	tmp, err := os.CreateTemp("", "toch-cmd-*")
+	if err != nil {
+		return "", err
+	}
+	if e := c.Start(); e != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", e
+	}
+	err = conv(stdout, tmp)
+	if err != nil {
+		// the command may still be writing.  It's stopped and the pipe closed, so that anything it started
+		// stops too, when it next writes, rather than holding its stderr open.
+		_ = c.Process.Kill()
+		_ = stdout.Close()
+	}
+	if e := c.Wait(); e != nil && err == nil {
+		err = fmt.Errorf("%s failed: %v: %s", c.Args[0], e, strings.TrimSpace(stderr.String()))
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// runCmd runs c and returns its stdout.  If c fails, its stderr is included in the error.
+func runCmd(c *exec.Cmd) ([]byte, error) {
+	body, err := c.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%s failed: %v: %s", c.Args[0], err, string(ee.Stderr))
+		}
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/invertedv/chutils"
+)
+
+func TestSpoolCmd(t *testing.T) {
+	tmp, err := spoolCmd(exec.Command("sh", "-c", "printf 'a,b\\n1,2\\n'"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(tmp) }()
+	if body, _ := os.ReadFile(tmp); string(body) != "a,b\n1,2\n" {
+		t.Errorf("spooled %q", body)
+	}
+
+	// a failing command reports its stderr and leaves no file behind
+	_, err = spoolCmd(exec.Command("sh", "-c", "echo partial; echo no such table >&2; exit 3"), nil)
+	if err == nil || !strings.Contains(err.Error(), "no such table") {
+		t.Errorf("failing command: %v", err)
+	}
+
+	// so does a conversion that fails, even if the command would go on writing
+	_, err = spoolCmd(exec.Command("sh", "-c", "yes"), func(r io.Reader, w io.Writer) error {
+		_, e := io.CopyN(w, r, 10)
+		if e == nil {
+			e = io.ErrUnexpectedEOF
+		}
+		return e
+	})
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("failing conversion: %v", err)
+	}
+}
+
+func TestNewCmd(t *testing.T) {
+	rdr, err := newCmd(`printf 'src\n%s\n' "$TOCH_SOURCE"`, "s3://b/k.csv", "csv", "", '"', 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rdr.Close() }()
+	if e := rdr.Init("", chutils.MergeTree); e != nil || rdr.TableSpec().FieldDefs[0].Name != "src" {
+		t.Fatalf("Init: %v", e)
+	}
+	row, _, err := rdr.Read(1, false)
+	if err != nil || len(row) != 1 || row[0][0] != "s3://b/k.csv" {
+		t.Errorf("read %v, %v", row, err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/chutils/file"
+)
+
+// allowed values of -schema-policy
+var schemaPolicies = []string{"first", "union", "strict"}
+
+// conform matches the fields of j.source, a file of a multi-file load, to spec, the table spec made from the
+// first file.  Fields are matched by name.  With -schema-policy
+//   - strict: it is an error if the fields differ from spec's.
+//   - first: fields not in spec are dropped and fields of spec that aren't in the file are filled with
+//     missing values.
+//   - union: as first, except that fields not in spec are added to the table.  The new spec is returned.
+//
+// The fields that are dropped, added and missing are reported.  If the fields were given with -h, every file
+// is taken to have them.
+func (j *job) conform(spec *chutils.TableDef, con *chutils.Connect) (*chutils.TableDef, error) {
+	j.columns = nil
+	if len(j.headers) > 0 {
+		return spec, nil
+	}
+	cols, err := j.fileFields()
+	if err != nil {
+		return nil, err
+	}
+
+	nSrc := len(spec.FieldDefs) - len(j.derive)
+	inTable, inFile := make(map[string]bool), make(map[string]bool)
+	same := len(cols) == nSrc
+	for ind := 0; ind < nSrc; ind++ {
+		inTable[spec.FieldDefs[ind].Name] = true
+		same = same && spec.FieldDefs[ind].Name == cols[ind]
+	}
+	if same {
+		return spec, nil
+	}
+	var missing, extra []string
+	for _, c := range cols {
+		inFile[c] = true
+		if !inTable[c] {
+			extra = append(extra, c)
+		}
+	}
+	for ind := 0; ind < nSrc; ind++ {
+		if name := spec.FieldDefs[ind].Name; !inFile[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	switch j.schemaPolicy {
+	case "strict":
+		if len(missing) == 0 && len(extra) == 0 {
+			return nil, fmt.Errorf("the fields are in a different order than in the first file")
+		}
+		return nil, fmt.Errorf("the fields differ from the first file: missing %s; not in the first file %s",
+			listOrNone(missing), listOrNone(extra))
+	case "union":
+		if len(extra) > 0 {
+			if spec, err = j.addColumns(spec, extra, con); err != nil {
+				return nil, err
+			}
+			fmt.Printf("%s: columns %s added to %s\n", j.source, strings.Join(extra, ", "), j.table)
+		}
+	default:
+		if len(extra) > 0 {
+			fmt.Printf("%s: fields %s dropped\n", j.source, strings.Join(extra, ", "))
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("%s: fields %s missing, filled with missing values\n", j.source, strings.Join(missing, ", "))
+	}
+	j.columns = cols
+	return spec, nil
+}
+
+// listOrNone returns the comma-separated names or "none"
+func listOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// fileFields returns the field names of j.source, as read from its header row and named by nameFields
+func (j *job) fileFields() ([]string, error) {
+	rdr, err := j.newReader()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rdr.Close() }()
+	if e := rdr.Init("", chutils.MergeTree); e != nil {
+		return nil, e
+	}
+	spec := rdr.TableSpec()
+	j.nameFields(spec)
+	cols := make([]string, len(spec.FieldDefs))
+	for ind := range cols {
+		cols[ind] = spec.FieldDefs[ind].Name
+	}
+	return cols, nil
+}
+
+// addColumns adds the fields extra of j.source to j.table and to spec after its source fields.  The types of the
+// fields are imputed from j.source.  The existing rows get the default value of the type.
+func (j *job) addColumns(spec *chutils.TableDef, extra []string, con *chutils.Connect) (*chutils.TableDef, error) {
+	fj := *j
+	// the reader is only used for the types, so the rows failing the rules aren't reported
+	fj.columns, fj.rules = nil, nil
+	in, err := openReader(&fj, nil)
+	if err != nil {
+		return nil, err
+	}
+	_ = in.Close()
+
+	nSrc := len(spec.FieldDefs) - len(j.derive)
+	fds := make(map[int]*chutils.FieldDef)
+	for ind := 0; ind < nSrc; ind++ {
+		fds[ind] = spec.FieldDefs[ind]
+	}
+	after := spec.FieldDefs[nSrc-1].Name
+	for _, name := range extra {
+		_, fd, e := in.TableSpec().Get(name)
+		if e != nil {
+			return nil, e
+		}
+		qry := fmt.Sprintf("ALTER TABLE %s ADD COLUMN `%s` %s AFTER `%s`", j.table, name, chType(fd), after)
+		if _, e := con.Exec(qry); e != nil {
+			return nil, e
+		}
+		fds[len(fds)], after = fd, name
+	}
+	// the derived fields
+	for ind := nSrc; ind < len(spec.FieldDefs); ind++ {
+		fds[len(fds)] = spec.FieldDefs[ind]
+	}
+	return chutils.NewTableDef(spec.Key, spec.Engine, fds), nil
+}
+
+// remap is a chutils.Input that returns the rows of a file whose fields differ from the table's.  The fields
+// are put in the table's order; those the file doesn't have are empty.
+type remap struct {
+	chutils.Input
+	spec *chutils.TableDef // spec of the table's source fields
+	pick []int             // the file field of each table field, -1 if the file doesn't have it
+}
+
+// newRemap creates a remap of rdr, whose fields are cols, to the fields of spec
+func newRemap(rdr *file.Reader, cols []string, spec *chutils.TableDef) *remap {
+	// the file's own fields are read as they are
+	fds := make(map[int]*chutils.FieldDef)
+	at := make(map[string]int)
+	for ind, c := range cols {
+		fds[ind] = &chutils.FieldDef{Name: c, ChSpec: chutils.ChField{Base: chutils.ChString}, Legal: &chutils.LegalValues{}}
+		at[c] = ind
+	}
+	rdr.SetTableSpec(chutils.NewTableDef(cols[0], spec.Engine, fds))
+
+	r := &remap{Input: rdr, spec: spec, pick: make([]int, len(spec.FieldDefs))}
+	for ind := range r.pick {
+		r.pick[ind] = -1
+		if col, ok := at[spec.FieldDefs[ind].Name]; ok {
+			r.pick[ind] = col
+		}
+	}
+	return r
+}
+
+// TableSpec returns the spec of the table's source fields
+func (r *remap) TableSpec() *chutils.TableDef {
+	return r.spec
+}
+
+// Read reads nTarget rows (all of them, if nTarget is 0) and puts their fields in the table's order
+func (r *remap) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	rows, _, err := r.Input.Read(nTarget, false)
+	for _, row := range rows {
+		out := make(chutils.Row, len(r.pick))
+		status := make(chutils.Valid, len(r.pick))
+		for ind, col := range r.pick {
+			out[ind], status[ind] = "", chutils.VPass
+			if col >= 0 && col < len(row) {
+				out[ind] = row[col]
+			}
+			if validate {
+				out[ind], status[ind] = convert(r.spec.FieldDefs[ind], out[ind])
+			}
+		}
+		data, valid = append(data, out), append(valid, status)
+	}
+	return data, valid, err
+}
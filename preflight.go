@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// sourceSize estimates the uncompressed size in bytes of the data to be loaded: that of the local file or, with
+// -recursive or a glob, of the selected files, or the Content-Length of a web address.  If the size can't be
+// known (e.g. an S3 object, a command, a bz2 file or a compressed web source), it returns 0 and the reason.
+func sourceSize(j *job, recursive bool, include, exclude []string) (size int64, unknown string, err error) {
+	switch {
+	case j.readerCmd != "":
+		return 0, "the source is read by -reader-cmd", nil
+	case isS3(j.source):
+		return 0, "the source is an S3 object", nil
+	case strings.Contains(strings.ToLower(j.source), "http"):
+		return j.webSize()
+	}
+
+	var files []string
+	if isGlob(j.source) {
+		if files, err = globFiles(j.source); err != nil {
+			return 0, "", err
+		}
+	} else {
+		info, e := os.Stat(j.source)
+		if e != nil {
+			// a query or an API
+			return 0, "the source isn't a file", nil
+		}
+		files = []string{j.source}
+		if info.IsDir() && recursive {
+			files = nil
+			err = filepath.WalkDir(j.source, func(p string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, e := filepath.Rel(j.source, p)
+				if e != nil {
+					return e
+				}
+				if selected(filepath.ToSlash(rel), include, exclude) {
+					files = append(files, p)
+				}
+				return nil
+			})
+			if err != nil {
+				return 0, "", err
+			}
+		}
+	}
+	for _, f := range files {
+		n, why, e := j.fileSize(f)
+		if e != nil || why != "" {
+			return 0, why, e
+		}
+		size += n
+	}
+	return size, "", nil
+}
+
+// fileSize returns the uncompressed size of the local file name.  For a gzip file, it is the size in the gzip
+// trailer, which is the size modulo 4 GiB: it is taken to be the smallest such size that could have been
+// compressed to the size of the file.  For a zip archive, it is the size of the files in its directory.  A bz2 file doesn't record its
+// size, so it is unknown.  Spreadsheets and Parquet files are compressed themselves: their size is used as-is.
+func (j *job) fileSize(name string) (int64, string, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return 0, "", err
+	}
+	mode := j.decompress
+	if j.sType == "xlsx" || j.sType == "parquet" {
+		mode = "none"
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = f.Close() }()
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(f, head)
+
+	switch compressionOf(mode, name, head[:n]) {
+	case "gzip":
+		trailer := make([]byte, 4)
+		if _, e := f.ReadAt(trailer, info.Size()-4); e != nil {
+			return 0, "", fmt.Errorf("%s: %v", name, e)
+		}
+		size := int64(littleEndian32(trailer))
+		// data that doesn't compress grows by a little
+		for size+size/100+1024 < info.Size() {
+			size += 1 << 32
+		}
+		return size, "", nil
+	case "zip":
+		zr, e := zip.OpenReader(name)
+		if e != nil {
+			return 0, "", fmt.Errorf("%s: %v", name, e)
+		}
+		defer func() { _ = zr.Close() }()
+		var size int64
+		for _, m := range zr.File {
+			size += int64(m.UncompressedSize64)
+		}
+		return size, "", nil
+	case "bz2":
+		return 0, name + " is bz2, which doesn't record its size", nil
+	}
+	return info.Size(), "", nil
+}
+
+// littleEndian32 decodes the little-endian 32-bit unsigned integer b
+func littleEndian32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// webSize returns the Content-Length of the web source, from a HEAD request.  Its size is unknown if the request
+// isn't a GET, the server doesn't give the length or the source is compressed.
+func (j *job) webSize() (int64, string, error) {
+	head := webRequest{agent: "NA"}
+	if j.web != nil {
+		head = *j.web
+	}
+	if head.method != "" && head.method != "GET" {
+		return 0, "the source is fetched with -http-method " + head.method, nil
+	}
+	if _, ok := compressedExts[strings.ToLower(path.Ext(j.source))]; ok || j.decompress != "auto" && j.decompress != "none" {
+		return 0, "the web source is compressed", nil
+	}
+	head.method, head.body = "HEAD", nil
+	resp, err := head.get(j.source)
+	if err != nil {
+		return 0, fmt.Sprintf("HEAD %s failed: %v", j.source, err), nil
+	}
+	_ = resp.Body.Close()
+	if resp.ContentLength <= 0 || resp.Header.Get("Content-Encoding") != "" {
+		return 0, "the web server doesn't give the length of the source", nil
+	}
+	return resp.ContentLength, "", nil
+}
+
+// freeSpace returns the free space on the disks of the server's default storage policy
+func freeSpace(con *chutils.Connect) (uint64, error) {
+	qry := `SELECT sum(free_space) FROM system.disks 
+WHERE name IN (SELECT arrayJoin(disks) FROM system.storage_policies WHERE policy_name = 'default')`
+	var free uint64
+	err := con.QueryRow(qry).Scan(&free)
+	return free, err
+}
+
+// checkSize compares the size of the source to the free space on the server.  The table is compressed, so it
+// usually takes much less space than the source, but merging parts needs room for a second copy.  So toch
+// fails if the source is larger than the free space and warns if it is over half of it.
+func checkSize(size int64, con *chutils.Connect) error {
+	if size <= 0 {
+		return nil
+	}
+	free, err := freeSpace(con)
+	if err != nil {
+		return fmt.Errorf("cannot check the free space on the server (use -size-check N to skip): %v", err)
+	}
+	switch {
+	case uint64(size) > free:
+		return fmt.Errorf("the source is %s and the server has only %s free; free up space or use -size-check N "+
+			"if the data compresses well", bytesString(uint64(size)), bytesString(free))
+	case uint64(size) > free/2:
+		fmt.Printf("warning: the source is %s and the server has %s free\n", bytesString(uint64(size)), bytesString(free))
+	}
+	return nil
+}
+
+// bytesString formats b as B, KiB, MiB, ...
+func bytesString(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSize(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte(strings.Repeat("a,b,c\n1,2,3\n", 1000))
+	write := func(name string, b []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	var tiny bytes.Buffer
+	w = gzip.NewWriter(&tiny)
+	_, _ = w.Write([]byte("x"))
+	_ = w.Close()
+	var zp bytes.Buffer
+	zw := zip.NewWriter(&zp)
+	f, _ := zw.Create("data.csv")
+	_, _ = f.Write(data)
+	_ = zw.Close()
+
+	tests := []struct {
+		name    string
+		file    string
+		sType   string
+		want    int64
+		unknown bool
+	}{
+		{"plain", write("data.csv", data), "csv", int64(len(data)), false},
+		{"gzip", write("data.csv.gz", gz.Bytes()), "csv", int64(len(data)), false},
+		{"gzip larger than its data", write("tiny.gz", tiny.Bytes()), "csv", 1, false},
+		{"gzip without the extension", write("data.bin", gz.Bytes()), "csv", int64(len(data)), false},
+		{"zip", write("data.zip", zp.Bytes()), "csv", int64(len(data)), false},
+		{"xlsx as-is", write("book.xlsx", zp.Bytes()), "xlsx", int64(zp.Len()), false},
+		{"bz2", write("data.csv.bz2", []byte("BZh91AY&SY")), "csv", 0, true},
+	}
+	for _, tt := range tests {
+		j := &job{decompress: "auto", sType: tt.sType}
+		got, unknown, err := j.fileSize(tt.file)
+		if err != nil || got != tt.want || (unknown != "") != tt.unknown {
+			t.Errorf("%s: got %d, %q, %v; want %d", tt.name, got, unknown, err, tt.want)
+		}
+	}
+
+	// a directory loaded with -recursive
+	j := &job{decompress: "auto", sType: "csv", source: dir}
+	if _, unknown, err := sourceSize(j, true, nil, []string{"*.bz2", "*.xlsx"}); unknown != "" || err != nil {
+		t.Errorf("directory: %q, %v", unknown, err)
+	}
+	if _, unknown, _ := sourceSize(j, true, nil, nil); !strings.Contains(unknown, "bz2") {
+		t.Errorf("directory with a bz2 file: %q", unknown)
+	}
+	j.source = "s3://bucket/key.csv"
+	if size, unknown, _ := sourceSize(j, false, nil, nil); size != 0 || unknown == "" {
+		t.Errorf("S3: %d, %q", size, unknown)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profile is a named set of connection options and command line defaults, from the profiles file
+type profile struct {
+	Host     string                 `yaml:"host"`
+	Port     int                    `yaml:"port"`
+	TLS      *bool                  `yaml:"tls"`
+	User     string                 `yaml:"user"`
+	Password string                 `yaml:"password"`
+	Settings map[string]interface{} `yaml:"settings"` // ClickHouse settings
+	Defaults map[string]string      `yaml:"defaults"` // defaults for other command line options
+}
+
+// defaultProfiles returns the default location of the profiles file
+func defaultProfiles() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".toch", "profiles.yaml")
+}
+
+// loadProfile reads the profile name from the YAML file fileName
+func loadProfile(fileName, name string) (*profile, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make(map[string]*profile)
+	if e := yaml.Unmarshal(b, &profiles); e != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, e)
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("no profile %s in %s", name, fileName)
+	}
+	return p, nil
+}
+
+// apply sets the command line options that are in the profile but were not given on the command line
+func (p *profile) apply() error {
+	values := make(map[string]string)
+	for name, val := range p.Defaults {
+		values[name] = val
+	}
+	if p.Host != "" {
+		values["host"] = p.Host
+	}
+	if p.Port != 0 {
+		values["port"] = strconv.Itoa(p.Port)
+	}
+	if p.TLS != nil {
+		values["tls"] = "N"
+		if *p.TLS {
+			values["tls"] = "Y"
+		}
+	}
+	if p.User != "" {
+		values["user"] = p.User
+	}
+	if p.Password != "" {
+		values["password"] = p.Password
+	}
+
+	return setDefaults(values, "profile")
+}
+
+// setDefaults sets the command line options in values that were not given on the command line.
+// from says where values came from, for errors.
+func setDefaults(values map[string]string, from string) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for name, val := range values {
+		if set[name] {
+			continue
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("%s has unknown option %s", from, name)
+		}
+		if e := flag.Set(name, val); e != nil {
+			return fmt.Errorf("%s option %s: %v", from, name, e)
+		}
+	}
+	return nil
+}
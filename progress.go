@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// progressMeter prints the rows loaded by a run, across its files, every so often, for -progress
+type progressMeter struct {
+	every time.Duration
+	mu    sync.Mutex
+	rows  int
+	last  time.Time
+}
+
+// newProgressMeter returns a meter that prints every d, nil if d isn't positive
+func newProgressMeter(d time.Duration) *progressMeter {
+	if d <= 0 {
+		return nil
+	}
+	return &progressMeter{every: d, last: time.Now()}
+}
+
+// add adds n rows loaded, printing the total if it's time.  A nil meter does nothing.
+func (pm *progressMeter) add(n int) {
+	if pm == nil {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.rows += n
+	if time.Since(pm.last) >= pm.every {
+		pm.last = time.Now()
+		fmt.Printf("progress: %d rows\n", pm.rows)
+	}
+}
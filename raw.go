@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// rawReader is a chutils.Input that returns each line of the source as a row without parsing it.  The
+// fields are the line number (starting at 1), the source and the line.
+type rawReader struct {
+	j    *job
+	rc   io.ReadCloser
+	sc   *bufio.Scanner
+	line int
+	spec *chutils.TableDef
+}
+
+// rawSpec is the table spec of a raw load
+func rawSpec() *chutils.TableDef {
+	fds := map[int]*chutils.FieldDef{
+		0: {Name: "rowNumber", ChSpec: chutils.ChField{Base: chutils.ChInt, Length: 64}, Legal: &chutils.LegalValues{}},
+		1: {Name: "source", ChSpec: chutils.ChField{Base: chutils.ChString}, Legal: &chutils.LegalValues{}, Missing: "!"},
+		2: {Name: "line", ChSpec: chutils.ChField{Base: chutils.ChString}, Legal: &chutils.LegalValues{}, Missing: "!"},
+	}
+	return chutils.NewTableDef("rowNumber", chutils.MergeTree, fds)
+}
+
+// newRaw creates a raw reader of j.source, creating the table if spec is nil.  Derived fields are Strings.
+func newRaw(j *job, spec *chutils.TableDef, con *chutils.Connect) (chutils.Input, error) {
+	r := &rawReader{j: j, spec: rawSpec()}
+	if err := r.Reset(); err != nil {
+		return nil, err
+	}
+	var in chutils.Input = r
+	if j.pipelined() {
+		p, err := newPipeline(r, j, spec)
+		if err != nil {
+			return nil, err
+		}
+		for _, fd := range p.spec.FieldDefs {
+			if fd.ChSpec.Base == chutils.ChUnknown {
+				fd.ChSpec.Base, fd.Missing = chutils.ChString, "!"
+			}
+		}
+		in = p
+	}
+	if spec != nil {
+		return in, nil
+	}
+	if err := j.create(in.TableSpec(), con); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// open opens the source: the body of an archive member, an S3 object, a web address or a file
+func (r *rawReader) open() (io.ReadCloser, error) {
+	switch {
+	case r.j.body != nil:
+		return io.NopCloser(bytes.NewReader(r.j.body)), nil
+	case isS3(r.j.source):
+		return r.j.s3.open(r.j.source)
+	case strings.Contains(strings.ToLower(r.j.source), "http"):
+		resp, err := r.j.web.get(r.j.source)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	default:
+		return os.Open(r.j.source)
+	}
+}
+
+// TableSpec returns the table spec
+func (r *rawReader) TableSpec() *chutils.TableDef {
+	return r.spec
+}
+
+// Read reads nTarget lines (all of them, if nTarget is 0)
+func (r *rawReader) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	for nTarget <= 0 || len(data) < nTarget {
+		if !r.sc.Scan() {
+			if e := r.sc.Err(); e != nil {
+				return data, valid, e
+			}
+			if len(data) == 0 {
+				return nil, nil, io.EOF
+			}
+			return data, valid, nil
+		}
+		r.line++
+		row := chutils.Row{int64(r.line), r.j.source, strings.TrimSuffix(r.sc.Text(), "\r")}
+		data, valid = append(data, row), append(valid, chutils.Valid{chutils.VPass, chutils.VPass, chutils.VPass})
+	}
+	return data, valid, nil
+}
+
+// Reset starts reading from the beginning of the source
+func (r *rawReader) Reset() error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+	rc, err := r.open()
+	if err != nil {
+		return err
+	}
+	r.rc, r.line = rc, 0
+	r.sc = bufio.NewScanner(rc)
+	// lines may be long
+	r.sc.Buffer(make([]byte, 64*1024), 1024*1024*1024)
+	return nil
+}
+
+// CountLines returns the number of lines in the source
+func (r *rawReader) CountLines() (int, error) {
+	if err := r.Reset(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for r.sc.Scan() {
+		n++
+	}
+	if err := r.sc.Err(); err != nil {
+		return 0, err
+	}
+	return n, r.Reset()
+}
+
+// Seek moves to line lineNo, so the next line read is lineNo+1
+func (r *rawReader) Seek(lineNo int) error {
+	if err := r.Reset(); err != nil {
+		return err
+	}
+	for r.line < lineNo && r.sc.Scan() {
+		r.line++
+	}
+	return r.sc.Err()
+}
+
+// Close closes the source
+func (r *rawReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
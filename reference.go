@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// missActions are the values of -on-miss
+var missActions = []string{"fail", "warn", "drop", "quarantine"}
+
+// reference is an item of -assert-in: the values of field must be in column of the ClickHouse table
+type reference struct {
+	field  string
+	table  string
+	column string
+}
+
+// referenceRe matches field IN table.column, where the table may have a database
+var referenceRe = regexp.MustCompile(`(?i)^\s*(\S+)\s+in\s+(\S+)\.([^.\s]+)\s*$`)
+
+// tableRe matches the table of a reference: a name or db.name, as ClickHouse takes them unquoted
+var tableRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// identEscaper escapes a name for use between back-quotes
+var identEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+
+// parseReferences parses -assert-in: items field IN table.column separated by ;
+func parseReferences(list string) ([]reference, error) {
+	refs := make([]reference, 0)
+	for _, item := range strings.Split(list, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		m := referenceRe.FindStringSubmatch(item)
+		if m == nil {
+			return nil, fmt.Errorf("-assert-in: expected field IN table.column, got %s", item)
+		}
+		if !tableRe.MatchString(m[2]) {
+			return nil, fmt.Errorf("-assert-in: %s is not a table name", m[2])
+		}
+		refs = append(refs, reference{field: strings.Trim(m[1], "`"), table: m[2], column: strings.Trim(m[3], "`")})
+	}
+	return refs, nil
+}
+
+// String returns the reference as it is given to -assert-in
+func (r reference) String() string {
+	return fmt.Sprintf("%s IN %s.%s", r.field, r.table, r.column)
+}
+
+// quotedTable returns the table with each part back-quoted
+func (r reference) quotedTable() string {
+	parts := strings.Split(r.table, ".")
+	for ind, part := range parts {
+		parts[ind] = quoteIdent(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteIdent back-quotes name, escaping the back-quotes and backslashes in it
+func quoteIdent(name string) string {
+	return "`" + identEscaper.Replace(name) + "`"
+}
+
+// rule reads the values of the column from ClickHouse and returns the rule that the field is one of them, with
+// the -on-miss action
+func (r reference) rule(action string, con *chutils.Connect) (rule, error) {
+	rows, err := con.Query(fmt.Sprintf("SELECT DISTINCT toString(%s) FROM %s", quoteIdent(r.column), r.quotedTable()))
+	if err != nil {
+		return rule{}, fmt.Errorf("-assert-in %s: %v", r, err)
+	}
+	defer func() { _ = rows.Close() }()
+	keys := make(map[string]struct{})
+	for rows.Next() {
+		var v string
+		if e := rows.Scan(&v); e != nil {
+			return rule{}, fmt.Errorf("-assert-in %s: %v", r, e)
+		}
+		// FixedStrings are padded with zero bytes
+		keys[strings.TrimRight(v, "\x00")] = struct{}{}
+	}
+	if e := rows.Err(); e != nil {
+		return rule{}, fmt.Errorf("-assert-in %s: %v", r, e)
+	}
+	fmt.Printf("%s: %d values\n", r, len(keys))
+	return rule{flag: "-assert-in", name: r.String(), x: &inSet{x: &field{r.field}, keys: keys}, action: action}, nil
+}
+
+// inSet is true if the value of x is one of keys
+type inSet struct {
+	x    expr
+	keys map[string]struct{}
+}
+
+// eval returns true if the value of x, as ClickHouse's toString would give it, is a key.  NULL is in no set.
+func (s *inSet) eval(e *env) (interface{}, error) {
+	v, err := s.x.eval(e)
+	if err != nil || v == nil {
+		return false, err
+	}
+	_, ok := s.keys[chString(v)]
+	return ok, nil
+}
+
+// chString returns v as ClickHouse's toString gives a value of its type
+func chString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32)
+	case time.Time:
+		if x.Hour() == 0 && x.Minute() == 0 && x.Second() == 0 && x.Nanosecond() == 0 {
+			return x.Format("2006-01-02")
+		}
+		return x.Format("2006-01-02 15:04:05")
+	default:
+		return fmt.Sprint(x)
+	}
+}
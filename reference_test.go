@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestParseReferences(t *testing.T) {
+	refs, err := parseReferences("msa IN dim.msa_code; st in geo.states.`st`")
+	if err != nil || len(refs) != 2 {
+		t.Fatalf("parseReferences: %v %v", refs, err)
+	}
+	if refs[1].table != "geo.states" || refs[1].column != "st" {
+		t.Errorf("got table %s column %s", refs[1].table, refs[1].column)
+	}
+	if got := refs[1].quotedTable(); got != "`geo`.`states`" {
+		t.Errorf("quotedTable = %s", got)
+	}
+	// the table goes into the query, so anything but a name is refused
+	for _, list := range []string{
+		"x IN (SELECT 1).c",
+		"x IN t;DROP.c",
+		"x IN a.b.c.d",
+		"x IN 1t.c",
+	} {
+		if _, e := parseReferences(list); e == nil {
+			t.Errorf("parseReferences(%s): no error", list)
+		}
+	}
+	if got := quoteIdent("a`) FROM t --\\"); got != "`a\\`) FROM t --\\\\`" {
+		t.Errorf("quoteIdent = %s", got)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/invertedv/chutils/file"
+)
+
+// region is the part of a source file that is loaded, given by -start-byte, -start-line and -end-line.  The
+// line numbers are those of the whole file, starting at 1.  Zero means not given.
+type region struct {
+	startByte int64
+	startLine int
+	endLine   int
+}
+
+// newRegion returns the region, nil if the whole file is loaded
+func newRegion(startByte int64, startLine, endLine int) (*region, error) {
+	switch {
+	case startByte == 0 && startLine == 0 && endLine == 0:
+		return nil, nil
+	case startByte < 0 || startLine < 0 || endLine < 0:
+		return nil, fmt.Errorf("-start-byte, -start-line and -end-line can't be negative")
+	case startByte > 0 && startLine > 0:
+		return nil, fmt.Errorf("-start-byte and -start-line cannot both be given")
+	case startByte > 0 && endLine > 0:
+		return nil, fmt.Errorf("-end-line needs line numbers, so it can't be used with -start-byte")
+	case endLine > 0 && startLine > endLine:
+		return nil, fmt.Errorf("-start-line %d is after -end-line %d", startLine, endLine)
+	}
+	return &region{startByte: startByte, startLine: startLine, endLine: endLine}, nil
+}
+
+// open returns a reader of the region of the text or csv file source.  The reader skips the first skip lines
+// of the file (the header and -skip), so they are kept, followed by the lines of the region.  The file before
+// -start-byte isn't read.
+func (rg *region) open(source, sType, delim string, quote rune, skip int) (*file.Reader, error) {
+	if sType != "text" && sType != "csv" {
+		return nil, fmt.Errorf("-start-byte, -start-line and -end-line need -type text or csv")
+	}
+	if multiDelim(delim) {
+		return nil, fmt.Errorf("-start-byte, -start-line and -end-line need a -sep of one character")
+	}
+	if isS3(source) || strings.Contains(strings.ToLower(source), "http") {
+		return nil, fmt.Errorf("-start-byte, -start-line and -end-line need a local file")
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	fail := func(e error) (*file.Reader, error) {
+		_ = f.Close()
+		return nil, e
+	}
+
+	// the lines the reader skips
+	br := bufio.NewReader(f)
+	var head bytes.Buffer
+	for ind := 0; ind < skip; ind++ {
+		line, e := br.ReadBytes('\n')
+		if e != nil && e != io.EOF {
+			return fail(e)
+		}
+		head.Write(line)
+	}
+	lineNo := skip + 1
+	if rg.startLine > 0 && rg.startLine < lineNo {
+		return fail(fmt.Errorf("-start-line %d is in the header or -skip lines", rg.startLine))
+	}
+
+	// start of the region
+	start := int64(head.Len())
+	if rg.startByte > start {
+		// the region starts with the first line that starts at or after startByte
+		if _, e := f.Seek(rg.startByte-1, io.SeekStart); e != nil {
+			return fail(e)
+		}
+		br.Reset(f)
+		prev, e := br.ReadBytes('\n')
+		if e != nil && e != io.EOF {
+			return fail(e)
+		}
+		start = rg.startByte - 1 + int64(len(prev))
+	}
+	for ; lineNo < rg.startLine; lineNo++ {
+		line, e := br.ReadBytes('\n')
+		if e == io.EOF {
+			return fail(fmt.Errorf("%s has fewer than %d lines", source, rg.startLine))
+		}
+		if e != nil {
+			return fail(e)
+		}
+		start += int64(len(line))
+	}
+
+	// end of the region
+	end := info.Size()
+	if rg.endLine > 0 {
+		end = start
+		for ; lineNo <= rg.endLine; lineNo++ {
+			line, e := br.ReadBytes('\n')
+			end += int64(len(line))
+			if e == io.EOF {
+				break
+			}
+			if e != nil {
+				return fail(e)
+			}
+		}
+	}
+	if start > info.Size() {
+		start = info.Size()
+	}
+	fmt.Printf("%s: loading bytes %d to %d\n", source, start, end)
+
+	hb := head.Bytes()
+	sr := io.NewSectionReader(&spliced{head: hb, f: f, off: start}, 0, int64(len(hb))+end-start)
+	return file.NewReader(source, sep(sType, delim), '\n', quote, 0, skip, 0, &regionFile{SectionReader: sr, f: f}, 0), nil
+}
+
+// spliced is an io.ReaderAt of head followed by the file f from off
+type spliced struct {
+	head []byte
+	f    *os.File
+	off  int64
+}
+
+// ReadAt reads len(p) bytes from offset o
+func (s *spliced) ReadAt(p []byte, o int64) (int, error) {
+	n := 0
+	if o < int64(len(s.head)) {
+		n = copy(p, s.head[o:])
+		if n == len(p) {
+			return n, nil
+		}
+	}
+	m, err := s.f.ReadAt(p[n:], s.off+o+int64(n)-int64(len(s.head)))
+	return n + m, err
+}
+
+// regionFile is the io.ReadSeekCloser of a region
+type regionFile struct {
+	*io.SectionReader
+	f *os.File
+}
+
+// Close closes the file
+func (r *regionFile) Close() error {
+	return r.f.Close()
+}
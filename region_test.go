@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/invertedv/chutils"
+)
+
+// regionRows returns the first field of the rows of the region of source, which has a header row
+func regionRows(t *testing.T, rg *region, source string) string {
+	t.Helper()
+	rdr, err := rg.open(source, "csv", "", '"', 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rdr.Close() }()
+	if e := rdr.Init("", chutils.MergeTree); e != nil {
+		t.Fatal(e)
+	}
+	rows, _, err := rdr.Read(0, false)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(rows))
+	for ind, row := range rows {
+		ids[ind] = row[0].(string)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestRegion(t *testing.T) {
+	// the header is line 1 (bytes 0-2), r1 is line 2 (bytes 3-5), r2 line 3 (6-8) and so on
+	source := filepath.Join(t.TempDir(), "r.csv")
+	if err := os.WriteFile(source, []byte("id\nr1\nr2\nr3\nr4\nr5"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		startByte      int64
+		startLine, end int
+		want           string
+	}{
+		{startLine: 3, want: "r2 r3 r4 r5"},
+		{startLine: 3, end: 4, want: "r2 r3"},
+		{end: 2, want: "r1"},
+		{startLine: 6, end: 9, want: "r5"},
+		// a byte part way through a line starts the region at the next line
+		{startByte: 6, want: "r2 r3 r4 r5"},
+		{startByte: 7, want: "r3 r4 r5"},
+		{startByte: 1, want: "r1 r2 r3 r4 r5"},
+	} {
+		rg, err := newRegion(tt.startByte, tt.startLine, tt.end)
+		if err != nil {
+			t.Errorf("newRegion(%d, %d, %d): %v", tt.startByte, tt.startLine, tt.end, err)
+			continue
+		}
+		if got := regionRows(t, rg, source); got != tt.want {
+			t.Errorf("region %+v = %s, want %s", *rg, got, tt.want)
+		}
+	}
+
+	if _, err := (&region{startLine: 1}).open(source, "csv", "", '"', 1); err == nil {
+		t.Errorf("-start-line in the header: no error")
+	}
+	if _, err := (&region{startLine: 10}).open(source, "csv", "", '"', 1); err == nil {
+		t.Errorf("-start-line past the end: no error")
+	}
+	if _, err := (&region{startLine: 2}).open(source, "csv", "||", '"', 1); err == nil {
+		t.Errorf("-sep ||: no error")
+	}
+}
+
+func TestNewRegion(t *testing.T) {
+	if rg, err := newRegion(0, 0, 0); rg != nil || err != nil {
+		t.Errorf("no region = %v, %v", rg, err)
+	}
+	for _, args := range [][3]int{{-1, 0, 0}, {10, 2, 0}, {10, 0, 5}, {0, 6, 5}} {
+		if _, err := newRegion(int64(args[0]), args[1], args[2]); err == nil {
+			t.Errorf("newRegion%v: no error", args)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	mrand "math/rand"
+	"time"
+)
+
+// repro holds the choices of a -repro run that would otherwise vary from run to run.  They are recorded in
+// the summary, so a run can be repeated with -seed and -repro-time.
+type repro struct {
+	Seed int64     `json:"seed"` // seed of the random choices (sampling)
+	Now  time.Time `json:"now"`  // the time now() and {now} give
+}
+
+// newRepro returns the choices of a -repro run.  If seed is 0, one is chosen.  If at is "", the time is start.
+func newRepro(seed int64, at string, start time.Time) (*repro, error) {
+	r := &repro{Seed: seed, Now: start}
+	if at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return nil, fmt.Errorf("-repro-time is an RFC 3339 time, e.g. 2024-03-01T09:00:00Z")
+		}
+		r.Now = t
+	}
+	if r.Seed == 0 {
+		n, err := crand.Int(crand.Reader, big.NewInt(math.MaxInt64))
+		if err != nil {
+			return nil, err
+		}
+		r.Seed = n.Int64() + 1
+	}
+	fmt.Printf("repro: -seed %d -repro-time %s\n", r.Seed, r.Now.Format(time.RFC3339))
+	return r, nil
+}
+
+// rand returns a source of random numbers seeded with the run's seed
+func (r *repro) rand() *mrand.Rand {
+	return mrand.New(mrand.NewSource(r.Seed))
+}
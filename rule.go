@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/invertedv/chutils"
+)
+
+// ruleActions are the values of -rule-action
+var ruleActions = []string{"fail", "drop", "quarantine"}
+
+// rule is a -rule or -assert-in: a row is good if x is true
+type rule struct {
+	flag   string // the option the rule comes from
+	name   string
+	x      expr
+	action string // what is done with a row failing the rule, "" for the action of the rules
+}
+
+// rules are the -rule checks of a job and what is done with the rows that fail them
+type rules struct {
+	checks     []rule
+	action     string // fail, drop or quarantine
+	quarantine string // file the rows are written to with -rule-action quarantine
+}
+
+// ruleNameRe matches the name of a rule, name: expr
+var ruleNameRe = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*:(.+)$`)
+
+// parseRules parses -rule: expressions separated by ;, each optionally named name: expr.  A rule without a name
+// is named by its expression.
+func parseRules(list string) ([]rule, error) {
+	rs := make([]rule, 0)
+	for _, r := range splitOutside(list, ';') {
+		if strings.TrimSpace(r) == "" {
+			continue
+		}
+		name, text := strings.TrimSpace(r), r
+		if m := ruleNameRe.FindStringSubmatch(r); m != nil {
+			name, text = m[1], m[2]
+		}
+		x, err := parseExpr(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		rs = append(rs, rule{flag: "-rule", name: name, x: x})
+	}
+	return rs, nil
+}
+
+// quarantineMu serializes the writes to quarantine files by the readers of a multi-file load
+var quarantineMu sync.Mutex
+
+// ruleCheck checks the rows of a pipeline against the rules
+type ruleCheck struct {
+	*rules
+	source  string
+	rows    int            // rows checked in this pass over the data
+	failed  map[string]int // rows failing each rule in this pass
+	dropped int            // rows left out in this pass
+	held    [][]string     // rows of this pass to quarantine
+	last    string         // the rule the last row left out failed
+}
+
+// newRuleCheck returns the check of the rows of source for rs
+func newRuleCheck(rs *rules, source string) *ruleCheck {
+	return &ruleCheck{rules: rs, source: source, failed: make(map[string]int)}
+}
+
+// check returns false if the converted row fails a rule and so isn't loaded.  raw is the row before conversion,
+// which is quarantined.  The first rule a row fails, other than those that only warn, is the one reported.  With
+// the action fail, a failure is an error.
+func (rc *ruleCheck) check(e *env, row, raw []interface{}, spec *chutils.TableDef) (bool, error) {
+	rc.rows++
+	for _, r := range rc.checks {
+		v, err := r.x.eval(e)
+		if err != nil {
+			return false, fmt.Errorf("%s %s, row %d: %v", r.flag, r.name, rc.rows, err)
+		}
+		ok, err := toBool(v)
+		if err != nil {
+			return false, fmt.Errorf("%s %s, row %d: %v", r.flag, r.name, rc.rows, err)
+		}
+		if ok {
+			continue
+		}
+		rc.failed[r.flag+" "+r.name]++
+		action := r.action
+		if action == "" {
+			action = rc.action
+		}
+		switch action {
+		case "fail":
+			return false, fmt.Errorf("row %d fails %s %s", rc.rows, r.flag, r.name)
+		case "warn":
+			continue
+		case "quarantine":
+			rec := []string{r.name, rc.source}
+			for ind := range spec.FieldDefs {
+				rec = append(rec, toStr(e, raw[ind]))
+			}
+			rc.held = append(rc.held, rec)
+		}
+		rc.dropped, rc.last = rc.dropped+1, r.flag+" "+r.name
+		return false, nil
+	}
+	return true, nil
+}
+
+// reset starts a new pass over the data
+func (rc *ruleCheck) reset() {
+	rc.rows, rc.failed, rc.dropped, rc.held = 0, make(map[string]int), 0, nil
+}
+
+// finish reports the rows that failed the rules in the last pass and writes those quarantined to the
+// quarantine file, with the rule, the source and the fields of spec.  A new file gets a header row.
+func (rc *ruleCheck) finish(spec *chutils.TableDef) error {
+	if len(rc.failed) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(rc.failed))
+	for name := range rc.failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s: %d rows fail %s\n", rc.source, rc.failed[name], name)
+	}
+	if n := rc.dropped - len(rc.held); n > 0 {
+		fmt.Printf("%s: %d rows dropped\n", rc.source, n)
+	}
+	if len(rc.held) == 0 {
+		return nil
+	}
+
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+	f, err := os.OpenFile(rc.quarantine, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	w := csv.NewWriter(f)
+	if info, e := f.Stat(); e == nil && info.Size() == 0 {
+		header := []string{"_rule", "_source"}
+		for _, fd := range spec.FieldDefs {
+			header = append(header, fd.Name)
+		}
+		if e := w.Write(header); e != nil {
+			return e
+		}
+	}
+	if e := w.WriteAll(rc.held); e != nil {
+		return e
+	}
+	fmt.Printf("%s: %d rows quarantined to %s\n", rc.source, len(rc.held), rc.quarantine)
+	return nil
+}
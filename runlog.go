@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// runSummary describes a run of toch.  It is saved as JSON with -summary.
+type runSummary struct {
+	RunID   string        `json:"runId"`
+	Table   string        `json:"table"`
+	Start   time.Time     `json:"start"`
+	End     time.Time     `json:"end"`
+	Rows    int           `json:"rows"`
+	Status  string        `json:"status"`
+	Error   string        `json:"error,omitempty"`
+	Sources []fileSummary `json:"sources"`
+	Server  *serverStats  `json:"server,omitempty"`
+	Repro   *repro        `json:"repro,omitempty"`
+}
+
+// serverStats are the server-side metrics of the inserts of a run, from system.query_log
+type serverStats struct {
+	Inserts      uint64 `json:"inserts"`
+	WrittenRows  uint64 `json:"writtenRows"`
+	WrittenBytes uint64 `json:"writtenBytes"`
+	Parts        uint64 `json:"partsCreated"`
+	PeakMemory   int64  `json:"peakMemoryBytes"`
+	Millis       uint64 `json:"queryMilliseconds"`
+}
+
+// fileSummary describes the load of one source
+type fileSummary struct {
+	Source  string  `json:"source"`
+	Rows    int     `json:"rows"`
+	Seconds float64 `json:"seconds"`
+	Status  string  `json:"status"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// newSummary summarizes the run of j whose sources had results and whose overall error is err.
+func newSummary(j *job, results []fileResult, err error) *runSummary {
+	rs := &runSummary{RunID: j.runID, Table: j.table, Start: j.start, End: time.Now(), Status: status(err),
+		Sources: make([]fileSummary, 0, len(results)), Repro: j.repro}
+	if err != nil {
+		rs.Error = err.Error()
+	}
+	for _, r := range results {
+		fs := fileSummary{Source: r.source, Rows: r.rows, Seconds: r.secs, Status: status(r.err)}
+		if r.err != nil {
+			fs.Error = r.err.Error()
+		}
+		rs.Rows += r.rows
+		rs.Sources = append(rs.Sources, fs)
+	}
+	return rs
+}
+
+// status returns "ok" if err is nil and "failed" otherwise
+func status(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "ok"
+}
+
+// save writes the summary to fileName as JSON
+func (rs *runSummary) save(fileName string) error {
+	b, err := json.MarshalIndent(rs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, b, 0644)
+}
+
+// serverStats fetches the metrics of the inserts of the run from system.query_log, where the queries of the
+// run are identified by their log_comment.  The query log is flushed first so the run's queries are there.
+func (rs *runSummary) serverStats(con *chutils.Connect) error {
+	// needs the SYSTEM FLUSH LOGS privilege; without it, the most recent inserts may be missing
+	if _, err := con.Exec("SYSTEM FLUSH LOGS"); err != nil {
+		fmt.Println("warning: server metrics may be incomplete:", err)
+	}
+
+	qry := `SELECT
+  count(),
+  sum(written_rows),
+  sum(written_bytes),
+  sum(ProfileEvents['MergeTreeDataWriterBlocks']),
+  max(memory_usage),
+  sum(query_duration_ms)
+FROM system.query_log
+WHERE type = 'QueryFinish' AND query_kind = 'Insert' AND log_comment = ? AND event_date >= toDate(?)`
+	ss := &serverStats{}
+	if err := con.QueryRow(qry, "toch run "+rs.RunID, rs.Start).Scan(&ss.Inserts, &ss.WrittenRows, &ss.WrittenBytes,
+		&ss.Parts, &ss.PeakMemory, &ss.Millis); err != nil {
+		return err
+	}
+	rs.Server = ss
+	fmt.Printf("server: %d inserts wrote %d rows, %d bytes in %d parts; peak memory %d bytes; %0.1f seconds\n",
+		ss.Inserts, ss.WrittenRows, ss.WrittenBytes, ss.Parts, ss.PeakMemory, float64(ss.Millis)/1000)
+	return nil
+}
+
+// audit adds a row for each source of the run to the ClickHouse table, creating it if necessary.
+func (rs *runSummary) audit(table string, con *chutils.Connect) error {
+	qry := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  runId String,
+  start DateTime,
+  end DateTime,
+  target String,
+  source String,
+  rows UInt64,
+  seconds Float64,
+  status String,
+  error String
+) ENGINE=MergeTree() ORDER BY (start, runId)`, table)
+	if _, err := con.Exec(qry); err != nil {
+		return err
+	}
+	for _, fs := range rs.Sources {
+		if _, err := con.Exec(fmt.Sprintf("INSERT INTO %s VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", table),
+			rs.RunID, rs.Start, rs.End, rs.Table, fs.Source, fs.Rows, fs.Seconds, fs.Status, fs.Error); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// s3Cmd copies objects from S3.  toch doesn't include an S3 client: the AWS command line client must be installed
+// and on the PATH.  It resolves the credentials as usual: the environment, the shared config and credentials files,
+// then the instance role.
+var s3Cmd = []string{"aws", "s3", "cp"}
+
+// checkS3Client returns an error if the AWS command line client isn't installed
+func checkS3Client() error {
+	if _, err := exec.LookPath(s3Cmd[0]); err != nil {
+		return fmt.Errorf("S3 sources are copied with the AWS command line client (%s), which isn't installed: %v",
+			strings.Join(s3Cmd, " "), err)
+	}
+	return nil
+}
+
+// isS3 determines whether source is an S3 object, s3://bucket/key
+func isS3(source string) bool {
+	return strings.HasPrefix(strings.ToLower(source), "s3://")
+}
+
+// s3Opts are the options of S3 sources
+type s3Opts struct {
+	region  string // AWS region of the bucket, "" for the client's default
+	profile string // profile of the shared config, "" for the client's default
+}
+
+// command returns the command that copies the object source to dest, "-" for stdout
+func (o *s3Opts) command(source, dest string) *exec.Cmd {
+	args := append(append([]string{}, s3Cmd[1:]...), source, dest, "--only-show-errors")
+	if o.region != "" {
+		args = append(args, "--region", o.region)
+	}
+	if o.profile != "" {
+		args = append(args, "--profile", o.profile)
+	}
+	return exec.Command(s3Cmd[0], args...)
+}
+
+// fetch copies the object source to a temporary file, with the extension of its key, and returns its name.
+// The caller removes the file.
+func (o *s3Opts) fetch(source string) (string, error) {
+	if e := checkS3Client(); e != nil {
+		return "", e
+	}
+	tmp, err := os.CreateTemp("", "toch-*"+path.Ext(source))
+	if err != nil {
+		return "", err
+	}
+	if e := tmp.Close(); e != nil {
+		return "", e
+	}
+	if _, e := runCmd(o.command(source, tmp.Name())); e != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("%s: %v", source, e)
+	}
+	return tmp.Name(), nil
+}
+
+// open returns a stream of the object source
+func (o *s3Opts) open(source string) (io.ReadCloser, error) {
+	if e := checkS3Client(); e != nil {
+		return nil, e
+	}
+	c := o.command(source, "-")
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	s := &s3Stream{ReadCloser: stdout, c: c, source: source}
+	c.Stderr = &s.stderr
+	if e := c.Start(); e != nil {
+		return nil, fmt.Errorf("%s: %v", source, e)
+	}
+	return s, nil
+}
+
+// s3Stream is the output of the copy of an S3 object to stdout
+type s3Stream struct {
+	io.ReadCloser
+	c      *exec.Cmd
+	source string
+	stderr bytes.Buffer
+}
+
+// Read reads the object, returning the client's error, if it failed, at the end
+func (s *s3Stream) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if err == io.EOF && s.c != nil {
+		e := s.c.Wait()
+		s.c = nil
+		if e != nil {
+			return n, fmt.Errorf("%s: %v: %s", s.source, e, s.stderr.String())
+		}
+	}
+	return n, err
+}
+
+// Close stops the copy if it hasn't finished
+func (s *s3Stream) Close() error {
+	if s.c != nil {
+		_ = s.c.Process.Kill()
+		_ = s.c.Wait()
+		s.c = nil
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// checkTable verifies that the table may be written to.  The table must match one of the allow patterns (if
+// there are any) and none of the deny patterns.
+func checkTable(table string, allow, deny []string) error {
+	for _, pattern := range deny {
+		if ok, _ := path.Match(pattern, table); ok {
+			return fmt.Errorf("table %s matches -deny-tables pattern %s", table, pattern)
+		}
+	}
+	if len(allow) == 0 {
+		return nil
+	}
+	for _, pattern := range allow {
+		if ok, _ := path.Match(pattern, table); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("table %s does not match any -allow-tables pattern", table)
+}
+
+// allowed values of -exists
+var existsModes = []string{"drop", "fail", "append", "replace"}
+
+// tableInfo returns whether table exists and, if so, its number of rows and when it was created or last altered.
+// ClickHouse doesn't keep the creation time of a table: modified is the time its metadata was last written,
+// which is when it was created unless it has been altered (or renamed) since.
+func tableInfo(table string, con *chutils.Connect) (exists bool, rows uint64, modified time.Time, err error) {
+	db, name := splitTable(table)
+	qry := "SELECT metadata_modification_time FROM system.tables WHERE database = if(? = '', currentDatabase(), ?) AND name = ?"
+	if err = con.QueryRow(qry, db, db, name).Scan(&modified); err == sql.ErrNoRows {
+		return false, 0, modified, nil
+	}
+	if err != nil {
+		return false, 0, modified, err
+	}
+	if err = con.QueryRow(fmt.Sprintf("SELECT count() FROM %s", table)).Scan(&rows); err != nil {
+		return true, 0, modified, err
+	}
+	return true, rows, modified, nil
+}
+
+// splitTable splits a table name of the form db.table.  The database is "" if table has none.
+func splitTable(table string) (db, name string) {
+	if ind := strings.Index(table, "."); ind > 0 {
+		return table[:ind], table[ind+1:]
+	}
+	return "", table
+}
+
+// qualifyTable returns table in the database db, unless it names its own or is ""
+func qualifyTable(table, db string) string {
+	if table == "" || strings.Contains(table, ".") {
+		return table
+	}
+	return db + "." + table
+}
+
+// checkDatabases makes sure the databases of tables exist.  With create, those that don't are created.
+func checkDatabases(tables []string, create bool, con *chutils.Connect) error {
+	done := make(map[string]bool)
+	for _, table := range tables {
+		db, _ := splitTable(table)
+		if db == "" || done[db] {
+			continue
+		}
+		done[db] = true
+		if create {
+			if _, err := con.Exec(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", db)); err != nil {
+				return err
+			}
+			continue
+		}
+		var n uint64
+		if err := con.QueryRow("SELECT count() FROM system.databases WHERE name = ?", db).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("database %s of table %s doesn't exist; -create-db Y creates it", db, table)
+		}
+	}
+	return nil
+}
+
+// checkExists applies the -exists mode to the table, if it already exists.
+// With "fail", an error is returned.  With "append", the rows are added to the table.  With "drop", the table is dropped when the load creates it, so the user
+// is asked to confirm if toch is run interactively, unless yes is true.  With "replace", the table is replaced only
+// once the load succeeds (see replaceTable).
+func checkExists(table, mode string, yes bool, con *chutils.Connect) error {
+	exists, rows, modified, err := tableInfo(table, con)
+	if err != nil || !exists {
+		return err
+	}
+
+	switch mode {
+	case "fail":
+		return fmt.Errorf("table %s already exists", table)
+	case "drop":
+		if yes || !isTTY() {
+			return nil
+		}
+		fmt.Printf("table %s exists with %d rows (created or last altered %s). Drop it? [y/N] ", table, rows,
+			modified.Format("2006-01-02 15:04:05"))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return fmt.Errorf("table %s not dropped", table)
+		}
+	}
+	return nil
+}
+
+// isTTY returns true if stdin is a terminal
+func isTTY() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// stagingName returns the name of the table that run runID loads before it replaces table
+func stagingName(table, runID string) string {
+	return fmt.Sprintf("%s_toch_new_%s", table, strings.ReplaceAll(runID, "-", "")[:12])
+}
+
+// replaceTable puts the table staging, which has been loaded, in place of table.  The old table is dropped or,
+// if keep > 0, kept as the backup of the run runID (see backup).  The two tables are renamed in one statement,
+// so table is always there.
+func replaceTable(staging, table, runID string, keep time.Duration, log string, con *chutils.Connect) error {
+	if keep > 0 {
+		if err := backup(table, runID, keep, false, log, con); err != nil {
+			return err
+		}
+	}
+	exists, _, _, err := tableInfo(table, con)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err = con.Exec(fmt.Sprintf("RENAME TABLE %s TO %s", staging, table))
+		return err
+	}
+	old := strings.Replace(staging, "_toch_new_", "_toch_old_", 1)
+	if _, e := con.Exec(fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s", table, old, staging, table)); e != nil {
+		return e
+	}
+	fmt.Printf("table %s replaced\n", table)
+	_, err = con.Exec(fmt.Sprintf("DROP TABLE %s", old))
+	return err
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestQualifyTable(t *testing.T) {
+	for _, tt := range []struct{ table, db, want string }{
+		{"t", "default", "default.t"},
+		{"t", "stage", "stage.t"},
+		{"prod.t", "stage", "prod.t"},
+		{"", "stage", ""},
+	} {
+		got := qualifyTable(tt.table, tt.db)
+		if got != tt.want {
+			t.Errorf("qualifyTable(%q, %q) = %q, want %q", tt.table, tt.db, got, tt.want)
+		}
+		if db, name := splitTable(got); got != "" && db+"."+name != got {
+			t.Errorf("splitTable(%q) = %q, %q", got, db, name)
+		}
+	}
+}
+
+// TestCheckTable checks the -allow-tables and -deny-tables patterns, which are qualified with -db as the
+// tables are
+func TestCheckTable(t *testing.T) {
+	allow, deny := []string{qualifyTable("stg_*", "default"), "scratch.*"}, []string{qualifyTable("stg_keep", "default")}
+	for table, ok := range map[string]bool{
+		"default.stg_a":    true,
+		"default.stg_keep": false,
+		"other.stg_a":      false,
+		"scratch.x":        true,
+		"default.x":        false,
+	} {
+		if err := checkTable(table, allow, deny); (err == nil) != ok {
+			t.Errorf("checkTable(%s): %v, want allowed %v", table, err, ok)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+)
+
+// sampleOpts are the options of "toch sample"
+type sampleOpts struct {
+	n    int      // rows in the sample
+	hash []string // fields whose values are replaced by a hash
+	out  string   // file the sample is written to, stdout if ""
+}
+
+// sample writes a random sample of j.source to a CSV file with a header row.  The source is read just as it is
+// for a load (-type, -h, -t, -derive, -where...) and the values are written as they would be loaded.  The values
+// of the hash fields are replaced by a salted SHA-256 hash: a value has the same hash throughout the sample, so
+// the field can still be joined on and counted, but the salt is random so the values can't be recovered by
+// hashing candidates.
+func sample(j *job, args []string, opts *sampleOpts) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: toch sample -s <source> [-n rows] [-hash 'f1,...'] [-out file] (and the options of a load)")
+	}
+	if opts.n < 1 {
+		return fmt.Errorf("-n must be positive")
+	}
+	in, err := openReader(j, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	spec := in.TableSpec()
+
+	hashed := make(map[int]bool)
+	for _, name := range opts.hash {
+		ind, _, e := spec.Get(name)
+		if e != nil {
+			return fmt.Errorf("-hash: %s is not a field", name)
+		}
+		hashed[ind] = true
+	}
+	// with -repro, the sample and salt come from the run's seed
+	randInt := func(n int) (int, error) {
+		r, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+		if err != nil {
+			return 0, err
+		}
+		return int(r.Int64()), nil
+	}
+	fill := rand.Read
+	if j.repro != nil {
+		rng := j.repro.rand()
+		randInt = func(n int) (int, error) { return rng.Intn(n), nil }
+		fill = rng.Read
+	}
+	salt := make([]byte, 16)
+	if _, e := fill(salt); e != nil {
+		return e
+	}
+
+	// reservoir sample of the rows, with their row numbers so the sample is in the order of the source
+	type kept struct {
+		row    int
+		values []string
+	}
+	reservoir := make([]kept, 0, opts.n)
+	ev := &env{dateFmt: j.dateFmt}
+	for rowNo := 0; ; rowNo++ {
+		data, _, err := in.Read(1, true)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		slot := rowNo
+		if rowNo >= opts.n {
+			var e error
+			if slot, e = randInt(rowNo + 1); e != nil {
+				return e
+			}
+			if slot >= opts.n {
+				continue
+			}
+		}
+		values := make([]string, len(data[0]))
+		for ind, v := range data[0] {
+			values[ind] = toStr(ev, v)
+			if hashed[ind] {
+				h := sha256.Sum256(append(salt, values[ind]...))
+				values[ind] = hex.EncodeToString(h[:8])
+			}
+		}
+		if slot == len(reservoir) {
+			reservoir = append(reservoir, kept{rowNo, values})
+			continue
+		}
+		reservoir[slot] = kept{rowNo, values}
+	}
+	sort.Slice(reservoir, func(a, b int) bool { return reservoir[a].row < reservoir[b].row })
+
+	var w io.Writer = os.Stdout
+	if opts.out != "" {
+		f, err := os.Create(opts.out)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+	cw := csv.NewWriter(w)
+	header := make([]string, len(spec.FieldDefs))
+	for ind := range header {
+		header[ind] = spec.FieldDefs[ind].Name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, k := range reservoir {
+		if err := cw.Write(k.values); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	if opts.out != "" {
+		fmt.Printf("%d rows written to %s\n", len(reservoir), opts.out)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// column is a column of an existing ClickHouse table
+type column struct {
+	name   string
+	chType string
+}
+
+// describe returns the columns of table that an insert supplies, in order.  MATERIALIZED and ALIAS columns
+// are computed by ClickHouse, so they are left out.
+func describe(table string, con *chutils.Connect) ([]column, error) {
+	db, name := splitTable(table)
+	qry := `SELECT name, type FROM system.columns 
+WHERE database = if(? = '', currentDatabase(), ?) AND table = ? AND default_kind NOT IN ('MATERIALIZED', 'ALIAS')
+ORDER BY position`
+	rows, err := con.Query(qry, db, db, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	cols := make([]column, 0)
+	for rows.Next() {
+		var c column
+		if e := rows.Scan(&c.name, &c.chType); e != nil {
+			return nil, e
+		}
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
+
+// aggRe matches SimpleAggregateFunction(f, T) and AggregateFunction(f, T)
+var aggRe = regexp.MustCompile(`^(Simple)?AggregateFunction\(\s*([^,]+),\s*(.+)\)$`)
+
+// wrapRe matches the wrappers Nullable(T) and LowCardinality(T)
+var wrapRe = regexp.MustCompile(`^(Nullable|LowCardinality)\((.+)\)$`)
+
+// baseField sets the type of fd to the ClickHouse type chType.  It returns false if chutils has no
+// equivalent of chType, in which case fd is left alone.
+//
+// chutils has no unsigned integers, so a UInt8, UInt16 or UInt32 is held by the Int twice as wide, with legal
+// values from 0 to the largest of the UInt.  The values of a UInt64 don't fit an Int64, so it has no
+// equivalent, nor have the 128- and 256-bit integers.
+func baseField(fd *chutils.FieldDef, chType, dateFmt string) bool {
+	for m := wrapRe.FindStringSubmatch(chType); m != nil; m = wrapRe.FindStringSubmatch(chType) {
+		chType = m[2]
+	}
+	switch {
+	case strings.HasPrefix(chType, "UInt"):
+		bits, _ := strconv.Atoi(strings.TrimPrefix(chType, "UInt"))
+		if bits != 8 && bits != 16 && bits != 32 {
+			return false
+		}
+		fd.ChSpec.Base, fd.ChSpec.Length = chutils.ChInt, 2*bits
+		if fd.Legal == nil {
+			fd.Legal = &chutils.LegalValues{}
+		}
+		fd.Legal.LowLimit, fd.Legal.HighLimit = int64(0), int64(1)<<bits-1
+	case strings.HasPrefix(chType, "Int"):
+		bits, _ := strconv.Atoi(strings.TrimPrefix(chType, "Int"))
+		if bits != 8 && bits != 16 && bits != 32 && bits != 64 {
+			return false
+		}
+		fd.ChSpec.Base, fd.ChSpec.Length = chutils.ChInt, bits
+	case strings.HasPrefix(chType, "Float"):
+		bits, _ := strconv.Atoi(strings.TrimPrefix(chType, "Float"))
+		fd.ChSpec.Base, fd.ChSpec.Length = chutils.ChFloat, bits
+	case chType == "Date":
+		fd.ChSpec.Base, fd.ChSpec.Format = chutils.ChDate, dateFmt
+	case chType == "String":
+		fd.ChSpec.Base = chutils.ChString
+	case strings.HasPrefix(chType, "FixedString("):
+		n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(chType, "FixedString("), ")"))
+		fd.ChSpec.Base, fd.ChSpec.Length = chutils.ChFixedString, n
+	default:
+		return false
+	}
+	return true
+}
+
+// chType returns the ClickHouse type of fd
+func chType(fd *chutils.FieldDef) string {
+	var t string
+	switch fd.ChSpec.Base {
+	case chutils.ChInt:
+		t = fmt.Sprintf("Int%d", fd.ChSpec.Length)
+	case chutils.ChFloat:
+		t = fmt.Sprintf("Float%d", fd.ChSpec.Length)
+	case chutils.ChDate:
+		t = "Date"
+	case chutils.ChFixedString:
+		t = fmt.Sprintf("FixedString(%d)", fd.ChSpec.Length)
+	default:
+		t = "String"
+	}
+	// the outermost function is first
+	for ind := len(fd.ChSpec.Funcs) - 1; ind >= 0; ind-- {
+		t = fmt.Sprintf("%s(%s)", fd.ChSpec.Funcs[ind], t)
+	}
+	return t
+}
+
+// appendTo checks that the fields of spec can be inserted into the existing table j.table.  toch inserts
+// without a column list, so the fields must match the columns in number and order.
+//
+// A SimpleAggregateFunction(f, T) column of an AggregatingMergeTree takes plain values of type T, so the
+// field is given type T whatever type the data suggested.  An AggregateFunction column holds the
+// intermediate state of f, which can't be made from a plain value, so it is an error.
+func (j *job) appendTo(spec *chutils.TableDef, con *chutils.Connect) error {
+	cols, err := describe(j.table, con)
+	if err != nil {
+		return err
+	}
+	// the fields that are loaded
+	fds := make([]*chutils.FieldDef, 0, len(spec.FieldDefs))
+	for ind := 0; ind < len(spec.FieldDefs); ind++ {
+		if !spec.FieldDefs[ind].Drop {
+			fds = append(fds, spec.FieldDefs[ind])
+		}
+	}
+	if len(cols) != len(fds) {
+		return fmt.Errorf("the data has %d fields and table %s has %d columns", len(fds), j.table, len(cols))
+	}
+	for ind, c := range cols {
+		fd := fds[ind]
+		if fd.Name != c.name {
+			fmt.Printf("warning: field %d is %s in the data and %s in table %s\n", ind+1, fd.Name, c.name, j.table)
+		}
+		m := aggRe.FindStringSubmatch(c.chType)
+		if m == nil {
+			continue
+		}
+		if m[1] == "" {
+			return fmt.Errorf("column %s of %s is %s: toch can't load plain values into an AggregateFunction column; "+
+				"load them into a table of plain columns and INSERT ... SELECT %sState(...) from it", c.name, j.table, c.chType, m[2])
+		}
+		if !baseField(fd, m[3], j.dateFmt) {
+			return fmt.Errorf("column %s of %s is %s: toch can't load type %s", c.name, j.table, c.chType, m[3])
+		}
+		fmt.Printf("field %s loaded into %s as %s\n", fd.Name, c.chType, m[3])
+	}
+	return nil
+}
+
+// create creates j.table from spec or, if the load appends to the table, checks spec against it.  As with
+// chutils' Create, a table of that name is dropped first.  The table is created with the statement ddl gives,
+// so its columns have their final types from the start.
+func (j *job) create(spec *chutils.TableDef, con *chutils.Connect) error {
+	if j.appending {
+		return j.appendTo(spec, con)
+	}
+	if spec.Key == "" {
+		return fmt.Errorf("the table has no key")
+	}
+	if err := j.checkCodecs(spec); err != nil {
+		return err
+	}
+	if _, err := con.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", j.table)); err != nil {
+		return err
+	}
+	_, err := con.Exec(j.ddl(spec))
+	return err
+}
+
+// tableSchema returns the spec of the existing table j.table, for -use-table-schema: the fields are its
+// columns, with their types, so the data is converted to the table's types rather than imputed.  The fields of
+// j.source are matched to the columns by name; fields the table lacks are dropped and columns the source
+// lacks are filled with missing values.  If no field matches a column by name, as when the source has no
+// header row, the fields are matched in order.  Columns whose types toch can't convert to are loaded as
+// strings for ClickHouse to convert.
+func (j *job) tableSchema(con *chutils.Connect) (*chutils.TableDef, error) {
+	if len(j.derive) > 0 {
+		return nil, fmt.Errorf("-derive can't be used with -use-table-schema")
+	}
+	cols, err := describe(j.table, con)
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("table %s has no columns toch can insert into", j.table)
+	}
+	fds := make(map[int]*chutils.FieldDef)
+	names := make([]string, len(cols))
+	for ind, c := range cols {
+		t := c.chType
+		if m := aggRe.FindStringSubmatch(t); m != nil {
+			if m[1] == "" {
+				return nil, fmt.Errorf("column %s of %s is %s: toch can't load plain values into an AggregateFunction column",
+					c.name, j.table, c.chType)
+			}
+			t = m[3]
+		}
+		fd := &chutils.FieldDef{Name: c.name, ChSpec: chutils.ChField{Base: chutils.ChString}, Legal: &chutils.LegalValues{}}
+		if !baseField(&chutils.FieldDef{}, t, j.dateFmt) {
+			fmt.Printf("column %s is %s: loaded as a String for ClickHouse to convert\n", c.name, c.chType)
+			t = "String"
+		}
+		if err := setType(fd, t, j.dateFmt); err != nil {
+			return nil, fmt.Errorf("column %s: %v", c.name, err)
+		}
+		fds[ind], names[ind] = fd, c.name
+	}
+	spec := chutils.NewTableDef(cols[0].name, chutils.MergeTree, fds)
+
+	src := j.headers
+	if len(src) == 0 {
+		if src, err = j.fileFields(); err != nil {
+			return nil, err
+		}
+	}
+	inTable, inSrc := make(map[string]bool), make(map[string]bool)
+	for _, n := range names {
+		inTable[n] = true
+	}
+	var missing, extra []string
+	for _, c := range src {
+		inSrc[c] = true
+		if !inTable[c] {
+			extra = append(extra, c)
+		}
+	}
+	for _, n := range names {
+		if !inSrc[n] {
+			missing = append(missing, n)
+		}
+	}
+	switch {
+	case len(extra) == len(src):
+		if len(src) != len(names) {
+			return nil, fmt.Errorf("no field of the data is a column of %s, and the data has %d fields and the table %d columns",
+				j.table, len(src), len(names))
+		}
+		fmt.Printf("no field of the data is a column of %s: the fields are loaded into the columns in order\n", j.table)
+		j.columns = nil
+		return spec, nil
+	case strings.Join(src, ",") == strings.Join(names, ","):
+		j.columns = nil
+		return spec, nil
+	}
+	if len(extra) > 0 {
+		fmt.Printf("%s: fields %s aren't columns of %s, dropped\n", j.source, strings.Join(extra, ", "), j.table)
+	}
+	if len(missing) > 0 {
+		fmt.Printf("%s: columns %s missing, filled with missing values\n", j.source, strings.Join(missing, ", "))
+	}
+	j.columns = src
+	return spec, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/invertedv/chutils"
+)
+
+func TestBaseField(t *testing.T) {
+	tests := []struct {
+		chType   string
+		ok       bool
+		base     chutils.ChType
+		length   int
+		low, hi  interface{}
+		missing  interface{}
+		accepted string // a value convert passes
+		rejected string // one it fails
+	}{
+		{"Int8", true, chutils.ChInt, 8, nil, nil, math.MaxInt8, "-128", "128"},
+		{"Int64", true, chutils.ChInt, 64, nil, nil, math.MaxInt64, "-9223372036854775808", "9223372036854775808"},
+		{"UInt8", true, chutils.ChInt, 16, int64(0), int64(255), 255, "255", "256"},
+		{"UInt16", true, chutils.ChInt, 32, int64(0), int64(65535), 65535, "65535", "-1"},
+		{"UInt32", true, chutils.ChInt, 64, int64(0), int64(4294967295), 4294967295, "4294967295", "4294967296"},
+		{"Nullable(UInt16)", true, chutils.ChInt, 32, int64(0), int64(65535), nil, "0", "65536"},
+		{"UInt64", false, chutils.ChString, 0, nil, nil, "!", "", ""},
+		{"UInt128", false, chutils.ChString, 0, nil, nil, "!", "", ""},
+		{"Int128", false, chutils.ChString, 0, nil, nil, "!", "", ""},
+		{"Int", false, chutils.ChString, 0, nil, nil, "!", "", ""},
+	}
+	for _, tt := range tests {
+		if ok := baseField(&chutils.FieldDef{}, tt.chType, ""); ok != tt.ok {
+			t.Errorf("baseField(%s) = %v, want %v", tt.chType, ok, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		// a field that was an unsigned type before doesn't keep its limits
+		fd := &chutils.FieldDef{Legal: &chutils.LegalValues{LowLimit: int64(0), HighLimit: int64(1)}}
+		if err := setType(fd, tt.chType, ""); err != nil {
+			t.Errorf("setType(%s): %v", tt.chType, err)
+			continue
+		}
+		if fd.ChSpec.Base != tt.base || fd.ChSpec.Length != tt.length || fd.Legal.LowLimit != tt.low ||
+			fd.Legal.HighLimit != tt.hi || fd.Missing != tt.missing {
+			t.Errorf("setType(%s): %v %v..%v missing %v", tt.chType, fd.ChSpec, fd.Legal.LowLimit, fd.Legal.HighLimit,
+				fd.Missing)
+		}
+		if _, status := convert(fd, tt.accepted); status != chutils.VPass {
+			t.Errorf("%s: convert(%s) = %s", tt.chType, tt.accepted, status)
+		}
+		if _, status := convert(fd, tt.rejected); status == chutils.VPass {
+			t.Errorf("%s: convert(%s) passed", tt.chType, tt.rejected)
+		}
+	}
+}
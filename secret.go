@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// resolvePassword fetches a password from source, which is one of:
+//   - keyring:<service>                the OS keyring (secret-tool on linux, security on macOS)
+//   - vault://<mount>/<path>#<key>     HashiCorp Vault, using VAULT_ADDR and VAULT_TOKEN (or ~/.vault-token)
+//   - env:<name>                       the environment variable name
+func resolvePassword(source string) (string, error) {
+	switch {
+	case strings.HasPrefix(source, "keyring:"):
+		return fromKeyring(strings.TrimPrefix(source, "keyring:"))
+	case strings.HasPrefix(source, "vault://"):
+		return fromVault(strings.TrimPrefix(source, "vault://"))
+	case strings.HasPrefix(source, "env:"):
+		name := strings.TrimPrefix(source, "env:")
+		pw, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return pw, nil
+	default:
+		return "", fmt.Errorf("unknown -password-source %s", source)
+	}
+}
+
+// resolveSecret returns secret, unless it is a -password-source (keyring:, vault:// or env:), in which case
+// the secret is fetched from there.
+func resolveSecret(secret string) (string, error) {
+	for _, prefix := range []string{"keyring:", "vault://", "env:"} {
+		if strings.HasPrefix(secret, prefix) {
+			return resolvePassword(secret)
+		}
+	}
+	return secret, nil
+}
+
+// fromKeyring fetches the password stored in the OS keyring under service
+func fromKeyring(service string) (string, error) {
+	var c *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		c = exec.Command("secret-tool", "lookup", "service", service)
+	case "darwin":
+		c = exec.Command("security", "find-generic-password", "-s", service, "-w")
+	default:
+		return "", fmt.Errorf("keyring is not supported on %s", runtime.GOOS)
+	}
+	pw, err := runCmd(c)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(pw), "\r\n"), nil
+}
+
+// fromVault fetches the key from the secret at path from Vault.  Both the KV version 2 and version 1 engines
+// are handled.
+func fromVault(path string) (string, error) {
+	path, key, ok := strings.Cut(path, "#")
+	if !ok || key == "" {
+		return "", fmt.Errorf("vault source must be vault://<path>#<key>")
+	}
+	addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		home, _ := os.UserHomeDir()
+		b, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return "", fmt.Errorf("VAULT_TOKEN is not set and there is no ~/.vault-token")
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	// KV version 2 puts "data" after the mount and nests the secret in data.data
+	mount, rest, _ := strings.Cut(strings.Trim(path, "/"), "/")
+	var v2 struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := vaultGet(fmt.Sprintf("%s/v1/%s/data/%s", addr, mount, rest), token, &v2); err == nil && v2.Data.Data != nil {
+		return vaultKey(v2.Data.Data, key)
+	}
+
+	var v1 struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := vaultGet(fmt.Sprintf("%s/v1/%s", addr, strings.Trim(path, "/")), token, &v1); err != nil {
+		return "", err
+	}
+	return vaultKey(v1.Data, key)
+}
+
+// vaultGet reads the Vault API at url into out
+func vaultGet(url, token string, out interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Body.Close() }()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault returned %s for %s", r.Status, url)
+	}
+	return json.NewDecoder(r.Body).Decode(out)
+}
+
+// vaultKey returns the value of key in the secret data
+func vaultKey(data map[string]interface{}, key string) (string, error) {
+	v, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret has no key %s", key)
+	}
+	return fmt.Sprint(v), nil
+}
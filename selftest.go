@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fixtureTypes are the -type of a fixture, by its extension
+var fixtureTypes = map[string]string{".csv": "csv", ".txt": "text", ".tsv": "text", ".tab": "text", ".xlsx": "xlsx",
+	".xls": "xls", ".json": "json", ".ndjson": "ndjson", ".jsonl": "ndjson"}
+
+// goldenExt ends the name of the golden file of a fixture: <fixture>.golden.json
+const goldenExt = ".golden.json"
+
+// selftestOpts are the options of "toch selftest"
+type selftestOpts struct {
+	dir     string // directory of the fixtures
+	update  bool   // write the golden files rather than compare to them
+	typeSet bool   // -type was given, so it applies to every fixture
+}
+
+// golden is what toch makes of a fixture: the fields, with their types, and the values of the rows as they
+// would be loaded
+type golden struct {
+	Fields []goldenField `json:"fields"`
+	Rows   [][]string    `json:"rows"`
+}
+
+// goldenField is a field of a golden file
+type goldenField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// selftest reads each fixture of opts.dir with the options of a load and compares the fields and rows to
+// those of its golden file.  With opts.update, the golden files are written instead.  The type of a fixture
+// is given by its extension, unless -type was given.  It returns an error if any fixture fails.
+func selftest(j *job, args []string, opts *selftestOpts) error {
+	if len(args) > 0 || opts.dir == "" {
+		return fmt.Errorf("usage: toch selftest -dir <fixtures> [-update Y] (and the options of a load)")
+	}
+	entries, err := os.ReadDir(opts.dir)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0)
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || strings.HasSuffix(e.Name(), goldenExt) || (fixtureTypes[ext] == "" && !opts.typeSet) {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return fmt.Errorf("no fixtures in %s", opts.dir)
+	}
+
+	failed := 0
+	for _, name := range names {
+		fj := *j
+		fj.source = filepath.Join(opts.dir, name)
+		if !opts.typeSet {
+			fj.sType = fixtureTypes[strings.ToLower(filepath.Ext(name))]
+		}
+		got, err := readFixture(&fj)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %v\n", name, err)
+			continue
+		}
+		b, err := json.MarshalIndent(got, "", "  ")
+		if err != nil {
+			return err
+		}
+		goldenFile := fj.source + goldenExt
+		if opts.update {
+			if e := os.WriteFile(goldenFile, append(b, '\n'), 0644); e != nil {
+				return e
+			}
+			fmt.Printf("wrote %s\n", goldenFile)
+			continue
+		}
+		want, err := os.ReadFile(goldenFile)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %v (write it with -update Y)\n", name, err)
+			continue
+		}
+		if bytes.Equal(bytes.TrimSpace(want), b) {
+			fmt.Printf("ok    %s\n", name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  %s: %s\n", name, firstDiff(want, got))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d fixtures failed", failed, len(names))
+	}
+	if !opts.update {
+		fmt.Printf("%d fixtures ok\n", len(names))
+	}
+	return nil
+}
+
+// readFixture reads the source of j as a load would
+func readFixture(j *job) (*golden, error) {
+	in, err := openReader(j, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = in.Close() }()
+	g := &golden{Rows: make([][]string, 0)}
+	for _, fd := range in.TableSpec().FieldDefs {
+		g.Fields = append(g.Fields, goldenField{Name: fd.Name, Type: chType(fd)})
+	}
+	ev := &env{dateFmt: j.dateFmt}
+	for {
+		data, _, err := in.Read(1000, true)
+		for _, row := range data {
+			values := make([]string, len(row))
+			for ind, v := range row {
+				values[ind] = toStr(ev, v)
+			}
+			g.Rows = append(g.Rows, values)
+		}
+		if err == io.EOF {
+			return g, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// firstDiff describes the first difference between the golden file want and got
+func firstDiff(want []byte, got *golden) string {
+	g := &golden{}
+	if e := json.Unmarshal(want, g); e != nil {
+		return fmt.Sprintf("bad golden file: %v", e)
+	}
+	if fmt.Sprint(g.Fields) != fmt.Sprint(got.Fields) {
+		return fmt.Sprintf("fields are %v, expected %v", got.Fields, g.Fields)
+	}
+	for ind := 0; ind < min(len(g.Rows), len(got.Rows)); ind++ {
+		if fmt.Sprintf("%q", g.Rows[ind]) != fmt.Sprintf("%q", got.Rows[ind]) {
+			return fmt.Sprintf("row %d is %q, expected %q", ind+1, got.Rows[ind], g.Rows[ind])
+		}
+	}
+	return fmt.Sprintf("%d rows, expected %d", len(got.Rows), len(g.Rows))
+}
@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// serverJob is a load submitted to toch serve.  It is run as a separate toch process with the server's
+// options followed by Args.
+type serverJob struct {
+	ID        int        `json:"id"`
+	Args      []string   `json:"args"`
+	Table     string     `json:"table,omitempty"`
+	State     string     `json:"state"` // queued, running, succeeded, failed or cancelled
+	RunID     string     `json:"runId,omitempty"`
+	Submitted time.Time  `json:"submitted"`
+	Started   time.Time  `json:"started,omitempty"`
+	Finished  time.Time  `json:"finished,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Output    string     `json:"output,omitempty"`
+	Restarts  int        `json:"restarts,omitempty"` // times the job was running when toch stopped and was run again
+	Rows      int        `json:"rows"`               // rows loaded, as the job reports them
+	out       *jobOutput // output of the job while it runs
+}
+
+// progressRe matches the lines -progress prints
+var progressRe = regexp.MustCompile(`progress: (\d+) rows`)
+
+// jobOutput collects the output of a running job and takes the rows loaded from its -progress lines
+type jobOutput struct {
+	s   *server
+	j   *serverJob
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write adds p to the output and updates the rows of the job
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if ms := progressRe.FindAllSubmatch(p, -1); len(ms) > 0 {
+		var rows int
+		_, _ = fmt.Sscanf(string(ms[len(ms)-1][1]), "%d", &rows)
+		o.s.mu.Lock()
+		o.j.Rows = rows
+		o.s.mu.Unlock()
+	}
+	return o.buf.Write(p)
+}
+
+// String returns the output so far
+func (o *jobOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// server runs the jobs submitted to toch serve, up to maxJobs at a time and one at a time for each table
+type server struct {
+	con      *chutils.Connect
+	options  []string // options of toch serve passed on to each job
+	maxJobs  int      // jobs run at a time
+	mu       sync.Mutex
+	jobs     []*serverJob
+	queue    chan *serverJob
+	draining bool
+	running  map[int]*exec.Cmd // processes of the running jobs, by id
+	journal  bool              // write an entry for each job to the systemd journal
+	store    *jobStore         // store of the jobs, nil if they aren't kept
+	token    string            // the token requests must give
+	root     string            // the directory the sources of the jobs must be in, "" if jobs can't give -s
+	secrets  []string          // the passwords and keys of the options, hidden in what the server reports
+}
+
+// runIDRe finds the run id that toch prints when it starts
+var runIDRe = regexp.MustCompile(`run id: ([0-9a-f-]+)`)
+
+// serve runs toch as a server on opts.listen.  It accepts loads at POST /jobs and reports their state at GET
+// /jobs.  /healthz reports whether toch is up and /readyz whether it can take jobs.  The other requests must
+// give opts.token (see authorized), and jobs may give only some options (see checkArgs).  On SIGTERM, toch stops
+// taking jobs, lets the running job finish (for at most opts.drain) and exits.  Under systemd, toch tells the
+// service manager it's ready once it's listening and, with opts.journal, writes an entry for each job to the
+// journal.  With opts.jobsDB, the jobs are kept in that file: those queued or running when toch stopped are
+// run when it starts again.
+func serve(opts *cmdOptions, con *chutils.Connect) error {
+	addr := opts.listen
+	token, err := resolveSecret(opts.token)
+	if err != nil {
+		return fmt.Errorf("-serve-token: %v", err)
+	}
+	if token == "" {
+		return fmt.Errorf("toch serve needs -serve-token, the token requests must give, e.g. -serve-token env:TOCH_TOKEN")
+	}
+	root := opts.root
+	if root != "" {
+		if root, err = filepath.Abs(root); err != nil {
+			return err
+		}
+		if root, err = filepath.EvalSymlinks(root); err != nil {
+			return fmt.Errorf("-serve-root: %v", err)
+		}
+	}
+	s := &server{con: con, options: os.Args[1:], maxJobs: max(1, opts.maxJobs), running: make(map[int]*exec.Cmd),
+		journal: opts.journal, token: token, root: root}
+	s.secrets = append(secretsOf(s.options), token)
+	if con != nil {
+		s.secrets = append(s.secrets, con.Password)
+	}
+	var pending []*serverJob
+	if opts.jobsDB != "" {
+		if s.store, err = openJobStore(opts.jobsDB); err != nil {
+			return err
+		}
+		defer func() { _ = s.store.Close() }()
+		if pending, err = s.restore(); err != nil {
+			return err
+		}
+	}
+	s.queue = make(chan *serverJob, 1000+len(pending))
+	for _, j := range pending {
+		s.queue <- j
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", s.ready)
+	mux.HandleFunc("POST /jobs", s.authorized(s.submit))
+	mux.HandleFunc("GET /jobs", s.authorized(s.list))
+	mux.HandleFunc("GET /jobs/{id}", s.authorized(s.get))
+	mux.HandleFunc("GET /jobs/{id}/output", s.authorized(s.output))
+	mux.HandleFunc("GET /{$}", s.authorized(ui))
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(ln) }()
+
+	done := make(chan struct{})
+	go s.work(done)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	fmt.Printf("toch serving on %s\n", addr)
+	sdReady(ctx, "serving on "+addr)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// drain: refuse new jobs, cancel the queued ones and wait for the running ones
+	fmt.Println("draining")
+	sdNotify("STOPPING=1\nSTATUS=draining")
+	s.mu.Lock()
+	s.draining = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(opts.drain):
+		s.mu.Lock()
+		for id, c := range s.running {
+			if c.Process != nil {
+				fmt.Printf("drain timeout: stopping job %d\n", id)
+				_ = c.Process.Signal(syscall.SIGTERM)
+			}
+		}
+		s.mu.Unlock()
+		<-done
+	}
+
+	shut, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shut); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// restore reads the jobs of the store and returns those to run: the queued ones and those that were running
+// when toch stopped, in the order they were submitted
+func (s *server) restore() ([]*serverJob, error) {
+	jobs, err := s.store.load()
+	if err != nil {
+		return nil, err
+	}
+	var pending []*serverJob
+	for ind, j := range jobs {
+		if j.ID != ind+1 {
+			return nil, fmt.Errorf("-jobs-db: job %d is missing", ind+1)
+		}
+		switch j.State {
+		case "running":
+			j.State, j.Started, j.Restarts = "queued", time.Time{}, j.Restarts+1
+			if e := s.store.save(j); e != nil {
+				return nil, e
+			}
+			fallthrough
+		case "queued":
+			pending = append(pending, j)
+		}
+	}
+	s.jobs = jobs
+	if len(jobs) > 0 {
+		fmt.Printf("%d jobs restored, %d to run\n", len(jobs), len(pending))
+	}
+	return pending, nil
+}
+
+// work runs the queued jobs, in the order they were submitted, until the queue is closed and the running jobs
+// are done.  Up to s.maxJobs jobs run at a time, but only one for each table: a job waits while another job
+// loads its table, so two jobs don't race on a table, and the jobs behind it for other tables go ahead.  Jobs
+// still queued when draining are cancelled, unless the jobs are stored, in which case they are left to run
+// when toch starts again.
+func (s *server) work(done chan struct{}) {
+	defer close(done)
+	queue, finished := s.queue, make(chan *serverJob)
+	var waiting []*serverJob
+	busy := make(map[string]bool) // tables being loaded, one job each
+	for queue != nil || len(waiting) > 0 || len(busy) > 0 {
+		s.mu.Lock()
+		draining := s.draining
+		for _, j := range waiting {
+			if draining && s.store == nil {
+				j.State = "cancelled"
+			}
+		}
+		s.mu.Unlock()
+		if draining {
+			waiting = nil
+		}
+
+		// start the jobs that can start, keeping the rest in order
+		held := waiting[:0]
+		blocked := make(map[string]bool) // tables with a job held back, which the later jobs for them wait behind
+		for _, j := range waiting {
+			table := s.tableOf(j)
+			if len(busy) >= s.maxJobs || busy[table] || blocked[table] {
+				held, blocked[table] = append(held, j), true
+				continue
+			}
+			busy[table] = true
+			go func(j *serverJob) {
+				s.run(j)
+				finished <- j
+			}(j)
+		}
+		waiting = held
+
+		select {
+		case j, ok := <-queue:
+			if !ok {
+				queue = nil
+				continue
+			}
+			waiting = append(waiting, j)
+		case j := <-finished:
+			delete(busy, s.tableOf(j))
+		}
+	}
+}
+
+// tableOf returns the -table of job j, the last given in the options of toch serve and j's own, in its -db, or
+// "" if it has none, e.g. when the tables are in a config file
+func (s *server) tableOf(j *serverJob) string {
+	table, db := "", "default"
+	args := append(append([]string{}, s.options...), j.Args...)
+	for ind, a := range args {
+		name, value, ok := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || (name != "table" && name != "db") {
+			continue
+		}
+		if !ok && ind+1 < len(args) {
+			value = args[ind+1]
+		}
+		if name == "db" {
+			db = value
+			continue
+		}
+		table = value
+	}
+	return qualifyTable(table, db)
+}
+
+// save stores j, if the jobs are stored.  s.mu is held.
+func (s *server) save(j *serverJob) {
+	if e := s.store.save(j); e != nil {
+		fmt.Printf("job %d: %v\n", j.ID, e)
+	}
+}
+
+// run runs job j as a toch process
+func (s *server) run(j *serverJob) {
+	exe, err := os.Executable()
+	if err != nil {
+		s.finish(j, "", err)
+		return
+	}
+
+	// the job reports the rows it has loaded, unless its own options say otherwise
+	out := &jobOutput{s: s, j: j}
+	c := exec.Command(exe, append(append([]string{"-progress", "2s"}, s.options...), j.Args...)...)
+	c.Stdout, c.Stderr, c.Env = out, out, childEnv()
+
+	s.mu.Lock()
+	j.State, j.Started, j.Rows, j.out = "running", time.Now(), 0, out
+	s.running[j.ID] = c
+	s.save(j)
+	s.mu.Unlock()
+
+	err = c.Run()
+	s.finish(j, out.String(), err)
+}
+
+// finish records the outcome of job j
+func (s *server) finish(j *serverJob, output string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, j.ID)
+	j.Finished, j.Output, j.State, j.out = time.Now(), output, "succeeded", nil
+	if m := runIDRe.FindStringSubmatch(output); m != nil {
+		j.RunID = m[1]
+	}
+	if err != nil {
+		j.State, j.Error = "failed", err.Error()
+	}
+	s.save(j)
+	fmt.Printf("job %d %s\n", j.ID, j.State)
+	sdNotify(fmt.Sprintf("STATUS=serving: job %d %s", j.ID, j.State))
+	if s.journal {
+		fields := map[string]string{"TOCH_JOB": strconv.Itoa(j.ID), "TOCH_STATUS": j.State, "TOCH_RUN_ID": j.RunID,
+			"TOCH_ARGS": strings.Join(s.redactArgs(j.Args), " ")}
+		if !j.Started.IsZero() {
+			fields["TOCH_SECONDS"] = fmt.Sprintf("%0.1f", j.Finished.Sub(j.Started).Seconds())
+		}
+		prio := prioInfo
+		if err != nil {
+			prio, fields["TOCH_ERROR"] = prioErr, s.redact(j.Error)
+		}
+		journalSend(prio, fmt.Sprintf("job %d %s", j.ID, j.State), fields)
+	}
+}
+
+// ready reports whether the server is taking jobs and can reach ClickHouse
+func (s *server) ready(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	draining := s.draining
+	s.mu.Unlock()
+	if draining {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	if err := s.con.PingContext(r.Context()); err != nil {
+		http.Error(w, "ClickHouse: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// submit queues the job in the request body, which is JSON of the form {"args": ["-s", "file.csv", ...]}
+func (s *server) submit(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Args []string `json:"args"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Args) == 0 {
+		http.Error(w, "no args", http.StatusBadRequest)
+		return
+	}
+	args, err := checkArgs(req.Args, s.root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	j := &serverJob{ID: len(s.jobs) + 1, Args: args, State: "queued", Submitted: time.Now()}
+	j.Table = s.tableOf(j)
+	select {
+	case s.queue <- j:
+	default:
+		http.Error(w, "the queue is full", http.StatusServiceUnavailable)
+		return
+	}
+	s.jobs = append(s.jobs, j)
+	s.save(j)
+	w.WriteHeader(http.StatusAccepted)
+	write(w, s.public(j))
+}
+
+// list reports the state of all jobs
+func (s *server) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]serverJob, len(s.jobs))
+	for ind, j := range s.jobs {
+		jobs[ind] = s.public(j)
+	}
+	write(w, jobs)
+}
+
+// get reports the state of one job
+func (s *server) get(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.job(r)
+	if j == nil {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	write(w, s.public(j))
+}
+
+// output reports the output of one job as text, so far if it's running
+func (s *server) output(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j := s.job(r)
+	var out string
+	var live *jobOutput
+	if j != nil {
+		out, live = j.Output, j.out
+	}
+	s.mu.Unlock()
+	if j == nil {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	if live != nil {
+		out = live.String()
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprint(w, s.redact(out))
+}
+
+// job returns the job of the id of the request, nil if there is none.  s.mu is held.
+func (s *server) job(r *http.Request) *serverJob {
+	var id int
+	if _, err := fmt.Sscanf(r.PathValue("id"), "%d", &id); err != nil || id < 1 || id > len(s.jobs) {
+		return nil
+	}
+	return s.jobs[id-1]
+}
+
+// write writes v as JSON
+func write(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// authorized wraps h so that it answers only the requests that give the token of the server: as a bearer token
+// (Authorization: Bearer <token>) or, so the status page works in a browser, as the password of basic
+// authentication, which the browser asks for
+func (s *server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, given, _ = r.BasicAuth()
+		}
+		if s.token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="toch"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// jobOptions are the options a job may give: those that say how to read, check and load the source.  The
+// options that run commands (e.g. -reader-cmd, -transform-cmd), read or write files other than the source
+// (e.g. -bad, -out, -quarantine, -config), run SQL (-incremental, -checks) or set the connection, its
+// credentials and the tables allowed are left to the options of toch serve.  -s is checked by sourceIn.
+var jobOptions = map[string]bool{
+	"table": true, "db": true, "create-db": true, "type": true, "s": true, "c": true, "h": true, "t": true,
+	"q": true, "sep": true, "skip": true, "i": true, "dateFormat": true, "datetime-format": true, "locale": true,
+	"rows": true, "cols": true, "sheet": true, "xl-date-system": true, "recursive": true, "include": true,
+	"exclude": true, "member-pattern": true, "schema-policy": true, "file-workers": true, "derive": true,
+	"extract": true, "recode": true, "decode": true, "upper": true, "lower": true, "titlecase": true, "fill": true,
+	"rule": true, "rule-action": true, "assert-sorted": true, "assert-action": true, "assert-unique": true,
+	"assert-in": true, "on-miss": true, "on-duplicate": true, "nullable": true, "not-nullable": true,
+	"where": true, "run-id-col": true, "batch": true, "size-check": true, "optimize": true, "max-parts": true,
+	"server-stats": true, "exists": true, "use-table-schema": true, "keep-backup": true, "expect-rows": true,
+	"require-cols": true, "expect-max-date": true, "key": true, "yes": true, "raw": true, "strip-ctrl": true,
+	"ctrl-replace": true, "normalize": true, "normalize-values": true, "all-types": true, "sparse": true,
+	"pivot": true, "pivot-max-cols": true, "drop-empty-cols": true, "truncate-policy": true, "shrink": true,
+	"int64": true, "codec": true, "null": true, "missing": true, "infer-bool": true, "bool-tokens": true,
+	"infer-decimal": true, "flatten-sep": true, "decompress": true, "start-line": true, "end-line": true,
+	"auto-string": true, "lc-threshold": true, "preserve-order": true, "progress": true, "dryrun": true,
+	"dryrun-rows": true, "dryrun-sample": true,
+}
+
+// checkArgs returns the options of a job, args, with -s made absolute.  It is an error if args give an option
+// that isn't one of jobOptions or a source that isn't in root.
+func checkArgs(args []string, root string) ([]string, error) {
+	out := make([]string, 0, len(args))
+	for ind := 0; ind < len(args); ind++ {
+		a := args[ind]
+		if !strings.HasPrefix(a, "-") || a == "-" {
+			return nil, fmt.Errorf("%q is not an option", a)
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !jobOptions[name] {
+			return nil, fmt.Errorf("jobs can't give -%s: it is left to the options of toch serve", name)
+		}
+		// -yes takes no value
+		if name == "yes" {
+			out = append(out, a)
+			continue
+		}
+		if !hasValue {
+			if ind+1 == len(args) {
+				return nil, fmt.Errorf("-%s has no value", name)
+			}
+			ind++
+			value = args[ind]
+		}
+		if name == "s" {
+			src, err := sourceIn(value, root)
+			if err != nil {
+				return nil, err
+			}
+			value = src
+		}
+		out = append(out, "-"+name, value)
+	}
+	return out, nil
+}
+
+// sourceIn returns the absolute path of the source of a job, which is relative to root unless it is absolute.
+// It is an error if the source, or the path its symbolic links lead to, is outside root, or if it is a web
+// address or an S3 object, or if root is "".  A pattern (*.csv) must have its directory in root; the files it
+// matches are not checked, so root shouldn't hold links to files outside it.
+func sourceIn(source, root string) (string, error) {
+	if root == "" {
+		return "", fmt.Errorf("jobs can't give -s unless toch serve has -serve-root")
+	}
+	if strings.Contains(source, "://") {
+		return "", fmt.Errorf("-s %s: jobs can only load files in -serve-root", source)
+	}
+	p := source
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	p = filepath.Clean(p)
+	check := []string{p}
+	dir := p
+	if isGlob(p) {
+		dir = filepath.Dir(p)
+		check = []string{dir}
+	}
+	// the links of the part of the path that exists
+	for d, rest := dir, ""; d != filepath.Dir(d); d, rest = filepath.Dir(d), filepath.Join(filepath.Base(d), rest) {
+		if real, err := filepath.EvalSymlinks(d); err == nil {
+			check = append(check, filepath.Join(real, rest))
+			break
+		}
+	}
+	for _, c := range check {
+		rel, err := filepath.Rel(root, c)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("-s %s is outside -serve-root", source)
+		}
+	}
+	return p, nil
+}
+
+// secretOptions are the options whose values are secrets
+var secretOptions = map[string]bool{"password": true, "src-password": true, "api-key": true,
+	"oauth-client-secret": true, "serve-token": true, "http-body": true}
+
+// secretsOf returns the values of the secret options in options and, for those fetched from a keyring, Vault or
+// the environment, the secrets themselves
+func secretsOf(options []string) []string {
+	var secrets []string
+	for ind, a := range options {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || !secretOptions[name] {
+			continue
+		}
+		if !hasValue && ind+1 < len(options) {
+			value = options[ind+1]
+		}
+		secrets = append(secrets, value)
+		if v, err := resolveSecret(value); err == nil && v != value {
+			secrets = append(secrets, v)
+		}
+	}
+	return secrets
+}
+
+// userinfoRe matches the user and password of a URL
+var userinfoRe = regexp.MustCompile(`(://)[^/@\s]+@`)
+
+// redact returns text with the secrets of the server and the passwords of URLs hidden
+func (s *server) redact(text string) string {
+	for _, secret := range s.secrets {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, "***")
+		}
+	}
+	return userinfoRe.ReplaceAllString(text, "${1}***@")
+}
+
+// redactArgs returns args with the values of the secret options and the secrets of the server hidden
+func (s *server) redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	hide := false // the arg is the value of a secret option
+	for ind, a := range args {
+		if hide {
+			out[ind], hide = "***", false
+			continue
+		}
+		out[ind] = s.redact(a)
+		name, _, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || !secretOptions[name] {
+			continue
+		}
+		if hasValue {
+			out[ind] = a[:strings.Index(a, "=")+1] + "***"
+		} else {
+			hide = true
+		}
+	}
+	return out
+}
+
+// public returns a copy of j for the API, with its secrets hidden.  s.mu is held.
+func (s *server) public(j *serverJob) serverJob {
+	p := *j
+	p.Args, p.Output, p.Error, p.out = s.redactArgs(j.Args), s.redact(j.Output), s.redact(j.Error), nil
+	return p
+}
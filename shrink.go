@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/invertedv/chutils"
+)
+
+// shrink narrows the imputed numeric fields of in to the smallest type that holds every valid value: if ints,
+// Int8, Int16 or Int32 for the Int64 fields and, if floats, Float32 for the Float64 fields whose values are the
+// same when stored as a Float32 (to the digits shown).  It reads in to find the values and then resets it.  The
+// fields narrowed are reported.
+func shrink(in chutils.Input, ints, floats bool) error {
+	spec := in.TableSpec()
+	lo, hi := make(map[int]int64), make(map[int]int64)
+	fits32 := make(map[int]bool)
+	for ind, fd := range spec.FieldDefs {
+		switch {
+		case ints && fd.ChSpec.Base == chutils.ChInt && fd.ChSpec.Length == 64:
+			lo[ind], hi[ind] = math.MaxInt64, math.MinInt64
+		case floats && fd.ChSpec.Base == chutils.ChFloat && fd.ChSpec.Length == 64:
+			fits32[ind] = true
+		}
+	}
+	if len(lo)+len(fits32) == 0 {
+		return nil
+	}
+
+	if err := in.Reset(); err != nil {
+		return err
+	}
+	for {
+		data, valid, err := in.Read(1000, true)
+		for r, row := range data {
+			for ind, v := range row {
+				if r >= len(valid) || ind >= len(valid[r]) || valid[r][ind] != chutils.VPass {
+					continue
+				}
+				switch x := v.(type) {
+				case int64:
+					if _, ok := lo[ind]; ok {
+						lo[ind], hi[ind] = min(lo[ind], x), max(hi[ind], x)
+					}
+				case float64:
+					if fits32[ind] && strconv.FormatFloat(x, 'g', -1, 32) != strconv.FormatFloat(x, 'g', -1, 64) {
+						fits32[ind] = false
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := in.Reset(); err != nil {
+		return err
+	}
+
+	for ind, fd := range spec.FieldDefs {
+		if fits32[ind] {
+			fd.ChSpec.Length, fd.Missing = 32, math.MaxFloat32
+			fmt.Printf("%s: Float64 -> Float32\n", fd.Name)
+			continue
+		}
+		if _, ok := lo[ind]; !ok || lo[ind] > hi[ind] {
+			continue
+		}
+		// the largest value is left for the values that are illegal
+		switch {
+		case lo[ind] >= math.MinInt8 && hi[ind] < math.MaxInt8:
+			fd.ChSpec.Length, fd.Missing = 8, math.MaxInt8
+		case lo[ind] >= math.MinInt16 && hi[ind] < math.MaxInt16:
+			fd.ChSpec.Length, fd.Missing = 16, math.MaxInt16
+		case lo[ind] >= math.MinInt32 && hi[ind] < math.MaxInt32:
+			fd.ChSpec.Length, fd.Missing = 32, math.MaxInt32
+		default:
+			continue
+		}
+		fmt.Printf("%s: Int64 -> Int%d (values %d to %d)\n", fd.Name, fd.ChSpec.Length, lo[ind], hi[ind])
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffBytes is the size of the sample of the source sniff reads
+const sniffBytes = 1 << 20
+
+// magic numbers of the compressed and binary formats sniff recognizes
+var magics = []struct {
+	prefix []byte
+	name   string
+}{
+	{[]byte{0x1f, 0x8b}, "gzip"},
+	{[]byte("BZh"), "bzip2"},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0}, "xz"},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd"},
+	{[]byte("PK\x03\x04"), "zip"},
+	{[]byte{0xd0, 0xcf, 0x11, 0xe0}, "xls"},
+	{[]byte("PAR1"), "parquet"},
+}
+
+// sniffCmd runs "toch sniff", which reports what toch can tell about a source without loading it: its format,
+// compression, encoding, delimiter, number of lines, whether it has a header and its first and last lines.
+func sniffCmd(args []string) error {
+	fs := flag.NewFlagSet("sniff", flag.ExitOnError)
+	sourcePtr := fs.String("s", "", "string")
+	agentPtr := fs.String("agent", "NA", "string")
+	methodPtr := fs.String("http-method", "GET", "string")
+	bodyPtr := fs.String("http-body", "", "string")
+	typePtr := fs.String("http-content-type", "", "string")
+	nPtr := fs.Int("n", 5, "int")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *sourcePtr == "" {
+		return fmt.Errorf("usage: toch sniff -s <file or url> [-n lines] [-agent agent]")
+	}
+	web, err := newWebRequest(*agentPtr, *methodPtr, *bodyPtr, *typePtr)
+	if err != nil {
+		return err
+	}
+
+	sample, size, err := sniffSample(*sourcePtr, web)
+	if err != nil {
+		return err
+	}
+	whole := int64(len(sample)) == size
+	fmt.Printf("source       %s\n", *sourcePtr)
+	if size >= 0 {
+		fmt.Printf("size         %s\n", bytesString(uint64(size)))
+	}
+
+	// compressed and binary formats
+	compression := "none"
+	for _, m := range magics {
+		if !bytes.HasPrefix(sample, m.prefix) {
+			continue
+		}
+		switch m.name {
+		case "gzip", "bzip2":
+			compression = m.name
+			if sample, err = decompress(m.name, sample); err != nil {
+				return err
+			}
+			// all of it, if the whole file was read and it decompresses to less than the sample size
+			whole = whole && len(sample) < sniffBytes
+		case "zip":
+			if bytes.Contains(sample, []byte("xl/")) {
+				fmt.Println("format       xlsx")
+				return nil
+			}
+			fmt.Println("compression  zip")
+			return nil
+		case "xls", "parquet":
+			fmt.Printf("format       %s\n", m.name)
+			return nil
+		default:
+			fmt.Printf("compression  %s (not read by toch)\n", m.name)
+			return nil
+		}
+		break
+	}
+	fmt.Printf("compression  %s\n", compression)
+	if len(sample) > 262 && string(sample[257:262]) == "ustar" {
+		fmt.Println("format       tar archive")
+		return nil
+	}
+
+	encoding, body := sniffEncoding(sample)
+	fmt.Printf("encoding     %s\n", encoding)
+
+	lines := strings.Split(strings.ReplaceAll(body, "\r", ""), "\n")
+	// the last line of a partial sample may be cut off
+	if !whole && len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		fmt.Println("lines        0")
+		return nil
+	}
+
+	delim := sniffDelimiter(lines)
+	var format string
+	switch delim {
+	case ',':
+		format = "csv (-type csv)"
+	case '\t':
+		format = "tab-delimited (-type text)"
+	case 0:
+		format = "single column"
+	default:
+		format = fmt.Sprintf("%q-delimited (-type csv -sep '%c')", delim, delim)
+	}
+	fmt.Printf("format       %s\n", format)
+
+	switch {
+	case whole:
+		fmt.Printf("lines        %d\n", len(lines))
+	case size > 0 && compression == "none":
+		fmt.Printf("lines        about %d\n", int64(float64(len(lines))*float64(size)/float64(len(sample))))
+	default:
+		fmt.Printf("lines        more than %d\n", len(lines))
+	}
+
+	header := "no (use -h to name the fields)"
+	if looksLikeHeader(lines, delim) {
+		header = "yes (-h Y)"
+	}
+	fmt.Printf("header       %s\n", header)
+	if delim != 0 {
+		fmt.Printf("fields       %d\n", len(splitFields(lines[0], delim)))
+	}
+
+	n := *nPtr
+	if n > len(lines) {
+		n = len(lines)
+	}
+	fmt.Println("first lines:")
+	for _, l := range lines[:n] {
+		fmt.Printf("  %s\n", l)
+	}
+	tail, label := lines[len(lines)-n:], "last lines:"
+	if !whole {
+		if compression != "none" || size < 0 || strings.Contains(strings.ToLower(*sourcePtr), "http") {
+			label = "last lines of the sample:"
+		} else if tail, err = lastLines(*sourcePtr, n); err != nil {
+			return err
+		}
+	}
+	fmt.Println(label)
+	for _, l := range tail {
+		fmt.Printf("  %s\n", l)
+	}
+	return nil
+}
+
+// sniffSample returns up to sniffBytes from the start of source and the size of source (-1 if not known)
+func sniffSample(source string, web *webRequest) (sample []byte, size int64, err error) {
+	if strings.Contains(strings.ToLower(source), "http") {
+		r, e := web.get(source)
+		if e != nil {
+			return nil, -1, e
+		}
+		defer func() { _ = r.Body.Close() }()
+		sample, err = io.ReadAll(io.LimitReader(r.Body, sniffBytes))
+		return sample, r.ContentLength, err
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, -1, err
+	}
+	sample, err = io.ReadAll(io.LimitReader(f, sniffBytes))
+	return sample, info.Size(), err
+}
+
+// decompress decompresses as much of the compressed sample as it can
+func decompress(compression string, sample []byte) ([]byte, error) {
+	var rdr io.Reader
+	switch compression {
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(sample))
+		if err != nil {
+			return nil, err
+		}
+		rdr = gz
+	case "bzip2":
+		rdr = bzip2.NewReader(bytes.NewReader(sample))
+	}
+	out, err := io.ReadAll(io.LimitReader(rdr, sniffBytes))
+	// the sample usually ends in the middle of the compressed stream
+	if err != nil && err != io.ErrUnexpectedEOF && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sniffEncoding returns the encoding of the sample and the sample as a string without its byte order mark
+func sniffEncoding(sample []byte) (encoding, body string) {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8 with BOM", string(sample[3:])
+	case bytes.HasPrefix(sample, []byte{0xff, 0xfe}):
+		return "utf-16le (not read by toch)", ""
+	case bytes.HasPrefix(sample, []byte{0xfe, 0xff}):
+		return "utf-16be (not read by toch)", ""
+	}
+
+	// the sample may end in the middle of a character
+	check := sample
+	for ind := 0; ind < utf8.UTFMax && len(check) > 0 && !utf8.Valid(check); ind++ {
+		check = check[:len(check)-1]
+	}
+	if !utf8.Valid(check) {
+		return "not utf-8 (probably latin-1 or windows-1252)", string(sample)
+	}
+	for _, b := range sample {
+		if b >= utf8.RuneSelf {
+			return "utf-8", string(sample)
+		}
+	}
+	return "ascii", string(sample)
+}
+
+// sniffDelimiter returns the delimiter that splits the first lines into the same number of fields.
+// It returns 0 if no candidate does.
+func sniffDelimiter(lines []string) rune {
+	if len(lines) > 20 {
+		lines = lines[:20]
+	}
+	var best rune
+	bestFields := 1
+	for _, d := range []rune{',', '\t', '|', ';'} {
+		n := len(splitFields(lines[0], d))
+		if n <= bestFields {
+			continue
+		}
+		consistent := true
+		for _, l := range lines[1:] {
+			if len(splitFields(l, d)) != n {
+				consistent = false
+				break
+			}
+		}
+		if consistent {
+			best, bestFields = d, n
+		}
+	}
+	return best
+}
+
+// splitFields splits line on delim where delim is not inside double quotes
+func splitFields(line string, delim rune) []string {
+	fields := make([]string, 0)
+	inQuote := false
+	st := 0
+	for ind, r := range line {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case r == delim && !inQuote:
+			fields = append(fields, line[st:ind])
+			st = ind + utf8.RuneLen(r)
+		}
+	}
+	return append(fields, line[st:])
+}
+
+// looksLikeHeader guesses whether the first line is a header: none of its fields is a number or empty.
+func looksLikeHeader(lines []string, delim rune) bool {
+	for _, f := range splitFields(lines[0], delim) {
+		f = strings.Trim(strings.TrimSpace(f), `"`)
+		if _, err := strconv.ParseFloat(f, 64); err == nil || f == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// lastLines returns the last n lines of the uncompressed file source
+func lastLines(source string, n int) ([]string, error) {
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start := info.Size() - 64*1024
+	if start < 0 {
+		start = 0
+	}
+	if _, e := f.Seek(start, io.SeekStart); e != nil {
+		return nil, e
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(string(b), "\r", ""), "\n"), "\n")
+	// the first line may be cut off
+	if start > 0 && len(lines) > 1 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
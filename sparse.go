@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// sparseShare is the share of empty values above which a field is sparse
+const sparseShare = 0.99
+
+// zeroValue returns the ClickHouse default value of the type of fd
+func zeroValue(fd *chutils.FieldDef) interface{} {
+	switch fd.ChSpec.Base {
+	case chutils.ChInt:
+		return int64(0)
+	case chutils.ChFloat:
+		return 0.0
+	case chutils.ChDate:
+		return time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return ""
+}
+
+// emptyCounts reads the data and counts the empty values of each field.  The data is then reset.
+func emptyCounts(in chutils.Input) (empty []int, rows int, err error) {
+	empty = make([]int, len(in.TableSpec().FieldDefs))
+	for {
+		data, _, e := in.Read(1000, false)
+		for _, row := range data {
+			for ind := range empty {
+				if ind < len(row) && (row[ind] == nil || strings.TrimSpace(fmt.Sprint(row[ind])) == "") {
+					empty[ind]++
+				}
+			}
+		}
+		rows += len(data)
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			return nil, 0, e
+		}
+	}
+	return empty, rows, in.Reset()
+}
+
+// sparseFields finds the fields that are empty in more than 99% of the rows.  They are declared with their
+// type's default as the DEFAULT and their empty (and invalid) values are loaded as that default rather than
+// the usual missing value.  ClickHouse stores a column that is mostly defaults in a sparse form, so these
+// fields take almost no space.
+func sparseFields(spec *chutils.TableDef, empty []int, rows int) {
+	if rows == 0 {
+		return
+	}
+	sparse := make([]string, 0)
+	for ind := 0; ind < len(spec.FieldDefs); ind++ {
+		fd := spec.FieldDefs[ind]
+		// the key can't be sparse
+		if ind == 0 || fd.Drop || float64(empty[ind])/float64(rows) <= sparseShare {
+			continue
+		}
+		fd.Default = zeroValue(fd)
+		fd.Missing = fd.Default
+		sparse = append(sparse, fd.Name)
+	}
+	if len(sparse) > 0 {
+		fmt.Printf("sparse fields (over %0.0f%% empty): %s\n", 100*sparseShare, strings.Join(sparse, ", "))
+	}
+}
+
+// dropEmpty drops the fields that are empty in every row.  The first field, the table key, is kept.
+func dropEmpty(spec *chutils.TableDef, empty []int, rows int) {
+	if rows == 0 {
+		return
+	}
+	dropped := make([]string, 0)
+	for ind := 1; ind < len(spec.FieldDefs); ind++ {
+		if empty[ind] == rows {
+			spec.FieldDefs[ind].Drop = true
+			dropped = append(dropped, spec.FieldDefs[ind].Name)
+		}
+	}
+	if len(dropped) > 0 {
+		fmt.Printf("dropped empty fields: %s\n", strings.Join(dropped, ", "))
+	}
+}
+
+// emptyFields reads in to drop the fields of a new table that are always empty, with -drop-empty-cols, and to
+// make those that are nearly always empty sparse, with -sparse
+func (j *job) emptyFields(in chutils.Input) error {
+	if !j.sparse && !j.dropEmpty {
+		return nil
+	}
+	empty, rows, err := emptyCounts(in)
+	if err != nil {
+		return err
+	}
+	if j.dropEmpty {
+		dropEmpty(in.TableSpec(), empty, rows)
+	}
+	if j.sparse {
+		sparseFields(in.TableSpec(), empty, rows)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// splitOpts are the options of "toch split"
+type splitOpts struct {
+	by    []string // fields whose values choose the shard of a row
+	rows  int      // rows per shard
+	bytes int64    // bytes (about) per shard
+	out   string   // template of the shard file names: {field} for a -by field, {n} for the shard number
+}
+
+// placeholder is a {name} of a -out template
+var placeholder = regexp.MustCompile(`\{[^{}]+\}`)
+
+// shard is an output file of split
+type shard struct {
+	name  string
+	f     *os.File
+	w     *csv.Writer
+	rows  int
+	bytes int64
+}
+
+// split writes the rows of j.source to CSV files with a header row, the shards.  The source is read just as it
+// is for a load (-type, -h, -t, -derive, -where...) and the values are written as they would be loaded, so each
+// shard can be loaded on its own.  With -by, the rows with the same values of the -by fields go to the same
+// shard, named by putting the values into the -out template.  With -split-rows or -split-bytes, the shards are
+// consecutive and numbered from 1.
+func split(j *job, args []string, opts *splitOpts) error {
+	usage := fmt.Errorf("usage: toch split -s <source> -out 'parts/{field}.csv' [-by 'f1,...' | -split-rows n | -split-bytes n] (and the options of a load)")
+	if len(args) > 0 || opts.out == "" {
+		return usage
+	}
+	given := 0
+	for _, b := range []bool{len(opts.by) > 0, opts.rows > 0, opts.bytes > 0} {
+		if b {
+			given++
+		}
+	}
+	if given != 1 || opts.rows < 0 || opts.bytes < 0 {
+		return fmt.Errorf("give one of -by, -split-rows or -split-bytes")
+	}
+	names := placeholder.FindAllString(opts.out, -1)
+	if len(opts.by) == 0 && (len(names) != 1 || names[0] != "{n}") {
+		return fmt.Errorf("-out must have {n}, the number of the shard, e.g. 'parts/part_{n}.csv'")
+	}
+
+	in, err := openReader(j, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	spec := in.TableSpec()
+	header := make([]string, len(spec.FieldDefs))
+	for ind := range header {
+		header[ind] = spec.FieldDefs[ind].Name
+	}
+
+	// the field of each placeholder of -out
+	byField := make(map[string]int)
+	for _, name := range opts.by {
+		ind, _, e := spec.Get(name)
+		if e != nil {
+			return fmt.Errorf("-by: %s is not a field", name)
+		}
+		if !strings.Contains(opts.out, "{"+name+"}") {
+			return fmt.Errorf("-out must have {%s}", name)
+		}
+		byField["{"+name+"}"] = ind
+	}
+	for _, name := range names {
+		if _, ok := byField[name]; !ok && len(opts.by) > 0 {
+			return fmt.Errorf("-out has %s, which is not a -by field", name)
+		}
+	}
+
+	shards := make(map[string]*shard)
+	order := make([]string, 0)
+	var cur *shard
+	closeShard := func(s *shard) error {
+		s.w.Flush()
+		if e := s.w.Error(); e != nil {
+			_ = s.f.Close()
+			return e
+		}
+		return s.f.Close()
+	}
+	// the shards are closed when done, or on an error
+	defer func() {
+		for _, s := range shards {
+			_ = s.f.Close()
+		}
+	}()
+
+	ev := &env{dateFmt: j.dateFmt}
+	for {
+		data, _, err := in.Read(1, true)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		values := make([]string, len(data[0]))
+		size := int64(len(values))
+		for ind, v := range data[0] {
+			values[ind] = toStr(ev, v)
+			size += int64(len(values[ind]))
+		}
+
+		var name string
+		switch {
+		case len(opts.by) > 0:
+			name = placeholder.ReplaceAllStringFunc(opts.out, func(p string) string {
+				return fileSafe(values[byField[p]])
+			})
+		case cur == nil || (opts.rows > 0 && cur.rows >= opts.rows) || (opts.bytes > 0 && cur.bytes+size > opts.bytes && cur.rows > 0):
+			name = strings.ReplaceAll(opts.out, "{n}", strconv.Itoa(len(order)+1))
+		default:
+			name = cur.name
+		}
+
+		s, ok := shards[name]
+		if !ok {
+			if cur != nil && len(opts.by) == 0 {
+				// consecutive shards are done with
+				if e := closeShard(cur); e != nil {
+					return e
+				}
+				delete(shards, cur.name)
+			}
+			if s, err = newShard(name, header); err != nil {
+				return err
+			}
+			shards[name] = s
+			order = append(order, name)
+		}
+		cur = s
+		if e := s.w.Write(values); e != nil {
+			return e
+		}
+		s.rows++
+		s.bytes += size
+	}
+	for name, s := range shards {
+		if e := closeShard(s); e != nil {
+			return e
+		}
+		delete(shards, name)
+	}
+
+	sort.Strings(order)
+	fmt.Printf("%d shards written:\n%s\n", len(order), strings.Join(order, "\n"))
+	return nil
+}
+
+// newShard creates the shard file name, with its directory, and writes the header row
+func newShard(name string, header []string) (*shard, error) {
+	if e := os.MkdirAll(filepath.Dir(name), 0755); e != nil {
+		return nil, e
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &shard{name: name, f: f, w: csv.NewWriter(f)}
+	if e := s.w.Write(header); e != nil {
+		_ = f.Close()
+		return nil, e
+	}
+	return s, nil
+}
+
+// unsafeChars are the characters of a value that can't be in a file name
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fileSafe returns value as a part of a file name
+func fileSafe(value string) string {
+	value = unsafeChars.ReplaceAllString(value, "_")
+	if value == "" || strings.Trim(value, ".") == "" {
+		return "_"
+	}
+	return value
+}
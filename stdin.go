@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// stdinSource is the -s of data piped to toch
+const stdinSource = "-"
+
+// spoolStdin copies stdin to a temporary file and returns its name.  The readers read the source more than once
+// (the types are imputed before the rows are loaded), so a stream can't be read directly.  The file has the
+// extension of sType, which newFile needs for xls.  The caller removes the file.
+func spoolStdin(sType string) (string, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return "", fmt.Errorf("-s - reads the data piped to toch, but stdin is a terminal")
+	}
+	tmp, err := os.CreateTemp("", "toch-stdin-*."+sType)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(tmp, os.Stdin)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("reading stdin: %v", err)
+	}
+	fmt.Printf("stdin: %d bytes read\n", n)
+	return tmp.Name(), nil
+}
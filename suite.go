@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/invertedv/chutils"
+	"gopkg.in/yaml.v3"
+)
+
+// severities of a check
+var severities = []string{"error", "warn"}
+
+// check is an expectation of the -checks file.  A column check has Column and any of the column expectations,
+// each of which is checked separately.  A table check has Rows.
+type check struct {
+	Name     string   `yaml:"name"`
+	Severity string   `yaml:"severity"` // error (the default) fails the load, warn only reports
+	Column   string   `yaml:"column"`
+	Type     string   `yaml:"type"`      // ClickHouse type of the column, e.g. Float64
+	Min      *float64 `yaml:"min"`       // smallest value allowed
+	Max      *float64 `yaml:"max"`       // largest value allowed
+	Regex    string   `yaml:"regex"`     // values must match (re2 syntax)
+	Unique   bool     `yaml:"unique"`    // no two rows have the same value
+	NotEmpty bool     `yaml:"not_empty"` // no value is the empty string
+	In       string   `yaml:"in"`        // values must be in this column of another table: db.table.column
+	Rows     string   `yaml:"rows"`      // table check: the number of rows is in this range (as -expect-rows)
+}
+
+// suite is the -checks file
+type suite struct {
+	Checks []check `yaml:"checks"`
+}
+
+// loadSuite reads and validates the checks file fileName
+func loadSuite(fileName string) (*suite, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	s := &suite{}
+	if e := yaml.Unmarshal(b, s); e != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, e)
+	}
+	for ind := range s.Checks {
+		c := &s.Checks[ind]
+		if c.Severity == "" {
+			c.Severity = "error"
+		}
+		if !isIn(&c.Severity, severities, true) {
+			return nil, fmt.Errorf("%s: check %d: severity is error or warn", fileName, ind+1)
+		}
+		if (c.Column == "") == (c.Rows == "") {
+			return nil, fmt.Errorf("%s: check %d needs either a column or rows", fileName, ind+1)
+		}
+		if c.Rows != "" {
+			if _, e := parseRowRange(c.Rows); e != nil {
+				return nil, fmt.Errorf("%s: check %d: %v", fileName, ind+1, e)
+			}
+		}
+		if c.In != "" && strings.Count(c.In, ".") < 1 {
+			return nil, fmt.Errorf("%s: check %d: in is table.column or db.table.column", fileName, ind+1)
+		}
+	}
+	return s, nil
+}
+
+// outcome is the result of one expectation
+type outcome struct {
+	name     string
+	severity string
+	failed   uint64 // rows that fail, or 1 for a check that isn't about rows
+	detail   string
+}
+
+// run evaluates the checks on table.  If runID is not "", only the rows of this run are checked.  The outcomes
+// are printed.  It returns an error if a check of severity error fails.
+func (s *suite) run(table, runID string, con *chutils.Connect) error {
+	where := "1"
+	if runID != "" {
+		where = fmt.Sprintf("_runId = '%s'", runID)
+	}
+	outcomes := make([]outcome, 0)
+	for ind, c := range s.Checks {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("check %d", ind+1)
+		}
+		res, err := c.evaluate(table, where, con)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for _, o := range res {
+			o.name, o.severity = name+": "+o.name, c.Severity
+			outcomes = append(outcomes, o)
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "check of %s\tseverity\tstatus\tfailing rows\tdetail\n", table)
+	failed, warned := 0, 0
+	for _, o := range outcomes {
+		status := "pass"
+		if o.failed > 0 {
+			status = "FAIL"
+			if o.severity == "warn" {
+				status = "warn"
+				warned++
+			} else {
+				failed++
+			}
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", o.name, o.severity, status, o.failed, o.detail)
+	}
+	_ = tw.Flush()
+	fmt.Printf("%d checks: %d failed, %d warnings\n", len(outcomes), failed, warned)
+	if failed > 0 {
+		return fmt.Errorf("%d checks of %s failed", failed, table)
+	}
+	return nil
+}
+
+// evaluate runs each expectation of c on the rows of table selected by where
+func (c *check) evaluate(table, where string, con *chutils.Connect) ([]outcome, error) {
+	count := func(cond string) (uint64, error) {
+		var n uint64
+		err := con.QueryRow(fmt.Sprintf("SELECT countIf(%s) FROM %s WHERE %s", cond, table, where)).Scan(&n)
+		return n, err
+	}
+	out := make([]outcome, 0)
+	add := func(name, cond, detail string) error {
+		n, err := count(cond)
+		if err != nil {
+			return err
+		}
+		out = append(out, outcome{name: name, failed: n, detail: detail})
+		return nil
+	}
+
+	if c.Rows != "" {
+		r, _ := parseRowRange(c.Rows)
+		var n int
+		if err := con.QueryRow(fmt.Sprintf("SELECT toInt64(count()) FROM %s WHERE %s", table, where)).Scan(&n); err != nil {
+			return nil, err
+		}
+		o := outcome{name: "rows", detail: fmt.Sprintf("%d rows, expected %s", n, r)}
+		if r.check(table, n) != nil {
+			o.failed = 1
+		}
+		return append(out, o), nil
+	}
+
+	col := fmt.Sprintf("`%s`", c.Column)
+	if c.Type != "" {
+		var chType string
+		db, name := splitTable(table)
+		qry := "SELECT type FROM system.columns WHERE database = if(? = '', currentDatabase(), ?) AND table = ? AND name = ?"
+		if err := con.QueryRow(qry, db, db, name, c.Column).Scan(&chType); err != nil {
+			return nil, fmt.Errorf("column %s: %v", c.Column, err)
+		}
+		o := outcome{name: "type", detail: fmt.Sprintf("%s is %s", c.Column, chType)}
+		if chType != c.Type {
+			o.failed, o.detail = 1, fmt.Sprintf("%s is %s, expected %s", c.Column, chType, c.Type)
+		}
+		out = append(out, o)
+	}
+	if c.Min != nil {
+		if err := add("min", fmt.Sprintf("%s < %v", col, *c.Min), fmt.Sprintf("%s >= %v", c.Column, *c.Min)); err != nil {
+			return nil, err
+		}
+	}
+	if c.Max != nil {
+		if err := add("max", fmt.Sprintf("%s > %v", col, *c.Max), fmt.Sprintf("%s <= %v", c.Column, *c.Max)); err != nil {
+			return nil, err
+		}
+	}
+	if c.Regex != "" {
+		re := strings.ReplaceAll(strings.ReplaceAll(c.Regex, `\`, `\\`), "'", `\'`)
+		if err := add("regex", fmt.Sprintf("NOT match(toString(%s), '%s')", col, re), c.Column+" matches "+c.Regex); err != nil {
+			return nil, err
+		}
+	}
+	if c.NotEmpty {
+		if err := add("not_empty", fmt.Sprintf("empty(toString(%s))", col), c.Column+" is not empty"); err != nil {
+			return nil, err
+		}
+	}
+	if c.Unique {
+		var n uint64
+		qry := fmt.Sprintf("SELECT count() - uniqExact(%s) FROM %s WHERE %s", col, table, where)
+		if err := con.QueryRow(qry).Scan(&n); err != nil {
+			return nil, err
+		}
+		out = append(out, outcome{name: "unique", failed: n, detail: c.Column + " is unique"})
+	}
+	if c.In != "" {
+		ind := strings.LastIndex(c.In, ".")
+		ref := fmt.Sprintf("%s NOT IN (SELECT `%s` FROM %s)", col, c.In[ind+1:], c.In[:ind])
+		if err := add("in", ref, c.Column+" is in "+c.In); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalSocket is the socket of systemd-journald's native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// the syslog priorities of journal entries
+const (
+	prioErr  = 3
+	prioInfo = 6
+)
+
+// sdNotify sends state, e.g. READY=1 or STATUS=..., to the service manager over $NOTIFY_SOCKET.  It does
+// nothing unless toch runs as a systemd service of Type=notify, which sets the variable.
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	// an abstract socket is given with a leading @
+	if strings.HasPrefix(addr, "@") {
+		addr = "\x00" + addr[1:]
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer func() { _ = c.Close() }()
+	_, _ = c.Write([]byte(state))
+}
+
+// sdReady tells the service manager that toch is up, with status as its status line, and, if the unit has a
+// WatchdogSec, pings the watchdog at half its interval until ctx is done.  When ctx is done, it tells the
+// service manager that toch is stopping.
+func sdReady(ctx context.Context, status string) {
+	sdNotify("READY=1\nSTATUS=" + status)
+	interval := sdWatchdogInterval()
+	go func() {
+		var tick <-chan time.Time
+		if interval > 0 {
+			t := time.NewTicker(interval / 2)
+			defer t.Stop()
+			tick = t.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				sdNotify("STOPPING=1")
+				return
+			case <-tick:
+				sdNotify("WATCHDOG=1")
+			}
+		}
+	}()
+}
+
+// sdWatchdogInterval returns the watchdog interval of the unit, 0 if it has none or it is for another process
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// childEnv returns the environment of toch without the variables that address the service manager, so that
+// the processes toch starts don't speak for it
+func childEnv() []string {
+	env := make([]string, 0, len(os.Environ()))
+	for _, e := range os.Environ() {
+		if name, _, _ := strings.Cut(e, "="); name != "NOTIFY_SOCKET" && name != "WATCHDOG_PID" && name != "WATCHDOG_USEC" {
+			env = append(env, e)
+		}
+	}
+	return env
+}
+
+// journalOK returns an error if the journal can't be written to
+func journalOK() error {
+	if _, err := os.Stat(journalSocket); err != nil {
+		return fmt.Errorf("-journal: no systemd journal: %v", err)
+	}
+	return nil
+}
+
+// journalSend writes an entry with the message msg, the syslog priority prio and fields to the systemd journal.
+// The field names are upper case letters, digits and underscores, e.g. TOCH_TABLE.  A failure is printed, as
+// the entry only repeats what toch prints.
+func journalSend(prio int, msg string, fields map[string]string) {
+	var b bytes.Buffer
+	add := func(name, value string) {
+		if !strings.Contains(value, "\n") {
+			b.WriteString(name + "=" + value + "\n")
+			return
+		}
+		// a value with a newline is given by its length, a 64-bit little-endian integer
+		b.WriteString(name + "\n")
+		for n, ind := uint64(len(value)), 0; ind < 8; ind++ {
+			b.WriteByte(byte(n >> (8 * ind)))
+		}
+		b.WriteString(value + "\n")
+	}
+	add("MESSAGE", msg)
+	add("PRIORITY", strconv.Itoa(prio))
+	add("SYSLOG_IDENTIFIER", "toch")
+	for name, value := range fields {
+		add(name, value)
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		fmt.Printf("-journal: %v\n", err)
+		return
+	}
+	defer func() { _ = c.Close() }()
+	if _, err := c.Write(b.Bytes()); err != nil {
+		fmt.Printf("-journal: %v\n", err)
+	}
+}
+
+// journalLoad writes an entry for the load res of a -watch directory or -reopen-on-eof pipe into table to the
+// systemd journal
+func journalLoad(res fileResult, table, runID string) {
+	fields := map[string]string{"TOCH_SOURCE": res.source, "TOCH_TABLE": table, "TOCH_RUN_ID": runID,
+		"TOCH_ROWS": strconv.Itoa(res.rows), "TOCH_SECONDS": fmt.Sprintf("%0.1f", res.secs), "TOCH_STATUS": "loaded"}
+	if res.err != nil {
+		fields["TOCH_STATUS"], fields["TOCH_ERROR"] = "failed", res.err.Error()
+		journalSend(prioErr, fmt.Sprintf("%s: failed: %v", res.source, res.err), fields)
+		return
+	}
+	journalSend(prioInfo, fmt.Sprintf("%s: %d rows loaded into %s", res.source, res.rows, table), fields)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// isTar determines whether source is a tar archive, by its extension
+func isTar(source string) bool {
+	source = strings.ToLower(source)
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(source, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTar loads the members of the tar archive j.source that match one of patterns (or all members, if there are
+// none) into j.table.  The members are handled just as the files of loadDir, except that the encoding of each
+// member is detected and the member is converted to UTF-8.  Up to j.fileWorkers members are loaded at a time.
+func loadTar(j *job, patterns []string, con *chutils.Connect) ([]fileResult, error) {
+	var f io.ReadCloser
+	var err error
+	if isS3(j.source) {
+		f, err = j.s3.open(j.source)
+	} else {
+		f, err = os.Open(j.source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var r io.Reader = f
+	if !strings.HasSuffix(strings.ToLower(j.source), ".tar") {
+		gz, e := gzip.NewReader(f)
+		if e != nil {
+			return nil, e
+		}
+		defer func() { _ = gz.Close() }()
+		r = gz
+	}
+
+	l := newLoader(j, j.fileWorkers, con)
+	tr := tar.NewReader(r)
+	for {
+		hdr, e := tr.Next()
+		if e == io.EOF {
+			break
+		}
+		if e != nil {
+			l.wait()
+			return nil, e
+		}
+		if hdr.Typeflag != tar.TypeReg || !selected(strings.TrimPrefix(hdr.Name, "./"), patterns, nil) {
+			continue
+		}
+
+		mj := *j
+		mj.source = fmt.Sprintf("%s:%s", j.source, hdr.Name)
+		// the member is held in memory until it's loaded
+		l.slot()
+		body, e := io.ReadAll(tr)
+		if e != nil {
+			l.wait()
+			return nil, e
+		}
+		// members of an archive often come from different systems, so each has its own encoding
+		var enc string
+		if mj.body, enc = toUTF8(body); enc != "utf-8" {
+			fmt.Printf("%s: converted from %s\n", hdr.Name, enc)
+		}
+		l.load(&mj)
+	}
+	results := l.wait()
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no members of %s match -member-pattern", j.source)
+	}
+
+	return results, nil
+}
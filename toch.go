@@ -17,48 +17,221 @@
 //
 // Required command line arguments:
 //
-//	-s       source of data. This is either a file or web address.
+//	-s       source of data. This is either a file, a web address, an S3 object (s3://bucket/key), - for stdin or clipboard for the system clipboard.
 //	-type    type of data.  The options are:
+//	    -auto   sniffed from the start of the file or URL: text, csv (with -sep), xlsx, xls or parquet. The default.
 //	    -text   tab delimited
 //	    -csv    comma separated
 //	    -xls    Excel XLS
 //	    -xlsx   Excel XLSX
-//	-table   destination ClickHouse table.
+//	    -json   an array of JSON objects
+//	    -ndjson JSON objects, one per line
+//	    -parquet    Parquet, read with clickhouse local
+//	    -snowflake  results of -query run by snowsql
+//	    -bigquery   results of -query run by bq
+//	    -chquery    results of -query run on a ClickHouse server
+//	    -fred       the -series of the St. Louis Fed's FRED API
+//	    -bls        the -series of the BLS API
+//	    -census     the -series variables of the Census dataset -s (e.g. 2021/acs/acs5)
+//	-table   destination ClickHouse table, in -db unless given as db.table.
 //
 // Optional command line arguments:
 //
 //			-host           IP of ClickHouse database. Default: 127.0.0.1
+//			-port           port of the ClickHouse native protocol. Default: 9000
+//			-tls [Y/N]      connect to ClickHouse using TLS. Default: N
+//			-compress       compression of the data sent to ClickHouse: none, lz4 or zstd. Default: none
+//			-compress-level level of zstd compression. Default: the library's
 //			-user           ClickHouse user. Default: "default"
 //			-password       ClickHouse password. Default: ""
+//			-password-source where to fetch the password: keyring:<service>, vault://<path>#<key> or env:<name>
+//			-db             database of -table (and of config file targets) that don't name theirs. Default: default
+//			-create-db [Y/N] create the databases of the tables if they don't exist. Default: N
 //	     -agent          user agent for http requests (optional)
+//			 -http-method    GET, POST or PUT: the method of http requests. Default: GET
+//			 -http-body      the body of http requests. @<file> reads it from file.
+//			 -http-content-type the Content-Type of -http-body, e.g. application/json
+//			 -oauth-token-url the token endpoint of an OAuth2 protected source (client credentials flow).
+//			 -oauth-client-id the OAuth2 client id.
+//			 -oauth-client-secret the OAuth2 client secret, or keyring:, vault:// or env: to fetch it from.
+//			 -s3-region      the AWS region of an S3 source. Default: that of the AWS configuration
+//			 -s3-profile     the AWS profile of an S3 source. Default: that of the AWS configuration
 //			-c [Y/N]        convert field names to camel case. Default N
 //			-i [Y/N]        ignore read errors. Default: N
+//			-bad <file>     CSV file every row that fails to read, has a value that doesn't convert or fails a -rule is appended to, with the row number, field and problem.
+//			-progress <d>   print the rows loaded so far every d, e.g. 30s. Default: 0 (don't)
+//			-debug [Y/N]    describe the rows that fail to read or convert: the fields, the conversion and the line in hex. Default: N
 //			-skip <n>       rows to skip at beginning of file. Default: 0.
+//			-start-byte <n> load from the first line that starts at or after byte n of a text or csv file, without reading what comes before.
+//			-start-line <n> load from line n of a text or csv file (lines of the file, starting at 1).
+//			-end-line <n>   load to line n of a text or csv file. The header and -skip lines are still read from the start of the file.
 //			-q <char>       character for delimiting text. Default: "
+//			-sep <s>        field delimiter of -type text and csv: any characters, a Go escape such as \x01, or tab, comma, pipe, semicolon or space. Default: tab (text), comma (csv)
 //		    -dateFormat     format for dates using Jan 2, 2006 as the prototype, e.g. 1/2/2006 or 20060102
+//			-datetime-format <f> format of dt fields: a Go layout, auto (common formats and Unix times), epoch or epoch_ms. Default: auto
+//			-locale <l>     numbers and dates are written as in locale l, e.g. de_DE: 1.234,5 and 31.12.2024.
 //			-h 'f1,f2,...'  the field names are comma separated and the entire list is enclosed in single quotes. The default is to read these from the data.
 //			-t 't1,t2,...'  the types are comma separated and the entire list is encludes in single quotes. The default is to infer these from the data. Supported types are:
 //			    f   Float64
 //			    i   Int64
+//			    i8, i16, i32  Int8, Int16, Int32
+//			    u8, u16, u32, u64  UInt8, UInt16, UInt32, UInt64
 //			    d   Date
 //			    s   String
+//			    lc  LowCardinality(String)
+//			    b   Bool, from -bool-tokens
+//			    fs(n) FixedString(n)
+//			    pt, ring, poly, mpoly  Point, Ring, Polygon, MultiPolygon from WKT (or "lon lat" for pt)
+//			    tm  time of day (15:04:05, 3:04 PM) as Int64 seconds since midnight
+//			    dur duration (1:23:45, PT1H23M45S, 1h23m45s) as Int64 seconds
+//			    dt, dt3, dt6 DateTime, DateTime64(3) and DateTime64(6), in UTC (see -datetime-format)
+//			    dec(P,S) Decimal(P,S), e.g. dec(12,2)
+//			-truncate-policy <p> what to do with a value that doesn't fit a FixedString: error, truncate or pad. Default: error
+//			-pivot 'index=f1,...; columns=f; values=v' make a field v_<value> for each value of f, one row for each index.
+//			-pivot-max-cols <n> the most fields -pivot may create. Default: 100
+//			-drop-empty-cols [Y/N] leave out fields that are empty in every row. Default: N
+//			-sparse [Y/N]   fields over 99% empty get their type's default as DEFAULT and for empty values. Default: N
+//			-null 't1,...;f=t,...' values read as missing, in every field or in the field f, e.g. 'NA,N/A;price=.,-'.
+//			-missing 'f=v,i=v,d=v,s=v' the values illegal entries of Float, Int, Date and String fields are filled in with, e.g. 'f=-1,i=-1,s='.
+//			-nullable [Y/N] make the fields Nullable and load empty and illegal values as NULL, not the type's missing value. Default: N
+//			-not-nullable 'f1,...' with -nullable, fields that aren't made Nullable.
+//			-all-types <t>  give every field the type t (e.g. s), rather than listing them with -t.
+//			-decompress <c> compression of the source: auto (detect it), none, gzip, zip or bz2. Default: auto
+//			-flatten-sep <s> the separator of the names of nested JSON fields, e.g. address_city. Default: _
+//			-shrink [Y/N]   narrow imputed Float64 fields to Float32 (and, with -int64 Y, Int64 fields) if the values fit. Default: N
+//			-infer-bool [Y/N] make imputed fields whose values are all -bool-tokens (Y/N, true/false, 0/1) Bool. Default: N
+//			-bool-tokens 't1,.../f1,...' the values read as true and as false. Default: y,yes,true,t,1/n,no,false,f,0
+//			-codec 'f=c,...;...' compression codecs of the columns of a new table, e.g. 'asof=Delta,ZSTD(3);*=ZSTD(1)'. Default: LZ4
+//			-dryrun [Y/N]   read and impute the source without connecting to ClickHouse, print the CREATE TABLE statement and a sample of rows and estimate the bytes each field takes on disk. Default: N
+//			-dryrun-rows <n> rows read for the -dryrun estimate. Default: 100000
+//			-dryrun-sample <n> rows -dryrun prints as they would be loaded. Default: 10
+//			-int64 [Y/N]    impute every integer field as Int64, rather than the smallest of Int8 to Int64 that fits. Default: N
+//			-infer-decimal [Y/N] make imputed Float64 fields whose values all have the same scale Decimal(18,S) or Decimal(38,S). Default: N
+//			-auto-string [Y/N] make imputed String fields FixedString(n) or LowCardinality(String) if the values suit. Default: N
+//			-lc-threshold <n> make imputed String fields with at most n distinct values LowCardinality(String). Default: 0 (off)
+//			-strip-ctrl <p> control characters to remove: none, all or a list of codes such as '0x1e,0x1f'. Default: none
+//			-ctrl-replace <s> replace the characters removed by -strip-ctrl with s. Default: ""
+//			-normalize <form> put field names in Unicode normal form nfc or nfkc and strip zero-width and non-breaking spaces. Default: none
+//			-normalize-values [Y/N] with -normalize, normalize the values, too. Default: N
+//			-raw [Y/N]      load each line, unparsed, as a String field with its line number and the source. Default: N
 //			 -sheet          sheet name for Excel inputs. Default: first sheet in the workbook.
 //			 -rows <S:E>     start row:end row range from which to pull data from Excel inputs. If E=0, all rows after S are taken. Default: 0:0
+//			 -xl-date-system <s> the date system of Excel serial dates: auto (read from the workbook), 1900 or 1904. Default: auto
 //			 -cols <S:E>     start column:end column range from which to pull data from Excel inputs. If E=0, all columns after S are taken. Default 0:0
+//			 -recursive [Y/N] load every file in the directory tree -s into the table. Default: N
+//			 -watch <dir>    run until interrupted, loading the files that arrive in dir and moving them to dir/processed or dir/failed.
+//			 -watch-interval <d> with -watch, the time between looks at the directory. Default: 10s
+//			 -reopen-on-eof [Y/N] with -s a named pipe, run until interrupted, loading what each writer writes to it. Default: N
+//			 -fifo-batch <n> with -reopen-on-eof, also load every n lines of a writer; 0 loads when the writer closes the pipe. Default: 0
+//			 -include 'p1,...' with -recursive or -watch, load only files matching one of these patterns, e.g. '*.csv'
+//			 -exclude 'p1,...' with -recursive or -watch, skip files matching any of these patterns, e.g. '**/archive/**'
+//			 -member-pattern 'p1,...' load only members of a .tar, .tar.gz or .tgz source matching one of these patterns.
+//			 -file-workers <n> with -recursive or an archive, the number of files loaded at a time. Default: 1
+//			 -repro [Y/N]    make the run repeatable: files and rows in order, and the seed and time recorded. Default: N
+//			 -seed <n>       with -repro, the seed of the random choices.  Default: chosen
+//			 -repro-time <t> with -repro, the time (RFC 3339) given by now() and {now}.  Default: the start
+//			 -schema-policy  how the fields of later files and members are matched to the first's: first, union or strict. Default: first
+//			 -query          query to run for -type snowflake, bigquery and chquery.
+//			 -series 'id,...' FRED or BLS series ids or, for -type census, the variables to pull.
+//			 -api-key        key for -type fred (required), bls and census. keyring:, vault:// or env: fetch it.
+//			 -years <S:E>    first and last year pulled from FRED and BLS. Default: all (FRED), last 10 (BLS)
+//			 -census-for     Census geography, e.g. 'county:*'
+//			 -census-in      Census geography that contains -census-for, e.g. 'state:06'
+//			 -src-host       IP of the ClickHouse server that runs -query for -type chquery. Default: -host
+//			 -src-user       ClickHouse user for -src-host. Default: -user
+//			 -src-password   ClickHouse password for -src-host. Default: -password
+//			 -reader-cmd     shell command that reads -s and writes it to stdout in the -type format (text or csv).
+//			 -transform-cmd  shell command that reads rows on stdin and writes transformed rows to stdout.
+//			 -transform-format format of the rows for -transform-cmd: csv or json (lines). Default: csv
+//			 -derive 'name=expr;...' add fields calculated from the other fields.
+//			 -extract 'n1,...=f:/re/;...' add fields holding the capture groups of re in the field f, e.g. 'cusip=id:/^(\w{9})/'.
+//			 -recode 'name=expr;...' replace the values of source fields.
+//			 -upper, -lower, -titlecase 'f1,...' change the case of the values of these fields (* for all), e.g. -lower email.
+//			 -fill 'f=v [when c],...' replace the value of f with v when it is empty (or when c is true), e.g. "region='UNK'".
+//			 -rule 'name: expr;...' rows must make each expr true, e.g. 'dates: end_date >= start_date'.
+//			 -rule-action <a> what is done with a row failing a -rule: fail, drop or quarantine. Default: fail
+//			 -quarantine <file> CSV file the rows failing a -rule are appended to, with the rule. Default: <table>_quarantine.csv
+//			 -assert-sorted <f> check that the values of field f never decrease, e.g. for append-only feeds.
+//			 -assert-action <a> what is done when an -assert check fails: fail or warn. Default: fail
+//			 -assert-unique 'f1,...' check that no two rows loaded have the same values of these fields.
+//			 -on-duplicate <a> what is done with a row whose -assert-unique key was loaded before: fail, warn or dedup. Default: fail
+//			 -unique-bloom <MB> keep the -assert-unique keys in a Bloom filter of this many megabytes rather than exactly. Default: 0 (exact)
+//			 -assert-in 'f IN t.c;...' check that the values of field f are in the column c of the ClickHouse table t, e.g. 'msa IN dim_msa.msa_code'.
+//			 -on-miss <a>    what is done with a row failing an -assert-in check: fail, warn, drop or quarantine. Default: fail
+//			 -decode 'k|f=k,...' decode the values of every field, or of field f, of kind k: url (%20) or html (&amp;).
+//			 -where 'expr'   load only the rows for which expr is true.
+//			 -incremental 'expr' load only the rows newer than the table's watermark, e.g.
+//			                 'asof > (SELECT max(asof) FROM prices)'. Implies -exists append.
+//			 -tombstones [Y/N] with -incremental and -key, mark the keys of the table that aren't in the source as
+//			                 deleted with rows having _deleted=1 and _asof=now(). Default: N
+//			 -run-id-col [Y/N] add the field _runId with the run's id. Default: N
+//			 -summary <file> write a JSON summary of the run to file.
+//			 -optimize <m>   after the load, run OPTIMIZE on -table: N, Y, final or partition (FINAL on the partitions loaded). Default: N
+//			 -size-check [Y/N] before loading, check the server has room for the source. Default: Y
+//			 -batch <n>      insert the rows in batches of n. Default: 1000
+//			 -max-parts <n>  fail if the load will create more than n parts. Default: 0 (no limit)
+//			 -server-stats [Y/N] report the server-side metrics of the inserts from system.query_log. Default: Y
+//			 -audit <table>  add a row for each source loaded to this ClickHouse table.
+//			 -exists <mode>  what to do if -table exists: drop, fail, append or replace (once the load succeeds). Default: drop
+//			 -use-table-schema [Y/N] when appending, match the fields to the table's columns by name and use their types. Default: N
+//			 -yes            don't ask for confirmation before dropping -table.
+//			 -require-cols 'f1,...' fail before creating the table if the source lacks any of these fields.
+//			 -expect-max-date 'expr' fail the load if expr, on a date field, is false for the newest date, e.g. 'asof >= today()-3'.
+//			 -checks <file>  YAML file of expectations of the columns and rows loaded, checked after the load.
+//			 -expect-rows <min:max> fail the load if the rows loaded are outside this range, e.g. 1000000: or 90000:110000.
+//			 -keep-backup <d> rename an existing -table aside rather than dropping it (copy it if appending) and keep it for duration d (e.g. 24h).
+//			 -backup-log <table> ClickHouse table that records backups for "toch undo". Default: toch_backups
+//			 -preserve-order [Y/N] N inserts batches over several connections, in no particular order. Default: Y
+//			 -listen <addr>  address for "toch serve". Default: 127.0.0.1:8080
+//			 -serve-token <t> the token requests to "toch serve" must give; keyring:, vault:// or env: to fetch it from.
+//			 -serve-root <dir> the directory the -s of "toch serve" jobs must be in. Default: none (jobs can't give -s)
+//			 -drain-timeout <d> on SIGTERM, how long "toch serve" waits for the running jobs. Default: 10m
+//			 -max-concurrent-jobs <n> the jobs "toch serve" runs at a time; jobs for the same table run one at a time. Default: 1
+//			 -jobs-db <file> the bbolt file "toch serve" keeps its jobs in, so they survive a restart. Default: none (kept in memory)
+//			 -journal [Y/N]  with -watch, -reopen-on-eof or "toch serve", write an entry with fields for each load to the systemd journal. Default: N
+//			 -readonly-check [Y/N] check -table against -allow-tables and -deny-tables before creating it. Default: N
+//			 -allow-tables 'p1,...' with -readonly-check, -table must match one of these patterns, e.g. 'stg_*' (in -db).
+//			 -deny-tables 'p1,...' with -readonly-check, -table must not match any of these patterns.
+//			 -config <file>  YAML file with options and the target tables of a chained load.
+//			 -profile <name> take connection options and defaults from this profile in the profiles file.
+//			 -profile-file   the profiles file. Default: ~/.toch/profiles.yaml
 //
 // Notes:
 //   - S and E are 0-based indices.
 //   - if -h is supplied, the list must include all fields.
 //   - if -t is supplied, the list must included all fields.
 //   - The options -h and -t are independent: one can be supplied without the other.
-//   - ctrl-R's in the data are ignored.
+//   - ctrl-R's in the data are ignored. Other control characters are loaded unless -strip-ctrl is given.
 //   - The -skip parameter works with spreadsheets, too. It is applied within (any possible) range supplied by -rows.
+//   - With -recursive, the first file loaded creates the table and the rest are appended to it.
+//     Members of tar archives are handled the same way. Each member is converted to UTF-8 from its own encoding.
+//     So are the files matching a pattern, e.g. -s 'data/part-*.csv'.
+//   - "toch sample -s <source> -n 1000 -hash 'f1,...' -out sample.csv" writes a random sample of the source,
+//     read with the options of a load, with the values of the -hash fields replaced by a salted hash.
+//   - "toch diff -s <source> -table <table> -key 'f1,...'" reports the rows added, removed and changed in the
+//     source compared to the table.  -out <file> writes them to a CSV file and -apply Y inserts the added and
+//     changed rows.
+//   - "toch split -s huge.csv -by 'state' -out 'parts/{state}.csv'" writes the rows of the source, read with the
+//     options of a load, to a CSV file for each value of the -by fields.  -split-rows <n> or -split-bytes <n>
+//     instead write consecutive files of n rows or about n bytes, named by -out with {n} the file number.
+//   - "toch cat -s 'monthly/*.csv' -out all.csv" writes the rows of the files matching -s to one CSV file.  The
+//     fields are matched by name and are those of all the files (-schema-policy union, the default here), of the
+//     first file (first) or must be the same in every file (strict).
+//   - "toch selftest -dir testdata/" reads each fixture file of the directory and compares the fields and rows
+//     to its golden file, <fixture>.golden.json.  -update Y writes the golden files.
+//   - "toch sniff -s <file or url>" reports the format, compression, encoding, delimiter, number of lines,
+//     header and first and last lines of a source without loading it.
 //
 // Values that are illegal for the field type are filled in as:
 //   - Float64  the maximum value for Float64 (~E308)
 //   - Int64    the maximum value for Int64 (9223372036854775807)
+//   - Int8, Int16, Int32, UInt8 to UInt64, Float32 (-shrink) the maximum value of the type
 //   - Date     1970/1/1
+//   - Bool     false
 //   - String   "!"
+//   - Decimal  0
+//
+// -missing replaces the Float, Int, Date and String values.
 //
 // # Examples
 //
@@ -96,11 +269,11 @@
 package main
 
 import (
+	"crypto/rand"
 	"flag"
 	"fmt"
 	"io"
 	"math"
-	"net/http"
 	"os"
 	"os/exec"
 	"strconv"
@@ -123,24 +296,64 @@ var (
 )
 
 // types of file formats toch handles
-var types = []string{"text", "csv", "xlsx", "xls"}
+var types = []string{"text", "csv", "xlsx", "xls", "json", "ndjson", "parquet", "snowflake", "bigquery", "chquery", "fred", "bls", "census"}
 
 // reserved field names -- ClickHouse will not allow these
 var reserved = []string{"index"}
 
 // allowed values for -t field types the user can specify
-var ftypes = []string{"s", "i", "d", "f"}
+var ftypes = []string{"s", "i", "d", "f", "lc", "b"}
 
 // allowed values for -c camel case
 var ctypes = []string{"y", "n"}
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "expr" {
+		if e := exprCmd(os.Args[2:]); e != nil {
+			panic(e)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		versionCmd()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sniff" {
+		if e := sniffCmd(os.Args[2:]); e != nil {
+			panic(e)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "self-update" {
+		if e := selfUpdateCmd(os.Args[2:]); e != nil {
+			panic(e)
+		}
+		return
+	}
+	// commands that take the usual connection options
+	cmd, cmdArgs := command()
+
 	hostPtr := flag.String("host", "127.0.0.1", "string")
+	portPtr := flag.Int("port", 9000, "int")
+	tlsPtr := flag.String("tls", "N", "string")
+	compressPtr := flag.String("compress", "none", "string")
+	compressLevelPtr := flag.Int("compress-level", 0, "int")
 	userPtr := flag.String("user", "default", "string")
 	passwordPtr := flag.String("password", "", "string")
+	passwordSourcePtr := flag.String("password-source", "", "string")
 	agentPtr := flag.String("agent", "NA", "string")
+	httpMethodPtr := flag.String("http-method", "GET", "string")
+	httpBodyPtr := flag.String("http-body", "", "string")
+	httpTypePtr := flag.String("http-content-type", "", "string")
+	oauthURLPtr := flag.String("oauth-token-url", "", "string")
+	oauthIDPtr := flag.String("oauth-client-id", "", "string")
+	oauthSecretPtr := flag.String("oauth-client-secret", "", "string")
+	s3RegionPtr := flag.String("s3-region", "", "string")
+	s3ProfilePtr := flag.String("s3-profile", "", "string")
 
 	tablePtr := flag.String("table", "", "string")
+	dbPtr := flag.String("db", "default", "string")
+	createDBPtr := flag.String("create-db", "N", "string")
 
 	sTypePtr := flag.String("type", "", "string")
 	sourcePtr := flag.String("s", "", "string")
@@ -149,15 +362,310 @@ func main() {
 	headerPtr := flag.String("h", "", "string")
 	fieldPtr := flag.String("t", "", "string")
 	quotePtr := flag.String("q", `"`, "string")
+	delimPtr := flag.String("sep", "", "string")
 	skipPtr := flag.Int("skip", 0, "int")
 	ignorePtr := flag.String("i", "N", "string")
 	datePtr := flag.String("dateFormat", "1/2/2006", "string")
+	dateTimePtr := flag.String("datetime-format", "auto", "string")
+	localePtr := flag.String("locale", "", "string")
 
 	xlRowsPtr := flag.String("rows", "0:0", "string")
 	xlColsPtr := flag.String("cols", "0:0", "string")
 	xlSheetPtr := flag.String("sheet", "", "string")
+	dateSystemPtr := flag.String("xl-date-system", "auto", "string")
+
+	recursivePtr := flag.String("recursive", "N", "string")
+	watchPtr := flag.String("watch", "", "string")
+	watchIntervalPtr := flag.Duration("watch-interval", 10*time.Second, "duration")
+	reopenPtr := flag.String("reopen-on-eof", "N", "string")
+	fifoBatchPtr := flag.Int("fifo-batch", 0, "int")
+	includePtr := flag.String("include", "", "string")
+	excludePtr := flag.String("exclude", "", "string")
+	memberPtr := flag.String("member-pattern", "", "string")
+	schemaPolicyPtr := flag.String("schema-policy", "first", "string")
+	fileWorkersPtr := flag.Int("file-workers", 1, "int")
+	reproPtr := flag.String("repro", "N", "string")
+	seedPtr := flag.Int64("seed", 0, "int")
+	reproTimePtr := flag.String("repro-time", "", "string")
+	queryPtr := flag.String("query", "", "string")
+	seriesPtr := flag.String("series", "", "string")
+	apiKeyPtr := flag.String("api-key", "", "string")
+	yearsPtr := flag.String("years", "", "string")
+	censusForPtr := flag.String("census-for", "", "string")
+	censusInPtr := flag.String("census-in", "", "string")
+	srcHostPtr := flag.String("src-host", "", "string")
+	srcUserPtr := flag.String("src-user", "", "string")
+	srcPasswordPtr := flag.String("src-password", "", "string")
+	readerCmdPtr := flag.String("reader-cmd", "", "string")
+	transformCmdPtr := flag.String("transform-cmd", "", "string")
+	transformFmtPtr := flag.String("transform-format", "csv", "string")
+	derivePtr := flag.String("derive", "", "string")
+	extractPtr := flag.String("extract", "", "string")
+	recodePtr := flag.String("recode", "", "string")
+	decodePtr := flag.String("decode", "", "string")
+	upperPtr := flag.String("upper", "", "string")
+	fillPtr := flag.String("fill", "", "string")
+	rulePtr := flag.String("rule", "", "string")
+	ruleActionPtr := flag.String("rule-action", "fail", "string")
+	quarantinePtr := flag.String("quarantine", "", "string")
+	assertSortedPtr := flag.String("assert-sorted", "", "string")
+	assertActionPtr := flag.String("assert-action", "fail", "string")
+	assertUniquePtr := flag.String("assert-unique", "", "string")
+	assertInPtr := flag.String("assert-in", "", "string")
+	onMissPtr := flag.String("on-miss", "fail", "string")
+	onDuplicatePtr := flag.String("on-duplicate", "fail", "string")
+	uniqueBloomPtr := flag.Int("unique-bloom", 0, "int")
+	nullablePtr := flag.String("nullable", "N", "string")
+	notNullablePtr := flag.String("not-nullable", "", "string")
+	lowerPtr := flag.String("lower", "", "string")
+	titlePtr := flag.String("titlecase", "", "string")
+	wherePtr := flag.String("where", "", "string")
+	incrementalPtr := flag.String("incremental", "", "string")
+	tombstonesPtr := flag.String("tombstones", "N", "string")
+	runIDColPtr := flag.String("run-id-col", "N", "string")
+	summaryPtr := flag.String("summary", "", "string")
+	batchPtr := flag.Int("batch", 1000, "int")
+	sizeCheckPtr := flag.String("size-check", "Y", "string")
+	optimizePtr := flag.String("optimize", "N", "string")
+	maxPartsPtr := flag.Int("max-parts", 0, "int")
+	serverStatsPtr := flag.String("server-stats", "Y", "string")
+	auditPtr := flag.String("audit", "", "string")
+	existsPtr := flag.String("exists", "drop", "string")
+	useSchemaPtr := flag.String("use-table-schema", "N", "string")
+	keepPtr := flag.Duration("keep-backup", 0, "duration")
+	expectRowsPtr := flag.String("expect-rows", "", "string")
+	requirePtr := flag.String("require-cols", "", "string")
+	freshPtr := flag.String("expect-max-date", "", "string")
+	checksPtr := flag.String("checks", "", "string")
+	sampleNPtr := flag.Int("n", 1000, "int")
+	hashPtr := flag.String("hash", "", "string")
+	byPtr := flag.String("by", "", "string")
+	splitRowsPtr := flag.Int("split-rows", 0, "int")
+	splitBytesPtr := flag.Int64("split-bytes", 0, "int64")
+	outPtr := flag.String("out", "", "string")
+	keyPtr := flag.String("key", "", "string")
+	dirPtr := flag.String("dir", "", "string")
+	updatePtr := flag.String("update", "N", "string")
+	applyPtr := flag.String("apply", "N", "string")
+	backupLogPtr := flag.String("backup-log", "toch_backups", "string")
+	yesPtr := flag.Bool("yes", false, "bool")
+	rawPtr := flag.String("raw", "N", "string")
+	stripCtrlPtr := flag.String("strip-ctrl", "none", "string")
+	ctrlReplacePtr := flag.String("ctrl-replace", "", "string")
+	normalizePtr := flag.String("normalize", "none", "string")
+	normValuesPtr := flag.String("normalize-values", "N", "string")
+	allTypesPtr := flag.String("all-types", "", "string")
+	sparsePtr := flag.String("sparse", "N", "string")
+	pivotPtr := flag.String("pivot", "", "string")
+	pivotMaxPtr := flag.Int("pivot-max-cols", 100, "int")
+	dropEmptyPtr := flag.String("drop-empty-cols", "N", "string")
+	truncatePtr := flag.String("truncate-policy", "error", "string")
+	shrinkPtr := flag.String("shrink", "N", "string")
+	int64Ptr := flag.String("int64", "N", "string")
+	codecPtr := flag.String("codec", "", "string")
+	nullPtr := flag.String("null", "", "string")
+	badPtr := flag.String("bad", "", "string")
+	missingPtr := flag.String("missing", "", "string")
+	dryRunPtr := flag.String("dryrun", "N", "string")
+	dryRunRowsPtr := flag.Int("dryrun-rows", 100000, "int")
+	dryRunSamplePtr := flag.Int("dryrun-sample", 10, "int")
+	inferBoolPtr := flag.String("infer-bool", "N", "string")
+	boolTokensPtr := flag.String("bool-tokens", "y,yes,true,t,1/n,no,false,f,0", "string")
+	inferDecimalPtr := flag.String("infer-decimal", "N", "string")
+	flattenSepPtr := flag.String("flatten-sep", "_", "string")
+	decompressPtr := flag.String("decompress", "auto", "string")
+	debugPtr := flag.String("debug", "N", "string")
+	startBytePtr := flag.Int64("start-byte", 0, "int64")
+	startLinePtr := flag.Int("start-line", 0, "int")
+	endLinePtr := flag.Int("end-line", 0, "int")
+	autoStringPtr := flag.String("auto-string", "N", "string")
+	lcThresholdPtr := flag.Int("lc-threshold", 0, "int")
+	orderPtr := flag.String("preserve-order", "Y", "string")
+	listenPtr := flag.String("listen", "127.0.0.1:8080", "string")
+	serveTokenPtr := flag.String("serve-token", "", "string")
+	serveRootPtr := flag.String("serve-root", "", "string")
+	drainPtr := flag.Duration("drain-timeout", 10*time.Minute, "duration")
+	journalPtr := flag.String("journal", "N", "string")
+	jobsDBPtr := flag.String("jobs-db", "", "string")
+	maxJobsPtr := flag.Int("max-concurrent-jobs", 1, "int")
+	progressPtr := flag.Duration("progress", 0, "duration")
+	readonlyPtr := flag.String("readonly-check", "N", "string")
+	allowPtr := flag.String("allow-tables", "", "string")
+	denyPtr := flag.String("deny-tables", "", "string")
+	configPtr := flag.String("config", "", "string")
+	profilePtr := flag.String("profile", "", "string")
+	profileFilePtr := flag.String("profile-file", defaultProfiles(), "string")
 
 	flag.Parse()
+	// fill in options from the config file and then the profile
+	var cfg *config
+	if *configPtr != "" {
+		var e error
+		if cfg, e = loadConfig(*configPtr); e != nil {
+			panic(e)
+		}
+		if e := cfg.apply(); e != nil {
+			panic(e)
+		}
+	}
+	settings := clickhouse.Settings{"max_memory_usage": 40000000000}
+	if *profilePtr != "" {
+		p, e := loadProfile(*profileFilePtr, *profilePtr)
+		if e != nil {
+			panic(e)
+		}
+		if e := p.apply(); e != nil {
+			panic(e)
+		}
+		for k, v := range p.Settings {
+			settings[k] = v
+		}
+	}
+
+	// fetch the password from a secret store
+	if *passwordSourcePtr != "" && *passwordPtr == "" {
+		pw, e := resolvePassword(*passwordSourcePtr)
+		if e != nil {
+			panic(e)
+		}
+		*passwordPtr = pw
+	}
+
+	if !isIn(tlsPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-tls option is Y or N"))
+	}
+	*compressPtr = strings.ToLower(*compressPtr)
+	if _, ok := compressions[*compressPtr]; !ok {
+		help()
+		panic(fmt.Errorf("-compress option is none, lz4 or zstd"))
+	}
+
+	if *maxJobsPtr < 1 {
+		panic(fmt.Errorf("-max-concurrent-jobs must be positive"))
+	}
+	if !isIn(journalPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-journal option is Y or N"))
+	}
+	if *journalPtr == "y" {
+		if e := journalOK(); e != nil {
+			panic(e)
+		}
+	}
+
+	s := time.Now()
+	runID := newRunID()
+	fmt.Printf("run id: %s\n", runID)
+
+	// The run id is recorded with each query in system.query_log.
+	settings["log_comment"] = "toch run " + runID
+	cs := &connSpec{host: *hostPtr, port: *portPtr, tls: *tlsPtr == "y", user: *userPtr, password: *passwordPtr, settings: settings,
+		compress: *compressPtr, level: *compressLevelPtr}
+
+	if cmd != "" && !isIn(&cmd, loadCommands, false) {
+		if e := runCommand(cmd, cmdArgs, cs, &cmdOptions{backupLog: *backupLogPtr, listen: *listenPtr, drain: *drainPtr, journal: *journalPtr == "y", jobsDB: *jobsDBPtr,
+			maxJobs: *maxJobsPtr, token: *serveTokenPtr, root: *serveRootPtr}); e != nil {
+			panic(e)
+		}
+		return
+	}
+
+	// the type of a selftest fixture is given by its extension, unless -type is given
+	typeSet := *sTypePtr != ""
+	if cmd == "selftest" && !typeSet {
+		*sTypePtr = "csv"
+	}
+
+	// the clipboard is read once, into a file
+	if *sourcePtr == clipboardSource {
+		tmp, e := spoolClipboard()
+		if e != nil {
+			panic(e)
+		}
+		defer func() { _ = os.Remove(tmp) }()
+		*sourcePtr = tmp
+	}
+
+	// before connecting to ClickHouse, make sure an S3 source can be copied
+	if isS3(*sourcePtr) {
+		if e := checkS3Client(); e != nil {
+			panic(e)
+		}
+	}
+
+	// a named pipe is read once, into a file, unless -reopen-on-eof reads it until interrupted
+	if !isIn(reopenPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-reopen-on-eof option is Y or N"))
+	}
+	fifo := isFIFO(*sourcePtr)
+	if *reopenPtr == "y" {
+		switch {
+		case !fifo:
+			panic(fmt.Errorf("-reopen-on-eof needs -s to be a named pipe"))
+		case *sTypePtr == "" || strings.EqualFold(*sTypePtr, "auto"):
+			panic(fmt.Errorf("-reopen-on-eof needs -type: sniffing would take the data of the first writer"))
+		case !isIn(sTypePtr, []string{"text", "csv", "ndjson"}, true):
+			panic(fmt.Errorf("-reopen-on-eof reads lines: -type must be text, csv or ndjson"))
+		case strings.EqualFold(*dryRunPtr, "y"):
+			panic(fmt.Errorf("-dryrun can't be used with -reopen-on-eof"))
+		case *fifoBatchPtr < 0:
+			panic(fmt.Errorf("-fifo-batch can't be negative"))
+		}
+	}
+	if fifo && *reopenPtr != "y" {
+		ext := strings.ToLower(*sTypePtr)
+		if ext == "" || ext == "auto" {
+			ext = "txt"
+		}
+		tmp, e := spoolFIFO(*sourcePtr, ext)
+		if e != nil {
+			panic(e)
+		}
+		defer func() { _ = os.Remove(tmp) }()
+		*sourcePtr = tmp
+	}
+
+	// -type auto, the default for a source, sniffs the type, delimiter and header row
+	if *sTypePtr == "" && *sourcePtr != "" {
+		*sTypePtr = "auto"
+	}
+	if strings.EqualFold(*sTypePtr, "auto") {
+		if strings.EqualFold(*recursivePtr, "y") || *watchPtr != "" || isTar(*sourcePtr) || isGlob(*sourcePtr) {
+			panic(fmt.Errorf("-type auto sniffs a single file or URL: give -type for -recursive, -watch, a pattern or an archive"))
+		}
+		if *sourcePtr == stdinSource {
+			tmp, e := spoolStdin("txt")
+			if e != nil {
+				panic(e)
+			}
+			defer func() { _ = os.Remove(tmp) }()
+			*sourcePtr = tmp
+		}
+		sw, e := newWebRequest(*agentPtr, *httpMethodPtr, *httpBodyPtr, *httpTypePtr)
+		if e != nil {
+			panic(e)
+		}
+		sn, e := autoType(*sourcePtr, sw)
+		if e != nil {
+			panic(e)
+		}
+		fmt.Printf("-type auto: %s\n", sn)
+		*sTypePtr = sn.sType
+		if *delimPtr == "" {
+			*delimPtr = sn.delim
+		}
+		// fields without a header row are named col_1, col_2...
+		if !sn.header && sn.fields > 0 && *headerPtr == "" {
+			names := make([]string, sn.fields)
+			for ind := range names {
+				names[ind] = fmt.Sprintf("col_%d", ind+1)
+			}
+			*headerPtr = strings.Join(names, ",")
+		}
+	}
+
 	// work through the flags
 	headers, fieldTypes, camel, ignore, quote, xlArea, err :=
 		flags(sTypePtr, camelPtr, headerPtr, fieldPtr, quotePtr, xlRowsPtr, xlColsPtr, skipPtr, ignorePtr)
@@ -165,9 +673,466 @@ func main() {
 		help() // print help string
 		panic(err)
 	}
+	delim, err := newDelim(*delimPtr, quote)
+	if err != nil {
+		help()
+		panic(err)
+	}
+	if delim != "" && *sTypePtr != "text" && *sTypePtr != "csv" {
+		help()
+		panic(fmt.Errorf("-sep needs -type text or csv"))
+	}
+	if !isIn(recursivePtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-recursive option is Y or N"))
+	}
+	if (isIn(sTypePtr, warehouses, false) || *sTypePtr == "chquery") && *queryPtr == "" {
+		help()
+		panic(fmt.Errorf("-type %s requires -query", *sTypePtr))
+	}
+	// the types of the results of a warehouse query come from the warehouse
+	if isIn(sTypePtr, warehouses, false) && len(fieldTypes) == 0 && *allTypesPtr == "" {
+		wt, e := warehouseTypes(*sTypePtr, *queryPtr)
+		if e != nil {
+			fmt.Printf("warning: the types of the query's columns are imputed, since the warehouse didn't give them: %v\n", e)
+		}
+		fieldTypes = wt
+		// both warehouses write dates as 2006-01-02
+		given := false
+		flag.Visit(func(f *flag.Flag) { given = given || f.Name == "dateFormat" })
+		if !given {
+			*datePtr = "2006-01-02"
+		}
+	}
+	var api *apiSpec
+	if isIn(sTypePtr, apis, false) {
+		api = &apiSpec{series: splitList(*seriesPtr), geoFor: *censusForPtr, geoIn: *censusInPtr}
+		if api.key, err = resolveSecret(*apiKeyPtr); err != nil {
+			panic(err)
+		}
+		if api.years, err = parseYears(*yearsPtr); err != nil {
+			panic(err)
+		}
+		switch {
+		case len(api.series) == 0:
+			panic(fmt.Errorf("-type %s requires -series", *sTypePtr))
+		case *sTypePtr == "fred" && api.key == "":
+			panic(fmt.Errorf("-type fred requires -api-key"))
+		case *sTypePtr == "census" && (*sourcePtr == "" || api.geoFor == ""):
+			panic(fmt.Errorf("-type census requires the dataset (-s) and -census-for"))
+		}
+		if len(fieldTypes) == 0 && *allTypesPtr == "" {
+			fieldTypes = apiTypes(*sTypePtr)
+		}
+	}
+	if *fileWorkersPtr < 1 {
+		panic(fmt.Errorf("-file-workers must be positive"))
+	}
+	if !isIn(reproPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-repro option is Y or N"))
+	}
+	if *reproPtr == "y" {
+		// rows and files are loaded in order
+		*orderPtr, *fileWorkersPtr = "y", 1
+	}
+	if !isIn(schemaPolicyPtr, schemaPolicies, true) {
+		help()
+		panic(fmt.Errorf("-schema-policy is first, union or strict"))
+	}
+	if !isIn(transformFmtPtr, transformFormats, true) {
+		help()
+		panic(fmt.Errorf("-transform-format is csv or json"))
+	}
+	derive, err := parseAssignments(*derivePtr)
+	if err != nil {
+		panic(fmt.Errorf("-derive: %v", err))
+	}
+	if !isIn(nullablePtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-nullable option is Y or N"))
+	}
+	refs, err := parseReferences(*assertInPtr)
+	if err != nil {
+		panic(err)
+	}
+	if !isIn(onMissPtr, missActions, true) {
+		help()
+		panic(fmt.Errorf("-on-miss is fail, warn, drop or quarantine"))
+	}
+	// the -assert-in rules are added once there is a connection to read the values from
+	var rowRules *rules
+	if *rulePtr != "" || len(refs) > 0 {
+		if !isIn(ruleActionPtr, ruleActions, true) {
+			help()
+			panic(fmt.Errorf("-rule-action is fail, drop or quarantine"))
+		}
+		rowRules = &rules{action: *ruleActionPtr, quarantine: *quarantinePtr}
+		if rowRules.quarantine == "" {
+			rowRules.quarantine = *tablePtr + "_quarantine.csv"
+		}
+		if rowRules.checks, err = parseRules(*rulePtr); err != nil {
+			panic(fmt.Errorf("-rule %v", err))
+		}
+	}
+	if !isIn(assertActionPtr, assertActions, true) {
+		help()
+		panic(fmt.Errorf("-assert-action is fail or warn"))
+	}
+	fills, err := parseFills(*fillPtr)
+	if err != nil {
+		panic(fmt.Errorf("-fill: %v", err))
+	}
+	// extracted fields come first, so -derive can use them
+	extract, err := parseExtract(*extractPtr)
+	if err != nil {
+		panic(fmt.Errorf("-extract: %v", err))
+	}
+	derive = append(extract, derive...)
+	decode, err := parseDecode(*decodePtr)
+	if err != nil {
+		help()
+		panic(err)
+	}
+	recode, err := parseAssignments(*recodePtr)
+	if err != nil {
+		panic(fmt.Errorf("-recode: %v", err))
+	}
+	if *allTypesPtr != "" {
+		*allTypesPtr = strings.ToLower(*allTypesPtr)
+		if !validType(*allTypesPtr) {
+			help()
+			panic(fmt.Errorf("-all-types is one of %s or fs(n)", strings.Join(ftypes, ", ")))
+		}
+		if len(fieldTypes) > 0 {
+			panic(fmt.Errorf("-all-types and -t cannot both be given"))
+		}
+	}
+	scrub, err := scrubber(*stripCtrlPtr, *ctrlReplacePtr)
+	if err != nil {
+		help()
+		panic(err)
+	}
+	pivot, err := parsePivot(*pivotPtr)
+	if err != nil {
+		panic(err)
+	}
+	if pivot != nil && (*recursivePtr == "y" || isTar(*sourcePtr) || isGlob(*sourcePtr)) {
+		panic(fmt.Errorf("-pivot loads a single source"))
+	}
+	if !isIn(dropEmptyPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-drop-empty-cols option is Y or N"))
+	}
+	if !isIn(sparsePtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-sparse option is Y or N"))
+	}
+	if !isIn(truncatePtr, truncatePolicies, true) {
+		help()
+		panic(fmt.Errorf("-truncate-policy is one of %s", strings.Join(truncatePolicies, ", ")))
+	}
+	if !isIn(normalizePtr, normForms, true) {
+		help()
+		panic(fmt.Errorf("-normalize is one of %s", strings.Join(normForms, ", ")))
+	}
+	if !isIn(normValuesPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-normalize-values option is Y or N"))
+	}
+	if !isIn(rawPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-raw option is Y or N"))
+	}
+	if *rawPtr == "y" && (*sTypePtr != "text" && *sTypePtr != "csv" || *readerCmdPtr != "" || *transformCmdPtr != "") {
+		panic(fmt.Errorf("-raw loads the lines of text and csv sources; it can't be used with -reader-cmd or -transform-cmd"))
+	}
+	if !isIn(optimizePtr, optimizeModes, true) {
+		help()
+		panic(fmt.Errorf("-optimize is N, Y, final or partition"))
+	}
+	if !isIn(sizeCheckPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-size-check option is Y or N"))
+	}
+	if *batchPtr < 1 {
+		panic(fmt.Errorf("-batch must be positive"))
+	}
+	if !isIn(serverStatsPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-server-stats option is Y or N"))
+	}
+	if !isIn(shrinkPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-shrink option is Y or N"))
+	}
+	if !isIn(int64Ptr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-int64 option is Y or N"))
+	}
+	if !isIn(inferBoolPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-infer-bool option is Y or N"))
+	}
+	nulls, err := parseNull(*nullPtr)
+	if err != nil {
+		panic(err)
+	}
+	if *badPtr != "" && (*rawPtr == "y" || *sTypePtr == "chquery") {
+		panic(fmt.Errorf("-bad can't be used with -raw or -type chquery, whose rows aren't converted by toch"))
+	}
+	missing, err := parseMissing(*missingPtr)
+	if err != nil {
+		panic(err)
+	}
+	codecs, err := parseCodecs(*codecPtr)
+	if err != nil {
+		panic(err)
+	}
+	if !isIn(dryRunPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-dryrun option is Y or N"))
+	}
+	if *dryRunRowsPtr < 1 {
+		help()
+		panic(fmt.Errorf("-dryrun-rows must be positive"))
+	}
+	if *dryRunSamplePtr < 0 {
+		help()
+		panic(fmt.Errorf("-dryrun-sample can't be negative"))
+	}
+	boolTokens, err := parseBoolTokens(*boolTokensPtr)
+	if err != nil {
+		help()
+		panic(err)
+	}
+	if !isIn(inferDecimalPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-infer-decimal option is Y or N"))
+	}
+	if !isIn(debugPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-debug option is Y or N"))
+	}
+	loc, err := newLocale(*localePtr)
+	if err != nil {
+		help()
+		panic(err)
+	}
+	// the dates of a locale are read as 2006-01-02
+	if loc != nil {
+		given := false
+		flag.Visit(func(f *flag.Flag) { given = given || f.Name == "dateFormat" })
+		if !given {
+			*datePtr = "2006-01-02"
+		}
+	}
+	rg, err := newRegion(*startBytePtr, *startLinePtr, *endLinePtr)
+	if err != nil {
+		help()
+		panic(err)
+	}
+	if !isIn(dateSystemPtr, dateSystems, true) {
+		help()
+		panic(fmt.Errorf("-xl-date-system option is auto, 1900 or 1904"))
+	}
+	if !isIn(decompressPtr, decompressions, true) {
+		help()
+		panic(fmt.Errorf("-decompress option is one of %s", strings.Join(decompressions, ", ")))
+	}
+	if !isIn(autoStringPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-auto-string option is Y or N"))
+	}
+	if *lcThresholdPtr < 0 {
+		help()
+		panic(fmt.Errorf("-lc-threshold must be at least 0"))
+	}
+	if !isIn(orderPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-preserve-order option is Y or N"))
+	}
+	if !isIn(existsPtr, existsModes, true) {
+		help()
+		panic(fmt.Errorf("-exists option is drop, fail, append or replace"))
+	}
+	if *incrementalPtr != "" {
+		*existsPtr = "append"
+	}
+	if !isIn(useSchemaPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-use-table-schema option is Y or N"))
+	}
+	if !isIn(readonlyPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-readonly-check option is Y or N"))
+	}
+	if *dbPtr == "" || strings.ContainsAny(*dbPtr, ".` ") {
+		help()
+		panic(fmt.Errorf("-db is the name of a database"))
+	}
+	if !isIn(createDBPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-create-db option is Y or N"))
+	}
+	// the tables loaded, which are in -db unless they name their database
+	*tablePtr = qualifyTable(*tablePtr, *dbPtr)
+	tables := []string{*tablePtr}
+	if cfg != nil && len(cfg.Targets) > 0 {
+		if *recursivePtr == "y" || isTar(*sourcePtr) || isGlob(*sourcePtr) || *rawPtr == "y" || *sTypePtr == "chquery" || *keepPtr > 0 {
+			panic(fmt.Errorf("config file targets can't be used with -recursive, archives, patterns, -raw, chquery or -keep-backup"))
+		}
+		tables = tables[:0]
+		for ind := range cfg.Targets {
+			cfg.Targets[ind].Table = qualifyTable(cfg.Targets[ind].Table, *dbPtr)
+			tables = append(tables, cfg.Targets[ind].Table)
+		}
+	}
+	if *readonlyPtr == "y" {
+		// so are the patterns
+		allow, deny := splitList(*allowPtr), splitList(*denyPtr)
+		for ind := range allow {
+			allow[ind] = qualifyTable(allow[ind], *dbPtr)
+		}
+		for ind := range deny {
+			deny[ind] = qualifyTable(deny[ind], *dbPtr)
+		}
+		for _, table := range tables {
+			if e := checkTable(table, allow, deny); e != nil {
+				panic(e)
+			}
+		}
+	}
+	if !isIn(runIDColPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-run-id-col option is Y or N"))
+	}
+	if *runIDColPtr == "y" {
+		derive = append(derive, assignment{name: "_runId", x: &template{"run_id"}})
+	}
+	if !isIn(tombstonesPtr, ctypes, true) {
+		help()
+		panic(fmt.Errorf("-tombstones option is Y or N"))
+	}
+	if *tombstonesPtr == "y" {
+		if *incrementalPtr == "" || *keyPtr == "" {
+			panic(fmt.Errorf("-tombstones needs -incremental and -key"))
+		}
+		derive = append(derive, assignment{name: "_deleted", x: &literal{0.0}}, assignment{name: "_asof", x: &template{"now"}})
+	}
+	web, err := newWebRequest(*agentPtr, *httpMethodPtr, *httpBodyPtr, *httpTypePtr)
+	if err != nil {
+		panic(err)
+	}
+	if web.oauth, err = newOAuthClient(*oauthURLPtr, *oauthIDPtr, *oauthSecretPtr); err != nil {
+		panic(err)
+	}
+	expect, err := parseRowRange(*expectRowsPtr)
+	if err != nil {
+		panic(err)
+	}
+	fresh, err := parseFreshness(*freshPtr)
+	if err != nil {
+		panic(err)
+	}
+	if !isIn(onDuplicatePtr, duplicateActions, true) {
+		help()
+		panic(fmt.Errorf("-on-duplicate is fail, warn or dedup"))
+	}
+	if *uniqueBloomPtr < 0 {
+		help()
+		panic(fmt.Errorf("-unique-bloom must be at least 0"))
+	}
+	unique := newUniqueKeys(splitList(*assertUniquePtr), *onDuplicatePtr, *uniqueBloomPtr)
+	var checks *suite
+	if *checksPtr != "" {
+		if checks, err = loadSuite(*checksPtr); err != nil {
+			panic(err)
+		}
+	}
+	var where expr
+	if *wherePtr != "" {
+		if where, err = parseExpr(*wherePtr); err != nil {
+			panic(fmt.Errorf("-where: %v", err))
+		}
+	}
 
-	// connect to ClickHouse
-	con, err := chutils.NewConnect(*hostPtr, *userPtr, *passwordPtr, clickhouse.Settings{"max_memory_usage": 40000000000})
+	// the data piped to toch is read more than once, so it's spooled to a file
+	if *sourcePtr == stdinSource {
+		if *recursivePtr == "y" {
+			panic(fmt.Errorf("-s - can't be used with -recursive"))
+		}
+		tmp, e := spoolStdin(*sTypePtr)
+		if e != nil {
+			panic(e)
+		}
+		defer func() { _ = os.Remove(tmp) }()
+		*sourcePtr = tmp
+	}
+
+	j := &job{runID: runID, start: s, now: s, source: *sourcePtr, web: web, sType: *sTypePtr, dateFmt: *datePtr, dateTimeFmt: *dateTimePtr, table: *tablePtr,
+		xlSheet: *xlSheetPtr, skip: *skipPtr, quote: quote, delim: delim, camel: camel, ignore: ignore, headers: headers,
+		fieldTypes: fieldTypes, allTypes: *allTypesPtr, truncate: *truncatePtr, xlArea: xlArea, query: *queryPtr, api: api, readerCmd: *readerCmdPtr,
+		transformCmd: *transformCmdPtr, transformFmt: *transformFmtPtr, derive: derive, recode: recode, decode: decode, cases: parseCases(*upperPtr, *lowerPtr, *titlePtr), fills: fills, rules: rowRules, assertSorted: strings.Trim(*assertSortedPtr, " '`"), assertAction: *assertActionPtr, nullable: *nullablePtr == "y", notNullable: splitList(*notNullablePtr), where: where,
+		batch: *batchPtr, sparse: *sparsePtr == "y",
+		dropEmpty: *dropEmptyPtr == "y", pivot: pivot, pivotMax: *pivotMaxPtr, raw: *rawPtr == "y", scrub: scrub, normalize: normalizer(*normalizePtr),
+		normValues: *normValuesPtr == "y" && *normalizePtr != "none", preserveOrder: *orderPtr == "y", shrink: *shrinkPtr == "y", wideInts: *int64Ptr == "y", inferBool: *inferBoolPtr == "y", boolTokens: boolTokens, codecs: codecs, nulls: nulls, missing: missing, bad: *badPtr, journal: *journalPtr == "y", progress: newProgressMeter(*progressPtr), inferDecimal: *inferDecimalPtr == "y", flattenSep: *flattenSepPtr, decompress: *decompressPtr, debug: *debugPtr == "y", locale: loc, dateSystem: *dateSystemPtr, region: rg, s3: &s3Opts{region: *s3RegionPtr, profile: *s3ProfilePtr},
+		autoString: *autoStringPtr == "y", lcThreshold: *lcThresholdPtr, schemaPolicy: *schemaPolicyPtr, useTableSchema: *useSchemaPtr == "y",
+		fileWorkers: *fileWorkersPtr, required: splitList(*requirePtr),
+		fresh: fresh, unique: unique}
+	if cfg != nil {
+		j.coercion = cfg.Coercion
+	}
+	if *reproPtr == "y" {
+		if j.repro, err = newRepro(*seedPtr, *reproTimePtr, s); err != nil {
+			panic(err)
+		}
+		j.now = j.repro.Now
+	}
+	if cmd == "selftest" {
+		if !isIn(updatePtr, ctypes, true) {
+			panic(fmt.Errorf("-update option is Y or N"))
+		}
+		if e := selftest(j, cmdArgs, &selftestOpts{dir: *dirPtr, update: *updatePtr == "y", typeSet: typeSet}); e != nil {
+			panic(e)
+		}
+		return
+	}
+	if cmd == "sample" {
+		if e := sample(j, cmdArgs, &sampleOpts{n: *sampleNPtr, hash: splitList(*hashPtr), out: *outPtr}); e != nil {
+			panic(e)
+		}
+		return
+	}
+	if cmd == "cat" {
+		// cat unions the fields of the files unless told otherwise
+		policy := "union"
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "schema-policy" {
+				policy = *schemaPolicyPtr
+			}
+		})
+		if e := cat(j, cmdArgs, &catOpts{out: *outPtr, policy: policy}); e != nil {
+			panic(e)
+		}
+		return
+	}
+	if cmd == "split" {
+		if e := split(j, cmdArgs, &splitOpts{by: splitList(*byPtr), rows: *splitRowsPtr, bytes: *splitBytesPtr, out: *outPtr}); e != nil {
+			panic(e)
+		}
+		return
+	}
+
+	if *dryRunPtr == "y" {
+		if e := dryRun(j, *dryRunRowsPtr, *dryRunSamplePtr); e != nil {
+			panic(e)
+		}
+		return
+	}
+
+	// connect to ClickHouse.
+	con, err := cs.connect()
 	if err != nil {
 		panic(err)
 	}
@@ -176,20 +1141,371 @@ func main() {
 			fmt.Println(e)
 		}
 	}()
+	server, err := checkServer(con)
+	if err != nil {
+		panic(err)
+	}
+	if e := checkDatabases(tables, *createDBPtr == "y", con); e != nil {
+		panic(e)
+	}
 
-	s := time.Now()
-	rdr, err := buildReader(*sourcePtr, *agentPtr, *sTypePtr, *datePtr, *skipPtr, quote, camel, headers, fieldTypes, xlArea, *xlSheetPtr, *tablePtr, con)
+	// the ClickHouse server that runs -query for -type chquery
+	src := con
+	if *sTypePtr == "chquery" && (*srcHostPtr != "" || *srcUserPtr != "" || *srcPasswordPtr != "") {
+		scs := *cs
+		if *srcHostPtr != "" {
+			scs.host, scs.port, scs.tls = *srcHostPtr, 9000, false
+		}
+		if *srcUserPtr != "" {
+			scs.user, scs.password = *srcUserPtr, *srcPasswordPtr
+		}
+		if src, err = scs.connect(); err != nil {
+			panic(err)
+		}
+		defer func() {
+			if e := src.Close(); e != nil {
+				fmt.Println(e)
+			}
+		}()
+	}
+
+	j.server, j.src = server, src
+	for _, r := range refs {
+		rl, e := r.rule(*onMissPtr, con)
+		if e != nil {
+			panic(e)
+		}
+		j.rules.checks = append(j.rules.checks, rl)
+	}
+	if cmd == "diff" {
+		if !isIn(applyPtr, ctypes, true) {
+			panic(fmt.Errorf("-apply option is Y or N"))
+		}
+		if e := diff(j, cmdArgs, &diffOpts{key: splitList(*keyPtr), out: *outPtr, apply: *applyPtr == "y"}, con); e != nil {
+			panic(e)
+		}
+		return
+	}
+	// the keys of the table are marked deleted only once there is a table
+	tombstones := false
+	if *incrementalPtr != "" {
+		x, e := incremental(*incrementalPtr, *tablePtr, con)
+		if e != nil {
+			panic(e)
+		}
+		tombstones = x != nil && *tombstonesPtr == "y"
+		if x != nil && j.where != nil {
+			x = &binary{op: "and", l: j.where, r: x}
+		}
+		if x != nil {
+			j.where = x
+		}
+	}
+
+	if *reopenPtr == "y" {
+		if *recursivePtr == "y" || *watchPtr != "" || (cfg != nil && len(cfg.Targets) > 0) || cmd != "" {
+			panic(fmt.Errorf("-reopen-on-eof loads a named pipe, so -recursive, -watch, config targets and commands can't be used with it"))
+		}
+		opts := &fifoOpts{sType: *sTypePtr, header: *headerPtr == "" && *sTypePtr != "ndjson", batch: *fifoBatchPtr}
+		if e := fifoLoop(j, opts, con); e != nil {
+			panic(e)
+		}
+		return
+	}
+
+	if *watchPtr != "" {
+		if *sourcePtr != "" || *recursivePtr == "y" || (cfg != nil && len(cfg.Targets) > 0) || cmd != "" {
+			panic(fmt.Errorf("-watch loads the files of its directory, so -s, -recursive, config targets and commands can't be used with it"))
+		}
+		if *watchIntervalPtr <= 0 {
+			panic(fmt.Errorf("-watch-interval must be positive"))
+		}
+		opts := &watchOpts{dir: *watchPtr, interval: *watchIntervalPtr, include: splitList(*includePtr), exclude: splitList(*excludePtr)}
+		if e := watchDir(j, opts, con); e != nil {
+			panic(e)
+		}
+		return
+	}
+
+	chained := cfg != nil && len(cfg.Targets) > 0
+	// the files of a multi-file load are checked as they are loaded
+	if *recursivePtr != "y" && !isTar(*sourcePtr) && !isGlob(*sourcePtr) && *sTypePtr != "chquery" && *rawPtr != "y" {
+		if e := j.checkRequired(); e != nil {
+			panic(e)
+		}
+	}
+	// with -exists replace, the load goes to a staging table that replaces the table once it succeeds
+	staging := ""
+	if *existsPtr == "replace" {
+		if chained {
+			panic(fmt.Errorf("-exists replace can't be used with config file targets"))
+		}
+		staging = stagingName(*tablePtr, runID)
+		if _, e := con.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", staging)); e != nil {
+			panic(e)
+		}
+		j.table, tables = staging, []string{staging}
+	}
+	if !chained {
+		// an existing table that is kept as a backup needn't be confirmed
+		if e := checkExists(*tablePtr, *existsPtr, *yesPtr || *keepPtr > 0, con); e != nil {
+			panic(e)
+		}
+		exists, _, _, e := tableInfo(j.table, con)
+		if e != nil {
+			panic(e)
+		}
+		j.appending = exists && *existsPtr == "append"
+	}
+	if e := cleanBackups(*backupLogPtr, con); e != nil {
+		panic(e)
+	}
+	// a table that is appended to is copied, so the rows of the run can be taken out again
+	if *keepPtr > 0 && staging == "" {
+		if e := backup(*tablePtr, runID, *keepPtr, j.appending, *backupLogPtr, con); e != nil {
+			panic(e)
+		}
+	}
+
+	if *sizeCheckPtr == "y" {
+		size, unknown, e := sourceSize(j, *recursivePtr == "y", splitList(*includePtr), splitList(*excludePtr))
+		if e != nil {
+			panic(e)
+		}
+		if unknown != "" {
+			fmt.Printf("the size of the source isn't known (%s), so the free space on the server isn't checked\n", unknown)
+		}
+		if e := checkSize(size, con); e != nil {
+			panic(e)
+		}
+	}
+	if e := checkParts(j, *maxPartsPtr); e != nil {
+		panic(e)
+	}
+
+	var results []fileResult
+	switch {
+	case chained:
+		results, err = loadTargets(j, cfg.Targets, *existsPtr, *yesPtr, con)
+	case *recursivePtr == "y":
+		results, err = loadDir(j, splitList(*includePtr), splitList(*excludePtr), con)
+	case isTar(*sourcePtr):
+		results, err = loadTar(j, splitList(*memberPtr), con)
+	case isGlob(*sourcePtr):
+		results, err = loadGlob(j, con)
+	default:
+		results = []fileResult{j.loadFile(nil, con)}
+		err = results[0].err
+	}
+	if len(results) > 1 || (isGlob(*sourcePtr) && len(results) > 0) {
+		err = summarize(results)
+	}
+	if unique != nil {
+		unique.finish()
+	}
+	// the checks of the data loaded
+	if err == nil && expect != nil {
+		err = expectRows(expect, results, tables, chained)
+	}
+	if err == nil && fresh != nil {
+		err = fresh.check(*datePtr)
+	}
+	if err == nil && checks != nil {
+		// with -run-id-col, only the rows of this run are checked
+		thisRun := ""
+		if *runIDColPtr == "y" {
+			thisRun = runID
+		}
+		for _, table := range tables {
+			if err = checks.run(table, thisRun, con); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil && tombstones {
+		err = tombstone(j, splitList(*keyPtr), where, con)
+	}
+	if err != nil && (expect != nil || fresh != nil || checks != nil) && *keepPtr > 0 && staging == "" {
+		// put back the table the load replaced or appended to
+		if e := undo(runID, *backupLogPtr, con); e != nil {
+			fmt.Println(e)
+		}
+	}
+
+	if staging != "" {
+		if err == nil {
+			err = replaceTable(staging, *tablePtr, runID, *keepPtr, *backupLogPtr, con)
+		}
+		if err != nil {
+			fmt.Printf("table %s left as it was\n", *tablePtr)
+			if _, e := con.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", staging)); e != nil {
+				fmt.Println(e)
+			}
+		}
+		j.table, tables = *tablePtr, []string{*tablePtr}
+	}
+
+	if err == nil && *optimizePtr != "n" {
+		for _, table := range tables {
+			if err = optimize(table, *optimizePtr, s, con); err != nil {
+				break
+			}
+		}
+	}
+
+	rs := newSummary(j, results, err)
+	if *serverStatsPtr == "y" {
+		if e := rs.serverStats(con); e != nil {
+			fmt.Println("warning: cannot get the server metrics from system.query_log:", e)
+		} else {
+			// the data is loaded, so only warn
+			_ = partsGuard(int(rs.Server.Parts), "created", 0, *batchPtr, rs.Rows)
+		}
+	}
+	if *summaryPtr != "" {
+		if e := rs.save(*summaryPtr); e != nil {
+			fmt.Println(e)
+		}
+	}
+	if *auditPtr != "" {
+		if e := rs.audit(*auditPtr, con); e != nil {
+			fmt.Println(e)
+		}
+	}
 	if err != nil {
 		panic(err)
 	}
+	fmt.Printf("run %s elapsed time: %s\n", runID, elapsed(s))
+}
+
+// job holds the digested command line options needed to load a source into a table.
+type job struct {
+	runID      string    // unique identifier of this run of toch
+	start      time.Time // start of the run
+	now        time.Time // the time of the run given by now() and {now}: the start, or -repro-time
+	repro      *repro    // the choices of a -repro run, nil if not -repro
+	source     string
+	web        *webRequest // how to request a web source
+	sType      string
+	dateFmt    string
+	table      string
+	xlSheet    string
+	skip       int
+	quote      rune
+	delim      string // field delimiter of text and csv sources, "" for the type's
+	camel      bool
+	ignore     bool
+	headers    []string
+	fieldTypes []string
+	allTypes   string     // type of every field, if fieldTypes is empty
+	raw        bool       // load each line as a String without parsing it
+	truncate   string     // -truncate-policy for FixedString fields
+	sparse     bool       // give fields that are nearly all empty a DEFAULT
+	dropEmpty  bool       // leave out the fields that are always empty
+	pivot      *pivotSpec // pivot the data from long to wide, if not nil
+	pivotMax   int        // maximum number of columns the pivot may create
+	xlArea     []int
+	body       []byte           // if not nil, the data, already read from source
+	query      string           // query for warehouse and chquery sources
+	api        *apiSpec         // options of an API source
+	src        *chutils.Connect // ClickHouse server that runs the query for chquery sources
+	readerCmd  string           // user-supplied command that reads the source
+	// user-supplied command that transforms the rows and the format (csv, json) of the rows sent to it
+	transformCmd, transformFmt string
+	scrub                      func(string) string    // replaces control characters, nil if none
+	normalize                  func(string) string    // Unicode normalization of the field names, nil if none
+	normValues                 bool                   // normalize the values, too
+	derive                     []assignment           // fields to add to the data
+	recode                     []assignment           // new values for source fields
+	decode                     []fieldMap             // percent-encoded and HTML-escaped values to decode
+	cases                      []fieldMap             // fields whose values are upper-, lower- or title-cased
+	fills                      []fill                 // values that replace blanks and other values of source fields
+	rules                      *rules                 // checks of the converted rows, nil if none
+	nullable                   bool                   // make the fields Nullable, loading empty and illegal values as NULL
+	notNullable                []string               // with nullable, fields that aren't made Nullable
+	assertSorted               string                 // field whose values must not decrease, "" if none
+	assertAction               string                 // what is done when an assertion fails: fail or warn
+	where                      expr                   // rows are loaded only if where is true
+	server                     serverVersion          // version of the ClickHouse server loaded into
+	batch                      int                    // rows per insert
+	appending                  bool                   // add the rows to the existing table rather than creating it
+	useTableSchema             bool                   // when appending, take the fields' types from the table
+	preserveOrder              bool                   // insert the rows in the order of the source
+	debug                      bool                   // describe the rows that fail to read or convert
+	decompress                 string                 // compression of the source: auto, none, gzip, zip or bz2
+	dateTimeFmt                string                 // format of the DateTime fields: a Go layout, auto, epoch or epoch_ms
+	inferDecimal               bool                   // make Float fields whose values have the same scale Decimals
+	decimals                   map[int]decimal        // Decimal fields found by -infer-decimal, by index
+	region                     *region                // the part of the source file to load, nil if all of it
+	dateSystem                 string                 // date system of Excel serial dates: auto, 1900 or 1904
+	epoch                      time.Time              // epoch of the serial dates of an Excel source, zero if not Excel
+	locale                     *locale                // how numbers and dates are written in the source, nil if as toch reads them
+	s3                         *s3Opts                // options of S3 sources
+	parquet                    []column               // the columns of a Parquet source, with their types
+	flattenSep                 string                 // separator of the names of the fields of nested JSON objects
+	shrink                     bool                   // narrow the imputed numeric fields to the smallest type that fits
+	wideInts                   bool                   // impute integer fields as Int64 rather than the smallest Int that fits
+	inferBool                  bool                   // make fields whose values are all boolTokens Bools
+	boolTokens                 map[string]bool        // the values read as true and false, lower-cased
+	bools                      map[int]bool           // Bool fields found by -infer-bool, by index
+	codecs                     map[string]string      // compression codecs of the new table's columns, by field (* for all)
+	nulls                      *nullTokens            // values read as missing, nil if none
+	missing                    map[string]interface{} // -missing: the values of illegal entries by type code
+	bad                        string                 // -bad file of the rows that fail, "" if none
+	journal                    bool                   // write an entry for each file of -watch or batch of -reopen-on-eof to the systemd journal
+	progress                   *progressMeter         // prints the rows loaded, nil if not
+	autoString                 bool                   // choose FixedString, LowCardinality(String) or String from the values
+	lcThreshold                int                    // make String fields with at most this many distinct values LowCardinality, 0 if not
+	coercion                   []coercion             // types of the fields with values of mixed kinds, from the config file
+	schemaPolicy               string                 // how the files of a multi-file load are matched to the table
+	columns                    []string               // fields of this file, if they differ from the table's
+	fileWorkers                int                    // files of a multi-file load loaded at a time
+	required                   []string               // fields the source must have
+	fresh                      *freshness             // check of the newest date loaded, nil if none
+	unique                     *uniqueKeys            // check that the rows loaded have unique keys, nil if none
+}
+
+// load moves j.source into j.table. If spec is nil, the table spec is built from the data and the table is
+// created. Otherwise, spec is used as-is and the rows are appended to the existing table.
+// It returns the number of rows read and the table spec used.
+func (j *job) load(spec *chutils.TableDef, con *chutils.Connect) (rows int, tableSpec *chutils.TableDef, err error) {
+	var rdr chutils.Input
+	switch {
+	case j.raw:
+		rdr, err = newRaw(j, spec, con)
+	case j.sType == "chquery":
+		rdr, err = newQuery(j, con)
+	default:
+		rdr, err = buildReader(j, spec, con)
+	}
+	if err == nil && j.debug {
+		rdr = newDiagnoser(rdr, j)
+	}
+	if err == nil && j.fresh != nil {
+		rdr, err = j.fresh.watch(rdr)
+	}
+	if err == nil && j.unique != nil {
+		rdr, err = j.unique.watch(rdr, j.source)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
 	defer func() {
 		if e := rdr.Close(); e != nil {
 			fmt.Println(e)
 		}
 	}()
 
+	cnt := &counter{Input: j.insertable(rdr), progress: j.progress}
+	if !j.preserveOrder {
+		if e := exportUnordered(cnt, j, con); e != nil {
+			return cnt.rows, nil, e
+		}
+		return cnt.rows, rdr.TableSpec(), nil
+	}
+
 	// create the writer.
-	wtr := sql.NewWriter(*tablePtr, con)
+	wtr := sql.NewWriter(j.table, con)
 	defer func() {
 		if e := wtr.Close(); e != nil {
 			fmt.Println(e)
@@ -197,116 +1513,429 @@ func main() {
 	}()
 
 	// now do the transfer.  If the csv is large (>1GB), the connection will be reset if after=0
-	if e := chutils.Export(rdr, wtr, 1000, ignore); e != nil {
+	if e := chutils.Export(cnt, wtr, j.batch, j.ignore); e != nil {
+		return cnt.rows, nil, e
+	}
+	return cnt.rows, rdr.TableSpec(), nil
+}
+
+// loadFile loads j.source, timing it.  See load.
+func (j *job) loadFile(spec *chutils.TableDef, con *chutils.Connect) fileResult {
+	s := time.Now()
+	rows, ts, err := j.load(spec, con)
+	return fileResult{source: j.source, rows: rows, secs: time.Since(s).Seconds(), err: err, spec: ts}
+}
+
+// newRunID returns a random (version 4) UUID to identify a run
+func newRunID() string {
+	b := make([]byte, 16)
+	if _, e := rand.Read(b); e != nil {
 		panic(e)
 	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// counter is a chutils.Input that keeps track of the number of rows read
+type counter struct {
+	chutils.Input
+	rows     int
+	progress *progressMeter // -progress of the run, nil if none
+}
+
+// Read reads rows from the underlying Input, adding them to the count
+func (c *counter) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	data, valid, err = c.Input.Read(nTarget, validate)
+	c.rows += len(data)
+	c.progress.add(len(data))
+	return data, valid, err
+}
+
+// Reset resets the underlying Input and the row count
+func (c *counter) Reset() error {
+	c.rows = 0
+	return c.Input.Reset()
+}
+
+// elapsed returns the time since s in minutes and seconds
+func elapsed(s time.Time) string {
 	ts := int(time.Since(s).Seconds())
-	mins := ts / 60
-	secs := ts % 60
-	fmt.Printf("elapsed time: %d minutes %d seconds\n", mins, secs)
+	return fmt.Sprintf("%d minutes %d seconds", ts/60, ts%60)
+}
+
+// buildReader creates a reader for chutils.Export and creates the table. See openReader.
+// If spec is not nil, it is used as the table spec and no table is created.
+func buildReader(j *job, spec *chutils.TableDef, con *chutils.Connect) (chutils.Input, error) {
+	// the table's own columns and types, so the table isn't checked against the data
+	if spec == nil && j.appending && j.useTableSchema {
+		ts, err := j.tableSchema(con)
+		if err != nil {
+			return nil, err
+		}
+		return openReader(j, ts)
+	}
+	in, err := openReader(j, spec)
+	if err != nil || spec != nil {
+		return in, err
+	}
+	if err := j.emptyFields(in); err != nil {
+		return nil, err
+	}
+	// create the table
+	if err := j.create(in.TableSpec(), con); err != nil {
+		return nil, err
+	}
+	if j.sparse && !j.appending && !j.server.supports("sparse columns") {
+		fmt.Println("warning: the server doesn't support sparse columns; the sparse fields are stored as usual")
+	}
+	return in, nil
 }
 
-// buildReader creates a reader for chutils.Export. It handles options regarding field names and types
-func buildReader(source, agent, sType, dateFmt string, skip int, quote rune, camel bool, headers, fieldTypes []string, xl []int, xlSheet string, table string, con *chutils.Connect) (*file.Reader, error) {
+// newReader creates the reader of j.source, before the fields are named and typed
+func (j *job) newReader() (*file.Reader, error) {
 	// if reading a header row, need to skip it before reading data.
-	if len(headers) == 0 {
+	skip := j.skip
+	if len(j.headers) == 0 {
 		skip += 1
 	}
 	// Get the reader
-	rdr, err := NewReader(source, agent, sType, quote, skip, xl, xlSheet)
+	var rdr *file.Reader
+	var err error
+	switch {
+	case isIn(&j.sType, jsonTypes, false) && j.readerCmd == "":
+		rdr, err = newJSON(j, skip)
+	case j.sType == "parquet":
+		rdr, err = newParquet(j, skip)
+	case j.body != nil:
+		rdr, err = newBytes(j.body, j.sType, j.delim, j.quote, skip, j.xlArea, j.xlSheet)
+	case j.readerCmd != "":
+		rdr, err = newCmd(j.readerCmd, j.source, j.sType, j.delim, j.quote, skip)
+	case isIn(&j.sType, warehouses, false):
+		rdr, err = newWarehouse(j.sType, j.query, j.quote, skip)
+	case isIn(&j.sType, apis, false):
+		rdr, err = newAPI(j, skip)
+	case j.region != nil:
+		rdr, err = j.region.open(j.source, j.sType, j.delim, j.quote, skip)
+	default:
+		rdr, err = j.openSource(skip)
+	}
 	if err != nil {
 		return nil, err
 	}
-	// handle headers: read them from file
-	if len(headers) == 0 {
-		if err := rdr.Init("", chutils.MergeTree); err != nil {
+	if j.sType == "xlsx" || j.sType == "xls" {
+		if j.epoch = j.excelEpoch(j.dateSystem); j.epoch.Equal(epoch1904) {
+			fmt.Printf("%s: the workbook uses the 1904 date system\n", j.source)
+		}
+	}
+	// run the rows through the user's transform
+	if j.transformCmd != "" {
+		if rdr, err = newTransform(rdr, j.transformCmd, j.transformFmt, j.headers); err != nil {
 			return nil, err
 		}
-		for ind, fd := range rdr.TableSpec().FieldDefs {
-			if camel {
-				fd.Name = toCamel(fd.Name)
-			}
-			if isIn(&fd.Name, reserved, false) {
-				fd.Name += "1"
-			}
-			// we may have renamed the key...
-			if ind == 0 {
-				rdr.TableSpec().Key = fd.Name
+	}
+	return rdr, nil
+}
+
+// openReader creates a reader of j.source. It handles options regarding field names and types.
+// If spec is not nil, it is used as the table spec.
+func openReader(j *job, spec *chutils.TableDef) (chutils.Input, error) {
+	rdr, err := j.newReader()
+	if err != nil {
+		return nil, err
+	}
+	// the table already exists
+	if spec != nil {
+		var in chutils.Input = rdr
+		// the source fields come first in the spec, followed by the derived fields
+		src := spec
+		if j.pipelined() {
+			fds := make(map[int]*chutils.FieldDef)
+			for ind := 0; ind < len(spec.FieldDefs)-len(j.derive); ind++ {
+				fds[ind] = spec.FieldDefs[ind]
 			}
+			src = chutils.NewTableDef(spec.Key, spec.Engine, fds)
+		}
+		if err := j.setMissing(spec); err != nil {
+			return nil, err
+		}
+		if j.columns != nil {
+			in = newRemap(rdr, j.columns, src)
+		} else {
+			rdr.SetTableSpec(src)
 		}
+		if !j.pipelined() {
+			return in, nil
+		}
+		return newPipeline(in, j, spec)
+	}
+	// handle headers: read them from file
+	if len(j.headers) == 0 {
+		if err := rdr.Init("", chutils.MergeTree); err != nil {
+			return nil, err
+		}
+		j.nameFields(rdr.TableSpec())
 	} else {
 		// user-supplied field names
 		fds := make(map[int]*chutils.FieldDef)
 		// choosing ChUnknown tells Impute to figure it out.
-		for ind, name := range headers {
+		for ind, name := range j.headers {
 			fds[ind] = &chutils.FieldDef{Name: name, ChSpec: chutils.ChField{Base: chutils.ChUnknown}, Legal: &chutils.LegalValues{}}
 		}
-		tableSpec := chutils.NewTableDef(headers[0], chutils.MergeTree, fds)
+		tableSpec := chutils.NewTableDef(j.headers[0], chutils.MergeTree, fds)
 		rdr.SetTableSpec(tableSpec)
 	}
+	var in chutils.Input = rdr
+	if j.pipelined() {
+		if in, err = newPipeline(rdr, j, nil); err != nil {
+			return nil, err
+		}
+	}
 	// Find field types from data
-	if len(fieldTypes) == 0 {
-		if err := rdr.TableSpec().Impute(rdr, 0, 0.95); err != nil {
+	if j.parquet != nil && !j.typed() {
+		if err := j.parquetTypes(in.TableSpec()); err != nil {
 			return nil, err
 		}
-	} else {
-		// handle user-supplied data types
-		if len(fieldTypes) != len(rdr.TableSpec().FieldDefs) {
-			return nil, fmt.Errorf("supplied field types have length %d, data has %d columns", len(fieldTypes), len(rdr.TableSpec().FieldDefs))
-		}
-		for ind, fd := range rdr.TableSpec().FieldDefs {
-			switch fieldTypes[ind] {
-			case "d":
-				fd.ChSpec.Base, fd.Missing = chutils.ChDate, time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
-				fd.ChSpec.Format = dateFmt
-			case "i":
-				fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChInt, 64, math.MaxInt64
-			case "f":
-				fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChFloat, 64, math.MaxFloat64
-			default:
-				fd.ChSpec.Base, fd.Missing = chutils.ChString, "!"
+	} else if !j.typed() {
+		if err := in.TableSpec().Impute(in, 0, 0.95); err != nil {
+			return nil, err
+		}
+		if len(j.coercion) > 0 {
+			if err := coerce(in, j.coercion, j.dateFmt); err != nil {
+				return nil, err
+			}
+		}
+		if j.inferDecimal {
+			if err := j.inferDecimals(in, len(in.TableSpec().FieldDefs)-len(j.derive)); err != nil {
+				return nil, err
 			}
 		}
+		if j.inferBool {
+			if err := j.inferBools(in, len(in.TableSpec().FieldDefs)-len(j.derive)); err != nil {
+				return nil, err
+			}
+		}
+		if j.shrink || !j.wideInts {
+			if err := shrink(in, true, j.shrink); err != nil {
+				return nil, err
+			}
+		}
+		if j.autoString {
+			if err := autoString(in); err != nil {
+				return nil, err
+			}
+		}
+		if j.lcThreshold > 0 {
+			if err := lowCardinality(in, j.lcThreshold); err != nil {
+				return nil, err
+			}
+		}
+	} else if err := j.setTypes(in.TableSpec()); err != nil {
+		return nil, err
 	}
-	// create the table
-	if err := rdr.TableSpec().Create(con, table); err != nil {
+	if j.pivot != nil {
+		if in, err = newPivot(in, j.pivot, j.pivotMax); err != nil {
+			return nil, err
+		}
+	}
+	// the columns of a table appended to are as they are
+	if j.nullable && !j.appending {
+		nullable(in.TableSpec(), j.notNullable)
+	}
+	if err := j.setMissing(in.TableSpec()); err != nil {
 		return nil, err
 	}
-	return rdr, nil
+	return in, nil
+}
+
+// nameFields cleans up the field names read from the data
+func (j *job) nameFields(spec *chutils.TableDef) {
+	for ind, fd := range spec.FieldDefs {
+		if j.scrub != nil {
+			fd.Name = j.scrub(fd.Name)
+		}
+		if j.normalize != nil {
+			fd.Name = j.normalize(fd.Name)
+		}
+		if j.camel {
+			fd.Name = toCamel(fd.Name)
+		}
+		if isIn(&fd.Name, reserved, false) {
+			fd.Name += "1"
+		}
+		// we may have renamed the key...
+		if ind == 0 {
+			spec.Key = fd.Name
+		}
+	}
+	uniqueNames(spec)
+}
+
+// uniqueNames names empty fields col_<n>, where n is the column number (col_<n>_2... if another field has that
+// name), and renames repeated names name_2, name_3,... so that the names are unique.  The changes are reported.
+func uniqueNames(spec *chutils.TableDef) {
+	used := make(map[string]bool)
+	for ind := 0; ind < len(spec.FieldDefs); ind++ {
+		used[spec.FieldDefs[ind].Name] = true
+	}
+	seen := make(map[string]int)
+	for ind := 0; ind < len(spec.FieldDefs); ind++ {
+		fd := spec.FieldDefs[ind]
+		name := fd.Name
+		switch {
+		case strings.TrimSpace(name) == "":
+			name = fmt.Sprintf("col_%d", ind+1)
+			for n := 2; used[name]; n++ {
+				name = fmt.Sprintf("col_%d_%d", ind+1, n)
+			}
+		case seen[name] > 0:
+			for n := seen[name] + 1; ; n++ {
+				if cand := fmt.Sprintf("%s_%d", name, n); !used[cand] {
+					seen[name], name = n, cand
+					break
+				}
+			}
+		default:
+			seen[name] = 1
+			continue
+		}
+		fmt.Printf("column %d: '%s' renamed to %s\n", ind+1, fd.Name, name)
+		used[name] = true
+		fd.Name = name
+		if ind == 0 {
+			spec.Key = name
+		}
+	}
+}
+
+// target returns a copy of j that loads table with the fields pick of the source, in that order, e.g. a
+// config file target that selects some fields.  The types of the fields, from -t or found in the data, are
+// those of the fields picked.  If pick is nil, the fields are those of the source.
+func (j *job) target(table string, pick []int) *job {
+	tj := *j
+	tj.table = table
+	if pick == nil {
+		return &tj
+	}
+	tj.fieldTypes, tj.allTypes = make([]string, len(pick)), ""
+	tj.decimals, tj.bools = make(map[int]decimal), make(map[int]bool)
+	for ind, col := range pick {
+		tj.fieldTypes[ind] = j.typeOf(col)
+		if d, ok := j.decimals[col]; ok {
+			tj.decimals[ind] = d
+		}
+		tj.bools[ind] = j.bools[col]
+	}
+	return &tj
+}
+
+// typed returns true if the user supplied the field types with -t or -all-types
+func (j *job) typed() bool {
+	return len(j.fieldTypes) > 0 || j.allTypes != ""
+}
+
+// setTypes sets the field types of spec to the user-supplied types
+func (j *job) setTypes(spec *chutils.TableDef) error {
+	fieldTypes := j.fieldTypes
+	if len(fieldTypes) == 0 {
+		for range spec.FieldDefs {
+			fieldTypes = append(fieldTypes, j.allTypes)
+		}
+	}
+	if len(fieldTypes) != len(spec.FieldDefs) {
+		return fmt.Errorf("supplied field types have length %d, data has %d columns", len(fieldTypes), len(spec.FieldDefs))
+	}
+	for ind, fd := range spec.FieldDefs {
+		if _, ok := geoTypes[fieldTypes[ind]]; ok {
+			// read as a String, inserted as the geo type
+			fd.ChSpec.Base, fd.Missing = chutils.ChString, ""
+			continue
+		}
+		if _, ok := dateTimeTypes[fieldTypes[ind]]; ok {
+			// read as a String, inserted as DateTime
+			fd.ChSpec.Base, fd.Missing = chutils.ChString, zeroTime
+			continue
+		}
+		if fieldTypes[ind] == "b" {
+			// read as Int8 1 and 0, inserted as Bool
+			fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChInt, 8, 0
+			continue
+		}
+		if bits, ok := intTypes[fieldTypes[ind]]; ok {
+			fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChInt, bits, 1<<(bits-1)-1
+			continue
+		}
+		if bits, ok := uintTypes[fieldTypes[ind]]; ok {
+			// read as a String, inserted as UInt
+			fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChString, 0, maxUint(bits)
+			continue
+		}
+		if _, ok, _ := parseDecimal(fieldTypes[ind]); ok {
+			// read as a String, inserted as Decimal
+			fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChString, 0, "0"
+			continue
+		}
+		if n := fixedWidth(fieldTypes[ind]); n > 0 {
+			fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChFixedString, n, ""
+			continue
+		}
+		switch fieldTypes[ind] {
+		case "d":
+			fd.ChSpec.Base, fd.Missing = chutils.ChDate, time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+			fd.ChSpec.Format = j.dateFmt
+		case "i", "tm", "dur":
+			// times of day and durations are converted to seconds by the pipeline
+			fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChInt, 64, math.MaxInt64
+		case "f":
+			fd.ChSpec.Base, fd.ChSpec.Length, fd.Missing = chutils.ChFloat, 64, math.MaxFloat64
+		case "lc":
+			fd.ChSpec.Base, fd.Missing = chutils.ChString, "!"
+			fd.ChSpec.Funcs = chutils.OuterFuncs{chutils.OuterLowCardinality}
+		default:
+			fd.ChSpec.Base, fd.Missing = chutils.ChString, "!"
+		}
+	}
+	return nil
 }
 
 // NewReader creates the appropriate kind of reader
-func NewReader(source, agent, sType string, quote rune, skip int, xl []int, xlSheet string) (*file.Reader, error) {
+func NewReader(source string, web *webRequest, sType, delim string, quote rune, skip int, xl []int, xlSheet string) (*file.Reader, error) {
 	if strings.Contains(strings.ToLower(source), "http") {
 		// newHttp pulls the data as well.
-		return newHttp(source, agent, sType, quote, skip, xl, xlSheet)
+		return newHttp(source, web, sType, delim, quote, skip, xl, xlSheet)
 	}
-	return newFile(source, sType, quote, skip, xl, xlSheet)
+	return newFile(source, sType, delim, quote, skip, xl, xlSheet)
 }
 
 // newHttp creates a reader for data coming via http.
-// The package excelize cannot read .xls files.  So these are downloaded, converted to .xlsx and a file reader is created.
-func newHttp(source, agent, sType string, quote rune, skip int, xl []int, xlSheet string) (*file.Reader, error) {
+func newHttp(source string, web *webRequest, sType, delim string, quote rune, skip int, xl []int, xlSheet string) (*file.Reader, error) {
 	// get the data.  We will put into a string reader.
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", source, nil)
-
-	if agent != "NA" {
-		req.Header.Set("User-Agent", agent)
+	r, err := web.get(source)
+	if err != nil {
+		return nil, err
 	}
-
-	r, _ := client.Do(req)
 	defer func() { _ = r.Body.Close() }()
 
-	var err error
 	var body []byte
 	if body, err = io.ReadAll(r.Body); err != nil {
-		panic(err)
+		return nil, err
 	}
 
+	return newBytes(body, sType, delim, quote, skip, xl, xlSheet)
+}
+
+// newBytes creates a reader for data that has been read into memory.
+// The package excelize cannot read .xls files.  So these are saved, converted to .xlsx and a file reader is created.
+func newBytes(body []byte, sType, delim string, quote rune, skip int, xl []int, xlSheet string) (*file.Reader, error) {
 	switch sType {
 	case "text", "csv":
-		return str.NewReader(string(body), sep(sType), '\n', quote, 0, skip, 0), nil
+		if multiDelim(delim) {
+			var err error
+			if body, err = unsepBytes(body, delim, quote); err != nil {
+				return nil, err
+			}
+		}
+		return str.NewReader(string(body), sep(sType, delim), '\n', quote, 0, skip, 0), nil
 	case "xlsx":
 		// excelize will parse the data which is then put into a string reader by NewXlReader
 		r := strings.NewReader(string(body))
@@ -318,18 +1947,21 @@ func newHttp(source, agent, sType string, quote rune, skip int, xl []int, xlShee
 	case "xls":
 		// this works only on linux.  Save this as a file and then use the newFile protocol.  That
 		// will use libreoffice to convert it to an XLSX so that excelize can read it.
-		fileName := "/tmp/tmp.xls"
-		f, e := os.Create(fileName)
+		// excelize reads the converted file into memory, so both files are removed once the reader is made.
+		f, e := os.CreateTemp("", "toch-*.xls")
 		if e != nil {
 			return nil, e
 		}
+		defer func() { _ = os.Remove(f.Name()) }()
+		defer func() { _ = os.Remove(strings.TrimSuffix(f.Name(), ".xls") + ".xlsx") }()
 		if _, e := f.Write(body); e != nil {
+			_ = f.Close()
 			return nil, e
 		}
 		if e := f.Close(); e != nil {
 			return nil, e
 		}
-		return newFile(fileName, "xls", quote, skip, xl, xlSheet)
+		return newFile(f.Name(), "xls", "", quote, skip, xl, xlSheet)
 	default:
 		return nil, fmt.Errorf("illegal -type")
 	}
@@ -344,14 +1976,24 @@ func getDir(path string) string {
 }
 
 // newFile creates a reader for data coming from a file
-func newFile(source string, sType string, quote rune, skip int, xl []int, xlSheet string) (*file.Reader, error) {
-	f, err := os.Open(source)
+func newFile(source string, sType, delim string, quote rune, skip int, xl []int, xlSheet string) (*file.Reader, error) {
+	name := source
+	if multiDelim(delim) && (sType == "text" || sType == "csv") {
+		tmp, err := unsepFile(source, delim, quote)
+		if err != nil {
+			return nil, err
+		}
+		// the reader keeps the file open
+		defer func() { _ = os.Remove(tmp) }()
+		name = tmp
+	}
+	f, err := os.Open(name)
 	if err != nil {
 		return nil, err
 	}
 	switch sType {
 	case "text", "csv":
-		return file.NewReader(source, sep(sType), '\n', quote, 0, skip, 0, f, 0), nil
+		return file.NewReader(source, sep(sType, delim), '\n', quote, 0, skip, 0, f, 0), nil
 	case "xlsx", "xls":
 		// if sType = "xls" then convert to xlsx in the same directory
 		if sType == "xls" {
@@ -405,16 +2047,6 @@ func isIn(needle *string, stack []string, toLower bool) bool {
 	return false
 }
 
-// sep returns the field separate for the source type
-func sep(sType string) rune {
-	switch sType {
-	case "text", "xlsx":
-		return '\t'
-	default:
-		return ','
-	}
-}
-
 // flags checks that the flags are valid. It returns digested values.
 // Outputs:
 //   - headers      array of field names
@@ -458,9 +2090,10 @@ func flags(sTypePtr, camelPtr, headerPtr, fieldPtr, quotePtr, xlRowsPtr, xlColsP
 	}
 
 	if *fieldPtr != "" {
-		fieldTypes = strings.Split(strings.ReplaceAll(strings.ToLower(strings.ReplaceAll(*fieldPtr, " ", "")), "'", ""), ",")
+		// split outside parentheses, so dec(P,S) is one type
+		fieldTypes = splitOutside(strings.ReplaceAll(strings.ToLower(strings.ReplaceAll(*fieldPtr, " ", "")), "'", ""), ',')
 		for _, f := range fieldTypes {
-			if !isIn(&f, ftypes, false) {
+			if !validType(f) {
 				err = fmt.Errorf("not a valid field type: %s", f)
 				return
 			}
@@ -470,6 +2103,12 @@ func flags(sTypePtr, camelPtr, headerPtr, fieldPtr, quotePtr, xlRowsPtr, xlColsP
 		err = fmt.Errorf("-h headers and -t field types must have same length")
 		return
 	}
+	for ind, h := range headers {
+		if h == "" || isIn(&h, headers[:ind], false) {
+			err = fmt.Errorf("-h field names must be unique and not empty")
+			return
+		}
+	}
 
 	if *skipPtr < 0 {
 		err = fmt.Errorf("-skip value must be non-negative")
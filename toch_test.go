@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/invertedv/chutils"
+)
+
+func TestUniqueNames(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"a,b,c", "a,b,c"},
+		{",b, ", "col_1,b,col_3"},
+		{"a,a,a", "a,a_2,a_3"},
+		// a name made for a repeat doesn't take one the data has
+		{"a,a,a_2", "a,a_3,a_2"},
+		{"x,,x,col_2", "x,col_2_2,x_2,col_2"},
+	}
+	for _, tt := range tests {
+		fds := make(map[int]*chutils.FieldDef)
+		for ind, name := range strings.Split(tt.in, ",") {
+			fds[ind] = &chutils.FieldDef{Name: name}
+		}
+		spec := chutils.NewTableDef(fds[0].Name, chutils.MergeTree, fds)
+		uniqueNames(spec)
+		got := make([]string, len(fds))
+		for ind := range got {
+			got[ind] = fds[ind].Name
+		}
+		if strings.Join(got, ",") != tt.want || spec.Key != got[0] {
+			t.Errorf("uniqueNames(%s) = %s with key %s, want %s", tt.in, strings.Join(got, ","), spec.Key, tt.want)
+		}
+	}
+}
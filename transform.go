@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/chutils/file"
+)
+
+// allowed values for -transform-format
+var transformFormats = []string{"csv", "json"}
+
+// newTransform pipes the rows of rdr through the user-supplied command cmd and returns a reader for its output.
+//
+// The rows are written to the command's stdin in format (csv or json).  With csv, the first line is a header
+// of the field names. With json, each row is an object whose keys are the field names.  The command writes
+// the transformed rows to stdout in the same format.  The returned reader always has a header row.  Neither
+// side is held in memory: the rows are streamed to the command and its output to a temporary file.
+func newTransform(rdr *file.Reader, cmd, format string, headers []string) (*file.Reader, error) {
+	defer func() { _ = rdr.Close() }()
+
+	// field names come from the data or the user
+	names := headers
+	if len(names) == 0 {
+		if err := rdr.Init("", chutils.MergeTree); err != nil {
+			return nil, err
+		}
+		names = make([]string, len(rdr.TableSpec().FieldDefs))
+		for ind, fd := range rdr.TableSpec().FieldDefs {
+			names[ind] = fd.Name
+		}
+	}
+
+	c := exec.Command("sh", "-c", cmd)
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// the rows are fed to the command as it runs.  rdr is closed only once feeding is done.
+	fed := make(chan error, 1)
+	go func() {
+		err := feedRows(stdin, rdr, names, format)
+		if e := stdin.Close(); err == nil {
+			err = e
+		}
+		fed <- err
+	}()
+
+	var conv func(r io.Reader, w io.Writer) error
+	if format == "json" {
+		conv = jsonToCSV
+	}
+	tmp, err := spoolCmd(c, conv)
+	// a command that stops reading early closes the pipe, which is not an error of the source
+	if e := <-fed; err == nil && e != nil && !errors.Is(e, syscall.EPIPE) && !errors.Is(e, os.ErrClosed) {
+		err = e
+	}
+	if err != nil {
+		if tmp != "" {
+			_ = os.Remove(tmp)
+		}
+		return nil, err
+	}
+	// the reader keeps the file open
+	defer func() { _ = os.Remove(tmp) }()
+	return newFile(tmp, "csv", "", '"', 1, nil, "")
+}
+
+// feedRows writes the rows of rdr to w in format, csv with a header row of names or json lines.
+func feedRows(w io.Writer, rdr *file.Reader, names []string, format string) error {
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	if format == "csv" {
+		if err := cw.Write(names); err != nil {
+			return err
+		}
+	}
+	for {
+		data, _, err := rdr.Read(1, false)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		row := make([]string, len(data[0]))
+		for ind, v := range data[0] {
+			row[ind] = fmt.Sprint(v)
+		}
+		if format == "csv" {
+			if e := cw.Write(row); e != nil {
+				return e
+			}
+			continue
+		}
+		if e := writeJSON(bw, names, row); e != nil {
+			return e
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeJSON writes row as a JSON object with keys names, followed by a newline.
+func writeJSON(w io.Writer, names, row []string) error {
+	if len(names) != len(row) {
+		return fmt.Errorf("row has %d fields, expected %d", len(row), len(names))
+	}
+	line := make([]string, len(row))
+	for ind := range row {
+		k, _ := json.Marshal(names[ind])
+		v, _ := json.Marshal(row[ind])
+		line[ind] = string(k) + ":" + string(v)
+	}
+	_, err := fmt.Fprintf(w, "{%s}\n", strings.Join(line, ","))
+	return err
+}
+
+// jsonToCSV converts JSON lines read from r, each a flat object, to CSV with a header row written to w.
+// The columns are the keys of the first object, in order.
+func jsonToCSV(r io.Reader, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	var names []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		keys, vals, err := jsonObject(sc.Bytes())
+		if err != nil {
+			return fmt.Errorf("transform output line %d: %v", lineNo, err)
+		}
+		if names == nil {
+			names = keys
+			if e := cw.Write(names); e != nil {
+				return e
+			}
+		}
+		byKey := make(map[string]string)
+		for ind, k := range keys {
+			byKey[k] = vals[ind]
+		}
+		row := make([]string, len(names))
+		for ind, k := range names {
+			row[ind] = byKey[k]
+		}
+		if e := cw.Write(row); e != nil {
+			return e
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// jsonObject returns the keys, in order, and values of the flat JSON object in line.
+// Values that are not strings are returned as their JSON text; null is returned as an empty string.
+func jsonObject(line []byte) (keys, vals []string, err error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if t, e := dec.Token(); e != nil || t != json.Delim('{') {
+		return nil, nil, fmt.Errorf("not a JSON object")
+	}
+	for dec.More() {
+		t, e := dec.Token()
+		if e != nil {
+			return nil, nil, e
+		}
+		key, _ := t.(string)
+		var raw json.RawMessage
+		if e := dec.Decode(&raw); e != nil {
+			return nil, nil, e
+		}
+		var val string
+		switch {
+		case string(raw) == "null":
+		case raw[0] == '"':
+			_ = json.Unmarshal(raw, &val)
+		case raw[0] == '{' || raw[0] == '[':
+			return nil, nil, fmt.Errorf("field %s is not a scalar", key)
+		default:
+			val = string(raw)
+		}
+		keys, vals = append(keys, key), append(vals, val)
+	}
+	return keys, vals, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/invertedv/chutils"
+	"github.com/invertedv/chutils/str"
+)
+
+// transformed returns the header and rows of the output of cmd given the csv src
+func transformed(t *testing.T, src, cmd, format string) (string, []chutils.Row, error) {
+	t.Helper()
+	out, err := newTransform(str.NewReader(src, ',', '\n', '"', 0, 1, 0), cmd, format, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = out.Close() }()
+	if e := out.Init("", chutils.MergeTree); e != nil {
+		t.Fatal(e)
+	}
+	names := make([]string, len(out.TableSpec().FieldDefs))
+	for ind, fd := range out.TableSpec().FieldDefs {
+		names[ind] = fd.Name
+	}
+	rows, _, err := out.Read(0, false)
+	if err == io.EOF {
+		err = nil
+	}
+	return strings.Join(names, ","), rows, err
+}
+
+func TestTransform(t *testing.T) {
+	src := "id,name\n1,a\n2,\"b, c\"\n"
+	tests := []struct {
+		cmd, format string
+		header      string
+		rows        int
+	}{
+		{"cat", "csv", "id,name", 2},
+		{"cat", "json", "id,name", 2},
+		{`sed 's/"name":/"who":/'`, "json", "id,who", 2},
+		// the command needn't read its input
+		{`printf 'x\n7\n'`, "csv", "x", 1},
+	}
+	for _, tt := range tests {
+		header, rows, err := transformed(t, src, tt.cmd, tt.format)
+		if err != nil || header != tt.header || len(rows) != tt.rows {
+			t.Errorf("%s (%s): %s %v, %v", tt.cmd, tt.format, header, rows, err)
+		}
+	}
+
+	// a command that stops reading part way through
+	big := "n\n" + strings.Repeat("1\n", 200000)
+	if _, rows, err := transformed(t, big, "head -3", "csv"); err != nil || len(rows) != 2 {
+		t.Errorf("head -3: %d rows, %v", len(rows), err)
+	}
+
+	if _, _, err := transformed(t, src, "cat >/dev/null; echo bad row 2 >&2; exit 1", "csv"); err == nil ||
+		!strings.Contains(err.Error(), "bad row 2") {
+		t.Errorf("failing command: %v", err)
+	}
+	if _, _, err := transformed(t, src, "echo '[1]'", "json"); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("bad json: %v", err)
+	}
+}
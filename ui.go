@@ -0,0 +1,18 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// uiPage is the status page of toch serve.  It reads GET /jobs and GET /readyz itself, so it needs nothing
+// beyond the API.
+//
+//go:embed ui.html
+var uiPage []byte
+
+// ui serves the status page
+func ui(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(uiPage)
+}
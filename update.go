@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// releasesURL is the GitHub API endpoint for the latest toch release
+const releasesURL = "https://api.github.com/repos/invertedv/toch/releases/latest"
+
+// releaseKey is the base64 ed25519 public key that signs checksums.txt in each release.  It is set at build
+// time with -ldflags "-X main.releaseKey=...".  self-update refuses to run without it.
+var releaseKey = ""
+
+// release is the part of the GitHub release JSON that self-update uses
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// asset returns the download URL of the asset called name
+func (r *release) asset(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no asset %s", r.Tag, name)
+}
+
+// binaryName is the name of the release asset for this platform
+func binaryName() string {
+	name := fmt.Sprintf("toch_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// download fetches url
+func download(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// verify checks that checksums is signed by releaseKey and that it lists the SHA-256 of binary under name.
+func verify(binary, checksums, sig []byte, name string) error {
+	key, err := base64.StdEncoding.DecodeString(releaseKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("toch was built with an invalid release key")
+	}
+	// the signature may be raw or base64
+	if s, e := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig))); e == nil {
+		sig = s
+	}
+	if !ed25519.Verify(key, checksums, sig) {
+		return fmt.Errorf("the signature of checksums.txt is not valid")
+	}
+
+	sum := sha256.Sum256(binary)
+	for _, line := range strings.Split(string(checksums), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			if fields[0] != hex.EncodeToString(sum[:]) {
+				return fmt.Errorf("the checksum of %s does not match checksums.txt", name)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("checksums.txt has no entry for %s", name)
+}
+
+// replaceBinary replaces the running toch with binary.  The new file is written next to the old one and
+// renamed over it so that a failure leaves the old binary in place.
+func replaceBinary(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+
+	tmp := exe + ".new"
+	if e := os.WriteFile(tmp, binary, info.Mode().Perm()); e != nil {
+		return e
+	}
+	// Windows won't rename over a running binary, so move it out of the way first
+	if runtime.GOOS == "windows" {
+		if e := os.Rename(exe, exe+".old"); e != nil {
+			_ = os.Remove(tmp)
+			return e
+		}
+	}
+	if e := os.Rename(tmp, exe); e != nil {
+		_ = os.Remove(tmp)
+		return e
+	}
+	fmt.Printf("replaced %s\n", exe)
+	return nil
+}
+
+// selfUpdateCmd updates toch to the latest GitHub release
+func selfUpdateCmd(args []string) error {
+	fs := flag.NewFlagSet("self-update", flag.ExitOnError)
+	checkPtr := fs.Bool("check", false, "bool")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	body, err := download(releasesURL)
+	if err != nil {
+		return err
+	}
+	var r release
+	if e := json.Unmarshal(body, &r); e != nil {
+		return e
+	}
+
+	current := currentVersion()
+	fmt.Printf("installed: %s, latest release: %s\n", current, r.Tag)
+	if r.Tag == current {
+		fmt.Println("toch is up to date")
+		return nil
+	}
+	if *checkPtr {
+		return nil
+	}
+
+	if releaseKey == "" {
+		return fmt.Errorf("this toch was built without a release key so the download cannot be verified; " +
+			"install the release by hand")
+	}
+
+	name := binaryName()
+	urls := make([]string, 0, 3)
+	for _, a := range []string{name, "checksums.txt", "checksums.txt.sig"} {
+		u, e := r.asset(a)
+		if e != nil {
+			return e
+		}
+		urls = append(urls, u)
+	}
+	files := make([][]byte, 0, 3)
+	for _, u := range urls {
+		b, e := download(u)
+		if e != nil {
+			return e
+		}
+		files = append(files, b)
+	}
+
+	if e := verify(files[0], files[1], files[2], name); e != nil {
+		return e
+	}
+	if e := replaceBinary(files[0]); e != nil {
+		return e
+	}
+	fmt.Printf("toch updated to %s\n", r.Tag)
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// convert converts the raw value v to the type of fd, as chutils' validator does: an Int or Float value is
+// parsed with the width of the field, so it is of the Go type of that width, e.g. int8 for an Int8, and a
+// value that doesn't fit fails.  The value must also be within fd.Legal: between LowLimit and HighLimit for
+// Int, Float and Date fields, one of Levels for String fields.  If it can't be converted, fd.Missing is returned
+// with status VTypeFail; if it isn't legal, with status VValueFail.
+func convert(fd *chutils.FieldDef, v interface{}) (interface{}, chutils.Status) {
+	s := strings.TrimSpace(fmt.Sprint(v))
+	if v == nil {
+		s = ""
+	}
+	var out interface{}
+	switch fd.ChSpec.Base {
+	case chutils.ChInt:
+		x, err := strconv.ParseInt(s, 10, bitsOf(fd))
+		if err != nil {
+			return fd.Missing, chutils.VTypeFail
+		}
+		out = sizedInt(x, bitsOf(fd))
+	case chutils.ChFloat:
+		x, err := strconv.ParseFloat(s, bitsOf(fd))
+		if err != nil {
+			return fd.Missing, chutils.VTypeFail
+		}
+		out = x
+		if bitsOf(fd) == 32 {
+			out = float32(x)
+		}
+	case chutils.ChDate:
+		x, err := time.Parse(fd.ChSpec.Format, s)
+		if err != nil {
+			return fd.Missing, chutils.VTypeFail
+		}
+		out = x
+	case chutils.ChFixedString:
+		if fd.ChSpec.Length > 0 && len(s) > fd.ChSpec.Length {
+			return fd.Missing, chutils.VValueFail
+		}
+		out = fmt.Sprint(v)
+	default:
+		out = fmt.Sprint(v)
+	}
+	if !legal(fd, out) {
+		return fd.Missing, chutils.VValueFail
+	}
+	return out, chutils.VPass
+}
+
+// bitsOf returns the width of the Int or Float field fd, 64 if it has none
+func bitsOf(fd *chutils.FieldDef) int {
+	switch fd.ChSpec.Length {
+	case 8, 16, 32:
+		return fd.ChSpec.Length
+	}
+	return 64
+}
+
+// sizedInt returns x, which fits bits bits, as the Go integer of that width
+func sizedInt(x int64, bits int) interface{} {
+	switch bits {
+	case 8:
+		return int8(x)
+	case 16:
+		return int16(x)
+	case 32:
+		return int32(x)
+	}
+	return x
+}
+
+// legal returns true if the converted value v is within the legal values of fd.  Limits that are nil, or of a
+// type that doesn't go with the field's, are not checked.
+func legal(fd *chutils.FieldDef, v interface{}) bool {
+	if fd.Legal == nil {
+		return true
+	}
+	lg := fd.Legal
+	switch x := v.(type) {
+	case time.Time:
+		if lo, ok := lg.LowLimit.(time.Time); ok && x.Before(lo) {
+			return false
+		}
+		if hi, ok := lg.HighLimit.(time.Time); ok && x.After(hi) {
+			return false
+		}
+	case string:
+		if len(lg.Levels) == 0 {
+			return true
+		}
+		for _, l := range lg.Levels {
+			if x == l {
+				return true
+			}
+		}
+		return false
+	default:
+		if fd.ChSpec.Base == chutils.ChInt {
+			n, _ := asInt64(v)
+			if lo, ok := asInt64(lg.LowLimit); ok && n < lo {
+				return false
+			}
+			if hi, ok := asInt64(lg.HighLimit); ok && n > hi {
+				return false
+			}
+			return true
+		}
+		f, _ := asFloat64(v)
+		if lo, ok := asFloat64(lg.LowLimit); ok && f < lo {
+			return false
+		}
+		if hi, ok := asFloat64(lg.HighLimit); ok && f > hi {
+			return false
+		}
+	}
+	return true
+}
+
+// asInt64 returns the integer v, of any width, as an int64.  ok is false if v isn't a signed integer or an
+// unsigned one that fits.
+func asInt64(v interface{}) (n int64, ok bool) {
+	switch x := v.(type) {
+	case int:
+		return int64(x), true
+	case int8:
+		return int64(x), true
+	case int16:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	case uint8:
+		return int64(x), true
+	case uint16:
+		return int64(x), true
+	case uint32:
+		return int64(x), true
+	case uint:
+		return int64(x), x <= 1<<63-1
+	case uint64:
+		return int64(x), x <= 1<<63-1
+	}
+	return 0, false
+}
+
+// asFloat64 returns the number v, an integer or float of any width, as a float64.  ok is false if v isn't a
+// number.
+func asFloat64(v interface{}) (f float64, ok bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case uint:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	}
+	n, ok := asInt64(v)
+	return float64(n), ok
+}
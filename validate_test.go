@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+func TestConvert(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	field := func(base chutils.ChType, length int, legal *chutils.LegalValues, missing interface{}) *chutils.FieldDef {
+		return &chutils.FieldDef{Name: "x", ChSpec: chutils.ChField{Base: base, Length: length, Format: "2006-01-02"},
+			Legal: legal, Missing: missing}
+	}
+	tests := []struct {
+		name   string
+		fd     *chutils.FieldDef
+		in     interface{}
+		want   interface{}
+		status chutils.Status
+	}{
+		{"int64", field(chutils.ChInt, 64, nil, math.MaxInt64), "42", int64(42), chutils.VPass},
+		{"no length is Int64", field(chutils.ChInt, 0, nil, math.MaxInt64), " -7 ", int64(-7), chutils.VPass},
+		{"int8", field(chutils.ChInt, 8, nil, math.MaxInt8), "127", int8(127), chutils.VPass},
+		{"int8 overflow", field(chutils.ChInt, 8, nil, math.MaxInt8), "128", math.MaxInt8, chutils.VTypeFail},
+		{"int16", field(chutils.ChInt, 16, nil, math.MaxInt16), "-32768", int16(-32768), chutils.VPass},
+		{"int16 overflow", field(chutils.ChInt, 16, nil, math.MaxInt16), "40000", math.MaxInt16, chutils.VTypeFail},
+		{"int32", field(chutils.ChInt, 32, nil, math.MaxInt32), "2147483647", int32(math.MaxInt32), chutils.VPass},
+		{"int32 overflow", field(chutils.ChInt, 32, nil, math.MaxInt32), "2147483648", math.MaxInt32, chutils.VTypeFail},
+		{"not an int", field(chutils.ChInt, 64, nil, -1), "4.5", -1, chutils.VTypeFail},
+		{"empty int", field(chutils.ChInt, 64, nil, -1), nil, -1, chutils.VTypeFail},
+		{"int in limits", field(chutils.ChInt, 16, &chutils.LegalValues{LowLimit: 0, HighLimit: int64(255)}, 255),
+			"200", int16(200), chutils.VPass},
+		{"int below limit", field(chutils.ChInt, 16, &chutils.LegalValues{LowLimit: 0, HighLimit: int64(255)}, 255),
+			"-1", 255, chutils.VValueFail},
+		{"int above limit", field(chutils.ChInt, 16, &chutils.LegalValues{LowLimit: 0, HighLimit: int64(255)}, 255),
+			"256", 255, chutils.VValueFail},
+		{"float64", field(chutils.ChFloat, 64, nil, math.MaxFloat64), "1.5", 1.5, chutils.VPass},
+		{"float32", field(chutils.ChFloat, 32, nil, math.MaxFloat32), "1.5", float32(1.5), chutils.VPass},
+		{"float32 overflow", field(chutils.ChFloat, 32, nil, math.MaxFloat32), "1e39", math.MaxFloat32, chutils.VTypeFail},
+		{"float above limit", field(chutils.ChFloat, 64, &chutils.LegalValues{HighLimit: 1.0}, 0.0), "1.5", 0.0, chutils.VValueFail},
+		{"date", field(chutils.ChDate, 0, nil, day("1970-01-01")), "2024-02-29", day("2024-02-29"), chutils.VPass},
+		{"bad date", field(chutils.ChDate, 0, nil, day("1970-01-01")), "2023-02-29", day("1970-01-01"), chutils.VTypeFail},
+		{"date before limit", field(chutils.ChDate, 0, &chutils.LegalValues{LowLimit: day("2000-01-01")}, day("1970-01-01")),
+			"1999-12-31", day("1970-01-01"), chutils.VValueFail},
+		{"string", field(chutils.ChString, 0, nil, "!"), " a b ", " a b ", chutils.VPass},
+		{"string level", field(chutils.ChString, 0, &chutils.LegalValues{Levels: []string{"a", "b"}}, "!"), "b", "b", chutils.VPass},
+		{"string not a level", field(chutils.ChString, 0, &chutils.LegalValues{Levels: []string{"a", "b"}}, "!"), "c", "!", chutils.VValueFail},
+		{"fixed string", field(chutils.ChFixedString, 3, nil, ""), "abc", "abc", chutils.VPass},
+		{"fixed string too long", field(chutils.ChFixedString, 3, nil, ""), "abcd", "", chutils.VValueFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, status := convert(tt.fd, tt.in)
+			if got != tt.want || status != tt.status {
+				t.Errorf("convert(%v) = %v (%T), %s; want %v (%T), %s", tt.in, got, got, status, tt.want, tt.want, tt.status)
+			}
+		})
+	}
+}
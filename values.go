@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// unquoted is text that chutils.Export writes into the INSERT as it is, not quoted as a string: the ClickHouse
+// literal of a Decimal or a geo value
+type unquoted string
+
+// insertValue returns the converted value v of field ind as it is written into a column of the field's table
+// type (see tableType).  chutils knows only the String, Int, Float and Date types, so the values of the other
+// types are Strings (or, for Bool, Int8) until now: the Decimal and geo values become literals, the unsigned
+// ones uint64.  An empty value, which is a missing one or the DEFAULT of a sparse field, is the type's zero.
+func (j *job) insertValue(ind int, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	t := j.typeOf(ind)
+	if g, ok := geoTypes[t]; ok {
+		if s := fmt.Sprint(v); s != "" {
+			return unquoted(s)
+		}
+		return unquoted(g.empty)
+	}
+	if _, ok := dateTimeTypes[t]; ok {
+		if s := fmt.Sprint(v); s != "" {
+			return s
+		}
+		return zeroTime
+	}
+	if d, ok := j.decimalOf(ind); ok {
+		return unquoted(d.format(fmt.Sprint(v)))
+	}
+	if bits, ok := uintTypes[t]; ok {
+		s := strings.TrimSpace(fmt.Sprint(v))
+		if s == "" {
+			return uint64(0)
+		}
+		n, e := strconv.ParseUint(s, 10, bits)
+		if e != nil {
+			n, _ = strconv.ParseUint(maxUint(bits), 10, 64)
+		}
+		return n
+	}
+	return v
+}
+
+// hasLiterals returns true if the job has fields whose values insertValue changes
+func (j *job) hasLiterals() bool {
+	return j.hasGeo() || j.hasDateTime() || j.hasDecimal() || j.hasUnsigned()
+}
+
+// literals is a chutils.Input whose validated rows have the values of the fields of the table as insertValue
+// gives them
+type literals struct {
+	chutils.Input
+	j *job
+}
+
+// insertable returns in with its values as they are inserted into j.table
+func (j *job) insertable(in chutils.Input) chutils.Input {
+	if !j.hasLiterals() {
+		return in
+	}
+	return &literals{Input: in, j: j}
+}
+
+// Read reads rows from the underlying Input.  If they are validated, their values are changed by insertValue.
+func (l *literals) Read(nTarget int, validate bool) (data []chutils.Row, valid []chutils.Valid, err error) {
+	data, valid, err = l.Input.Read(nTarget, validate)
+	if !validate {
+		return data, valid, err
+	}
+	for _, row := range data {
+		for ind := range row {
+			row[ind] = l.j.insertValue(ind, row[ind])
+		}
+	}
+	return data, valid, err
+}
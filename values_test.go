@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/invertedv/chutils"
+)
+
+func TestInsertValue(t *testing.T) {
+	j := &job{fieldTypes: []string{"pt", "dt", "dec(5,2)", "u8", "u64", "b", "s"}}
+	tests := []struct {
+		ind  int
+		in   interface{}
+		want interface{}
+	}{
+		{0, "(1,2)", unquoted("(1,2)")},
+		{0, "", unquoted("(0,0)")},
+		{1, "2024-01-02 03:04:05", "2024-01-02 03:04:05"},
+		{1, "", zeroTime},
+		{2, "12.50", unquoted("12.50")},
+		{2, "", unquoted("0")},
+		{3, "200", uint64(200)},
+		{3, "300", uint64(255)},
+		{3, "", uint64(0)},
+		{4, "18446744073709551615", uint64(18446744073709551615)},
+		{5, int8(1), int8(1)},
+		{6, "a", "a"},
+		{6, nil, nil},
+	}
+	for _, tt := range tests {
+		if got := j.insertValue(tt.ind, tt.in); got != tt.want {
+			t.Errorf("insertValue(%d, %v) = %v (%T), want %v (%T)", tt.ind, tt.in, got, got, tt.want, tt.want)
+		}
+	}
+}
+
+// TestDDL checks that the table is created with the types the values are inserted as, with no changes after
+// the load, and that those types can be the key
+func TestDDL(t *testing.T) {
+	j := &job{table: "db.t", fieldTypes: []string{"dec(12,2)", "pt", "u16", "b", "dt3", "i"}}
+	fds := map[int]*chutils.FieldDef{
+		0: {Name: "price", ChSpec: chutils.ChField{Base: chutils.ChString}},
+		1: {Name: "loc", ChSpec: chutils.ChField{Base: chutils.ChString}},
+		2: {Name: "n", ChSpec: chutils.ChField{Base: chutils.ChString}, Default: ""},
+		3: {Name: "ok", ChSpec: chutils.ChField{Base: chutils.ChInt, Length: 8}},
+		4: {Name: "at", ChSpec: chutils.ChField{Base: chutils.ChString}},
+		5: {Name: "x", ChSpec: chutils.ChField{Base: chutils.ChInt, Length: 64}},
+	}
+	got := j.ddl(chutils.NewTableDef("price", chutils.MergeTree, fds))
+	for _, want := range []string{"`price` Decimal(12, 2)", "`loc` Point", "`n` UInt16 DEFAULT 0,", "`ok` Bool",
+		"`at` DateTime64(3, 'UTC')", "`x` Int64", "ORDER BY (`price`)"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("the CREATE TABLE has no %s:\n%s", want, got)
+		}
+	}
+}
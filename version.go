@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/invertedv/chutils"
+)
+
+// version is the toch version.  It is set at build time with -ldflags "-X main.version=v1.2.3"; otherwise
+// the module version from the build info is used.
+var version = ""
+
+// serverVersion is a ClickHouse server version, major.minor
+type serverVersion struct {
+	major, minor int
+}
+
+func (v serverVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// atLeast returns true if v is the same as or later than w
+func (v serverVersion) atLeast(w serverVersion) bool {
+	return v.major > w.major || (v.major == w.major && v.minor >= w.minor)
+}
+
+// the range of ClickHouse servers toch is known to work with
+var (
+	minServer     = serverVersion{21, 8}
+	maxServer     = serverVersion{24, 8}
+	serverUnknown = serverVersion{}
+)
+
+// feature is a ClickHouse feature that toch may use in the DDL it generates, and the version that introduced it.
+type feature struct {
+	name  string
+	since serverVersion
+}
+
+var features = []feature{
+	{"Bool type", serverVersion{21, 12}},
+	{"JSON type", serverVersion{22, 3}},
+	{"sparse columns", serverVersion{22, 1}},
+	{"lightweight DELETE", serverVersion{22, 8}},
+}
+
+// supports returns true if the server supports the named feature. An unknown server is assumed to.
+func (v serverVersion) supports(name string) bool {
+	if v == serverUnknown {
+		return true
+	}
+	for _, f := range features {
+		if f.name == name {
+			return v.atLeast(f.since)
+		}
+	}
+	return true
+}
+
+// parseServerVersion parses a version string such as 23.8.2.7
+func parseServerVersion(s string) (serverVersion, error) {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) < 2 {
+		return serverUnknown, fmt.Errorf("cannot parse ClickHouse version %s", s)
+	}
+	major, e1 := strconv.Atoi(parts[0])
+	minor, e2 := strconv.Atoi(parts[1])
+	if e1 != nil || e2 != nil {
+		return serverUnknown, fmt.Errorf("cannot parse ClickHouse version %s", s)
+	}
+	return serverVersion{major, minor}, nil
+}
+
+// checkServer finds the version of the server con is connected to and warns if it is outside the range
+// toch is known to work with or lacks features toch may use.
+func checkServer(con *chutils.Connect) (serverVersion, error) {
+	var s string
+	if err := con.QueryRow("SELECT version()").Scan(&s); err != nil {
+		return serverUnknown, err
+	}
+	v, err := parseServerVersion(s)
+	if err != nil {
+		return serverUnknown, err
+	}
+
+	switch {
+	case !v.atLeast(minServer):
+		fmt.Printf("warning: ClickHouse %s is older than %s, the oldest version toch is known to work with\n", s, minServer)
+	case v.atLeast(serverVersion{maxServer.major, maxServer.minor + 1}):
+		fmt.Printf("warning: ClickHouse %s is newer than %s, the latest version toch has been tested with\n", s, maxServer)
+	}
+	missing := make([]string, 0)
+	for _, f := range features {
+		if !v.atLeast(f.since) {
+			missing = append(missing, fmt.Sprintf("%s (%s)", f.name, f.since))
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("warning: ClickHouse %s does not support %s; options that need them will fail\n", s, strings.Join(missing, ", "))
+	}
+	return v, nil
+}
+
+// currentVersion returns the version of this toch binary
+func currentVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// versionCmd prints the version of toch and the libraries it is built with
+func versionCmd() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		fmt.Printf("toch %s\n", version)
+		return
+	}
+
+	fmt.Printf("toch %s\n", currentVersion())
+	fmt.Printf("  go           %s\n", info.GoVersion)
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified", "GOOS", "GOARCH":
+			fmt.Printf("  %-12s %s\n", s.Key, s.Value)
+		}
+	}
+	for _, d := range info.Deps {
+		if strings.HasSuffix(d.Path, "chutils") || strings.HasSuffix(d.Path, "clickhouse-go/v2") || strings.HasSuffix(d.Path, "excelize/v2") {
+			fmt.Printf("  %-12s %s %s\n", "dependency", d.Path, d.Version)
+		}
+	}
+	fmt.Printf("  ClickHouse   %s to %s\n", minServer, maxServer)
+}
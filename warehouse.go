@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/invertedv/chutils/file"
+)
+
+// warehouses are the source types that are the result of a query run against another data warehouse
+var warehouses = []string{"snowflake", "bigquery"}
+
+// warehouseCmd returns the command of the warehouse's command line client (snowsql or bq) that runs query and
+// writes the results as CSV with a header row
+func warehouseCmd(sType, query string) (*exec.Cmd, error) {
+	switch sType {
+	case "snowflake":
+		return exec.Command("snowsql", "-q", query, "-o", "output_format=csv", "-o", "header=true",
+			"-o", "timing=false", "-o", "friendly=false", "-o", "quiet=true"), nil
+	case "bigquery":
+		return exec.Command("bq", "query", "--quiet", "--format=csv", "--use_legacy_sql=false",
+			"--max_rows=1000000000", query), nil
+	}
+	return nil, fmt.Errorf("illegal -type")
+}
+
+// newWarehouse creates a reader for the results of query run on a Snowflake or BigQuery warehouse.
+// The query is run using the warehouse's command line client (snowsql or bq), which must be installed and
+// configured with credentials.  The client's output goes straight to a temporary file, which is read from
+// disk, so the results needn't fit in memory.  (They are read more than once, so they can't be read from the
+// client as it runs.)
+func newWarehouse(sType, query string, quote rune, skip int) (*file.Reader, error) {
+	c, err := warehouseCmd(sType, query)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp("", "toch-*.csv")
+	if err != nil {
+		return nil, err
+	}
+	// the file is read until the reader is closed
+	_ = os.Remove(tmp.Name())
+	var stderr bytes.Buffer
+	c.Stdout, c.Stderr = tmp, &stderr
+	if e := c.Run(); e != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("%s failed: %v: %s", c.Args[0], e, stderr.String())
+	}
+	if _, e := tmp.Seek(0, io.SeekStart); e != nil {
+		_ = tmp.Close()
+		return nil, e
+	}
+	return file.NewReader(query, ',', '\n', quote, 0, skip, 0, tmp, 0), nil
+}
+
+// warehouseTypes returns the -t types of the columns of the results of query, from the warehouse's schema of
+// them.  Snowflake describes the result of the query with LIMIT 0 (DESCRIBE RESULT); BigQuery gives the schema
+// of a dry run.  Neither reads any data.
+func warehouseTypes(sType, query string) ([]string, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	var c *exec.Cmd
+	switch sType {
+	case "snowflake":
+		c = exec.Command("snowsql", "-q",
+			fmt.Sprintf("SELECT * FROM (%s) LIMIT 0; DESCRIBE RESULT LAST_QUERY_ID();", query),
+			"-o", "output_format=csv", "-o", "header=true", "-o", "timing=false", "-o", "friendly=false",
+			"-o", "quiet=true")
+	case "bigquery":
+		c = exec.Command("bq", "query", "--dry_run", "--format=json", "--use_legacy_sql=false", query)
+	default:
+		return nil, fmt.Errorf("illegal -type")
+	}
+	out, err := runCmd(c)
+	if err != nil {
+		return nil, err
+	}
+	if sType == "snowflake" {
+		return snowflakeSchema(out)
+	}
+	return bigQuerySchema(out)
+}
+
+// snowflakeSchema returns the -t types of the columns in the output of DESCRIBE RESULT, which follows the
+// (empty) result of the query
+func snowflakeSchema(out []byte) ([]string, error) {
+	r := csv.NewReader(bytes.NewReader(out))
+	r.FieldsPerRecord, r.LazyQuotes = -1, true
+	types := make([]string, 0)
+	described := false
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(rec) >= 2 && strings.EqualFold(rec[0], "name") && strings.EqualFold(rec[1], "type"):
+			described = true
+		case described && len(rec) >= 2:
+			types = append(types, snowflakeType(rec[1]))
+		}
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("snowsql gave no description of the columns")
+	}
+	return types, nil
+}
+
+// numberRe matches the Snowflake NUMBER(P,S)
+var numberRe = regexp.MustCompile(`^(?:NUMBER|DECIMAL|NUMERIC)\((\d+),\s*(\d+)\)$`)
+
+// snowflakeType returns the -t type of the Snowflake type t.  A NUMBER that fits an Int64 is an Int64, other
+// NUMBERs are Decimals.  The TIMESTAMPs are DateTime64(6), read with their time zone if they have one.  The
+// semi-structured types (VARIANT, OBJECT, ARRAY) and GEOGRAPHY, whose values snowsql writes as JSON, are Strings.
+func snowflakeType(t string) string {
+	t = strings.ToUpper(strings.TrimSpace(t))
+	if m := numberRe.FindStringSubmatch(t); m != nil {
+		p, _ := strconv.Atoi(m[1])
+		s, _ := strconv.Atoi(m[2])
+		if s == 0 && p <= 18 {
+			return "i"
+		}
+		return fmt.Sprintf("dec(%d,%d)", p, s)
+	}
+	base := t
+	if ind := strings.Index(t, "("); ind > 0 {
+		base = t[:ind]
+	}
+	switch base {
+	case "NUMBER", "DECIMAL", "NUMERIC", "INT", "INTEGER", "BIGINT", "SMALLINT", "TINYINT", "BYTEINT":
+		return "i"
+	case "FLOAT", "FLOAT4", "FLOAT8", "DOUBLE", "DOUBLE PRECISION", "REAL":
+		return "f"
+	case "BOOLEAN":
+		return "b"
+	case "DATE":
+		return "d"
+	case "TIME":
+		return "tm"
+	case "DATETIME", "TIMESTAMP", "TIMESTAMP_NTZ", "TIMESTAMP_LTZ", "TIMESTAMP_TZ":
+		return "dt6"
+	}
+	return "s"
+}
+
+// bigQuerySchema returns the -t types of the columns of the schema of a bq dry run
+func bigQuerySchema(out []byte) ([]string, error) {
+	var job struct {
+		Statistics struct {
+			Query struct {
+				Schema struct {
+					Fields []struct {
+						Type string `json:"type"`
+						Mode string `json:"mode"`
+					} `json:"fields"`
+				} `json:"schema"`
+			} `json:"query"`
+		} `json:"statistics"`
+	}
+	if err := json.Unmarshal(out, &job); err != nil {
+		return nil, fmt.Errorf("the dry run of bq: %v", err)
+	}
+	fields := job.Statistics.Query.Schema.Fields
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("the dry run of bq gave no schema")
+	}
+	types := make([]string, len(fields))
+	for ind, f := range fields {
+		types[ind] = bigQueryType(f.Type, f.Mode)
+	}
+	return types, nil
+}
+
+// bigQueryType returns the -t type of the BigQuery type t of mode mode.  NUMERIC and BIGNUMERIC are the Decimals
+// of their full precision and scale, DATETIME and TIMESTAMP are DateTime64(6).  REPEATED fields (ARRAYs),
+// RECORDs, JSON and GEOGRAPHY are Strings.
+func bigQueryType(t, mode string) string {
+	if strings.EqualFold(mode, "REPEATED") {
+		return "s"
+	}
+	switch strings.ToUpper(t) {
+	case "INTEGER", "INT64":
+		return "i"
+	case "FLOAT", "FLOAT64":
+		return "f"
+	case "NUMERIC":
+		return "dec(38,9)"
+	case "BIGNUMERIC":
+		return "dec(76,38)"
+	case "BOOLEAN", "BOOL":
+		return "b"
+	case "DATE":
+		return "d"
+	case "TIME":
+		return "tm"
+	case "DATETIME", "TIMESTAMP":
+		return "dt6"
+	}
+	return "s"
+}
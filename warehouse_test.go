@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnowflakeSchema(t *testing.T) {
+	// the empty result of the query, then its description
+	out := `"ID","AMOUNT","NAME"
+"name","type","kind","null?","default","primary key","unique key"
+"ID","NUMBER(38,0)","COLUMN","Y",,"N","N"
+"AMOUNT","NUMBER(12,2)","COLUMN","Y",,"N","N"
+"NAME","VARCHAR(16777216)","COLUMN","Y",,"N","N"
+`
+	got, err := snowflakeSchema([]byte(out))
+	if err != nil || strings.Join(got, ",") != "dec(38,0),dec(12,2),s" {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if _, err := snowflakeSchema([]byte(`"ID"` + "\n")); err == nil {
+		t.Errorf("no description: no error")
+	}
+
+	for in, want := range map[string]string{"NUMBER(18,0)": "i", "NUMBER(19,0)": "dec(19,0)", "number(10, 3)": "dec(10,3)",
+		"FLOAT": "f", "BOOLEAN": "b", "DATE": "d", "TIME(9)": "tm", "TIMESTAMP_TZ(9)": "dt6", "TIMESTAMP_NTZ(3)": "dt6",
+		"ARRAY": "s", "VARIANT": "s", "GEOGRAPHY": "s", "BINARY(8)": "s"} {
+		if got := snowflakeType(in); got != want {
+			t.Errorf("snowflakeType(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestBigQuerySchema(t *testing.T) {
+	out := `{"statistics": {"query": {"schema": {"fields": [
+{"name": "id", "type": "INTEGER", "mode": "NULLABLE"},
+{"name": "price", "type": "NUMERIC"},
+{"name": "at", "type": "TIMESTAMP"},
+{"name": "tags", "type": "STRING", "mode": "REPEATED"},
+{"name": "ok", "type": "BOOLEAN"}]}}}}`
+	got, err := bigQuerySchema([]byte(out))
+	if err != nil || strings.Join(got, ",") != "i,dec(38,9),dt6,s,b" {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if _, err := bigQuerySchema([]byte(`{"statistics": {}}`)); err == nil {
+		t.Errorf("no schema: no error")
+	}
+}
+
+func TestWarehouseDateTimes(t *testing.T) {
+	for _, s := range []string{"2024-01-02 03:04:05.123 -0800", "2024-01-02 11:04:05.123 +0000",
+		"2024-01-02 11:04:05.123000 UTC"} {
+		d, err := parseDateTime(s, "auto")
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if got := d.UTC().Format("2006-01-02 15:04:05.000"); got != "2024-01-02 11:04:05.123" {
+			t.Errorf("%s = %s", s, got)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// the subdirectories of a watched directory that the files are moved to once loaded
+const (
+	processedDir = "processed"
+	failedDir    = "failed"
+)
+
+// watchOpts are the options of -watch
+type watchOpts struct {
+	dir      string        // directory watched
+	interval time.Duration // time between looks at the directory
+	include  []string      // load only the files that match one of these patterns, if any
+	exclude  []string      // don't load the files that match one of these patterns
+}
+
+// fileState is the size and modification time of a file
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// watchDir runs until it is interrupted, loading the files that arrive in opts.dir into j.table.  A file is
+// loaded once it is unchanged between two looks at the directory, so it isn't loaded while it's being written,
+// and is then moved to the processed or failed subdirectory.  Hidden files and the files that -include and
+// -exclude leave out are ignored.  The files are appended to the table, which the first file creates if it
+// doesn't exist.
+func watchDir(j *job, opts *watchOpts, con *chutils.Connect) error {
+	for _, sub := range []string{processedDir, failedDir} {
+		if e := os.MkdirAll(filepath.Join(opts.dir, sub), 0755); e != nil {
+			return e
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	fmt.Printf("watching %s every %v\n", opts.dir, opts.interval)
+	sdReady(ctx, "watching "+opts.dir)
+
+	seen := make(map[string]fileState)
+	loaded, failed := 0, 0
+	for {
+		ready, next, err := readyFiles(opts, seen)
+		if err != nil {
+			return err
+		}
+		seen = next
+		for _, name := range ready {
+			if ctx.Err() != nil {
+				break
+			}
+			delete(seen, name)
+			res := watchLoad(j, filepath.Join(opts.dir, name), con)
+			sub := processedDir
+			if res.err != nil {
+				sub = failedDir
+				failed++
+				fmt.Printf("%s: failed: %v\n", name, res.err)
+			} else {
+				loaded++
+				fmt.Printf("%s: %d rows loaded into %s in %0.1f seconds\n", name, res.rows, j.table, res.secs)
+			}
+			if j.journal {
+				journalLoad(res, j.table, j.runID)
+			}
+			sdNotify(fmt.Sprintf("STATUS=watching %s: %d files loaded, %d failed", opts.dir, loaded, failed))
+			if e := moveTo(filepath.Join(opts.dir, name), filepath.Join(opts.dir, sub)); e != nil {
+				return e
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Printf("stopped watching %s: %d files loaded, %d failed\n", opts.dir, loaded, failed)
+			return nil
+		case <-time.After(opts.interval):
+		}
+	}
+}
+
+// readyFiles returns the files of opts.dir that are the same as at the last look, seen, in the order of their
+// names, and the state of the files now
+func readyFiles(opts *watchOpts, seen map[string]fileState) (ready []string, now map[string]fileState, err error) {
+	entries, err := os.ReadDir(opts.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	now = make(map[string]fileState)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || !selected(name, opts.include, opts.exclude) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// removed since the directory was read
+			continue
+		}
+		st := fileState{size: info.Size(), modTime: info.ModTime()}
+		now[name] = st
+		if prev, ok := seen[name]; ok && prev.size == st.size && prev.modTime.Equal(st.modTime) {
+			ready = append(ready, name)
+		}
+	}
+	sort.Strings(ready)
+	return ready, now, nil
+}
+
+// watchLoad loads the file source into j.table, appending to the table if it exists
+func watchLoad(j *job, source string, con *chutils.Connect) fileResult {
+	fj := *j
+	fj.source = source
+	exists, _, _, err := tableInfo(j.table, con)
+	if err != nil {
+		return fileResult{source: source, err: err}
+	}
+	fj.appending = exists
+	if err := fj.checkRequired(); err != nil {
+		return fileResult{source: source, err: err}
+	}
+	return fj.loadFile(nil, con)
+}
+
+// moveTo moves the file source into the directory dir.  If dir has a file of that name, the time is added
+// to the name.
+func moveTo(source, dir string) error {
+	dest := filepath.Join(dir, filepath.Base(source))
+	if _, err := os.Stat(dest); err == nil {
+		ext := filepath.Ext(dest)
+		dest = fmt.Sprintf("%s_%s%s", strings.TrimSuffix(dest, ext), time.Now().Format("20060102T150405"), ext)
+	}
+	return os.Rename(source, dest)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// allowed values of -http-method
+var httpMethods = []string{"GET", "POST", "PUT"}
+
+// webRequest is the request toch sends for a source that is a web address
+type webRequest struct {
+	agent       string // User-Agent, "NA" for Go's default
+	method      string
+	body        []byte // request body, nil for none
+	contentType string
+	oauth       *oauthClient // if not nil, the source needs an OAuth2 bearer token
+}
+
+// newWebRequest creates the request from the -agent, -http-method, -http-body and -http-content-type options.
+// A body of the form @file is read from file.
+func newWebRequest(agent, method, body, contentType string) (*webRequest, error) {
+	w := &webRequest{agent: agent, method: strings.ToUpper(method), contentType: contentType}
+	if !isIn(&w.method, httpMethods, false) {
+		return nil, fmt.Errorf("-http-method is one of %s", strings.Join(httpMethods, ", "))
+	}
+	switch {
+	case strings.HasPrefix(body, "@"):
+		b, err := os.ReadFile(body[1:])
+		if err != nil {
+			return nil, err
+		}
+		w.body = b
+	case body != "":
+		w.body = []byte(body)
+	}
+	if w.body != nil && w.method == "GET" {
+		return nil, fmt.Errorf("-http-body needs -http-method POST or PUT")
+	}
+	return w, nil
+}
+
+// get sends the request for source.  It is an error if the response status isn't 200.
+// If the source rejects the OAuth2 token, a new token is fetched and the request is sent again.
+func (w *webRequest) get(source string) (*http.Response, error) {
+	resp, err := w.send(source, false)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && w.oauth != nil {
+		_ = resp.Body.Close()
+		resp, err = w.send(source, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", source, resp.Status)
+	}
+	return resp, nil
+}
+
+// send sends the request for source.  refresh forces a new OAuth2 token.
+func (w *webRequest) send(source string, refresh bool) (*http.Response, error) {
+	var req *http.Request
+	var err error
+	if w.body != nil {
+		req, err = http.NewRequest(w.method, source, bytes.NewReader(w.body))
+	} else {
+		req, err = http.NewRequest(w.method, source, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if w.agent != "NA" {
+		req.Header.Set("User-Agent", w.agent)
+	}
+	if w.contentType != "" {
+		req.Header.Set("Content-Type", w.contentType)
+	}
+	if w.oauth != nil {
+		token, e := w.oauth.bearer(refresh)
+		if e != nil {
+			return nil, e
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return http.DefaultClient.Do(req)
+}
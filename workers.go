@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// loader loads the files of a multi-file load into j.table, up to workers files at a time.  The first file to
+// load successfully creates the table, so it is loaded by itself.  Every file holds a slot while it is read and
+// loaded, which bounds the memory used.
+type loader struct {
+	j       *job
+	con     *chutils.Connect
+	workers int
+	start   time.Time
+
+	mu      sync.Mutex
+	spec    *chutils.TableDef // spec of the table, nil until a file loads
+	results []fileResult
+	done    int // files finished
+	rows    int // rows loaded
+
+	wg    sync.WaitGroup
+	slots chan struct{}
+}
+
+// newLoader creates a loader for j with the given number of workers
+func newLoader(j *job, workers int, con *chutils.Connect) *loader {
+	return &loader{j: j, con: con, workers: workers, start: time.Now(), slots: make(chan struct{}, workers)}
+}
+
+// slot waits for a worker to be free.  It must be called before the file is read and loaded with load.
+func (l *loader) slot() {
+	l.slots <- struct{}{}
+}
+
+// load loads the file of fj, conforming its fields to the table (see conform).  Until the table exists, the
+// file is loaded before load returns.
+func (l *loader) load(fj *job) {
+	l.mu.Lock()
+	ind := len(l.results)
+	l.results = append(l.results, fileResult{source: fj.source})
+	spec := l.spec
+	l.mu.Unlock()
+
+	if err := fj.checkRequired(); err != nil {
+		l.finish(ind, fileResult{source: fj.source, err: err})
+		return
+	}
+	if spec == nil {
+		l.finish(ind, fj.loadFile(nil, l.con))
+		return
+	}
+	// adding columns to the table while other files are being inserted would break their inserts
+	if fj.schemaPolicy == "union" && l.workers > 1 && l.adds(fj, spec) {
+		<-l.slots
+		l.wg.Wait()
+		l.slots <- struct{}{}
+	}
+	spec, err := fj.conform(spec, l.con)
+	if err != nil {
+		l.finish(ind, fileResult{source: fj.source, err: err})
+		return
+	}
+	l.mu.Lock()
+	l.spec = spec
+	l.mu.Unlock()
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		l.finish(ind, fj.loadFile(spec, l.con))
+	}()
+}
+
+// adds returns true if fj has fields that aren't in spec
+func (l *loader) adds(fj *job, spec *chutils.TableDef) bool {
+	cols, err := fj.fileFields()
+	if err != nil {
+		// conform reports the error
+		return false
+	}
+	for _, c := range cols {
+		if _, _, e := spec.Get(c); e != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// finish records the result r of the file at ind and frees its slot
+func (l *loader) finish(ind int, r fileResult) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.results[ind] = r
+	if r.err == nil && l.spec == nil {
+		l.spec = r.spec
+	}
+	l.done++
+	l.rows += r.rows
+	if l.workers > 1 {
+		fmt.Printf("%d files done, %d rows, %s\n", l.done, l.rows, elapsed(l.start))
+	}
+	<-l.slots
+}
+
+// wait waits for the files to load and returns their results, in the order they were given to load
+func (l *loader) wait() []fileResult {
+	l.wg.Wait()
+	return l.results
+}
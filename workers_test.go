@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/invertedv/chutils"
+)
+
+// TestLoader loads files with several workers, as load does once the table exists: each file takes a slot and
+// is loaded in its own goroutine.  No more than workers files may load at once, and the results must be in the
+// order the files were given.  Run with -race.
+func TestLoader(t *testing.T) {
+	const files, workers = 200, 4
+	l := newLoader(&job{}, workers, nil)
+	spec := chutils.NewTableDef("k", chutils.MergeTree, map[int]*chutils.FieldDef{0: {Name: "k"}})
+	var mu sync.Mutex
+	running, most := 0, 0
+	for f := 0; f < files; f++ {
+		l.slot()
+		l.mu.Lock()
+		ind := len(l.results)
+		l.results = append(l.results, fileResult{source: fmt.Sprint(f)})
+		l.mu.Unlock()
+
+		l.wg.Add(1)
+		go func(f int) {
+			defer l.wg.Done()
+			mu.Lock()
+			running++
+			most = max(most, running)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			r := fileResult{source: fmt.Sprint(f), rows: f, spec: spec}
+			if f%10 == 3 {
+				r = fileResult{source: fmt.Sprint(f), err: fmt.Errorf("file %d failed", f)}
+			}
+			l.finish(ind, r)
+		}(f)
+	}
+	results := l.wait()
+
+	if most > workers {
+		t.Errorf("%d files loaded at once, want at most %d", most, workers)
+	}
+	if len(results) != files {
+		t.Fatalf("%d results, want %d", len(results), files)
+	}
+	rows := 0
+	for f, r := range results {
+		if r.source != fmt.Sprint(f) {
+			t.Errorf("result %d is of file %s", f, r.source)
+		}
+		if (r.err != nil) != (f%10 == 3) {
+			t.Errorf("file %d: error %v", f, r.err)
+		}
+		rows += r.rows
+	}
+	if l.done != files || l.rows != rows {
+		t.Errorf("%d files done, %d rows; want %d, %d", l.done, l.rows, files, rows)
+	}
+	if l.spec != spec {
+		t.Errorf("the spec of the table isn't that of the files loaded")
+	}
+}